@@ -14,9 +14,13 @@ import (
 	"github.com/KotFed0t/invest_helper_bot/data/session"
 	"github.com/KotFed0t/invest_helper_bot/internal/externalApi/cloudStorageApi/googleDriveApi"
 	"github.com/KotFed0t/invest_helper_bot/internal/externalApi/moexApi"
+	"github.com/KotFed0t/invest_helper_bot/internal/portfolioCsv"
+	"github.com/KotFed0t/invest_helper_bot/internal/purchaseStrategy"
 	"github.com/KotFed0t/invest_helper_bot/internal/reportGenerator/xslsxGenerator"
+	"github.com/KotFed0t/invest_helper_bot/internal/reportParser/brokerReportParser"
 	"github.com/KotFed0t/invest_helper_bot/internal/scheduler"
 	"github.com/KotFed0t/invest_helper_bot/internal/service/investHelperService"
+	"github.com/KotFed0t/invest_helper_bot/internal/service/notificationService"
 	"github.com/KotFed0t/invest_helper_bot/internal/tgbot"
 	"github.com/KotFed0t/invest_helper_bot/internal/transport/telegram"
 )
@@ -46,6 +50,12 @@ func main() {
 
 	reportGenerator := xslsxGenerator.New()
 
+	reportParser := brokerReportParser.New()
+
+	portfolioCsvCodec := portfolioCsv.New()
+
+	purchaseStrategies := purchaseStrategy.New()
+
 	googleCloudStorage := googleDriveApi.New(ctx, cfg)
 
 	investHelperSrv := investHelperService.New(
@@ -54,19 +64,30 @@ func main() {
 		redisCache,
 		moexApiClient,
 		reportGenerator,
+		reportParser,
+		portfolioCsvCodec,
+		purchaseStrategies,
 		googleCloudStorage,
 		pgRepo, // в роли transactor
 	)
 
+	tgController := telegram.NewController(cfg, investHelperSrv, redisSession)
+
+	tgBot := tgbot.New(cfg, tgController, redisSession)
+
+	notificationSrv := notificationService.New(cfg, investHelperSrv, tgBot)
+
 	sched := scheduler.New()
 	sched.NewIntervalJob("fill moex cache", investHelperSrv.FillMoexCache, cfg.Jobs.FillMoexCacheInterval, true)
 	sched.NewIntervalJob("delete old files from goolgle drive", googleCloudStorage.DeleteOldFiles, cfg.Jobs.DeleteOldFilesInterval, true)
+	sched.NewCrontabJob("notify index drifts", notificationSrv.NotifyIndexDrifts, cfg.Jobs.IndexDriftCrontab, false)
+	sched.NewCrontabJob("update price history", investHelperSrv.UpdatePriceHistory, cfg.Jobs.PriceHistoryCrontab, true)
+	sched.NewCrontabJob("take portfolio snapshots", investHelperSrv.TakePortfolioSnapshots, cfg.Jobs.PortfolioSnapshotCrontab, false)
+	sched.NewCrontabJob("update dividends", investHelperSrv.UpdateDividends, cfg.Jobs.DividendsCrontab, true)
+	sched.NewCrontabJob("notify upcoming dividends", notificationSrv.NotifyUpcomingDividends, cfg.Jobs.DividendNotifyCrontab, false)
 	sched.Start()
 	defer sched.Stop()
 
-	tgController := telegram.NewController(cfg, investHelperSrv, redisSession)
-
-	tgBot := tgbot.New(cfg, tgController, redisSession)
 	tgBot.Start()
 	defer tgBot.Stop()
 
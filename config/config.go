@@ -6,6 +6,7 @@ import (
 
 	"github.com/caarlos0/env/v11"
 	"github.com/joho/godotenv"
+	"github.com/shopspring/decimal"
 )
 
 type Config struct {
@@ -17,9 +18,16 @@ type Config struct {
 	Cache             Cache
 	Jobs              Jobs
 	GoogleDrive       GoogleDrive
+	Notifications     Notifications
+	PriceHistory      PriceHistory
+	Tax               Tax
+	Rebalance         Rebalance
 	SessionExpiration time.Duration `env:"SESSION_EXPIRATION"`
 	StocksPerPage     int           `env:"STOCKS_PER_PAGE"`
 	PortfoliosPerPage int           `env:"PORTFOLIOS_PER_PAGE"`
+	OperationsPerPage int           `env:"OPERATIONS_PER_PAGE"`
+	// UndoWindow - сколько времени после записи операцию можно отменить
+	UndoWindow time.Duration `env:"UNDO_WINDOW"`
 }
 
 type Postgres struct {
@@ -40,6 +48,8 @@ type Telegram struct {
 	Token            string        `env:"TELEGRAM_TOKEN"`
 	UpdTimeout       time.Duration `env:"TELEGRAM_UPD_TIMEOUT"`
 	FileLimitInBytes int           `env:"TELEGRAM_FILE_LIMIT_IN_BYTES"`
+	// AdminChatIDs - чаты, которым доступны административные команды (корпоративные действия)
+	AdminChatIDs []int64 `env:"TELEGRAM_ADMIN_CHAT_IDS" envSeparator:","`
 }
 
 type Redis struct {
@@ -56,7 +66,10 @@ type API struct {
 }
 
 type MoexApi struct {
-	Url string `env:"MOEX_API_URL"`
+	Url          string   `env:"MOEX_API_URL"`
+	SharesBoards []string `env:"MOEX_SHARES_BOARDS" envSeparator:","`
+	FundsBoards  []string `env:"MOEX_FUNDS_BOARDS" envSeparator:","`
+	BondsBoards  []string `env:"MOEX_BONDS_BOARDS" envSeparator:","`
 }
 
 type Cache struct {
@@ -64,8 +77,13 @@ type Cache struct {
 }
 
 type Jobs struct {
-	FillMoexCacheInterval  time.Duration `env:"FILL_MOEX_CACHE_JOB_INTERVAL"`
-	DeleteOldFilesInterval time.Duration `env:"DELETE_OLD_FILES_JOB_INTERVAL"`
+	FillMoexCacheInterval    time.Duration `env:"FILL_MOEX_CACHE_JOB_INTERVAL"`
+	DeleteOldFilesInterval   time.Duration `env:"DELETE_OLD_FILES_JOB_INTERVAL"`
+	IndexDriftCrontab        string        `env:"INDEX_DRIFT_JOB_CRONTAB"`
+	PriceHistoryCrontab      string        `env:"PRICE_HISTORY_JOB_CRONTAB"`
+	PortfolioSnapshotCrontab string        `env:"PORTFOLIO_SNAPSHOT_JOB_CRONTAB"`
+	DividendsCrontab         string        `env:"DIVIDENDS_JOB_CRONTAB"`
+	DividendNotifyCrontab    string        `env:"DIVIDEND_NOTIFY_JOB_CRONTAB"`
 }
 
 type GoogleDrive struct {
@@ -73,6 +91,28 @@ type GoogleDrive struct {
 	FileTTL         time.Duration `env:"GOOGLE_DRIVE_FILE_TTL"`
 }
 
+type Notifications struct {
+	// IndexDriftThreshold - отклонение веса бумаги от индекса (в п.п.), после которого уведомляем владельца
+	IndexDriftThreshold decimal.Decimal `env:"INDEX_DRIFT_WEIGHT_THRESHOLD"`
+	// DividendDaysBefore - за сколько дней до закрытия реестра уведомлять о дивиденде
+	DividendDaysBefore int `env:"DIVIDEND_NOTIFY_DAYS_BEFORE"`
+}
+
+type PriceHistory struct {
+	// BackfillDays - за сколько дней загружать историю по новому тикеру
+	BackfillDays int `env:"PRICE_HISTORY_BACKFILL_DAYS"`
+}
+
+type Tax struct {
+	// LdvWarningDays - за сколько дней до наступления ЛДВ предупреждать о продаже лота
+	LdvWarningDays int `env:"TAX_LDV_WARNING_DAYS"`
+}
+
+type Rebalance struct {
+	// WeightTolerance - допустимое отклонение веса бумаги от целевого (в п.п.), в пределах которого ребалансировка не нужна
+	WeightTolerance decimal.Decimal `env:"REBALANCE_WEIGHT_TOLERANCE"`
+}
+
 func MustLoad() *Config {
 	_ = godotenv.Load(".env")
 
@@ -0,0 +1,133 @@
+package postgres
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/converter/dbConverter"
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/internal/model/dbModel"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+	"github.com/shopspring/decimal"
+)
+
+func (r *Postgres) InsertCashOperations(ctx context.Context, portfolioID int64, operations []model.CashOperation) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.InsertCashOperations"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+		"operations":  operations,
+	}
+	query := `
+		INSERT INTO cash_operations(portfolio_id, operation_type, amount, ticker, dt_create, operation_id)
+		SELECT $1, u.operation_type, u.amount, u.ticker, u.dt_create, NULLIF(u.operation_id, 0)
+		FROM UNNEST(
+			$2::text[],
+			$3::decimal[],
+			$4::text[],
+			$5::timestamptz[],
+			$6::bigint[]
+		) AS u(operation_type, amount, ticker, dt_create, operation_id)
+		`
+
+	operationTypes := make([]string, 0, len(operations))
+	amounts := make([]decimal.Decimal, 0, len(operations))
+	tickers := make([]string, 0, len(operations))
+	dates := make([]time.Time, 0, len(operations))
+	operationIDs := make([]int64, 0, len(operations))
+	for _, operation := range operations {
+		operationTypes = append(operationTypes, string(operation.OperationType))
+		amounts = append(amounts, operation.Amount)
+		tickers = append(tickers, operation.Ticker)
+		dates = append(dates, operation.DtCreate)
+		operationIDs = append(operationIDs, operation.OperationID)
+	}
+
+	slog.Debug("InsertCashOperations start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("InsertCashOperations failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("InsertCashOperations completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, query, portfolioID, operationTypes, amounts, tickers, dates, operationIDs)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Postgres) GetCashBalance(ctx context.Context, portfolioID int64) (balance decimal.Decimal, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetCashBalance"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+	}
+	query := `
+		SELECT COALESCE(SUM(amount), 0) FROM cash_operations
+		WHERE portfolio_id = $1
+		`
+
+	slog.Debug("GetCashBalance start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("GetCashBalance failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetCashBalance completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	err = r.txOrDb(ctx).QueryRowxContext(ctx, query, portfolioID).Scan(&balance)
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+
+	return balance, nil
+}
+
+// GetCashOperations возвращает последние limit операций с деньгами портфеля, от новых к старым
+func (r *Postgres) GetCashOperations(ctx context.Context, portfolioID int64, limit int) (operations []model.CashOperation, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetCashOperations"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+		"limit":       limit,
+	}
+	query := `
+		SELECT row_id, portfolio_id, operation_type, amount, ticker, COALESCE(operation_id, 0) AS operation_id, dt_create FROM cash_operations
+		WHERE portfolio_id = $1
+		ORDER BY dt_create DESC, row_id DESC
+		LIMIT $2
+		`
+
+	slog.Debug("GetCashOperations start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("GetCashOperations failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetCashOperations completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	rows, err := r.txOrDb(ctx).QueryxContext(ctx, query, portfolioID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var operationDb dbModel.CashOperation
+		err = rows.StructScan(&operationDb)
+		if err != nil {
+			return nil, err
+		}
+		operations = append(operations, dbConverter.ConvertCashOperation(operationDb))
+	}
+
+	return operations, nil
+}
@@ -0,0 +1,102 @@
+package postgres
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/converter/dbConverter"
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/internal/model/dbModel"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+	"github.com/shopspring/decimal"
+)
+
+// GetContributionAllocations возвращает доли пополнения пользователя в порядке расчета
+func (r *Postgres) GetContributionAllocations(ctx context.Context, userID int64) (allocations []model.ContributionAllocation, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetContributionAllocations"
+	params := map[string]any{
+		"userID": userID,
+	}
+	query := `
+		SELECT ca.portfolio_id, p.name, ca.percent
+		FROM contribution_allocations ca
+		JOIN portfolios p ON p.portfolio_id = ca.portfolio_id
+		WHERE ca.user_id = $1
+		ORDER BY ca.position
+		`
+
+	slog.Debug("GetContributionAllocations start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("GetContributionAllocations failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetContributionAllocations completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	var dbAllocations []dbModel.ContributionAllocation
+	err = r.txOrDb(ctx).SelectContext(ctx, &dbAllocations, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	allocations = make([]model.ContributionAllocation, 0, len(dbAllocations))
+	for _, allocation := range dbAllocations {
+		allocations = append(allocations, dbConverter.ConvertContributionAllocation(allocation))
+	}
+
+	return allocations, nil
+}
+
+// SetContributionAllocations заменяет доли пополнения пользователя, порядок в allocations - порядок расчета.
+// Должен вызываться внутри транзакции.
+func (r *Postgres) SetContributionAllocations(ctx context.Context, userID int64, allocations []model.ContributionAllocation) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.SetContributionAllocations"
+	params := map[string]any{
+		"userID":      userID,
+		"allocations": allocations,
+	}
+	deleteQuery := `
+		DELETE FROM contribution_allocations
+		WHERE user_id = $1
+		`
+	insertQuery := `
+		INSERT INTO contribution_allocations(user_id, portfolio_id, percent, position)
+		SELECT $1, u.portfolio_id, u.percent, u.position
+		FROM UNNEST($2::bigint[], $3::decimal[]) WITH ORDINALITY AS u(portfolio_id, percent, position)
+		`
+
+	slog.Debug("SetContributionAllocations start", slog.String("rqID", rqID), slog.String("op", op), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("SetContributionAllocations failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("SetContributionAllocations completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, deleteQuery, userID)
+	if err != nil {
+		return err
+	}
+
+	if len(allocations) == 0 {
+		return nil
+	}
+
+	portfolioIDs := make([]int64, 0, len(allocations))
+	percents := make([]decimal.Decimal, 0, len(allocations))
+	for _, allocation := range allocations {
+		portfolioIDs = append(portfolioIDs, allocation.PortfolioID)
+		percents = append(percents, allocation.Percent)
+	}
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, insertQuery, userID, portfolioIDs, percents)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
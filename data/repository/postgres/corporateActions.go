@@ -0,0 +1,149 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/KotFed0t/invest_helper_bot/data/repository"
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/internal/model/moexModel"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// GetPortfoliosWithTicker возвращает портфели, в составе которых есть бумага (в том числе с нулевым количеством)
+func (r *Postgres) GetPortfoliosWithTicker(ctx context.Context, ticker string) (portfolioIDs []int64, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetPortfoliosWithTicker"
+	params := map[string]any{
+		"ticker": ticker,
+	}
+	query := `
+		SELECT portfolio_id FROM stocks_portfolio_details
+		WHERE ticker = $1
+		ORDER BY portfolio_id
+		`
+
+	slog.Debug("GetPortfoliosWithTicker start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("GetPortfoliosWithTicker failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetPortfoliosWithTicker completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	err = r.txOrDb(ctx).SelectContext(ctx, &portfolioIDs, query, ticker)
+	if err != nil {
+		return nil, err
+	}
+
+	return portfolioIDs, nil
+}
+
+// RenamePortfolioStock переименовывает бумагу в составе портфеля. Если новый тикер уже есть в портфеле,
+// вес старого прибавляется к нему, а старый удаляется. Количество пересчитывается по истории отдельно.
+func (r *Postgres) RenamePortfolioStock(
+	ctx context.Context,
+	portfolioID int64,
+	ticker, newTicker, board string,
+	instrumentType moexModel.InstrumentType,
+) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.RenamePortfolioStock"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+		"ticker":      ticker,
+		"newTicker":   newTicker,
+	}
+	mergeQuery := `
+		UPDATE stocks_portfolio_details n
+		SET weight = LEAST(n.weight + o.weight, 100)
+		FROM stocks_portfolio_details o
+		WHERE n.portfolio_id = $1 AND n.ticker = $3
+		AND o.portfolio_id = $1 AND o.ticker = $2
+		`
+	deleteQuery := `
+		DELETE FROM stocks_portfolio_details
+		WHERE portfolio_id = $1 AND ticker = $2
+		AND EXISTS (SELECT 1 FROM stocks_portfolio_details WHERE portfolio_id = $1 AND ticker = $3)
+		`
+	renameQuery := `
+		UPDATE stocks_portfolio_details
+		SET ticker = $3, board = $4, instrument_type = $5
+		WHERE portfolio_id = $1 AND ticker = $2
+		`
+
+	slog.Debug("RenamePortfolioStock start", slog.String("rqID", rqID), slog.String("op", op), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("RenamePortfolioStock failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("RenamePortfolioStock completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, mergeQuery, portfolioID, ticker, newTicker)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, deleteQuery, portfolioID, ticker, newTicker)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, renameQuery, portfolioID, ticker, newTicker, board, string(instrumentType))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Postgres) InsertCorporateAction(ctx context.Context, action model.CorporateAction, portfoliosCnt int) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.InsertCorporateAction"
+	params := map[string]any{
+		"action":        action,
+		"portfoliosCnt": portfoliosCnt,
+	}
+	query := `
+		INSERT INTO corporate_actions(action_type, ticker, new_ticker, ratio_from, ratio_to, effective_date, portfolios_cnt, chat_id)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+		`
+
+	slog.Debug("InsertCorporateAction start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("InsertCorporateAction failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("InsertCorporateAction completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	_, err = r.txOrDb(ctx).ExecContext(
+		ctx,
+		query,
+		string(action.Type),
+		action.Ticker,
+		action.NewTicker,
+		action.RatioFrom,
+		action.RatioTo,
+		action.EffectiveDate,
+		portfoliosCnt,
+		action.ChatID,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			if pgErr.Code == "23505" { // unique_violation
+				return repository.ErrAlreadyExists
+			}
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,401 @@
+package postgres
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/converter/dbConverter"
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/internal/model/dbModel"
+	"github.com/KotFed0t/invest_helper_bot/internal/model/moexModel"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+	"github.com/shopspring/decimal"
+)
+
+func (r *Postgres) UpsertDividends(ctx context.Context, dividends []moexModel.Dividend) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.UpsertDividends"
+	params := map[string]any{
+		"dividendsCount": len(dividends),
+	}
+	query := `
+		INSERT INTO dividends(ticker, registry_close_date, value, currency)
+		SELECT u.ticker, u.registry_close_date, u.value, u.currency
+		FROM UNNEST(
+			$1::text[],
+			$2::date[],
+			$3::decimal[],
+			$4::text[]
+		) AS u(ticker, registry_close_date, value, currency)
+		ON CONFLICT ON CONSTRAINT dividends_pk DO UPDATE
+		SET value = EXCLUDED.value,
+			currency = EXCLUDED.currency
+		`
+
+	tickers := make([]string, 0, len(dividends))
+	dates := make([]time.Time, 0, len(dividends))
+	values := make([]decimal.Decimal, 0, len(dividends))
+	currencies := make([]string, 0, len(dividends))
+	for _, dividend := range dividends {
+		tickers = append(tickers, dividend.Ticker)
+		dates = append(dates, dividend.RegistryCloseDate)
+		values = append(values, dividend.Value)
+		currencies = append(currencies, dividend.CurrencyID)
+	}
+
+	slog.Debug("UpsertDividends start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("UpsertDividends failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("UpsertDividends completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, query, tickers, dates, values, currencies)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetDividends возвращает все известные дивиденды по тикеру по возрастанию даты закрытия реестра
+func (r *Postgres) GetDividends(ctx context.Context, ticker string) (dividends []moexModel.Dividend, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetDividends"
+	params := map[string]any{
+		"ticker": ticker,
+	}
+	query := `
+		SELECT ticker, registry_close_date, value, currency
+		FROM dividends
+		WHERE ticker = $1
+		ORDER BY registry_close_date
+		`
+
+	slog.Debug("GetDividends start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("GetDividends failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetDividends completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	rows, err := r.txOrDb(ctx).QueryxContext(ctx, query, ticker)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var dividendDb dbModel.Dividend
+		err = rows.StructScan(&dividendDb)
+		if err != nil {
+			return nil, err
+		}
+		dividends = append(dividends, dbConverter.ConvertDividend(dividendDb))
+	}
+
+	return dividends, nil
+}
+
+// GetDividendAccruals возвращает еще не начисленные дивиденды с прошедшей отсечкой.
+// Количество акций на отсечку считается по истории операций: с режимом T+1 купленные в день отсечки акции дивиденд не получают.
+// Дивиденд считается уже учтенным, если по бумаге портфеля есть любая запись журнала (начисление, загрузка из отчета
+// брокера или ручной ввод) с датой от отсечки до отсечки + duplicateWindowDays: выплата приходит позже отсечки.
+func (r *Postgres) GetDividendAccruals(ctx context.Context, duplicateWindowDays int) (accruals []model.DividendIncome, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetDividendAccruals"
+	query := `
+		SELECT
+			h.portfolio_id,
+			h.ticker,
+			dv.registry_close_date AS dividend_date,
+			SUM(h.quantity) AS quantity,
+			dv.value AS amount_per_share
+		FROM dividends dv
+		JOIN stocks_operations_history h ON h.ticker = dv.ticker AND h.dt_create::date < dv.registry_close_date
+		WHERE dv.registry_close_date <= CURRENT_DATE
+		AND NOT EXISTS (
+			SELECT 1
+			FROM dividend_income di
+			WHERE di.portfolio_id = h.portfolio_id
+			AND di.ticker = dv.ticker
+			AND di.dividend_date >= dv.registry_close_date
+			AND di.dividend_date <= dv.registry_close_date + $1::int
+		)
+		GROUP BY h.portfolio_id, h.ticker, dv.registry_close_date, dv.value
+		HAVING SUM(h.quantity) > 0
+		`
+
+	slog.Debug("GetDividendAccruals start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query))
+	defer func() {
+		if err != nil {
+			slog.Error("GetDividendAccruals failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetDividendAccruals completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	rows, err := r.txOrDb(ctx).QueryxContext(ctx, query, duplicateWindowDays)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var accrual model.DividendIncome
+		err = rows.Scan(&accrual.PortfolioID, &accrual.Ticker, &accrual.DividendDate, &accrual.Quantity, &accrual.AmountPerShare)
+		if err != nil {
+			return nil, err
+		}
+		accrual.Source = model.DividendSourceAuto
+		accruals = append(accruals, accrual)
+	}
+
+	return accruals, nil
+}
+
+func (r *Postgres) InsertDividendIncomes(ctx context.Context, incomes []model.DividendIncome) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.InsertDividendIncomes"
+	params := map[string]any{
+		"incomes": incomes,
+	}
+	query := `
+		INSERT INTO dividend_income(
+			portfolio_id, ticker, dividend_date, quantity, amount_per_share, gross_amount, tax_amount, net_amount, source
+		)
+		SELECT u.portfolio_id, u.ticker, u.dividend_date, u.quantity, u.amount_per_share, u.gross_amount, u.tax_amount, u.net_amount, u.source
+		FROM UNNEST(
+			$1::bigint[],
+			$2::text[],
+			$3::date[],
+			$4::integer[],
+			$5::decimal[],
+			$6::decimal[],
+			$7::decimal[],
+			$8::decimal[],
+			$9::text[]
+		) AS u(portfolio_id, ticker, dividend_date, quantity, amount_per_share, gross_amount, tax_amount, net_amount, source)
+		ON CONFLICT (portfolio_id, ticker, dividend_date) WHERE source = 'auto' DO NOTHING
+		`
+
+	portfolioIDs := make([]int64, 0, len(incomes))
+	tickers := make([]string, 0, len(incomes))
+	dates := make([]time.Time, 0, len(incomes))
+	quantities := make([]int, 0, len(incomes))
+	amountsPerShare := make([]decimal.Decimal, 0, len(incomes))
+	grossAmounts := make([]decimal.Decimal, 0, len(incomes))
+	taxAmounts := make([]decimal.Decimal, 0, len(incomes))
+	netAmounts := make([]decimal.Decimal, 0, len(incomes))
+	sources := make([]string, 0, len(incomes))
+	for _, income := range incomes {
+		portfolioIDs = append(portfolioIDs, income.PortfolioID)
+		tickers = append(tickers, income.Ticker)
+		dates = append(dates, income.DividendDate)
+		quantities = append(quantities, income.Quantity)
+		amountsPerShare = append(amountsPerShare, income.AmountPerShare)
+		grossAmounts = append(grossAmounts, income.GrossAmount)
+		taxAmounts = append(taxAmounts, income.TaxAmount)
+		netAmounts = append(netAmounts, income.NetAmount)
+		sources = append(sources, string(income.Source))
+	}
+
+	slog.Debug("InsertDividendIncomes start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("InsertDividendIncomes failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("InsertDividendIncomes completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	_, err = r.txOrDb(ctx).ExecContext(
+		ctx,
+		query,
+		portfolioIDs,
+		tickers,
+		dates,
+		quantities,
+		amountsPerShare,
+		grossAmounts,
+		taxAmounts,
+		netAmounts,
+		sources,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetDividendIncome возвращает журнал дивидендов портфеля по возрастанию даты. Пустой ticker - по всем бумагам.
+func (r *Postgres) GetDividendIncome(ctx context.Context, portfolioID int64, ticker string) (incomes []model.DividendIncome, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetDividendIncome"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+		"ticker":      ticker,
+	}
+	query := `
+		SELECT row_id, portfolio_id, ticker, dividend_date, quantity, amount_per_share, gross_amount, tax_amount, net_amount, source
+		FROM dividend_income
+		WHERE portfolio_id = $1
+		AND ($2 = '' OR ticker = $2)
+		ORDER BY dividend_date, row_id
+		`
+
+	slog.Debug("GetDividendIncome start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("GetDividendIncome failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetDividendIncome completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	rows, err := r.txOrDb(ctx).QueryxContext(ctx, query, portfolioID, ticker)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var incomeDb dbModel.DividendIncome
+		err = rows.StructScan(&incomeDb)
+		if err != nil {
+			return nil, err
+		}
+		incomes = append(incomes, dbConverter.ConvertDividendIncome(incomeDb))
+	}
+
+	return incomes, nil
+}
+
+// GetUpcomingDividends возвращает отсечки до until включительно по бумагам из портфелей с включенными уведомлениями,
+// о которых владельцу еще не сообщали
+func (r *Postgres) GetUpcomingDividends(ctx context.Context, until time.Time) (dividends []model.UpcomingDividend, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetUpcomingDividends"
+	params := map[string]any{
+		"until": until,
+	}
+	query := `
+		SELECT
+			p.portfolio_id,
+			p."name",
+			u.chat_id,
+			spd.ticker,
+			spd.quantity,
+			dv.registry_close_date,
+			dv.value,
+			dv.currency
+		FROM dividends dv
+		JOIN stocks_portfolio_details spd ON spd.ticker = dv.ticker AND spd.quantity > 0
+		JOIN portfolios p ON p.portfolio_id = spd.portfolio_id AND p.dividend_notifications
+		JOIN users u ON u.user_id = p.user_id
+		LEFT JOIN dividend_notifications_sent ns ON ns.portfolio_id = spd.portfolio_id
+			AND ns.ticker = dv.ticker
+			AND ns.registry_close_date = dv.registry_close_date
+		WHERE dv.registry_close_date >= CURRENT_DATE
+		AND dv.registry_close_date <= $1::date
+		AND ns.portfolio_id IS NULL
+		ORDER BY dv.registry_close_date, p.portfolio_id, spd.ticker
+		`
+
+	slog.Debug("GetUpcomingDividends start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("GetUpcomingDividends failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetUpcomingDividends completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	rows, err := r.txOrDb(ctx).QueryxContext(ctx, query, until)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var dividendDb dbModel.UpcomingDividend
+		err = rows.StructScan(&dividendDb)
+		if err != nil {
+			return nil, err
+		}
+		dividends = append(dividends, dbConverter.ConvertUpcomingDividend(dividendDb))
+	}
+
+	return dividends, nil
+}
+
+func (r *Postgres) MarkDividendNotified(ctx context.Context, portfolioID int64, ticker string, registryCloseDate time.Time) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.MarkDividendNotified"
+	params := map[string]any{
+		"portfolioID":       portfolioID,
+		"ticker":            ticker,
+		"registryCloseDate": registryCloseDate,
+	}
+	query := `
+		INSERT INTO dividend_notifications_sent(portfolio_id, ticker, registry_close_date)
+		VALUES ($1, $2, $3)
+		ON CONFLICT ON CONSTRAINT dividend_notifications_sent_pk DO NOTHING
+		`
+
+	slog.Debug("MarkDividendNotified start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("MarkDividendNotified failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("MarkDividendNotified completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, query, portfolioID, ticker, registryCloseDate)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Postgres) SetDividendNotifications(ctx context.Context, portfolioID int64, enabled bool) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.SetDividendNotifications"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+		"enabled":     enabled,
+	}
+	query := `
+		UPDATE portfolios
+		SET dividend_notifications = $1
+		WHERE portfolio_id = $2
+		`
+
+	slog.Debug("SetDividendNotifications start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("SetDividendNotifications failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("SetDividendNotifications completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, query, enabled, portfolioID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
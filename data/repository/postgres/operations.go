@@ -0,0 +1,544 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/data/repository"
+	"github.com/KotFed0t/invest_helper_bot/internal/converter/dbConverter"
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/internal/model/dbModel"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+)
+
+// LockPortfolio блокирует строку портфеля до конца транзакции, чтобы пересчет истории не шел параллельно со сделками
+func (r *Postgres) LockPortfolio(ctx context.Context, portfolioID int64) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.LockPortfolio"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+	}
+	query := `
+		SELECT portfolio_id FROM portfolios
+		WHERE portfolio_id = $1
+		FOR UPDATE
+		`
+
+	slog.Debug("LockPortfolio start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("LockPortfolio failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("LockPortfolio completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	var id int64
+	err = r.txOrDb(ctx).QueryRowxContext(ctx, query, portfolioID).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return repository.ErrNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// GetStockOperationsPage возвращает страницу истории операций портфеля, от новых к старым
+func (r *Postgres) GetStockOperationsPage(ctx context.Context, portfolioID int64, limit, offset int) (stockOperations []model.StockOperation, hasNextPage bool, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetStockOperationsPage"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+		"limit":       limit,
+		"offset":      offset,
+	}
+	query := `
+		select operation_id, portfolio_id, ticker, shortname, quantity, price, total_price, commission, currency, dt_create, external_id, operation_type, dt_acquired, COALESCE(transfer_portfolio_id, 0) AS transfer_portfolio_id, COALESCE(ratio_from, 0) AS ratio_from, COALESCE(ratio_to, 0) AS ratio_to from stocks_operations_history
+		where portfolio_id = $1
+		order by dt_create desc, operation_id desc
+		limit $2
+		offset $3
+		`
+
+	slog.Debug("GetStockOperationsPage start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("GetStockOperationsPage failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetStockOperationsPage completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	// выбираем на 1 больше, чтобы знать есть ли next page
+	rows, err := r.txOrDb(ctx).QueryxContext(ctx, query, portfolioID, limit+1, offset)
+	if err != nil {
+		return nil, false, err
+	}
+
+	defer rows.Close()
+
+	i := 0
+	stockOperations = make([]model.StockOperation, 0, limit)
+	for rows.Next() {
+		i++
+		var stockOperation dbModel.StockOperation
+		err = rows.StructScan(&stockOperation)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if i > limit { // если на 1 больше лимита, значит есть next page
+			hasNextPage = true
+			break
+		}
+		stockOperations = append(stockOperations, dbConverter.ConvertStockOperation(stockOperation))
+	}
+
+	return stockOperations, hasNextPage, nil
+}
+
+func (r *Postgres) GetStockOperation(ctx context.Context, portfolioID, operationID int64) (stockOperation model.StockOperation, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetStockOperation"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+		"operationID": operationID,
+	}
+	query := `
+		select operation_id, portfolio_id, ticker, shortname, quantity, price, total_price, commission, currency, dt_create, external_id, operation_type, dt_acquired, COALESCE(transfer_portfolio_id, 0) AS transfer_portfolio_id, COALESCE(ratio_from, 0) AS ratio_from, COALESCE(ratio_to, 0) AS ratio_to from stocks_operations_history
+		where portfolio_id = $1 and operation_id = $2
+		`
+
+	slog.Debug("GetStockOperation start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("GetStockOperation failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetStockOperation completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	var dbStockOperation dbModel.StockOperation
+	err = r.txOrDb(ctx).QueryRowxContext(ctx, query, portfolioID, operationID).StructScan(&dbStockOperation)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.StockOperation{}, repository.ErrNotFound
+		}
+		return model.StockOperation{}, err
+	}
+
+	return dbConverter.ConvertStockOperation(dbStockOperation), nil
+}
+
+// GetLastEnteredStockOperations возвращает последнюю пачку операций пользователя - все операции его портфелей
+// с наибольшим dt_insert. Операции, записанные в одной транзакции (закуп по расчету, импорт CSV или отчета брокера),
+// получают одинаковый dt_insert.
+func (r *Postgres) GetLastEnteredStockOperations(ctx context.Context, chatID int64) (lastOperations []model.LastOperation, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetLastEnteredStockOperations"
+	params := map[string]any{
+		"chatID": chatID,
+	}
+	query := `
+		with user_operations as (
+			select h.* from stocks_operations_history h
+			join portfolios p using(portfolio_id)
+			join users u using(user_id)
+			where u.chat_id = $1
+		)
+		select operation_id, portfolio_id, ticker, shortname, quantity, price, total_price, commission, currency,
+		dt_create, external_id, operation_type, dt_acquired, COALESCE(transfer_portfolio_id, 0) AS transfer_portfolio_id, COALESCE(ratio_from, 0) AS ratio_from, COALESCE(ratio_to, 0) AS ratio_to, dt_insert
+		from user_operations
+		where dt_insert = (select max(dt_insert) from user_operations)
+		order by portfolio_id, operation_id
+		`
+
+	slog.Debug("GetLastEnteredStockOperations start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("GetLastEnteredStockOperations failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetLastEnteredStockOperations completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	rows, err := r.txOrDb(ctx).QueryxContext(ctx, query, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	lastOperations = make([]model.LastOperation, 0)
+	for rows.Next() {
+		var dbStockOperation dbModel.StockOperation
+		err = rows.StructScan(&dbStockOperation)
+		if err != nil {
+			return nil, err
+		}
+		lastOperations = append(lastOperations, model.LastOperation{
+			PortfolioID: dbStockOperation.PortfolioID,
+			Operation:   dbConverter.ConvertStockOperation(dbStockOperation),
+			DtInsert:    dbStockOperation.DtInsert,
+		})
+	}
+
+	return lastOperations, rows.Err()
+}
+
+// GetEnteredStockOperation возвращает операцию по id, если она записана в портфель пользователя
+func (r *Postgres) GetEnteredStockOperation(ctx context.Context, chatID, operationID int64) (enteredOperation model.LastOperation, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetEnteredStockOperation"
+	params := map[string]any{
+		"chatID":      chatID,
+		"operationID": operationID,
+	}
+	query := `
+		select h.operation_id, h.portfolio_id, h.ticker, h.shortname, h.quantity, h.price, h.total_price, h.commission, h.currency,
+		h.dt_create, h.external_id, h.operation_type, h.dt_acquired, COALESCE(h.transfer_portfolio_id, 0) AS transfer_portfolio_id, COALESCE(h.ratio_from, 0) AS ratio_from, COALESCE(h.ratio_to, 0) AS ratio_to, h.dt_insert
+		from stocks_operations_history h
+		join portfolios p using(portfolio_id)
+		join users u using(user_id)
+		where u.chat_id = $1 and h.operation_id = $2
+		`
+
+	slog.Debug("GetEnteredStockOperation start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("GetEnteredStockOperation failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetEnteredStockOperation completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	var dbStockOperation dbModel.StockOperation
+	err = r.txOrDb(ctx).QueryRowxContext(ctx, query, chatID, operationID).StructScan(&dbStockOperation)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.LastOperation{}, repository.ErrNotFound
+		}
+		return model.LastOperation{}, err
+	}
+
+	return model.LastOperation{
+		PortfolioID: dbStockOperation.PortfolioID,
+		Operation:   dbConverter.ConvertStockOperation(dbStockOperation),
+		DtInsert:    dbStockOperation.DtInsert,
+	}, nil
+}
+
+// UpdateStockOperation перезаписывает количество, цену, сумму, комиссию и дату операции
+func (r *Postgres) UpdateStockOperation(ctx context.Context, portfolioID int64, stockOperation model.StockOperation) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.UpdateStockOperation"
+	params := map[string]any{
+		"portfolioID":    portfolioID,
+		"stockOperation": stockOperation,
+	}
+	query := `
+		UPDATE stocks_operations_history
+		SET quantity = $3, price = $4, total_price = $5, commission = $6, dt_create = $7
+		WHERE portfolio_id = $1 AND operation_id = $2
+		`
+
+	slog.Debug("UpdateStockOperation start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("UpdateStockOperation failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("UpdateStockOperation completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	res, err := r.txOrDb(ctx).ExecContext(
+		ctx,
+		query,
+		portfolioID,
+		stockOperation.OperationID,
+		stockOperation.Quantity,
+		stockOperation.Price,
+		stockOperation.TotalPrice,
+		stockOperation.Commission,
+		stockOperation.DtCreate,
+	)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return repository.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *Postgres) DeleteStockOperation(ctx context.Context, portfolioID, operationID int64) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.DeleteStockOperation"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+		"operationID": operationID,
+	}
+	query := `
+		DELETE FROM stocks_operations_history
+		WHERE portfolio_id = $1 AND operation_id = $2
+		`
+
+	slog.Debug("DeleteStockOperation start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("DeleteStockOperation failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("DeleteStockOperation completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	res, err := r.txOrDb(ctx).ExecContext(ctx, query, portfolioID, operationID)
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return repository.ErrNotFound
+	}
+
+	return nil
+}
+
+// DeletePortfolioStockRemainings удаляет все открытые лоты портфеля (перед пересборкой из истории)
+func (r *Postgres) DeletePortfolioStockRemainings(ctx context.Context, portfolioID int64) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.DeletePortfolioStockRemainings"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+	}
+	query := `
+		DELETE FROM stock_remainings
+		WHERE portfolio_id = $1
+		`
+
+	slog.Debug("DeletePortfolioStockRemainings start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("DeletePortfolioStockRemainings failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("DeletePortfolioStockRemainings completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, query, portfolioID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeletePortfolioRealizedLots удаляет реализованный результат портфеля (перед пересборкой из истории)
+func (r *Postgres) DeletePortfolioRealizedLots(ctx context.Context, portfolioID int64) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.DeletePortfolioRealizedLots"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+	}
+	query := `
+		DELETE FROM realized_pnl
+		WHERE portfolio_id = $1
+		`
+
+	slog.Debug("DeletePortfolioRealizedLots start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("DeletePortfolioRealizedLots failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("DeletePortfolioRealizedLots completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, query, portfolioID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Postgres) DeletePortfolioStockOperations(ctx context.Context, portfolioID int64) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.DeletePortfolioStockOperations"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+	}
+	query := `
+		DELETE FROM stocks_operations_history
+		WHERE portfolio_id = $1
+		`
+
+	slog.Debug("DeletePortfolioStockOperations start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("DeletePortfolioStockOperations failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("DeletePortfolioStockOperations completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, query, portfolioID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetPortfolioStockQuantities проставляет количество бумагам портфеля. Бумагам, которых нет в quantities, ставится 0.
+func (r *Postgres) SetPortfolioStockQuantities(ctx context.Context, portfolioID int64, quantities map[string]int) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.SetPortfolioStockQuantities"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+		"quantities":  quantities,
+	}
+	query := `
+		UPDATE stocks_portfolio_details s
+		SET quantity = COALESCE((
+			SELECT u.quantity FROM UNNEST($2::text[], $3::integer[]) AS u(ticker, quantity)
+			WHERE u.ticker = s.ticker
+		), 0)
+		WHERE s.portfolio_id = $1
+		`
+
+	tickers := make([]string, 0, len(quantities))
+	values := make([]int, 0, len(quantities))
+	for ticker, quantity := range quantities {
+		tickers = append(tickers, ticker)
+		values = append(values, quantity)
+	}
+
+	slog.Debug("SetPortfolioStockQuantities start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("SetPortfolioStockQuantities failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("SetPortfolioStockQuantities completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, query, portfolioID, tickers, values)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteTradeCashOperations удаляет денежные проводки, привязанные к сделкам портфеля,
+// и возвращает сделки, по которым они были
+func (r *Postgres) DeleteTradeCashOperations(ctx context.Context, portfolioID int64) (operationIDs []int64, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.DeleteTradeCashOperations"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+	}
+	query := `
+		WITH deleted AS (
+			DELETE FROM cash_operations
+			WHERE portfolio_id = $1 AND operation_id IS NOT NULL
+			RETURNING operation_id
+		)
+		SELECT DISTINCT operation_id FROM deleted
+		`
+
+	slog.Debug("DeleteTradeCashOperations start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("DeleteTradeCashOperations failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("DeleteTradeCashOperations completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	err = r.txOrDb(ctx).SelectContext(ctx, &operationIDs, query, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+
+	return operationIDs, nil
+}
+
+// GetAllCashOperations возвращает все операции с деньгами портфеля, от старых к новым
+func (r *Postgres) GetAllCashOperations(ctx context.Context, portfolioID int64) (operations []model.CashOperation, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetAllCashOperations"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+	}
+	query := `
+		SELECT row_id, portfolio_id, operation_type, amount, ticker, COALESCE(operation_id, 0) AS operation_id, dt_create FROM cash_operations
+		WHERE portfolio_id = $1
+		ORDER BY dt_create, row_id
+		`
+
+	slog.Debug("GetAllCashOperations start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("GetAllCashOperations failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetAllCashOperations completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	rows, err := r.txOrDb(ctx).QueryxContext(ctx, query, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var operationDb dbModel.CashOperation
+		err = rows.StructScan(&operationDb)
+		if err != nil {
+			return nil, err
+		}
+		operations = append(operations, dbConverter.ConvertCashOperation(operationDb))
+	}
+
+	return operations, nil
+}
+
+// GetLastStockOperationDate возвращает дату последней операции портфеля (нулевое время, если операций не было)
+func (r *Postgres) GetLastStockOperationDate(ctx context.Context, portfolioID int64) (dtCreate time.Time, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetLastStockOperationDate"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+	}
+	query := `
+		SELECT MAX(dt_create) FROM stocks_operations_history
+		WHERE portfolio_id = $1
+		`
+
+	slog.Debug("GetLastStockOperationDate start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("GetLastStockOperationDate failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetLastStockOperationDate completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	var lastDate sql.NullTime
+	err = r.txOrDb(ctx).QueryRowxContext(ctx, query, portfolioID).Scan(&lastDate)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return lastDate.Time, nil
+}
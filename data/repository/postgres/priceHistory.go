@@ -0,0 +1,119 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/internal/model/moexModel"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+	"github.com/shopspring/decimal"
+)
+
+// GetPriceHistoryStates возвращает все тикеры из портфелей с датой последней сохраненной свечи
+func (r *Postgres) GetPriceHistoryStates(ctx context.Context) (states []model.PriceHistoryState, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetPriceHistoryStates"
+	query := `
+		SELECT d.ticker, d.board, MAX(h.trade_date) AS last_trade_date
+		FROM (SELECT DISTINCT ticker, board FROM stocks_portfolio_details) d
+		LEFT JOIN price_history h ON h.ticker = d.ticker AND h.board = d.board
+		GROUP BY d.ticker, d.board
+		`
+
+	slog.Debug("GetPriceHistoryStates start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query))
+	defer func() {
+		if err != nil {
+			slog.Error("GetPriceHistoryStates failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetPriceHistoryStates completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	rows, err := r.txOrDb(ctx).QueryxContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var state model.PriceHistoryState
+		var lastTradeDate sql.NullTime
+		err = rows.Scan(&state.Ticker, &state.Board, &lastTradeDate)
+		if err != nil {
+			return nil, err
+		}
+		if lastTradeDate.Valid {
+			state.LastTradeDate = lastTradeDate.Time
+		}
+		states = append(states, state)
+	}
+
+	return states, nil
+}
+
+func (r *Postgres) UpsertPriceHistory(ctx context.Context, candles []moexModel.PriceCandle) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.UpsertPriceHistory"
+	params := map[string]any{
+		"candlesCount": len(candles),
+	}
+	query := `
+		INSERT INTO price_history(ticker, board, trade_date, open, high, low, close, volume)
+		SELECT u.ticker, u.board, u.trade_date, u.open, u.high, u.low, u.close, u.volume
+		FROM UNNEST(
+			$1::text[],
+			$2::text[],
+			$3::date[],
+			$4::decimal[],
+			$5::decimal[],
+			$6::decimal[],
+			$7::decimal[],
+			$8::bigint[]
+		) AS u(ticker, board, trade_date, open, high, low, close, volume)
+		ON CONFLICT ON CONSTRAINT price_history_pk DO UPDATE
+		SET open = EXCLUDED.open,
+			high = EXCLUDED.high,
+			low = EXCLUDED.low,
+			close = EXCLUDED.close,
+			volume = EXCLUDED.volume
+		`
+
+	tickers := make([]string, 0, len(candles))
+	boards := make([]string, 0, len(candles))
+	tradeDates := make([]time.Time, 0, len(candles))
+	opens := make([]decimal.Decimal, 0, len(candles))
+	highs := make([]decimal.Decimal, 0, len(candles))
+	lows := make([]decimal.Decimal, 0, len(candles))
+	closes := make([]decimal.Decimal, 0, len(candles))
+	volumes := make([]int64, 0, len(candles))
+	for _, candle := range candles {
+		tickers = append(tickers, candle.Ticker)
+		boards = append(boards, candle.Board)
+		tradeDates = append(tradeDates, candle.TradeDate)
+		opens = append(opens, candle.Open)
+		highs = append(highs, candle.High)
+		lows = append(lows, candle.Low)
+		closes = append(closes, candle.Close)
+		volumes = append(volumes, candle.Volume)
+	}
+
+	slog.Debug("UpsertPriceHistory start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("UpsertPriceHistory failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("UpsertPriceHistory completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, query, tickers, boards, tradeDates, opens, highs, lows, closes, volumes)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package postgres
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/converter/dbConverter"
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/internal/model/dbModel"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+	"github.com/shopspring/decimal"
+)
+
+func (r *Postgres) InsertRealizedLots(ctx context.Context, portfolioID int64, lots []model.RealizedLot) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.InsertRealizedLots"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+		"lots":        lots,
+	}
+	query := `
+		INSERT INTO realized_pnl(portfolio_id, ticker, quantity, buy_price, sell_price, buy_date, sell_date)
+		SELECT $1, u.ticker, u.quantity, u.buy_price, u.sell_price, u.buy_date, u.sell_date
+		FROM UNNEST(
+			$2::text[],
+			$3::integer[],
+			$4::decimal[],
+			$5::decimal[],
+			$6::timestamptz[],
+			$7::timestamptz[]
+		) AS u(ticker, quantity, buy_price, sell_price, buy_date, sell_date)
+		`
+
+	tickers := make([]string, 0, len(lots))
+	quantities := make([]int, 0, len(lots))
+	buyPrices := make([]decimal.Decimal, 0, len(lots))
+	sellPrices := make([]decimal.Decimal, 0, len(lots))
+	buyDates := make([]time.Time, 0, len(lots))
+	sellDates := make([]time.Time, 0, len(lots))
+	for _, lot := range lots {
+		tickers = append(tickers, lot.Ticker)
+		quantities = append(quantities, lot.Quantity)
+		buyPrices = append(buyPrices, lot.BuyPrice)
+		sellPrices = append(sellPrices, lot.SellPrice)
+		buyDates = append(buyDates, lot.BuyDate)
+		sellDates = append(sellDates, lot.SellDate)
+	}
+
+	slog.Debug("InsertRealizedLots start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("InsertRealizedLots failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("InsertRealizedLots completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, query, portfolioID, tickers, quantities, buyPrices, sellPrices, buyDates, sellDates)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetRealizedPnL возвращает реализованный результат портфеля, сгруппированный по году продажи и тикеру
+func (r *Postgres) GetRealizedPnL(ctx context.Context, portfolioID int64) (pnl []model.TickerRealizedPnL, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetRealizedPnL"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+	}
+	query := `
+		SELECT
+			EXTRACT(YEAR FROM sell_date)::int AS year,
+			ticker,
+			SUM(quantity) AS quantity,
+			SUM((sell_price - buy_price) * quantity) AS profit
+		FROM realized_pnl
+		WHERE portfolio_id = $1
+		GROUP BY year, ticker
+		ORDER BY year DESC, ticker
+		`
+
+	slog.Debug("GetRealizedPnL start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("GetRealizedPnL failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetRealizedPnL completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	rows, err := r.txOrDb(ctx).QueryxContext(ctx, query, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var pnlDb dbModel.TickerRealizedPnL
+		err = rows.StructScan(&pnlDb)
+		if err != nil {
+			return nil, err
+		}
+		pnl = append(pnl, dbConverter.ConvertTickerRealizedPnL(pnlDb))
+	}
+
+	return pnl, nil
+}
+
+// GetUserRealizedLots возвращает закрытые лоты всех портфелей владельца портфеля portfolioID
+func (r *Postgres) GetUserRealizedLots(ctx context.Context, portfolioID int64) (lots []model.RealizedLot, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetUserRealizedLots"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+	}
+	query := `
+		SELECT r.portfolio_id, r.ticker, r.quantity, r.buy_price, r.sell_price, r.buy_date, r.sell_date
+		FROM realized_pnl r
+		JOIN portfolios p USING(portfolio_id)
+		WHERE p.user_id = (SELECT user_id FROM portfolios WHERE portfolio_id = $1)
+		ORDER BY r.sell_date, r.row_id
+		`
+
+	slog.Debug("GetUserRealizedLots start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("GetUserRealizedLots failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetUserRealizedLots completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	rows, err := r.txOrDb(ctx).QueryxContext(ctx, query, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var lotDb dbModel.RealizedLot
+		err = rows.StructScan(&lotDb)
+		if err != nil {
+			return nil, err
+		}
+		lots = append(lots, dbConverter.ConvertRealizedLot(lotDb))
+	}
+
+	return lots, nil
+}
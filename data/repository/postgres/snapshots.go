@@ -0,0 +1,213 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/data/repository"
+	"github.com/KotFed0t/invest_helper_bot/internal/converter/dbConverter"
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/internal/model/dbModel"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+	"github.com/shopspring/decimal"
+)
+
+// GetAllStocks возвращает акции всех портфелей всех пользователей, сгруппированные по портфелям
+func (r *Postgres) GetAllStocks(ctx context.Context) (stocksByPortfolios map[int64][]model.StockBase, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetAllStocks"
+	query := `select portfolio_id, ticker, weight, quantity, board, instrument_type from stocks_portfolio_details`
+
+	slog.Debug("GetAllStocks start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query))
+	defer func() {
+		if err != nil {
+			slog.Error("GetAllStocks failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetAllStocks completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	rows, err := r.txOrDb(ctx).QueryxContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	stocksByPortfolios = make(map[int64][]model.StockBase)
+	for rows.Next() {
+		var stock dbModel.Stock
+		err = rows.StructScan(&stock)
+		if err != nil {
+			return nil, err
+		}
+		stocksByPortfolios[stock.PortfolioID] = append(stocksByPortfolios[stock.PortfolioID], dbConverter.ConvertStock(stock))
+	}
+
+	return stocksByPortfolios, nil
+}
+
+// SavePortfolioSnapshot сохраняет снапшот портфеля за дату. Повторный снапшот за ту же дату перезаписывает предыдущий.
+func (r *Postgres) SavePortfolioSnapshot(ctx context.Context, snapshot model.PortfolioSnapshot) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.SavePortfolioSnapshot"
+	params := map[string]any{
+		"portfolioID":  snapshot.PortfolioID,
+		"snapshotDate": snapshot.SnapshotDate,
+		"stocksCount":  len(snapshot.Stocks),
+	}
+	upsertQuery := `
+		INSERT INTO portfolio_snapshots(
+			portfolio_id, snapshot_date, balance_inside_index, balance_outside_index,
+			growth_sum_inside_index, growth_sum_outside_index, index_offset
+		)
+		VALUES($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT ON CONSTRAINT unique_portfolio_snapshot_date DO UPDATE
+		SET balance_inside_index = EXCLUDED.balance_inside_index,
+			balance_outside_index = EXCLUDED.balance_outside_index,
+			growth_sum_inside_index = EXCLUDED.growth_sum_inside_index,
+			growth_sum_outside_index = EXCLUDED.growth_sum_outside_index,
+			index_offset = EXCLUDED.index_offset
+		RETURNING snapshot_id
+		`
+	deleteStocksQuery := `DELETE FROM portfolio_snapshot_stocks WHERE snapshot_id = $1`
+	insertStocksQuery := `
+		INSERT INTO portfolio_snapshot_stocks(snapshot_id, ticker, quantity, price)
+		SELECT $1, u.ticker, u.quantity, u.price
+		FROM UNNEST($2::text[], $3::int[], $4::decimal[]) AS u(ticker, quantity, price)
+		`
+
+	slog.Debug("SavePortfolioSnapshot start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", upsertQuery), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("SavePortfolioSnapshot failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("SavePortfolioSnapshot completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	var snapshotID int64
+	err = r.txOrDb(ctx).QueryRowContext(
+		ctx,
+		upsertQuery,
+		snapshot.PortfolioID,
+		snapshot.SnapshotDate,
+		snapshot.BalanceInsideIndex,
+		snapshot.BalanceOutsideIndex,
+		snapshot.GrowthSumInsideIndex,
+		snapshot.GrowthSumOutsideIndex,
+		snapshot.IndexOffset,
+	).Scan(&snapshotID)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, deleteStocksQuery, snapshotID)
+	if err != nil {
+		return err
+	}
+
+	if len(snapshot.Stocks) == 0 {
+		return nil
+	}
+
+	tickers := make([]string, 0, len(snapshot.Stocks))
+	quantities := make([]int, 0, len(snapshot.Stocks))
+	prices := make([]decimal.Decimal, 0, len(snapshot.Stocks))
+	for _, stock := range snapshot.Stocks {
+		tickers = append(tickers, stock.Ticker)
+		quantities = append(quantities, stock.Quantity)
+		prices = append(prices, stock.Price)
+	}
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, insertStocksQuery, snapshotID, tickers, quantities, prices)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetPortfolioSnapshotOnDate возвращает последний снапшот портфеля не позднее даты date
+func (r *Postgres) GetPortfolioSnapshotOnDate(ctx context.Context, portfolioID int64, date time.Time) (snapshot model.PortfolioSnapshot, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetPortfolioSnapshotOnDate"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+		"date":        date,
+	}
+	query := `
+		SELECT snapshot_id, portfolio_id, snapshot_date, balance_inside_index, balance_outside_index,
+			growth_sum_inside_index, growth_sum_outside_index, index_offset
+		FROM portfolio_snapshots
+		WHERE portfolio_id = $1 AND snapshot_date <= $2
+		ORDER BY snapshot_date DESC
+		LIMIT 1
+		`
+
+	slog.Debug("GetPortfolioSnapshotOnDate start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("GetPortfolioSnapshotOnDate failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetPortfolioSnapshotOnDate completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	var snapshotDb dbModel.PortfolioSnapshot
+	err = r.txOrDb(ctx).QueryRowxContext(ctx, query, portfolioID, date).StructScan(&snapshotDb)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.PortfolioSnapshot{}, repository.ErrNotFound
+		}
+		return model.PortfolioSnapshot{}, err
+	}
+
+	return dbConverter.ConvertPortfolioSnapshot(snapshotDb), nil
+}
+
+// GetPortfolioSnapshots возвращает все снапшоты портфеля по возрастанию даты (без состава)
+func (r *Postgres) GetPortfolioSnapshots(ctx context.Context, portfolioID int64) (snapshots []model.PortfolioSnapshot, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetPortfolioSnapshots"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+	}
+	query := `
+		SELECT snapshot_id, portfolio_id, snapshot_date, balance_inside_index, balance_outside_index,
+			growth_sum_inside_index, growth_sum_outside_index, index_offset
+		FROM portfolio_snapshots
+		WHERE portfolio_id = $1
+		ORDER BY snapshot_date
+		`
+
+	slog.Debug("GetPortfolioSnapshots start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("GetPortfolioSnapshots failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetPortfolioSnapshots completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	rows, err := r.txOrDb(ctx).QueryxContext(ctx, query, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var snapshotDb dbModel.PortfolioSnapshot
+		err = rows.StructScan(&snapshotDb)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, dbConverter.ConvertPortfolioSnapshot(snapshotDb))
+	}
+
+	return snapshots, nil
+}
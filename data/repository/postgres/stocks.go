@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"log/slog"
 	"time"
@@ -10,6 +11,7 @@ import (
 	"github.com/KotFed0t/invest_helper_bot/internal/converter/dbConverter"
 	"github.com/KotFed0t/invest_helper_bot/internal/model"
 	"github.com/KotFed0t/invest_helper_bot/internal/model/dbModel"
+	"github.com/KotFed0t/invest_helper_bot/internal/model/moexModel"
 	"github.com/KotFed0t/invest_helper_bot/utils"
 	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib" // pgx driver
@@ -89,7 +91,7 @@ func (r *Postgres) GetUserID(ctx context.Context, chatID int64) (userID int64, e
 func (r *Postgres) GetStockFromPortfolio(ctx context.Context, ticker string, portfolioID int64) (stock model.StockBase, err error) {
 	rqID := utils.GetRequestIDFromCtx(ctx)
 	query := `
-		SELECT portfolio_id, ticker, weight, quantity
+		SELECT portfolio_id, ticker, weight, quantity, board, instrument_type
 		FROM stocks_portfolio_details 
 		WHERE portfolio_id = $1
 		AND ticker = $2
@@ -145,7 +147,7 @@ func (r *Postgres) getStocksFromPortfolio(ctx context.Context, portfolioID int64
 
 func (r *Postgres) GetStocksFromPortfolio(ctx context.Context, portfolioID int64) (stocks []model.StockBase, err error) {
 	query := `
-		SELECT portfolio_id, ticker, weight, quantity
+		SELECT portfolio_id, ticker, weight, quantity, board, instrument_type
 		FROM stocks_portfolio_details 
 		WHERE portfolio_id = $1
 		order by ticker 
@@ -156,7 +158,7 @@ func (r *Postgres) GetStocksFromPortfolio(ctx context.Context, portfolioID int64
 
 func (r *Postgres) GetOnlyInIndexStocksFromPortfolio(ctx context.Context, portfolioID int64) (stocks []model.StockBase, err error) {
 	query := `
-		SELECT portfolio_id, ticker, weight, quantity
+		SELECT portfolio_id, ticker, weight, quantity, board, instrument_type
 		FROM stocks_portfolio_details 
 		WHERE portfolio_id = $1
 		AND weight > 0
@@ -165,9 +167,9 @@ func (r *Postgres) GetOnlyInIndexStocksFromPortfolio(ctx context.Context, portfo
 	return r.getStocksFromPortfolio(ctx, portfolioID, query)
 }
 
-func (r *Postgres) InsertStockToPortfolio(ctx context.Context, portfolioID int64, ticker string) (err error) {
+func (r *Postgres) InsertStockToPortfolio(ctx context.Context, portfolioID int64, ticker, board string, instrumentType moexModel.InstrumentType) (err error) {
 	rqID := utils.GetRequestIDFromCtx(ctx)
-	query := `INSERT INTO stocks_portfolio_details(portfolio_id, ticker) VALUES($1, $2)`
+	query := `INSERT INTO stocks_portfolio_details(portfolio_id, ticker, board, instrument_type) VALUES($1, $2, $3, $4)`
 
 	slog.Debug("InsertStockToPortfolio start", slog.String("rqID", rqID), slog.String("query", query))
 	defer func() {
@@ -178,7 +180,7 @@ func (r *Postgres) InsertStockToPortfolio(ctx context.Context, portfolioID int64
 		}
 	}()
 
-	_, err = r.txOrDb(ctx).ExecContext(ctx, query, portfolioID, ticker)
+	_, err = r.txOrDb(ctx).ExecContext(ctx, query, portfolioID, ticker, board, string(instrumentType))
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) {
@@ -221,12 +223,13 @@ func (r *Postgres) UpdatePortfolioStock(ctx context.Context, portfolioID int64,
 	return nil
 }
 
-func (r *Postgres) InsertStockOperationToHistory(ctx context.Context, portfolioID int64, stockOperation model.StockOperation) (err error) {
+func (r *Postgres) InsertStockOperationToHistory(ctx context.Context, portfolioID int64, stockOperation model.StockOperation) (operationID int64, err error) {
 	rqID := utils.GetRequestIDFromCtx(ctx)
 	op := "Postgres.InsertStockOperationToHistory"
 	query := `
-		INSERT INTO stocks_operations_history(portfolio_id, ticker, shortname, quantity, price, total_price, currency, dt_create)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		INSERT INTO stocks_operations_history(portfolio_id, ticker, shortname, quantity, price, total_price, currency, dt_create, commission)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING operation_id
 	`
 
 	slog.Debug(
@@ -245,7 +248,7 @@ func (r *Postgres) InsertStockOperationToHistory(ctx context.Context, portfolioI
 		}
 	}()
 
-	_, err = r.txOrDb(ctx).ExecContext(
+	err = r.txOrDb(ctx).QueryRowxContext(
 		ctx,
 		query,
 		portfolioID,
@@ -256,12 +259,13 @@ func (r *Postgres) InsertStockOperationToHistory(ctx context.Context, portfolioI
 		stockOperation.TotalPrice,
 		stockOperation.Currency,
 		stockOperation.DtCreate,
-	)
+		stockOperation.Commission,
+	).Scan(&operationID)
 
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+	return operationID, nil
 }
 
 func (r *Postgres) GetPageStocksFromPortfolio(ctx context.Context, portfolioID int64, limit, offset int) (stocks []model.StockBase, err error) {
@@ -273,7 +277,7 @@ func (r *Postgres) GetPageStocksFromPortfolio(ctx context.Context, portfolioID i
 		"offset":      offset,
 	}
 	query := `
-		SELECT portfolio_id, ticker, weight, quantity
+		SELECT portfolio_id, ticker, weight, quantity, board, instrument_type
 		FROM stocks_portfolio_details 
 		WHERE portfolio_id = $1
 		ORDER BY ticker
@@ -371,6 +375,139 @@ func (r *Postgres) GetPortfolioName(ctx context.Context, portfolioID int64) (nam
 	return name, nil
 }
 
+func (r *Postgres) GetPortfolio(ctx context.Context, portfolioID int64) (portfolio model.Portfolio, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetPortfolio"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+	}
+
+	query := `
+		SELECT portfolio_id, name, index_id, dividend_notifications, commission_percent, purchase_strategy, allow_sells FROM portfolios 
+		WHERE portfolio_id = $1
+		`
+
+	slog.Debug("GetPortfolio start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("GetPortfolio failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetPortfolio completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	dbPortfolio := dbModel.Portfolio{}
+	err = r.txOrDb(ctx).QueryRowxContext(ctx, query, portfolioID).StructScan(&dbPortfolio)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.Portfolio{}, repository.ErrNotFound
+		}
+		return model.Portfolio{}, err
+	}
+
+	return dbConverter.ConvertPortfolio(dbPortfolio), nil
+}
+
+// SetPortfolioIndex привязывает портфель к индексу (nil - отвязать). Отпечаток отправленного расхождения сбрасывается.
+func (r *Postgres) SetPortfolioIndex(ctx context.Context, portfolioID int64, indexID *string) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.SetPortfolioIndex"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+		"indexID":     indexID,
+	}
+
+	query := `
+		UPDATE portfolios
+		SET index_id = $1, index_drift_signature = ''
+		WHERE portfolio_id = $2
+		`
+
+	slog.Debug("SetPortfolioIndex start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("SetPortfolioIndex failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("SetPortfolioIndex completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, query, indexID, portfolioID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Postgres) GetPortfoliosLinkedToIndex(ctx context.Context) (portfolios []model.LinkedPortfolio, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetPortfoliosLinkedToIndex"
+	query := `
+		select p.portfolio_id, p."name", p.index_id, p.index_drift_signature, u.chat_id from portfolios p
+		join users u using(user_id)
+		where p.index_id is not null
+		`
+
+	slog.Debug("GetPortfoliosLinkedToIndex start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query))
+	defer func() {
+		if err != nil {
+			slog.Error("GetPortfoliosLinkedToIndex failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetPortfoliosLinkedToIndex completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	rows, err := r.txOrDb(ctx).QueryxContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var portfolio dbModel.LinkedPortfolio
+		err = rows.StructScan(&portfolio)
+		if err != nil {
+			return nil, err
+		}
+		portfolios = append(portfolios, dbConverter.ConvertLinkedPortfolio(portfolio))
+	}
+
+	return portfolios, nil
+}
+
+func (r *Postgres) SetIndexDriftSignature(ctx context.Context, portfolioID int64, signature string) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.SetIndexDriftSignature"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+		"signature":   signature,
+	}
+
+	query := `
+		UPDATE portfolios
+		SET index_drift_signature = $1
+		WHERE portfolio_id = $2
+		`
+
+	slog.Debug("SetIndexDriftSignature start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("SetIndexDriftSignature failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("SetIndexDriftSignature completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, query, signature, portfolioID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (r *Postgres) GetPortfolios(ctx context.Context, chatID int64, limit, offset int) (portfolios []model.Portfolio, hasNextPage bool, err error) {
 	rqID := utils.GetRequestIDFromCtx(ctx)
 	op := "Postgres.GetPortfolios"
@@ -462,6 +599,88 @@ func (r *Postgres) RebalanceWeights(ctx context.Context, portfolioID int64) (err
 	return nil
 }
 
+// InsertStocksToPortfolio добавляет акции пачкой, уже существующие в портфеле пропускаются
+func (r *Postgres) InsertStocksToPortfolio(ctx context.Context, portfolioID int64, stocks []model.StockBase) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.InsertStocksToPortfolio"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+		"stocks":      stocks,
+	}
+	query := `
+		INSERT INTO stocks_portfolio_details(portfolio_id, ticker, board, instrument_type)
+		SELECT $1, u.ticker, u.board, u.instrument_type
+		FROM UNNEST($2::text[], $3::text[], $4::text[]) AS u(ticker, board, instrument_type)
+		ON CONFLICT ON CONSTRAINT unique_portfolio_ticker DO NOTHING
+		`
+
+	tickers := make([]string, 0, len(stocks))
+	boards := make([]string, 0, len(stocks))
+	instrumentTypes := make([]string, 0, len(stocks))
+	for _, stock := range stocks {
+		tickers = append(tickers, stock.Ticker)
+		boards = append(boards, stock.Board)
+		instrumentTypes = append(instrumentTypes, string(stock.InstrumentType))
+	}
+
+	slog.Debug("InsertStocksToPortfolio start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("InsertStocksToPortfolio failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("InsertStocksToPortfolio completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, query, portfolioID, tickers, boards, instrumentTypes)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetPortfolioWeights проставляет целевые веса по переданным тикерам, остальным акциям портфеля вес обнуляется
+func (r *Postgres) SetPortfolioWeights(ctx context.Context, portfolioID int64, weights map[string]decimal.Decimal) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.SetPortfolioWeights"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+		"weights":     weights,
+	}
+	query := `
+		UPDATE stocks_portfolio_details s
+		SET weight = COALESCE(
+			(SELECT u.weight FROM UNNEST($1::text[], $2::decimal[]) AS u(ticker, weight) WHERE u.ticker = s.ticker),
+			0
+		)
+		WHERE s.portfolio_id = $3
+		`
+
+	tickers := make([]string, 0, len(weights))
+	weightValues := make([]decimal.Decimal, 0, len(weights))
+	for ticker, weight := range weights {
+		tickers = append(tickers, ticker)
+		weightValues = append(weightValues, weight)
+	}
+
+	slog.Debug("SetPortfolioWeights start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("SetPortfolioWeights failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("SetPortfolioWeights completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, query, tickers, weightValues, portfolioID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (r *Postgres) DeletePortfolio(ctx context.Context, portfolioID int64) (err error) {
 	rqID := utils.GetRequestIDFromCtx(ctx)
 	op := "Postgres.DeletePortfolio"
@@ -499,7 +718,7 @@ func (r *Postgres) GetAllStocksByUserID(ctx context.Context, userID int64) (stoc
 		"userID": userID,
 	}
 	query := `
-		select portfolio_id, ticker, weight, quantity from portfolios
+		select portfolio_id, ticker, weight, quantity, board, instrument_type from portfolios
 		join stocks_portfolio_details using(portfolio_id)
 		where user_id = $1
 		`
@@ -542,9 +761,10 @@ func (r *Postgres) GetAllStockOperationsByUserID(ctx context.Context, userID int
 		"userID": userID,
 	}
 	query := `
-		select portfolio_id, ticker, shortname, quantity, price, total_price, currency, dt_create from portfolios
+		select operation_id, portfolio_id, ticker, shortname, quantity, price, total_price, commission, currency, dt_create, external_id, operation_type, dt_acquired, COALESCE(transfer_portfolio_id, 0) AS transfer_portfolio_id, COALESCE(ratio_from, 0) AS ratio_from, COALESCE(ratio_to, 0) AS ratio_to from portfolios
 		join stocks_operations_history using(portfolio_id)
 		where user_id = $1
+		order by dt_create, operation_id
 		`
 
 	slog.Debug("GetAllStockOperationsByUserID start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
@@ -657,7 +877,7 @@ func (r *Postgres) UpdateQuantityPortfolioStocks(ctx context.Context, portfolioI
 	return nil
 }
 
-func (r *Postgres) InsertStockOperationsToHistory(ctx context.Context, portfolioID int64, stockOperations []model.StockOperation) (err error) {
+func (r *Postgres) InsertStockOperationsToHistory(ctx context.Context, portfolioID int64, stockOperations []model.StockOperation) (operationIDs []int64, err error) {
 	rqID := utils.GetRequestIDFromCtx(ctx)
 	op := "Postgres.InsertStockOperationsToHistory"
 	params := map[string]any{
@@ -666,10 +886,12 @@ func (r *Postgres) InsertStockOperationsToHistory(ctx context.Context, portfolio
 	}
 	query := `
         INSERT INTO stocks_operations_history(
-            portfolio_id, ticker, shortname, quantity,
-            price, total_price, currency, dt_create
+            operation_id, portfolio_id, ticker, shortname, quantity,
+            price, total_price, currency, dt_create, commission, external_id, operation_type,
+            dt_acquired, transfer_portfolio_id, ratio_from, ratio_to
         )
         SELECT 
+            u.operation_id,
             $1, -- portfolio_id
             u.ticker, 
             u.shortname, 
@@ -677,15 +899,33 @@ func (r *Postgres) InsertStockOperationsToHistory(ctx context.Context, portfolio
             u.price, 
             u.total_price, 
             $2, -- currency
-            u.dt_create
+            u.dt_create,
+            u.commission,
+            u.external_id,
+            COALESCE(NULLIF(u.operation_type, ''), 'trade'),
+            u.dt_acquired,
+            NULLIF(u.transfer_portfolio_id, 0),
+            NULLIF(u.ratio_from, 0),
+            NULLIF(u.ratio_to, 0)
         FROM UNNEST(
             $3::text[],
             $4::text[],
             $5::integer[],
             $6::decimal[],
             $7::decimal[],
-            $8::timestamptz[]
-        ) AS u(ticker, shortname, quantity, price, total_price, dt_create)`
+            $8::timestamptz[],
+            $9::decimal[],
+            $10::bigint[],
+            $11::text[],
+            $12::text[],
+            $13::timestamptz[],
+            $14::bigint[],
+            $15::integer[],
+            $16::integer[]
+        ) AS u(ticker, shortname, quantity, price, total_price, dt_create, commission, operation_id, external_id, operation_type, dt_acquired, transfer_portfolio_id, ratio_from, ratio_to)`
+
+	// идентификаторы выделяем заранее, чтобы вернуть их в порядке переданных операций
+	idsQuery := `SELECT nextval(pg_get_serial_sequence('stocks_operations_history', 'operation_id')) FROM generate_series(1, $1)`
 
 	tickers := make([]string, 0, len(stockOperations))
 	shortNames := make([]string, 0, len(stockOperations))
@@ -693,6 +933,13 @@ func (r *Postgres) InsertStockOperationsToHistory(ctx context.Context, portfolio
 	prices := make([]decimal.Decimal, 0, len(stockOperations))
 	totalPrices := make([]decimal.Decimal, 0, len(stockOperations))
 	dtCreates := make([]time.Time, 0, len(stockOperations))
+	commissions := make([]decimal.Decimal, 0, len(stockOperations))
+	externalIDs := make([]string, 0, len(stockOperations))
+	operationTypes := make([]string, 0, len(stockOperations))
+	dtAcquireds := make([]*time.Time, 0, len(stockOperations))
+	transferPortfolioIDs := make([]int64, 0, len(stockOperations))
+	ratiosFrom := make([]int, 0, len(stockOperations))
+	ratiosTo := make([]int, 0, len(stockOperations))
 
 	for _, op := range stockOperations {
 		tickers = append(tickers, op.Ticker)
@@ -701,6 +948,17 @@ func (r *Postgres) InsertStockOperationsToHistory(ctx context.Context, portfolio
 		prices = append(prices, op.Price)
 		totalPrices = append(totalPrices, op.TotalPrice)
 		dtCreates = append(dtCreates, op.DtCreate)
+		commissions = append(commissions, op.Commission)
+		externalIDs = append(externalIDs, op.ExternalID)
+		operationTypes = append(operationTypes, string(op.OperationType))
+		if op.DtAcquired.IsZero() {
+			dtAcquireds = append(dtAcquireds, nil)
+		} else {
+			dtAcquireds = append(dtAcquireds, &op.DtAcquired)
+		}
+		transferPortfolioIDs = append(transferPortfolioIDs, op.TransferPortfolioID)
+		ratiosFrom = append(ratiosFrom, op.RatioFrom)
+		ratiosTo = append(ratiosTo, op.RatioTo)
 	}
 
 	slog.Debug(
@@ -718,6 +976,11 @@ func (r *Postgres) InsertStockOperationsToHistory(ctx context.Context, portfolio
 		}
 	}()
 
+	err = r.txOrDb(ctx).SelectContext(ctx, &operationIDs, idsQuery, len(stockOperations))
+	if err != nil {
+		return nil, err
+	}
+
 	_, err = r.txOrDb(ctx).ExecContext(
 		ctx,
 		query,
@@ -729,12 +992,20 @@ func (r *Postgres) InsertStockOperationsToHistory(ctx context.Context, portfolio
 		prices,
 		totalPrices,
 		dtCreates,
+		commissions,
+		operationIDs,
+		externalIDs,
+		operationTypes,
+		dtAcquireds,
+		transferPortfolioIDs,
+		ratiosFrom,
+		ratiosTo,
 	)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return operationIDs, nil
 }
 
 func (r *Postgres) GetAverageStockPurchasePrice(ctx context.Context, portfolioID int64, ticker string) (avgPrice decimal.Decimal, err error) {
@@ -846,8 +1117,8 @@ func (r *Postgres) InsertStockRemaining(ctx context.Context, portfolioID int64,
 	rqID := utils.GetRequestIDFromCtx(ctx)
 	op := "Postgres.InsertStockOperationToRemainings"
 	query := `
-		INSERT INTO stock_remainings(portfolio_id, ticker, quantity, price)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO stock_remainings(portfolio_id, ticker, quantity, price, dt_create, dt_update)
+		VALUES ($1, $2, $3, $4, $5, $6)
 	`
 
 	slog.Debug(
@@ -873,6 +1144,8 @@ func (r *Postgres) InsertStockRemaining(ctx context.Context, portfolioID int64,
 		stockRemaining.Ticker,
 		stockRemaining.Quantity,
 		stockRemaining.Price,
+		stockRemaining.DtCreate,
+		stockRemaining.DtUpdate,
 	)
 
 	if err != nil {
@@ -890,27 +1163,35 @@ func (r *Postgres) InsertStockRemainings(ctx context.Context, portfolioID int64,
 	}
 	query := `
         INSERT INTO stock_remainings(
-            portfolio_id, ticker, quantity, price
+            portfolio_id, ticker, quantity, price, dt_create, dt_update
         )
         SELECT 
             $1, -- portfolio_id
             u.ticker, 
             u.quantity,
-            u.price
+            u.price,
+            u.dt_create,
+            u.dt_update
         FROM UNNEST(
             $2::text[],
             $3::integer[],
-            $4::decimal[]
-        ) AS u(ticker, quantity, price)`
+            $4::decimal[],
+            $5::timestamptz[],
+            $6::timestamptz[]
+        ) AS u(ticker, quantity, price, dt_create, dt_update)`
 
 	tickers := make([]string, 0, len(stockRemainings))
 	quantities := make([]int, 0, len(stockRemainings))
 	prices := make([]decimal.Decimal, 0, len(stockRemainings))
+	dtCreates := make([]time.Time, 0, len(stockRemainings))
+	dtUpdates := make([]time.Time, 0, len(stockRemainings))
 
 	for _, op := range stockRemainings {
 		tickers = append(tickers, op.Ticker)
 		quantities = append(quantities, op.Quantity)
 		prices = append(prices, op.Price)
+		dtCreates = append(dtCreates, op.DtCreate)
+		dtUpdates = append(dtUpdates, op.DtUpdate)
 	}
 
 	slog.Debug(
@@ -935,6 +1216,8 @@ func (r *Postgres) InsertStockRemainings(ctx context.Context, portfolioID int64,
 		tickers,
 		quantities,
 		prices,
+		dtCreates,
+		dtUpdates,
 	)
 
 	if err != nil {
@@ -956,7 +1239,7 @@ func (r *Postgres) GetStockRemainingsForUpdate(ctx context.Context, portfolioID
 		FROM stock_remainings
 		WHERE portfolio_id = $1
 		AND ticker = $2
-		ORDER BY dt_create ASC
+		ORDER BY dt_create ASC, row_id ASC
 		FOR UPDATE
 	`
 
@@ -1049,3 +1332,178 @@ func (r *Postgres) DeleteStockRemainings(ctx context.Context, rowIDs ...int64) (
 // по кэшированию пока хз как лучше. По сути просто все сразу кэшировать (мапу), так как нам надо удостоверяться что именно акции нет, а с единичными так не получится.
 
 // будем хранить все такие по одной и тех которых нет просто с нулем. Операция где все селектим все равно только в portfolioSummary будет.
+
+func (r *Postgres) GetStockOperations(ctx context.Context, portfolioID int64) (stockOperations []model.StockOperation, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetStockOperations"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+	}
+	query := `
+		select operation_id, portfolio_id, ticker, shortname, quantity, price, total_price, commission, currency, dt_create, external_id, operation_type, dt_acquired, COALESCE(transfer_portfolio_id, 0) AS transfer_portfolio_id, COALESCE(ratio_from, 0) AS ratio_from, COALESCE(ratio_to, 0) AS ratio_to from stocks_operations_history
+		where portfolio_id = $1
+		order by dt_create, operation_id
+		`
+
+	slog.Debug("GetStockOperations start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("GetStockOperations failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetStockOperations completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	rows, err := r.txOrDb(ctx).QueryxContext(ctx, query, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var stockOperation dbModel.StockOperation
+		err = rows.StructScan(&stockOperation)
+		if err != nil {
+			return nil, err
+		}
+		stockOperations = append(stockOperations, dbConverter.ConvertStockOperation(stockOperation))
+	}
+
+	return stockOperations, nil
+}
+
+// GetStockRemainings возвращает открытые лоты портфеля без блокировки строк (для отчетов и проверок)
+func (r *Postgres) GetStockRemainings(ctx context.Context, portfolioID int64) (stockRemainings []model.StockRemaining, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.GetStockRemainings"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+	}
+	query := `
+		SELECT row_id, portfolio_id, ticker, quantity, price, dt_create, dt_update
+		FROM stock_remainings
+		WHERE portfolio_id = $1
+		ORDER BY ticker, dt_create ASC, row_id ASC
+		`
+
+	slog.Debug("GetStockRemainings start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("GetStockRemainings failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("GetStockRemainings completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	rows, err := r.txOrDb(ctx).QueryxContext(ctx, query, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var stockRemaining dbModel.StockRemaining
+		err = rows.StructScan(&stockRemaining)
+		if err != nil {
+			return nil, err
+		}
+		stockRemainings = append(stockRemainings, dbConverter.ConvertStockRemaining(stockRemaining))
+	}
+
+	return stockRemainings, nil
+}
+
+func (r *Postgres) SetCommissionPercent(ctx context.Context, portfolioID int64, percent decimal.Decimal) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.SetCommissionPercent"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+		"percent":     percent,
+	}
+
+	query := `
+		UPDATE portfolios
+		SET commission_percent = $1
+		WHERE portfolio_id = $2
+		`
+
+	slog.Debug("SetCommissionPercent start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("SetCommissionPercent failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("SetCommissionPercent completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, query, percent, portfolioID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Postgres) SetPurchaseStrategy(ctx context.Context, portfolioID int64, strategy model.PurchaseStrategy) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.SetPurchaseStrategy"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+		"strategy":    strategy,
+	}
+
+	query := `
+		UPDATE portfolios
+		SET purchase_strategy = $1
+		WHERE portfolio_id = $2
+		`
+
+	slog.Debug("SetPurchaseStrategy start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("SetPurchaseStrategy failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("SetPurchaseStrategy completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, query, strategy, portfolioID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Postgres) SetAllowSells(ctx context.Context, portfolioID int64, allowed bool) (err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Postgres.SetAllowSells"
+	params := map[string]any{
+		"portfolioID": portfolioID,
+		"allowed":     allowed,
+	}
+
+	query := `
+		UPDATE portfolios
+		SET allow_sells = $1
+		WHERE portfolio_id = $2
+		`
+
+	slog.Debug("SetAllowSells start", slog.String("rqID", rqID), slog.String("op", op), slog.String("query", query), slog.Any("params", params))
+	defer func() {
+		if err != nil {
+			slog.Error("SetAllowSells failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		} else {
+			slog.Debug("SetAllowSells completed", slog.String("rqID", rqID), slog.String("op", op))
+		}
+	}()
+
+	_, err = r.txOrDb(ctx).ExecContext(ctx, query, allowed, portfolioID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -3,33 +3,63 @@ package dbConverter
 import (
 	"github.com/KotFed0t/invest_helper_bot/internal/model"
 	"github.com/KotFed0t/invest_helper_bot/internal/model/dbModel"
+	"github.com/KotFed0t/invest_helper_bot/internal/model/moexModel"
 )
 
 func ConvertStock(dbStock dbModel.Stock) model.StockBase {
 	return model.StockBase{
-		PortfolioID:  dbStock.PortfolioID,
-		Ticker:       dbStock.Ticker,
-		TargetWeight: dbStock.Weight,
-		Quantity:     dbStock.Quantity,
+		PortfolioID:    dbStock.PortfolioID,
+		Ticker:         dbStock.Ticker,
+		TargetWeight:   dbStock.Weight,
+		Quantity:       dbStock.Quantity,
+		Board:          dbStock.Board,
+		InstrumentType: moexModel.InstrumentType(dbStock.InstrumentType),
 	}
 }
 
 func ConvertStockOperation(dbStock dbModel.StockOperation) model.StockOperation {
-	return model.StockOperation{
-		Ticker:     dbStock.Ticker,
-		Shortname:  dbStock.Shortname,
-		Quantity:   dbStock.Quantity,
-		Price:      dbStock.Price,
-		TotalPrice: dbStock.TotalPrice,
-		Currency:   dbStock.Currency,
-		DtCreate:   dbStock.DtCreate,
+	operation := model.StockOperation{
+		OperationID: dbStock.OperationID,
+		Ticker:      dbStock.Ticker,
+		Shortname:   dbStock.Shortname,
+		Quantity:    dbStock.Quantity,
+		Price:       dbStock.Price,
+		TotalPrice:  dbStock.TotalPrice,
+		Commission:  dbStock.Commission,
+		Currency:    dbStock.Currency,
+		DtCreate:    dbStock.DtCreate,
+		ExternalID:  dbStock.ExternalID,
+		OperationType: model.StockOperationType(dbStock.OperationType),
+		TransferPortfolioID: dbStock.TransferPortfolioID,
+		RatioFrom: dbStock.RatioFrom,
+		RatioTo: dbStock.RatioTo,
 	}
+	if dbStock.DtAcquired != nil {
+		operation.DtAcquired = *dbStock.DtAcquired
+	}
+	return operation
 }
 
 func ConvertPortfolio(dbPortfolio dbModel.Portfolio) model.Portfolio {
-	return model.Portfolio{
+	portfolio := model.Portfolio{
 		PortfolioID:   dbPortfolio.PortfolioID,
 		PortfolioName: dbPortfolio.Name,
+		DividendNotifications: dbPortfolio.DividendNotifications,
+		CommissionPercent: dbPortfolio.CommissionPercent,
+		PurchaseStrategy: model.PurchaseStrategy(dbPortfolio.PurchaseStrategy),
+		AllowSells: dbPortfolio.AllowSells,
+	}
+	if dbPortfolio.IndexID != nil {
+		portfolio.IndexID = *dbPortfolio.IndexID
+	}
+	return portfolio
+}
+
+func ConvertLinkedPortfolio(dbPortfolio dbModel.LinkedPortfolio) model.LinkedPortfolio {
+	return model.LinkedPortfolio{
+		Portfolio:           ConvertPortfolio(dbPortfolio.Portfolio),
+		ChatID:              dbPortfolio.ChatID,
+		IndexDriftSignature: dbPortfolio.IndexDriftSignature,
 	}
 }
 
@@ -43,4 +73,95 @@ func ConvertStockRemaining(stockRemaining dbModel.StockRemaining) model.StockRem
 		DtCreate: stockRemaining.DtCreate,
 		DtUpdate: stockRemaining.DtUpdate,
 	}
-}
\ No newline at end of file
+}
+
+func ConvertPortfolioSnapshot(snapshot dbModel.PortfolioSnapshot) model.PortfolioSnapshot {
+	return model.PortfolioSnapshot{
+		SnapshotID:            snapshot.SnapshotID,
+		PortfolioID:           snapshot.PortfolioID,
+		SnapshotDate:          snapshot.SnapshotDate,
+		BalanceInsideIndex:    snapshot.BalanceInsideIndex,
+		BalanceOutsideIndex:   snapshot.BalanceOutsideIndex,
+		GrowthSumInsideIndex:  snapshot.GrowthSumInsideIndex,
+		GrowthSumOutsideIndex: snapshot.GrowthSumOutsideIndex,
+		IndexOffset:           snapshot.IndexOffset,
+	}
+}
+
+func ConvertTickerRealizedPnL(pnl dbModel.TickerRealizedPnL) model.TickerRealizedPnL {
+	return model.TickerRealizedPnL{
+		Year:     pnl.Year,
+		Ticker:   pnl.Ticker,
+		Quantity: pnl.Quantity,
+		Profit:   pnl.Profit,
+	}
+}
+
+func ConvertRealizedLot(lot dbModel.RealizedLot) model.RealizedLot {
+	return model.RealizedLot{
+		PortfolioID: lot.PortfolioID,
+		Ticker:      lot.Ticker,
+		Quantity:    lot.Quantity,
+		BuyPrice:    lot.BuyPrice,
+		SellPrice:   lot.SellPrice,
+		BuyDate:     lot.BuyDate,
+		SellDate:    lot.SellDate,
+	}
+}
+
+func ConvertDividend(dividend dbModel.Dividend) moexModel.Dividend {
+	return moexModel.Dividend{
+		Ticker:            dividend.Ticker,
+		RegistryCloseDate: dividend.RegistryCloseDate,
+		Value:             dividend.Value,
+		CurrencyID:        dividend.Currency,
+	}
+}
+
+func ConvertDividendIncome(income dbModel.DividendIncome) model.DividendIncome {
+	return model.DividendIncome{
+		RowID:          income.RowID,
+		PortfolioID:    income.PortfolioID,
+		Ticker:         income.Ticker,
+		DividendDate:   income.DividendDate,
+		Quantity:       income.Quantity,
+		AmountPerShare: income.AmountPerShare,
+		GrossAmount:    income.GrossAmount,
+		TaxAmount:      income.TaxAmount,
+		NetAmount:      income.NetAmount,
+		Source:         model.DividendSource(income.Source),
+	}
+}
+
+func ConvertUpcomingDividend(dividend dbModel.UpcomingDividend) model.UpcomingDividend {
+	return model.UpcomingDividend{
+		PortfolioID:       dividend.PortfolioID,
+		PortfolioName:     dividend.PortfolioName,
+		ChatID:            dividend.ChatID,
+		Ticker:            dividend.Ticker,
+		Quantity:          dividend.Quantity,
+		RegistryCloseDate: dividend.RegistryCloseDate,
+		AmountPerShare:    dividend.Value,
+		Currency:          dividend.Currency,
+	}
+}
+
+func ConvertCashOperation(operation dbModel.CashOperation) model.CashOperation {
+	return model.CashOperation{
+		RowID:         operation.RowID,
+		PortfolioID:   operation.PortfolioID,
+		OperationType: model.CashOperationType(operation.OperationType),
+		Amount:        operation.Amount,
+		Ticker:        operation.Ticker,
+		OperationID:   operation.OperationID,
+		DtCreate:      operation.DtCreate,
+	}
+}
+
+func ConvertContributionAllocation(allocation dbModel.ContributionAllocation) model.ContributionAllocation {
+	return model.ContributionAllocation{
+		PortfolioID:   allocation.PortfolioID,
+		PortfolioName: allocation.PortfolioName,
+		Percent:       allocation.Percent,
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/KotFed0t/invest_helper_bot/internal/model"
 	"github.com/KotFed0t/invest_helper_bot/internal/model/moexModel"
@@ -17,17 +18,33 @@ func PortfolioDetailsResponse(portfolio model.PortfolioPage, stocksPerPage int)
 	var sb strings.Builder
 
 	// Заголовок портфеля
-	sb.WriteString(fmt.Sprintf("📊 Портфель: %s\n\n", portfolio.PortfolioName))
+	sb.WriteString(fmt.Sprintf("📊 Портфель: %s\n", portfolio.PortfolioName))
+	if portfolio.IndexID != "" {
+		sb.WriteString(fmt.Sprintf("🔗 Следует за индексом %s\n", portfolio.IndexID))
+	}
+	sb.WriteString("\n")
 	sb.WriteString("💰 Балансы: \n")
 	sb.WriteString(fmt.Sprintf("▸ в индексе: %s ₽\n", portfolio.BalanceInsideIndex.StringFixed(2)))
-	sb.WriteString(fmt.Sprintf("▸ вне индекса: %s ₽\n\n", portfolio.BalanceOutsideIndex.StringFixed(2)))
+	sb.WriteString(fmt.Sprintf("▸ вне индекса: %s ₽\n", portfolio.BalanceOutsideIndex.StringFixed(2)))
+	sb.WriteString(fmt.Sprintf("▸ свободные деньги: %s ₽\n", portfolio.CashBalance.StringFixed(2)))
+	sb.WriteString(fmt.Sprintf("▸ всего: %s ₽\n\n", portfolio.TotalBalance.StringFixed(2)))
 
 	sb.WriteString("📈 Показатели роста: \n")
 	sb.WriteString(fmt.Sprintf("▸ в индексе: %s%% (%s ₽)\n", portfolio.GrowthPercentInsideIndex.StringFixed(2), portfolio.GrowthSumInsideIndex.StringFixed(2)))
 	sb.WriteString(fmt.Sprintf("▸ вне индекса: %s%% (%s ₽)\n\n", portfolio.GrowthPercentOutsideIndex.StringFixed(2), portfolio.GrowthSumOutsideIndex.StringFixed(2)))
 
+	writePortfolioReturns(&sb, portfolio.Returns)
+	writeRealizedPnL(&sb, portfolio.RealizedPnL)
+	if !portfolio.DividendsReceived.IsZero() {
+		sb.WriteString(fmt.Sprintf("🪙 Дивиденды получено: %s ₽ (в этом году %s ₽)\n\n", portfolio.DividendsReceived.StringFixed(2), portfolio.DividendsReceivedThisYear.StringFixed(2)))
+	}
+
 	sb.WriteString(fmt.Sprintf("⚖️ Текущий вес %s%%\n", portfolio.TotalWeight.StringFixed(2)))
-	sb.WriteString(fmt.Sprintf("🔀 Отклонение от индекса %s%%\n\n", portfolio.IndexOffset.StringFixed(2)))
+	sb.WriteString(fmt.Sprintf("🔀 Отклонение от индекса %s%%\n", portfolio.IndexOffset.StringFixed(2)))
+	if portfolio.CommissionPercent.IsPositive() {
+		sb.WriteString(fmt.Sprintf("💸 Комиссия по сделкам %s%%\n", portfolio.CommissionPercent.String()))
+	}
+	sb.WriteString("\n")
 
 	// Состав портфеля
 	sb.WriteString("📋 Состав портфеля:\n\n")
@@ -63,9 +80,15 @@ func PortfolioDetailsResponse(portfolio model.PortfolioPage, stocksPerPage int)
 
 	addStockBtn := markup.Data("✚ Добавить акцию", tgCallback.AddStock)
 
-	var calculatePurchaseBtn tele.Btn
+	var calculatePurchaseBtn, rebalanceBtn tele.Btn
 	if portfolio.StocksCount > portfolio.StocksOutsideIndexCnt {
 		calculatePurchaseBtn = markup.Data("Рассчитать закуп", tgCallback.CalculatePurchase)
+		rebalanceBtn = markup.Data("ребалансировка", tgCallback.RebalancePortfolio)
+	}
+
+	var simulateBtn tele.Btn
+	if portfolio.StocksCount > 0 {
+		simulateBtn = markup.Data("что если", tgCallback.SimulatePortfolio)
 	}
 
 	var rebalanceWeights tele.Btn
@@ -73,6 +96,35 @@ func PortfolioDetailsResponse(portfolio model.PortfolioPage, stocksPerPage int)
 		rebalanceWeights = markup.Data("выровнять веса", tgCallback.RebalanceWeights)
 	}
 
+	var historyBtn tele.Btn
+	if portfolio.StocksCount > 0 {
+		historyBtn = markup.Data("история", tgCallback.PortfolioHistory)
+	}
+
+	taxReportBtn := markup.Data("налоги", tgCallback.TaxReport)
+
+	cashBtn := markup.Data("💵 деньги", tgCallback.PortfolioCash)
+
+	commissionBtn := markup.Data("комиссия", tgCallback.SetCommissionPercent)
+
+	operationsBtn := markup.Data("операции", tgCallback.OperationsHistory)
+
+	importReportBtn := markup.Data("📥 загрузить отчет брокера", tgCallback.ImportBrokerReport)
+
+	csvBtn := markup.Data("CSV", tgCallback.PortfolioCsv)
+
+	dividendNotificationsBtn := markup.Data("🔕 уведомления об отсечках", tgCallback.ToggleDividendNotifications)
+	if !portfolio.DividendNotifications {
+		dividendNotificationsBtn = markup.Data("🔔 уведомления об отсечках", tgCallback.ToggleDividendNotifications)
+	}
+
+	syncWithIndexBtn := markup.Data("синхронизировать с индексом", tgCallback.SyncWithIndex)
+
+	var unlinkIndexBtn tele.Btn
+	if portfolio.IndexID != "" {
+		unlinkIndexBtn = markup.Data("отвязать от индекса", tgCallback.UnlinkIndex)
+	}
+
 	var deletePortfolio tele.Btn
 	if portfolio.BalanceInsideIndex.IsZero() && portfolio.BalanceOutsideIndex.IsZero() {
 		deletePortfolio = markup.Data("⚠️ удалить портфель", tgCallback.InitDeletePortfolio)
@@ -82,7 +134,12 @@ func PortfolioDetailsResponse(portfolio model.PortfolioPage, stocksPerPage int)
 
 	markup.Inline(
 		markup.Row(addStockBtn, calculatePurchaseBtn),
-		markup.Row(rebalanceWeights),
+		markup.Row(rebalanceBtn, simulateBtn),
+		markup.Row(historyBtn, taxReportBtn, rebalanceWeights),
+		markup.Row(syncWithIndexBtn, unlinkIndexBtn),
+		markup.Row(cashBtn, commissionBtn, operationsBtn),
+		markup.Row(importReportBtn, csvBtn),
+		markup.Row(dividendNotificationsBtn),
 		markup.Row(stockBtns...),
 		markup.Row(paginationBtns...),
 		markup.Row(deletePortfolio),
@@ -92,6 +149,61 @@ func PortfolioDetailsResponse(portfolio model.PortfolioPage, stocksPerPage int)
 	return sb.String(), markup
 }
 
+func writePortfolioReturns(sb *strings.Builder, returns model.PortfolioReturns) {
+	if !returns.XIRRAvailable && !returns.TWRAvailable {
+		return
+	}
+
+	sb.WriteString("💹 Доходность: \n")
+	if returns.XIRRAvailable {
+		sb.WriteString(fmt.Sprintf("▸ XIRR: %s%% годовых\n", returns.XIRR.StringFixed(2)))
+	}
+	if returns.TWRAvailable {
+		sb.WriteString(fmt.Sprintf("▸ TWR: %s%% с %s\n", returns.TWR.StringFixed(2), returns.TWRSince.Format("02.01.2006")))
+	}
+	sb.WriteString("\n")
+}
+
+func writeRealizedPnL(sb *strings.Builder, realizedPnL []model.YearRealizedPnL) {
+	if len(realizedPnL) == 0 {
+		return
+	}
+
+	sb.WriteString("💵 Реализованный результат: \n")
+	for _, year := range realizedPnL {
+		tickers := make([]string, 0, len(year.ByTicker))
+		for _, tickerPnL := range year.ByTicker {
+			tickers = append(tickers, fmt.Sprintf("%s: %s", tickerPnL.Ticker, tickerPnL.Profit.StringFixed(2)))
+		}
+		sb.WriteString(fmt.Sprintf("▸ %d: %s ₽ (%s)\n", year.Year, year.Profit.StringFixed(2), strings.Join(tickers, ", ")))
+	}
+	sb.WriteString("\n")
+}
+
+func writeStockDividends(sb *strings.Builder, stock model.Stock) {
+	if stock.InstrumentType == moexModel.InstrumentTypeBond {
+		return
+	}
+
+	if !stock.Dividends.TrailingYearValue.IsZero() {
+		sb.WriteString(fmt.Sprintf(
+			"▸ Дивиденды за 12 мес: %s ₽ (доходность %s%%)\n",
+			stock.Dividends.TrailingYearValue.StringFixed(2),
+			stock.Dividends.Yield.StringFixed(2),
+		))
+	}
+	if stock.Dividends.Next != nil {
+		sb.WriteString(fmt.Sprintf(
+			"▸ Ближайшая отсечка: %s, %s ₽ на акцию\n",
+			stock.Dividends.Next.RegistryCloseDate.Format("02.01.2006"),
+			stock.Dividends.Next.Value.StringFixed(2),
+		))
+	}
+	if !stock.Dividends.Received.IsZero() {
+		sb.WriteString(fmt.Sprintf("▸ Получено дивидендов: %s ₽\n", stock.Dividends.Received.StringFixed(2)))
+	}
+}
+
 func StockNotFoundMarkup() (markup *tele.ReplyMarkup) {
 	markup = &tele.ReplyMarkup{}
 	backToPortfolioBtn := markup.Data("назад к портфелю", tgCallback.BackToPortolio)
@@ -108,6 +220,7 @@ func StockDetailResponse(stock model.Stock, stockChanges *model.StockChanges) (t
 	sb := strings.Builder{}
 
 	sb.WriteString(fmt.Sprintf("%s (%s)\n", stock.Ticker, stock.Shortname))
+	sb.WriteString(fmt.Sprintf("▸ Тип: %s, режим торгов %s\n", instrumentTypeName(stock.InstrumentType), stock.Board))
 	sb.WriteString(fmt.Sprintf("▸ Вес: %s%%\n", stock.ActualWeight.StringFixed(2)))
 	sb.WriteString(fmt.Sprintf("▸ Целевой вес: %s%%\n", stock.TargetWeight.StringFixed(2)))
 	sb.WriteString(fmt.Sprintf("▸ ср. цена покупки: %s ₽\n", stock.AvgPrice.StringFixed(2)))
@@ -117,6 +230,8 @@ func StockDetailResponse(stock model.Stock, stockChanges *model.StockChanges) (t
 	sb.WriteString(fmt.Sprintf("▸ Стоимость: %s ₽\n", stock.TotalPrice.StringFixed(2)))
 	sb.WriteString(fmt.Sprintf("▸ Размер лота: %d\n", stock.Lotsize))
 	sb.WriteString(fmt.Sprintf("▸ Цена лота: %s ₽\n", stock.Price.Mul(decimal.NewFromInt(int64(stock.Lotsize))).StringFixed(2)))
+	writeBondInfo(&sb, stock.Bond)
+	writeStockDividends(&sb, stock)
 
 	row1 := make([]tele.Btn, 0, 2)
 
@@ -130,12 +245,24 @@ func StockDetailResponse(stock model.Stock, stockChanges *model.StockChanges) (t
 
 	changeWeightStockBtn := markup.Data("изменить вес", tgCallback.ChangeWeight)
 
+	var dividendsBtn tele.Btn
+	if stock.InstrumentType != moexModel.InstrumentTypeBond {
+		dividendsBtn = markup.Data("дивиденды", tgCallback.StockDividends)
+	}
+
 	var deleteStockBtn tele.Btn
 	if stock.Quantity == 0 {
 		deleteStockBtn = markup.Data("⚠️ удалить из портфеля", tgCallback.DeleteStock)
 	}
 
+	var transferStockBtn tele.Btn
+	if stock.Quantity > 0 {
+		transferStockBtn = markup.Data("🔁 перевести в другой портфель", tgCallback.TransferStock)
+	}
+
 	var changePriceBtn tele.Btn
+	var changeCommissionBtn tele.Btn
+	var changeTradeDateBtn tele.Btn
 	var saveBtn tele.Btn
 
 	if stockChanges != nil {
@@ -153,6 +280,8 @@ func StockDetailResponse(stock model.Stock, stockChanges *model.StockChanges) (t
 			}
 
 			changePriceBtn = markup.Data(fmt.Sprintf("изменить цену %s", operation), tgCallback.ChangePrice)
+			changeCommissionBtn = markup.Data("указать комиссию", tgCallback.ChangeCommission)
+			changeTradeDateBtn = markup.Data("указать дату сделки", tgCallback.ChangeTradeDate)
 
 			if *stockChanges.Quantity > 0 {
 				sb.WriteString(fmt.Sprintf("▸ Акций к покупке: %d шт.\n", *stockChanges.Quantity))
@@ -181,6 +310,16 @@ func StockDetailResponse(stock model.Stock, stockChanges *model.StockChanges) (t
 			}
 			sb.WriteString(fmt.Sprintf("▸ Цена за акцию: %s ₽\n", stockPrice))
 			sb.WriteString(fmt.Sprintf("▸ Сумма %s: %s ₽\n", operation, totalSum))
+			if stockChanges.Commission != nil {
+				sb.WriteString(fmt.Sprintf("▸ Комиссия: %s ₽\n", stockChanges.Commission.StringFixed(2)))
+			} else {
+				sb.WriteString("▸ Комиссия: по тарифу портфеля\n")
+			}
+			if stockChanges.TradeDate != nil {
+				sb.WriteString(fmt.Sprintf("▸ Дата сделки: %s\n", stockChanges.TradeDate.Format("02.01.2006 15:04")))
+			} else {
+				sb.WriteString("▸ Дата сделки: сейчас\n")
+			}
 		}
 
 		saveBtn = markup.Data("сохранить изменения", tgCallback.SaveStockChanges)
@@ -190,8 +329,10 @@ func StockDetailResponse(stock model.Stock, stockChanges *model.StockChanges) (t
 
 	markup.Inline(
 		row1,
-		markup.Row(changePriceBtn),
-		markup.Row(changeWeightStockBtn),
+		markup.Row(changePriceBtn, changeCommissionBtn),
+		markup.Row(changeTradeDateBtn),
+		markup.Row(changeWeightStockBtn, dividendsBtn),
+		markup.Row(transferStockBtn),
 		markup.Row(deleteStockBtn),
 		markup.Row(backToPortfolioBtn),
 		markup.Row(saveBtn),
@@ -200,14 +341,44 @@ func StockDetailResponse(stock model.Stock, stockChanges *model.StockChanges) (t
 	return sb.String(), markup
 }
 
+func instrumentTypeName(instrumentType moexModel.InstrumentType) string {
+	switch instrumentType {
+	case moexModel.InstrumentTypeFund:
+		return "фонд"
+	case moexModel.InstrumentTypeBond:
+		return "облигация"
+	default:
+		return "акция"
+	}
+}
+
+// writeBondInfo дописывает параметры облигации, для акций ничего не делает
+func writeBondInfo(sb *strings.Builder, bond *moexModel.BondInfo) {
+	if bond == nil {
+		return
+	}
+
+	sb.WriteString(fmt.Sprintf("▸ Чистая цена: %s%% от номинала\n", bond.CleanPrice.StringFixed(2)))
+	sb.WriteString(fmt.Sprintf("▸ Номинал: %s ₽\n", bond.FaceValue.StringFixed(2)))
+	sb.WriteString(fmt.Sprintf("▸ НКД: %s ₽\n", bond.AccruedInt.StringFixed(2)))
+	if !bond.NextCoupon.IsZero() {
+		sb.WriteString(fmt.Sprintf("▸ Купон: %s ₽ (%s)\n", bond.CouponValue.StringFixed(2), bond.NextCoupon.Format("02.01.2006")))
+	}
+	if !bond.MatDate.IsZero() {
+		sb.WriteString(fmt.Sprintf("▸ Погашение: %s\n", bond.MatDate.Format("02.01.2006")))
+	}
+}
+
 func StockAddResponse(stock moexModel.StockInfo) (text string, markup *tele.ReplyMarkup) {
 	markup = &tele.ReplyMarkup{}
 	sb := strings.Builder{}
 
 	sb.WriteString(fmt.Sprintf("%s (%s)\n", stock.Ticker, stock.Shortname))
+	sb.WriteString(fmt.Sprintf("▸ Тип: %s, режим торгов %s\n", instrumentTypeName(stock.InstrumentType), stock.Board))
 	sb.WriteString(fmt.Sprintf("▸ Цена акции: %s ₽\n", stock.Price.StringFixed(2)))
 	sb.WriteString(fmt.Sprintf("▸ Размер лота: %d\n", stock.Lotsize))
 	sb.WriteString(fmt.Sprintf("▸ Цена лота: %s ₽\n", stock.Price.Mul(decimal.NewFromInt(int64(stock.Lotsize))).StringFixed(2)))
+	writeBondInfo(&sb, stock.Bond)
 
 	addToPortfolioBtn := markup.Data("добавить в портфель", tgCallback.AddStockToPortfolio)
 
@@ -224,7 +395,57 @@ func StockAddResponse(stock moexModel.StockInfo) (text string, markup *tele.Repl
 	return sb.String(), markup
 }
 
-func CalculatedStockPurchaseResponse(stocks []model.StockPurchase, purchaseSum decimal.Decimal) (texts []string, markup *tele.ReplyMarkup) {
+// purchaseStrategyNames - короткие названия стратегий закупа для кнопок
+var purchaseStrategyNames = map[model.PurchaseStrategy]string{
+	model.PurchaseStrategyLargestLot:      "крупные лоты",
+	model.PurchaseStrategyMostUnderweight: "недокупленные",
+	model.PurchaseStrategyMinOffset:       "мин. отклонение",
+}
+
+var purchaseStrategyDescriptions = map[model.PurchaseStrategy]string{
+	model.PurchaseStrategyLargestLot:      "докупка до целевого веса, начиная с самых дорогих лотов",
+	model.PurchaseStrategyMostUnderweight: "докупка до целевого веса, начиная с самых недокупленных бумаг",
+	model.PurchaseStrategyMinOffset:       "подбор лотов с минимальным отклонением от индекса после закупа, сумма вкладывается до остатка меньше лота",
+}
+
+func CalculatePurchaseRequest(cashBalance decimal.Decimal, strategy model.PurchaseStrategy) (text string, markup *tele.ReplyMarkup) {
+	markup = &tele.ReplyMarkup{}
+	sb := strings.Builder{}
+
+	if description, ok := purchaseStrategyDescriptions[strategy]; ok {
+		sb.WriteString(fmt.Sprintf("стратегия: %s\n\n", description))
+	}
+
+	strategyBtns := make([]tele.Btn, 0, len(model.PurchaseStrategies))
+	for _, s := range model.PurchaseStrategies {
+		name := purchaseStrategyNames[s]
+		if s == strategy {
+			name = "✓ " + name
+		}
+		strategyBtns = append(strategyBtns, markup.Data(name, tgCallback.PurchaseStrategyPrefix+string(s)))
+	}
+
+	if !cashBalance.IsPositive() {
+		markup.Inline(markup.Row(strategyBtns...))
+		sb.WriteString("введите сумму закупки:")
+		return sb.String(), markup
+	}
+
+	useCashBtn := markup.Data(fmt.Sprintf("на свободные деньги: %s ₽", cashBalance.StringFixed(2)), tgCallback.CalculatePurchaseWithCash)
+	markup.Inline(
+		markup.Row(useCashBtn),
+		markup.Row(strategyBtns...),
+	)
+
+	sb.WriteString("введите сумму закупки или рассчитайте на свободные деньги:")
+	return sb.String(), markup
+}
+
+func CalculatedStockPurchaseResponse(
+	stocks []model.StockPurchase,
+	purchaseSum decimal.Decimal,
+	constraints model.PurchaseConstraints,
+) (texts []string, markup *tele.ReplyMarkup) {
 	markup = &tele.ReplyMarkup{}
 	sb := strings.Builder{}
 	actualPurchaseSum := decimal.NewFromInt(0)
@@ -236,10 +457,28 @@ func CalculatedStockPurchaseResponse(stocks []model.StockPurchase, purchaseSum d
 		applyPurchaseToPortfolioBtn = markup.Data("применить докупку к портфелю", tgCallback.ApplyCalculatedPurchaseToPortfolio)
 	}
 
-	markup.Inline(
-		markup.Row(applyPurchaseToPortfolioBtn),
-		markup.Row(backToPortfolioBtn),
-	)
+	// исключить можно купленную бумагу, вернуть - исключенную
+	exclusionBtns := make([]tele.Btn, 0, len(stocks)+len(constraints.ExcludedTickers))
+	for _, stock := range stocks {
+		exclusionBtns = append(exclusionBtns, markup.Data("✕ "+stock.Ticker, tgCallback.PurchaseExclusionPrefix+stock.Ticker))
+	}
+	for _, ticker := range constraints.ExcludedTickers {
+		exclusionBtns = append(exclusionBtns, markup.Data("↺ "+ticker, tgCallback.PurchaseExclusionPrefix+ticker))
+	}
+
+	rows := make([]tele.Row, 0, len(exclusionBtns)/4+4)
+	rows = append(rows, markup.Row(applyPurchaseToPortfolioBtn))
+	for i := 0; i < len(exclusionBtns); i += 4 {
+		rows = append(rows, markup.Row(exclusionBtns[i:min(i+4, len(exclusionBtns))]...))
+	}
+	if len(stocks) > 0 || !constraints.IsEmpty() {
+		rows = append(rows, markup.Row(
+			markup.Data("лимит на бумагу", tgCallback.SetPurchaseMaxPerTicker),
+			markup.Data("мин. заявка", tgCallback.SetPurchaseMinOrder),
+		))
+	}
+	rows = append(rows, markup.Row(backToPortfolioBtn))
+	markup.Inline(rows...)
 
 	for i, stock := range stocks {
 		ordinal := fmt.Sprintf("%d)", i+1)
@@ -261,10 +500,207 @@ func CalculatedStockPurchaseResponse(stocks []model.StockPurchase, purchaseSum d
 	sb.WriteString(fmt.Sprintf("▸ Сумма докупки: %s ₽\n", actualPurchaseSum.StringFixed(2)))
 	sb.WriteString(fmt.Sprintf("▸ Остаток: %s ₽\n", purchaseSum.Sub(actualPurchaseSum).StringFixed(2)))
 
+	if !constraints.IsEmpty() {
+		sb.WriteString("\nОграничения:\n")
+		if len(constraints.ExcludedTickers) > 0 {
+			sb.WriteString(fmt.Sprintf("▸ исключены: %s\n", strings.Join(constraints.ExcludedTickers, ", ")))
+		}
+		if constraints.MaxPerTicker.IsPositive() {
+			sb.WriteString(fmt.Sprintf("▸ не больше %s ₽ на бумагу\n", constraints.MaxPerTicker.StringFixed(2)))
+		}
+		if constraints.MinOrderSum.IsPositive() {
+			sb.WriteString(fmt.Sprintf("▸ заявки от %s ₽\n", constraints.MinOrderSum.StringFixed(2)))
+		}
+	}
+
 	texts = append(texts, sb.String())
 	return texts, markup
 }
 
+func RebalanceRequest(allowSells bool, tolerance decimal.Decimal) (text string, markup *tele.ReplyMarkup) {
+	markup = &tele.ReplyMarkup{}
+
+	allowSellsBtn := markup.Data("продажи: запрещены", tgCallback.ToggleAllowSells)
+	if allowSells {
+		allowSellsBtn = markup.Data("продажи: разрешены", tgCallback.ToggleAllowSells)
+	}
+	backToPortfolioBtn := markup.Data("назад к портфелю", tgCallback.BackToPortolio)
+
+	markup.Inline(
+		markup.Row(allowSellsBtn),
+		markup.Row(backToPortfolioBtn),
+	)
+
+	return fmt.Sprintf(
+		"ребалансировка приводит веса бумаг к целевым с допуском ±%s п.п.\n\nвведите сумму довнесения (0 - без довнесения):",
+		tolerance.String(),
+	), markup
+}
+
+func RebalancePlanResponse(plan model.RebalancePlan) (texts []string, markup *tele.ReplyMarkup) {
+	markup = &tele.ReplyMarkup{}
+	sb := strings.Builder{}
+
+	backToPortfolioBtn := markup.Data("назад к портфелю", tgCallback.BackToPortolio)
+
+	var applyPlanBtn tele.Btn
+	if len(plan.Trades) > 0 {
+		applyPlanBtn = markup.Data("применить план к портфелю", tgCallback.ApplyCalculatedPurchaseToPortfolio)
+	}
+
+	markup.Inline(
+		markup.Row(applyPlanBtn),
+		markup.Row(backToPortfolioBtn),
+	)
+
+	if len(plan.Trades) == 0 {
+		sb.WriteString("сделки не нужны или на сумму довнесения нельзя купить ни одного лота\n\n")
+	}
+
+	for i, trade := range plan.Trades {
+		lots := trade.LotsQuantity.IntPart()
+		side := "купить"
+		if lots < 0 {
+			side = "продать"
+			lots = -lots
+		}
+
+		ordinal := fmt.Sprintf("%d)", i+1)
+		sb.WriteString(fmt.Sprintf("%s %s (%s): %s\n", ordinal, trade.Ticker, trade.Shortname, side))
+		sb.WriteString(fmt.Sprintf("▸ лотов: %d шт\n", lots))
+		sb.WriteString(fmt.Sprintf("▸ акций: %d шт\n", int64(trade.LotSize)*lots))
+		sum := trade.StockPrice.Mul(decimal.NewFromInt(lots * int64(trade.LotSize)))
+		sb.WriteString(fmt.Sprintf("▸ на сумму: %s ₽\n\n", sum.StringFixed(2)))
+
+		if (i+1)%50 == 0 {
+			texts = append(texts, sb.String())
+			sb = strings.Builder{}
+		}
+	}
+
+	sb.WriteString("Итоги:\n")
+	sb.WriteString(fmt.Sprintf("▸ Довнесение: %s ₽\n", plan.Contribution.StringFixed(2)))
+	if plan.AllowSells {
+		sb.WriteString(fmt.Sprintf("▸ Продажи: %s ₽\n", plan.SellSum.StringFixed(2)))
+	} else {
+		sb.WriteString("▸ Продажи запрещены в настройках ребалансировки\n")
+	}
+	sb.WriteString(fmt.Sprintf("▸ Покупки: %s ₽\n", plan.BuySum.StringFixed(2)))
+	sb.WriteString(fmt.Sprintf("▸ Остаток: %s ₽\n", plan.Contribution.Add(plan.SellSum).Sub(plan.BuySum).StringFixed(2)))
+	if len(plan.OutOfBand) > 0 {
+		sb.WriteString(fmt.Sprintf("\n⚠️ вне допуска ±%s п.п. останутся: %s\n", plan.Tolerance.String(), strings.Join(plan.OutOfBand, ", ")))
+	}
+
+	texts = append(texts, sb.String())
+	return texts, markup
+}
+
+func SimulationRequest() (text string, markup *tele.ReplyMarkup) {
+	markup = &tele.ReplyMarkup{}
+
+	backToPortfolioBtn := markup.Data("назад к портфелю", tgCallback.BackToPortolio)
+	markup.Inline(markup.Row(backToPortfolioBtn))
+
+	return "что если: портфель пересчитается по сценарию, ничего не сохраняется\n\n" +
+		"введите изменения, каждое с новой строки:\n" +
+		"SBER +20 - купить 20 шт\n" +
+		"GAZP -5 - продать 5 шт\n" +
+		"SBER -20% - цена изменится на -20%\n\n" +
+		"сначала меняются цены, затем по новым ценам проводятся сделки (без комиссии)", markup
+}
+
+func SimulationResponse(result model.SimulationResult) (texts []string, markup *tele.ReplyMarkup) {
+	markup = &tele.ReplyMarkup{}
+	sb := strings.Builder{}
+
+	simulateAgainBtn := markup.Data("другой сценарий", tgCallback.SimulatePortfolio)
+	backToPortfolioBtn := markup.Data("назад к портфелю", tgCallback.BackToPortolio)
+	markup.Inline(
+		markup.Row(simulateAgainBtn),
+		markup.Row(backToPortfolioBtn),
+	)
+
+	before, after := result.Before, result.After
+
+	sb.WriteString("Сценарий:\n")
+	for _, priceChange := range result.Scenario.PriceChanges {
+		sb.WriteString(fmt.Sprintf("▸ %s: цена %s%%\n", priceChange.Ticker, priceChange.Percent.StringFixed(2)))
+	}
+	for _, trade := range result.Scenario.Trades {
+		if trade.Quantity > 0 {
+			sb.WriteString(fmt.Sprintf("▸ купить %s: %d шт\n", trade.Ticker, trade.Quantity))
+		} else {
+			sb.WriteString(fmt.Sprintf("▸ продать %s: %d шт\n", trade.Ticker, -trade.Quantity))
+		}
+	}
+	sb.WriteString(fmt.Sprintf("▸ покупки: %s ₽, продажи: %s ₽\n\n", result.BuySum.StringFixed(2), result.SellSum.StringFixed(2)))
+
+	sb.WriteString("Портфель (было → стало):\n")
+	sb.WriteString(fmt.Sprintf("▸ Баланс в индексе: %s → %s ₽\n", before.BalanceInsideIndex.StringFixed(2), after.BalanceInsideIndex.StringFixed(2)))
+	if before.StocksOutsideIndexCnt > 0 {
+		sb.WriteString(fmt.Sprintf("▸ Баланс вне индекса: %s → %s ₽\n", before.BalanceOutsideIndex.StringFixed(2), after.BalanceOutsideIndex.StringFixed(2)))
+	}
+	sb.WriteString(fmt.Sprintf("▸ Свободные деньги: %s → %s ₽\n", before.CashBalance.StringFixed(2), after.CashBalance.StringFixed(2)))
+	sb.WriteString(fmt.Sprintf("▸ Всего: %s → %s ₽\n", before.TotalBalance.StringFixed(2), after.TotalBalance.StringFixed(2)))
+	sb.WriteString(fmt.Sprintf("▸ Отклонение от индекса: %s%% → %s%%\n", before.IndexOffset.StringFixed(2), after.IndexOffset.StringFixed(2)))
+	sb.WriteString(fmt.Sprintf(
+		"▸ Рост в индексе: %s%% (%s ₽) → %s%% (%s ₽)\n",
+		before.GrowthPercentInsideIndex.StringFixed(2), before.GrowthSumInsideIndex.StringFixed(2),
+		after.GrowthPercentInsideIndex.StringFixed(2), after.GrowthSumInsideIndex.StringFixed(2),
+	))
+	if before.StocksOutsideIndexCnt > 0 {
+		sb.WriteString(fmt.Sprintf(
+			"▸ Рост вне индекса: %s%% (%s ₽) → %s%% (%s ₽)\n",
+			before.GrowthPercentOutsideIndex.StringFixed(2), before.GrowthSumOutsideIndex.StringFixed(2),
+			after.GrowthPercentOutsideIndex.StringFixed(2), after.GrowthSumOutsideIndex.StringFixed(2),
+		))
+	}
+	if after.CashBalance.IsNegative() {
+		sb.WriteString("⚠️ свободных денег не хватает на покупки, потребуется пополнение\n")
+	}
+	sb.WriteString("\n")
+
+	sb.WriteString("Налог:\n")
+	sb.WriteString(fmt.Sprintf("▸ Результат продаж: %s ₽\n", result.RealizedProfit.StringFixed(2)))
+	if result.ExemptProfit.IsPositive() {
+		sb.WriteString(fmt.Sprintf("▸ из них освобождено по ЛДВ: %s ₽\n", result.ExemptProfit.StringFixed(2)))
+	}
+	sb.WriteString(fmt.Sprintf(
+		"▸ НДФЛ за год по всем портфелям: %s → %s ₽ (%s ₽)\n",
+		result.TaxBefore.StringFixed(0), result.TaxAfter.StringFixed(0), result.TaxAfter.Sub(result.TaxBefore).StringFixed(0),
+	))
+
+	texts = append(texts, sb.String())
+	sb = strings.Builder{}
+
+	sb.WriteString("Бумаги в индексе (вес было → стало / целевой):\n")
+	i := 0
+	for j, stock := range result.AfterStocks {
+		if stock.TargetWeight.IsZero() || j >= len(result.BeforeStocks) {
+			continue
+		}
+		stockBefore := result.BeforeStocks[j]
+
+		sb.WriteString(fmt.Sprintf(
+			"▸ %s: %s%% → %s%% / %s%%, рост %s%% → %s%%\n",
+			stock.Ticker,
+			stockBefore.ActualWeight.StringFixed(2), stock.ActualWeight.StringFixed(2), stock.TargetWeight.StringFixed(2),
+			stockBefore.GrowthPercent.StringFixed(2), stock.GrowthPercent.StringFixed(2),
+		))
+
+		i++
+		if i%50 == 0 {
+			texts = append(texts, sb.String())
+			sb = strings.Builder{}
+		}
+	}
+
+	if i%50 != 0 {
+		texts = append(texts, sb.String())
+	}
+	return texts, markup
+}
+
 func PortfolioListResponse(portfolios []model.Portfolio, portfoliosPerPage, curPage int, hasNextPage bool) (texts string, markup *tele.ReplyMarkup) {
 	markup = &tele.ReplyMarkup{}
 	sb := strings.Builder{}
@@ -310,13 +746,115 @@ func PortfolioListResponse(portfolios []model.Portfolio, portfoliosPerPage, curP
 
 	createPortfolioBtn := markup.Data("создать портфель", tgCallback.CreatePortfolio)
 
-	menuRows = append(menuRows, markup.Row(createPortfolioBtn), markup.Row(generateReportBtn), markup.Row(paginationBtns...))
+	createFromCsvBtn := markup.Data("создать из CSV", tgCallback.CreatePortfolioFromCsv)
+
+	distributeContributionBtn := markup.Data("распределить пополнение", tgCallback.DistributeContribution)
+
+	menuRows = append(
+		menuRows,
+		markup.Row(createPortfolioBtn, createFromCsvBtn),
+		markup.Row(distributeContributionBtn),
+		markup.Row(generateReportBtn),
+		markup.Row(paginationBtns...),
+	)
 
 	markup.Inline(menuRows...)
 
 	return sb.String(), markup
 }
 
+func ContributionRequest(allocations []model.ContributionAllocation) (text string, markup *tele.ReplyMarkup) {
+	markup = &tele.ReplyMarkup{}
+	sb := strings.Builder{}
+
+	setAllocationsBtn := markup.Data("настроить доли", tgCallback.SetContributionAllocations)
+	backToPortfolioListBtn := markup.Data("К списку портфелей", tgCallback.BackToPortolioList)
+	markup.Inline(
+		markup.Row(setAllocationsBtn),
+		markup.Row(backToPortfolioListBtn),
+	)
+
+	sb.WriteString("Доли пополнения (остаток портфеля переходит в следующий):\n")
+	for i, allocation := range allocations {
+		sb.WriteString(fmt.Sprintf("%d) %s: %s%%\n", i+1, allocation.PortfolioName, allocation.Percent.String()))
+	}
+	sb.WriteString("\nвведите сумму пополнения:")
+
+	return sb.String(), markup
+}
+
+func ContributionAllocationsRequest(portfolios []model.Portfolio) (text string, markup *tele.ReplyMarkup) {
+	markup = &tele.ReplyMarkup{}
+	sb := strings.Builder{}
+
+	backToPortfolioListBtn := markup.Data("К списку портфелей", tgCallback.BackToPortolioList)
+	markup.Inline(markup.Row(backToPortfolioListBtn))
+
+	if len(portfolios) == 0 {
+		return "список портфелей пуст", markup
+	}
+
+	sb.WriteString("Ваши портфели:\n")
+	for i, portfolio := range portfolios {
+		sb.WriteString(fmt.Sprintf("%d) %s\n", i+1, portfolio.PortfolioName))
+	}
+	sb.WriteString("\nвведите доли пополнения в формате \"номер портфеля процент\", каждую с новой строки, например:\n1 60\n2 30\n3 10\n\n")
+	sb.WriteString("закуп рассчитывается в порядке строк, остаток портфеля переходит в следующий")
+
+	return sb.String(), markup
+}
+
+func ContributionPlanResponse(plan model.ContributionPlan) (texts []string, markup *tele.ReplyMarkup) {
+	markup = &tele.ReplyMarkup{}
+	sb := strings.Builder{}
+
+	var applyPlanBtn tele.Btn
+	for _, portfolio := range plan.Portfolios {
+		if len(portfolio.Purchases) > 0 {
+			applyPlanBtn = markup.Data("применить ко всем портфелям", tgCallback.ApplyContributionPlan)
+			break
+		}
+	}
+	backToPortfolioListBtn := markup.Data("К списку портфелей", tgCallback.BackToPortolioList)
+	markup.Inline(
+		markup.Row(applyPlanBtn),
+		markup.Row(backToPortfolioListBtn),
+	)
+
+	for _, portfolio := range plan.Portfolios {
+		sb.WriteString(fmt.Sprintf("💼 %s\n", portfolio.PortfolioName))
+		sb.WriteString(fmt.Sprintf("▸ доля пополнения: %s ₽\n", portfolio.Allocated.StringFixed(2)))
+		if !portfolio.CarriedOver.IsZero() {
+			sb.WriteString(fmt.Sprintf("▸ остаток предыдущего портфеля: %s ₽\n", portfolio.CarriedOver.StringFixed(2)))
+		}
+		for _, stock := range portfolio.Purchases {
+			lots := stock.LotsQuantity.IntPart()
+			sum := stock.StockPrice.Mul(decimal.NewFromInt(lots * int64(stock.LotSize)))
+			sb.WriteString(fmt.Sprintf("  • %s: %d лот. (%d шт) на %s ₽\n", stock.Ticker, lots, lots*int64(stock.LotSize), sum.StringFixed(2)))
+		}
+		switch {
+		case portfolio.Skipped:
+			sb.WriteString("  • в портфеле нет бумаг, закуп не рассчитан - сумма переходит в следующий портфель\n")
+		case len(portfolio.Purchases) == 0:
+			sb.WriteString("  • на эту сумму купить нечего\n")
+		}
+		sb.WriteString(fmt.Sprintf("▸ докупка: %s ₽, остаток: %s ₽\n\n", portfolio.Spent.StringFixed(2), portfolio.Remainder.StringFixed(2)))
+
+		texts = append(texts, sb.String())
+		sb = strings.Builder{}
+	}
+
+	sb.WriteString("Итоги:\n")
+	sb.WriteString(fmt.Sprintf("▸ Пополнение: %s ₽\n", plan.Sum.StringFixed(2)))
+	if plan.Unallocated.IsPositive() {
+		sb.WriteString(fmt.Sprintf("▸ Не распределено долями: %s ₽\n", plan.Unallocated.StringFixed(2)))
+	}
+	sb.WriteString(fmt.Sprintf("▸ Остаток: %s ₽\n", plan.Remainder.StringFixed(2)))
+
+	texts = append(texts, sb.String())
+	return texts, markup
+}
+
 func DeletePortfolioConfirmation() (markup *tele.ReplyMarkup) {
 	markup = &tele.ReplyMarkup{}
 	backToPortfolioBtn := markup.Data("назад к портфелю", tgCallback.BackToPortolio)
@@ -327,3 +865,669 @@ func DeletePortfolioConfirmation() (markup *tele.ReplyMarkup) {
 	)
 	return markup
 }
+
+func IndexSyncResultResponse(result model.IndexSyncResult) (text string, markup *tele.ReplyMarkup) {
+	markup = &tele.ReplyMarkup{}
+	sb := strings.Builder{}
+
+	sb.WriteString(fmt.Sprintf("Веса синхронизированы с индексом %s", result.IndexID))
+	if !result.TradeDate.IsZero() {
+		sb.WriteString(fmt.Sprintf(" на %s", result.TradeDate.Format("02.01.2006")))
+	}
+	sb.WriteString("\n\n")
+	sb.WriteString(fmt.Sprintf("▸ обновлено весов: %d\n", result.Updated))
+	if len(result.Added) > 0 {
+		sb.WriteString(fmt.Sprintf("▸ добавлены: %s\n", strings.Join(result.Added, ", ")))
+	}
+	if len(result.Removed) > 0 {
+		sb.WriteString(fmt.Sprintf("▸ выбыли из индекса (вес обнулен): %s\n", strings.Join(result.Removed, ", ")))
+	}
+	if len(result.Skipped) > 0 {
+		sb.WriteString(fmt.Sprintf("▸ не найдены на бирже: %s\n", strings.Join(result.Skipped, ", ")))
+	}
+
+	backToPortfolioBtn := markup.Data("назад к портфелю", tgCallback.BackToPortolio)
+	markup.Inline(markup.Row(backToPortfolioBtn))
+
+	return sb.String(), markup
+}
+
+func IndexDriftNotification(drift model.IndexDrift) (text string, markup *tele.ReplyMarkup) {
+	markup = &tele.ReplyMarkup{}
+	sb := strings.Builder{}
+
+	sb.WriteString(fmt.Sprintf("🔔 Состав индекса %s изменился", drift.IndexID))
+	if !drift.TradeDate.IsZero() {
+		sb.WriteString(fmt.Sprintf(" (на %s)", drift.TradeDate.Format("02.01.2006")))
+	}
+	sb.WriteString(fmt.Sprintf("\nПортфель: %s\n\n", drift.PortfolioName))
+
+	if len(drift.Added) > 0 {
+		sb.WriteString("Вошли в индекс:\n")
+		for _, change := range drift.Added {
+			sb.WriteString(fmt.Sprintf("▸ %s: %s%%\n", change.Ticker, change.NewWeight.StringFixed(2)))
+		}
+		sb.WriteString("\n")
+	}
+
+	if len(drift.Removed) > 0 {
+		sb.WriteString("Выбыли из индекса:\n")
+		for _, change := range drift.Removed {
+			sb.WriteString(fmt.Sprintf("▸ %s (целевой вес %s%%)\n", change.Ticker, change.OldWeight.StringFixed(2)))
+		}
+		sb.WriteString("\n")
+	}
+
+	if len(drift.Changed) > 0 {
+		sb.WriteString("Изменился вес:\n")
+		for _, change := range drift.Changed {
+			sb.WriteString(fmt.Sprintf("▸ %s: %s%% → %s%%\n", change.Ticker, change.OldWeight.StringFixed(2), change.NewWeight.StringFixed(2)))
+		}
+	}
+
+	applyBtn := markup.Data("применить новые веса", tgCallback.ApplyIndexWeightsPrefix+strconv.FormatInt(drift.PortfolioID, 10))
+	markup.Inline(markup.Row(applyBtn))
+
+	return sb.String(), markup
+}
+
+func PortfolioHistoryResponse(history model.PortfolioHistory) (text string, markup *tele.ReplyMarkup) {
+	markup = &tele.ReplyMarkup{}
+	sb := strings.Builder{}
+
+	sb.WriteString(fmt.Sprintf("📅 История портфеля: %s\n\n", history.PortfolioName))
+	sb.WriteString(fmt.Sprintf("Текущая стоимость: %s ₽\n\n", history.CurrentValue.StringFixed(2)))
+
+	for _, period := range history.Periods {
+		if !period.Available {
+			sb.WriteString(fmt.Sprintf("▸ %s: нет данных\n", period.Label))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf(
+			"▸ %s: %s%% (%s ₽), с %s\n",
+			period.Label,
+			period.ChangePercent.StringFixed(2),
+			period.Change.StringFixed(2),
+			period.SnapshotDate.Format("02.01.2006"),
+		))
+	}
+
+	sb.WriteString("\nСнапшоты стоимости сохраняются раз в день, история начинает копиться с момента их появления.")
+
+	backToPortfolioBtn := markup.Data("назад к портфелю", tgCallback.BackToPortolio)
+	markup.Inline(markup.Row(backToPortfolioBtn))
+
+	return sb.String(), markup
+}
+
+func TaxReportResponse(report model.TaxReport, currentYear int) (text string, markup *tele.ReplyMarkup) {
+	markup = &tele.ReplyMarkup{}
+	sb := strings.Builder{}
+
+	sb.WriteString(fmt.Sprintf("🧾 НДФЛ за %d год: %s\n\n", report.Year, report.PortfolioName))
+	sb.WriteString(fmt.Sprintf("▸ результат продаж: %s ₽\n", report.RealizedProfit.StringFixed(2)))
+	sb.WriteString(fmt.Sprintf("▸ освобождено по ЛДВ: %s ₽\n", report.ExemptProfit.StringFixed(2)))
+	sb.WriteString(fmt.Sprintf("▸ налоговая база: %s ₽\n", report.TaxBase.StringFixed(2)))
+	sb.WriteString(fmt.Sprintf("▸ налог портфеля (оценка): %s ₽\n", report.Tax.StringFixed(0)))
+	sb.WriteString(fmt.Sprintf("▸ база по всем портфелям: %s ₽\n", report.UserTaxBase.StringFixed(2)))
+	sb.WriteString(fmt.Sprintf("▸ налог по всем портфелям: %s ₽\n\n", report.UserTax.StringFixed(0)))
+
+	if len(report.ExemptLots) > 0 {
+		sb.WriteString("Продано с ЛДВ:\n")
+		for _, lot := range report.ExemptLots {
+			sb.WriteString(fmt.Sprintf(
+				"▸ %s %d шт., куплено %s по %s ₽\n",
+				lot.Ticker, lot.Quantity, lot.BuyDate.Format("02.01.2006"), lot.BuyPrice.StringFixed(2),
+			))
+		}
+		sb.WriteString("\n")
+	}
+
+	if len(report.EligibleLots) > 0 {
+		sb.WriteString("✅ Можно продать без налога (ЛДВ):\n")
+		for _, lot := range report.EligibleLots {
+			sb.WriteString(fmt.Sprintf("▸ %s %d шт., куплено %s\n", lot.Ticker, lot.Quantity, lot.BuyDate.Format("02.01.2006")))
+		}
+		sb.WriteString("\n")
+	}
+
+	if len(report.UpcomingLots) > 0 {
+		sb.WriteString("⏳ Скоро наступит ЛДВ:\n")
+		for _, lot := range report.UpcomingLots {
+			sb.WriteString(fmt.Sprintf("▸ %s %d шт. - с %s\n", lot.Ticker, lot.Quantity, lot.EligibleFrom.Format("02.01.2006")))
+		}
+		sb.WriteString("\n")
+	}
+
+	sb.WriteString("Порог 15% и зачет убытков считаются по всем портфелям вместе, портфелю достается доля налога по его базе. " +
+		"Ставка 13% / 15% с дохода выше порога. Лимит ЛДВ не учитывается.")
+
+	yearBtns := make([]tele.Btn, 0, 2)
+	yearBtns = append(yearBtns, markup.Data(strconv.Itoa(report.Year-1), tgCallback.TaxReportYearPrefix+strconv.Itoa(report.Year-1)))
+	if report.Year < currentYear {
+		yearBtns = append(yearBtns, markup.Data(strconv.Itoa(report.Year+1), tgCallback.TaxReportYearPrefix+strconv.Itoa(report.Year+1)))
+	}
+
+	backToPortfolioBtn := markup.Data("назад к портфелю", tgCallback.BackToPortolio)
+	markup.Inline(
+		markup.Row(yearBtns...),
+		markup.Row(backToPortfolioBtn),
+	)
+
+	return sb.String(), markup
+}
+
+func SellTaxWarning(lots []model.LdvLot) string {
+	sb := strings.Builder{}
+
+	sb.WriteString("⚠️ Продажа затронет лоты, по которым скоро наступит льгота за долгосрочное владение (ЛДВ):\n")
+	for _, lot := range lots {
+		sb.WriteString(fmt.Sprintf(
+			"▸ %d шт., куплено %s по %s ₽ - ЛДВ с %s\n",
+			lot.Quantity, lot.BuyDate.Format("02.01.2006"), lot.BuyPrice.StringFixed(2), lot.EligibleFrom.Format("02.01.2006"),
+		))
+	}
+	sb.WriteString("\nЕсли подождать, прибыль по этим лотам не будет облагаться НДФЛ.")
+
+	return sb.String()
+}
+
+func StockDividendsResponse(stock model.Stock) (text string, markup *tele.ReplyMarkup) {
+	markup = &tele.ReplyMarkup{}
+	sb := strings.Builder{}
+
+	sb.WriteString(fmt.Sprintf("🪙 Дивиденды %s (%s)\n\n", stock.Ticker, stock.Shortname))
+	writeStockDividends(&sb, stock)
+
+	if len(stock.Dividends.Income) == 0 {
+		sb.WriteString("\nВыплат в портфеле пока не было.\n")
+	} else {
+		sb.WriteString("\nПолученные выплаты:\n")
+		for _, income := range stock.Dividends.Income {
+			if income.Source == model.DividendSourceManual {
+				sb.WriteString(fmt.Sprintf("▸ %s: %s ₽ (вручную)\n", income.DividendDate.Format("02.01.2006"), income.NetAmount.StringFixed(2)))
+				continue
+			}
+			sb.WriteString(fmt.Sprintf(
+				"▸ %s: %d шт. × %s ₽, после налога %s ₽\n",
+				income.DividendDate.Format("02.01.2006"),
+				income.Quantity,
+				income.AmountPerShare.StringFixed(2),
+				income.NetAmount.StringFixed(2),
+			))
+		}
+	}
+
+	addDividendBtn := markup.Data("добавить выплату", tgCallback.AddDividend)
+	backToStockBtn := markup.Data("назад к акции", tgCallback.EditStockPrefix+stock.Ticker)
+	markup.Inline(
+		markup.Row(addDividendBtn),
+		markup.Row(backToStockBtn),
+	)
+
+	return sb.String(), markup
+}
+
+func UpcomingDividendNotification(dividend model.UpcomingDividend) (text string, markup *tele.ReplyMarkup) {
+	markup = &tele.ReplyMarkup{}
+	sb := strings.Builder{}
+
+	sb.WriteString(fmt.Sprintf("🪙 Скоро отсечка по %s\n", dividend.Ticker))
+	sb.WriteString(fmt.Sprintf("Портфель: %s\n\n", dividend.PortfolioName))
+	sb.WriteString(fmt.Sprintf("▸ закрытие реестра: %s\n", dividend.RegistryCloseDate.Format("02.01.2006")))
+	sb.WriteString(fmt.Sprintf("▸ дивиденд на акцию: %s %s\n", dividend.AmountPerShare.StringFixed(2), dividend.Currency))
+	sb.WriteString(fmt.Sprintf(
+		"▸ ожидаемая выплата за %d шт.: %s %s после налога 13%%\n\n",
+		dividend.Quantity,
+		dividend.ExpectedNet.StringFixed(2),
+		dividend.Currency,
+	))
+
+	// расчеты проходят в режиме T+1, поэтому купленные в день отсечки бумаги в реестр не попадают
+	today := time.Now().Truncate(24 * time.Hour)
+	if dividend.LastBuyDate.Before(today) {
+		sb.WriteString(fmt.Sprintf("⚠️ Последний день покупки для попадания в реестр прошел: бумаги, купленные после %s, дивиденд не получат.", dividend.LastBuyDate.Format("02.01.2006")))
+	} else {
+		sb.WriteString(fmt.Sprintf("Чтобы попасть в реестр (режим T+1), купить нужно не позже %s.", dividend.LastBuyDate.Format("02.01.2006")))
+	}
+
+	return sb.String(), markup
+}
+
+var cashOperationNames = map[model.CashOperationType]string{
+	model.CashOperationDeposit:    "пополнение",
+	model.CashOperationWithdrawal: "вывод",
+	model.CashOperationBuy:        "покупка",
+	model.CashOperationSell:       "продажа",
+	model.CashOperationDividend:   "дивиденды",
+	model.CashOperationCoupon:     "купоны",
+	model.CashOperationCommission: "комиссия",
+	model.CashOperationTax:        "налог",
+}
+
+func PortfolioCashResponse(cash model.PortfolioCash) (text string, markup *tele.ReplyMarkup) {
+	markup = &tele.ReplyMarkup{}
+	sb := strings.Builder{}
+
+	sb.WriteString(fmt.Sprintf("💵 Деньги портфеля: %s\n\n", cash.PortfolioName))
+	sb.WriteString(fmt.Sprintf("Свободный остаток: %s ₽\n\n", cash.Balance.StringFixed(2)))
+
+	if len(cash.Operations) == 0 {
+		sb.WriteString("Операций с деньгами пока не было.\n")
+	} else {
+		sb.WriteString("Последние операции:\n")
+		for _, operation := range cash.Operations {
+			sb.WriteString(fmt.Sprintf("▸ %s %s", operation.DtCreate.Format("02.01.2006"), cashOperationNames[operation.OperationType]))
+			if operation.Ticker != "" {
+				sb.WriteString(fmt.Sprintf(" %s", operation.Ticker))
+			}
+			sb.WriteString(fmt.Sprintf(": %s ₽\n", operation.Amount.StringFixed(2)))
+		}
+	}
+
+	depositBtn := markup.Data("пополнить", tgCallback.CashOperationPrefix+string(model.CashOperationDeposit))
+	withdrawalBtn := markup.Data("вывести", tgCallback.CashOperationPrefix+string(model.CashOperationWithdrawal))
+	couponBtn := markup.Data("купоны", tgCallback.CashOperationPrefix+string(model.CashOperationCoupon))
+	backToPortfolioBtn := markup.Data("назад к портфелю", tgCallback.BackToPortolio)
+	markup.Inline(
+		markup.Row(depositBtn, withdrawalBtn, couponBtn),
+		markup.Row(backToPortfolioBtn),
+	)
+
+	return sb.String(), markup
+}
+
+func stockOperationName(operation model.StockOperation) string {
+	switch operation.OperationType {
+	case model.StockOperationSplit:
+		return "сплит"
+	case model.StockOperationRename:
+		if operation.Quantity < 0 {
+			return "смена тикера, списание"
+		}
+		return "смена тикера, зачисление"
+	case model.StockOperationTransfer:
+		if operation.Quantity < 0 {
+			return "перевод в другой портфель"
+		}
+		return "перевод из другого портфеля"
+	}
+	if operation.Quantity < 0 {
+		return "продажа"
+	}
+	return "покупка"
+}
+
+// operationQuantity - количество бумаг в операции без знака
+func operationQuantity(operation model.StockOperation) int {
+	if operation.Quantity < 0 {
+		return -operation.Quantity
+	}
+	return operation.Quantity
+}
+
+func OperationsHistoryResponse(history model.OperationsHistoryPage, operationsPerPage int) (text string, markup *tele.ReplyMarkup) {
+	markup = &tele.ReplyMarkup{}
+	sb := strings.Builder{}
+
+	sb.WriteString(fmt.Sprintf("📒 Операции портфеля: %s\n\n", history.PortfolioName))
+
+	backToPortfolioBtn := markup.Data("назад к портфелю", tgCallback.BackToPortolio)
+
+	if len(history.Operations) == 0 {
+		sb.WriteString("Операций пока не было.\n")
+		markup.Inline(markup.Row(backToPortfolioBtn))
+		return sb.String(), markup
+	}
+
+	operationBtns := make([]tele.Btn, 0, len(history.Operations))
+	for i, operation := range history.Operations {
+		ordinal := i + 1 + operationsPerPage*(history.CurPage-1)
+		if !operation.IsTrade() {
+			sb.WriteString(fmt.Sprintf(
+				"%d) %s %s %s: %+d шт.",
+				ordinal,
+				operation.DtCreate.Format("02.01.2006"),
+				stockOperationName(operation),
+				operation.Ticker,
+				operation.Quantity,
+			))
+			if operation.OperationType == model.StockOperationTransfer {
+				sb.WriteString(fmt.Sprintf(", по рынку %s ₽", operation.TotalPrice.Abs().StringFixed(2)))
+			}
+			sb.WriteString("\n")
+			operationBtns = append(operationBtns, markup.Data(strconv.Itoa(ordinal), tgCallback.EditOperationPrefix+strconv.FormatInt(operation.OperationID, 10)))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf(
+			"%d) %s %s %s: %d шт. × %s ₽ = %s ₽",
+			ordinal,
+			operation.DtCreate.Format("02.01.2006"),
+			stockOperationName(operation),
+			operation.Ticker,
+			operationQuantity(operation),
+			operation.Price.StringFixed(2),
+			operation.TotalPrice.Abs().StringFixed(2),
+		))
+		if !operation.Commission.IsZero() {
+			sb.WriteString(fmt.Sprintf(", комиссия %s ₽", operation.Commission.StringFixed(2)))
+		}
+		sb.WriteString("\n")
+		operationBtns = append(operationBtns, markup.Data(strconv.Itoa(ordinal), tgCallback.EditOperationPrefix+strconv.FormatInt(operation.OperationID, 10)))
+	}
+	sb.WriteString("\nВыберите номер операции, чтобы исправить или удалить ее.")
+
+	paginationBtns := make([]tele.Btn, 0)
+	if history.CurPage > 1 {
+		paginationBtns = append(paginationBtns, markup.Data("назад", tgCallback.ToOperationsPage+strconv.Itoa(history.CurPage-1)))
+	}
+
+	if history.CurPage > 1 || history.HasNextPage {
+		paginationBtns = append(paginationBtns, markup.Data(fmt.Sprintf("стр %d", history.CurPage), tgCallback.PageNumber))
+	}
+
+	if history.HasNextPage {
+		paginationBtns = append(paginationBtns, markup.Data("вперед", tgCallback.ToOperationsPage+strconv.Itoa(history.CurPage+1)))
+	}
+
+	markup.Inline(
+		markup.Row(operationBtns...),
+		markup.Row(paginationBtns...),
+		markup.Row(backToPortfolioBtn),
+	)
+
+	return sb.String(), markup
+}
+
+// StockSavedResponse - карточка бумаги после сохранения сделки с кнопкой отмены именно этой сделки
+func StockSavedResponse(stock model.Stock, operationID int64) (text string, markup *tele.ReplyMarkup) {
+	text, markup = StockDetailResponse(stock, nil)
+	undoBtn := markup.Data("↩️ отменить операцию", tgCallback.UndoOperationPrefix+strconv.FormatInt(operationID, 10))
+	markup.InlineKeyboard = append([][]tele.InlineButton{{*undoBtn.Inline()}}, markup.InlineKeyboard...)
+	return text, markup
+}
+
+func UndoneOperationsMessage(undoneOperations []model.LastOperation) string {
+	sb := strings.Builder{}
+	if len(undoneOperations) > 1 {
+		sb.WriteString(fmt.Sprintf("отменено операций: %d\n", len(undoneOperations)))
+	}
+	for _, undoneOperation := range undoneOperations {
+		operation := undoneOperation.Operation
+		sb.WriteString(fmt.Sprintf(
+			"отменена %s %s: %d шт. × %s ₽ в портфеле «%s»\n",
+			stockOperationName(operation),
+			operation.Ticker,
+			operationQuantity(operation),
+			operation.Price.StringFixed(2),
+			undoneOperation.PortfolioName,
+		))
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func StockOperationResponse(operation model.StockOperation) (text string, markup *tele.ReplyMarkup) {
+	markup = &tele.ReplyMarkup{}
+	sb := strings.Builder{}
+
+	sb.WriteString(fmt.Sprintf("Операция: %s %s (%s)\n", stockOperationName(operation), operation.Ticker, operation.Shortname))
+	sb.WriteString(fmt.Sprintf("▸ Дата: %s\n", operation.DtCreate.Format("02.01.2006 15:04")))
+
+	backToOperationsBtn := markup.Data("назад к операциям", tgCallback.OperationsHistory)
+	if operation.IsCorporateAction() {
+		if operation.RatioFrom > 0 && operation.RatioTo > 0 {
+			sb.WriteString(fmt.Sprintf("▸ Коэффициент: %d:%d\n", operation.RatioFrom, operation.RatioTo))
+		}
+		sb.WriteString(fmt.Sprintf("▸ Изменение кол-ва: %+d шт.\n", operation.Quantity))
+		sb.WriteString("\nКорпоративное действие применяется ко всем портфелям, стоимость покупки лотов при нем сохраняется. Исправить или удалить его нельзя.")
+		markup.Inline(markup.Row(backToOperationsBtn))
+		return sb.String(), markup
+	}
+	if operation.OperationType == model.StockOperationTransfer {
+		sb.WriteString(fmt.Sprintf("▸ Кол-во: %d шт.\n", operationQuantity(operation)))
+		if operation.Quantity > 0 {
+			sb.WriteString(fmt.Sprintf("▸ Цена покупки: %s ₽\n", operation.Price.StringFixed(2)))
+			sb.WriteString(fmt.Sprintf("▸ Дата покупки: %s\n", operation.DtAcquired.Format("02.01.2006 15:04")))
+		}
+		sb.WriteString(fmt.Sprintf("▸ Рыночная стоимость: %s ₽\n", operation.TotalPrice.Abs().StringFixed(2)))
+		sb.WriteString("\nПеревод между портфелями переносит лоты с ценой и датой покупки. Исправить или удалить его нельзя.")
+		markup.Inline(markup.Row(backToOperationsBtn))
+		return sb.String(), markup
+	}
+
+	sb.WriteString(fmt.Sprintf("▸ Кол-во: %d шт.\n", operationQuantity(operation)))
+	sb.WriteString(fmt.Sprintf("▸ Цена за акцию: %s ₽\n", operation.Price.StringFixed(2)))
+	sb.WriteString(fmt.Sprintf("▸ Сумма: %s ₽\n", operation.TotalPrice.Abs().StringFixed(2)))
+	sb.WriteString(fmt.Sprintf("▸ Комиссия: %s ₽\n", operation.Commission.StringFixed(2)))
+	sb.WriteString("\nПосле исправления остатки, средние цены и деньги портфеля пересчитываются по всей истории.")
+
+	quantityBtn := markup.Data("изменить кол-во", tgCallback.EditOperationQuantity)
+	priceBtn := markup.Data("изменить цену", tgCallback.EditOperationPrice)
+	commissionBtn := markup.Data("изменить комиссию", tgCallback.EditOperationCommission)
+	deleteBtn := markup.Data("⚠️ удалить операцию", tgCallback.InitDeleteOperation)
+	markup.Inline(
+		markup.Row(quantityBtn, priceBtn),
+		markup.Row(commissionBtn),
+		markup.Row(deleteBtn),
+		markup.Row(backToOperationsBtn),
+	)
+
+	return sb.String(), markup
+}
+
+func DeleteOperationConfirmation() (markup *tele.ReplyMarkup) {
+	markup = &tele.ReplyMarkup{}
+	backToOperationsBtn := markup.Data("назад к операциям", tgCallback.OperationsHistory)
+	deleteOperationBtn := markup.Data("подтвердить удаление", tgCallback.ProcessDeleteOperation)
+	markup.Inline(
+		markup.Row(backToOperationsBtn),
+		markup.Row(deleteOperationBtn),
+	)
+	return markup
+}
+
+// brokerImportPreviewLimit - сколько записей каждого вида показывать в предпросмотре загрузки, чтобы уложиться в размер сообщения
+const brokerImportPreviewLimit = 20
+
+var brokerNames = map[model.Broker]string{
+	model.BrokerTinkoff: "Т-Банк",
+	model.BrokerSber:    "Сбер",
+	model.BrokerVTB:     "ВТБ",
+	model.BrokerAlfa:    "Альфа-Инвестиции",
+}
+
+func BrokerImportPreviewResponse(preview model.BrokerImportPreview) (text string, markup *tele.ReplyMarkup) {
+	markup = &tele.ReplyMarkup{}
+	sb := strings.Builder{}
+	report := preview.Report
+
+	sb.WriteString(fmt.Sprintf("📥 Отчет брокера %s → портфель %s\n", brokerNames[report.Broker], preview.PortfolioName))
+
+	newItems := 0
+	if len(report.Trades) > 0 {
+		sb.WriteString(fmt.Sprintf("\nСделки (%d):\n", len(report.Trades)))
+		for i, trade := range report.Trades {
+			if !trade.Duplicate && !trade.UnknownTicker {
+				newItems++
+			}
+			if i >= brokerImportPreviewLimit {
+				continue
+			}
+
+			name := "покупка"
+			if trade.Quantity < 0 {
+				name = "продажа"
+			}
+			sb.WriteString(fmt.Sprintf(
+				"%s %s %s: %d шт. × %s ₽",
+				trade.TradeDate.Format("02.01.2006"), name, trade.Ticker, max(trade.Quantity, -trade.Quantity), trade.Price.StringFixed(2),
+			))
+			if !trade.Commission.IsZero() {
+				sb.WriteString(fmt.Sprintf(", комиссия %s ₽", trade.Commission.StringFixed(2)))
+			}
+			switch {
+			case trade.UnknownTicker:
+				sb.WriteString(" ⛔ бумага не найдена")
+			case trade.Duplicate:
+				sb.WriteString(" 🔁 уже есть")
+			}
+			sb.WriteString("\n")
+		}
+		if len(report.Trades) > brokerImportPreviewLimit {
+			sb.WriteString(fmt.Sprintf("… и еще %d\n", len(report.Trades)-brokerImportPreviewLimit))
+		}
+	}
+
+	if len(report.Dividends) > 0 {
+		sb.WriteString(fmt.Sprintf("\nДивиденды (%d):\n", len(report.Dividends)))
+		for i, dividend := range report.Dividends {
+			if !dividend.Duplicate {
+				newItems++
+			}
+			if i >= brokerImportPreviewLimit {
+				continue
+			}
+
+			sb.WriteString(fmt.Sprintf("%s %s: %s ₽", dividend.PaymentDate.Format("02.01.2006"), dividend.Ticker, dividend.NetAmount.StringFixed(2)))
+			if !dividend.TaxAmount.IsZero() {
+				sb.WriteString(fmt.Sprintf(", налог %s ₽", dividend.TaxAmount.StringFixed(2)))
+			}
+			if dividend.Duplicate {
+				sb.WriteString(" 🔁 уже есть")
+			}
+			sb.WriteString("\n")
+		}
+		if len(report.Dividends) > brokerImportPreviewLimit {
+			sb.WriteString(fmt.Sprintf("… и еще %d\n", len(report.Dividends)-brokerImportPreviewLimit))
+		}
+	}
+
+	if len(report.Fees) > 0 {
+		sb.WriteString(fmt.Sprintf("\nСписания брокера (%d):\n", len(report.Fees)))
+		for i, fee := range report.Fees {
+			if !fee.Duplicate {
+				newItems++
+			}
+			if i >= brokerImportPreviewLimit {
+				continue
+			}
+
+			sb.WriteString(fmt.Sprintf("%s %s: %s ₽", fee.Date.Format("02.01.2006"), fee.Description, fee.Amount.StringFixed(2)))
+			if fee.Duplicate {
+				sb.WriteString(" 🔁 уже есть")
+			}
+			sb.WriteString("\n")
+		}
+		if len(report.Fees) > brokerImportPreviewLimit {
+			sb.WriteString(fmt.Sprintf("… и еще %d\n", len(report.Fees)-brokerImportPreviewLimit))
+		}
+	}
+
+	backToPortfolioBtn := markup.Data("назад к портфелю", tgCallback.BackToPortolio)
+
+	switch {
+	case preview.Problem != "":
+		sb.WriteString(fmt.Sprintf("\n⚠️ Загрузить отчет нельзя: %s.", preview.Problem))
+		markup.Inline(markup.Row(backToPortfolioBtn))
+	case newItems == 0:
+		sb.WriteString("\nВсе записи из отчета уже есть в портфеле.")
+		markup.Inline(markup.Row(backToPortfolioBtn))
+	default:
+		sb.WriteString(fmt.Sprintf("\nБудет загружено записей: %d. Дубли и бумаги, не найденные на бирже, пропускаются.", newItems))
+		applyBtn := markup.Data("загрузить", tgCallback.ApplyBrokerImport)
+		markup.Inline(
+			markup.Row(applyBtn),
+			markup.Row(backToPortfolioBtn),
+		)
+	}
+
+	return sb.String(), markup
+}
+
+func BrokerImportResultMessage(result model.BrokerImportResult) string {
+	return fmt.Sprintf("загружено сделок: %d, дивидендов: %d, списаний: %d", result.Trades, result.Dividends, result.Fees)
+}
+
+const portfolioCsvFormat = "Форматы CSV (разделитель «;», первая строка - заголовок):\n" +
+	"▸ позиции: ticker;weight\n" +
+	"▸ операции: date;ticker;side;quantity;price;commission\n" +
+	"  например: 2024-03-01 10:15:00;SBER;buy;10;300.50;1.50"
+
+func PortfolioCsvMenuResponse() (text string, markup *tele.ReplyMarkup) {
+	markup = &tele.ReplyMarkup{}
+	sb := strings.Builder{}
+
+	sb.WriteString("Выгрузка и загрузка портфеля в CSV.\n\n")
+	sb.WriteString(portfolioCsvFormat)
+	sb.WriteString("\n\nЗагрузка позиций заменяет состав и веса портфеля, загрузка операций - всю историю сделок.")
+
+	exportBtn := markup.Data("выгрузить в CSV", tgCallback.ExportPortfolioCsv)
+	importBtn := markup.Data("загрузить из CSV", tgCallback.ImportPortfolioCsv)
+	backToPortfolioBtn := markup.Data("назад к портфелю", tgCallback.BackToPortolio)
+	markup.Inline(
+		markup.Row(exportBtn, importBtn),
+		markup.Row(backToPortfolioBtn),
+	)
+
+	return sb.String(), markup
+}
+
+func PortfolioCsvUploadRequest() string {
+	return "отправьте CSV файл с позициями или операциями.\n\n" + portfolioCsvFormat
+}
+
+// csvImportErrorsLimit - сколько ошибок по строкам показывать, чтобы уложиться в размер сообщения
+const csvImportErrorsLimit = 30
+
+func CsvImportErrorsResponse(result model.CsvImportResult) string {
+	sb := strings.Builder{}
+	sb.WriteString("Файл не загружен, исправьте ошибки и отправьте его снова:\n\n")
+	for i, lineErr := range result.Errors {
+		if i == csvImportErrorsLimit {
+			sb.WriteString(fmt.Sprintf("… и еще %d\n", len(result.Errors)-csvImportErrorsLimit))
+			break
+		}
+		sb.WriteString(fmt.Sprintf("строка %d: %s\n", lineErr.Line, lineErr.Message))
+	}
+	return sb.String()
+}
+
+func CsvImportResultMessage(result model.CsvImportResult) string {
+	if result.Kind == model.CsvKindPositions {
+		return fmt.Sprintf("загружено позиций: %d", result.Positions)
+	}
+	return fmt.Sprintf("загружено операций: %d", result.Operations)
+}
+
+func CorporateActionResultMessage(result model.CorporateActionResult) string {
+	date := result.Action.EffectiveDate.Format("02.01.2006")
+	if result.Action.Type == model.CorporateActionRename {
+		return fmt.Sprintf("смена тикера %s → %s с %s применена, затронуто портфелей: %d", result.Action.Ticker, result.Action.NewTicker, date, result.Portfolios)
+	}
+	return fmt.Sprintf("сплит %s %d:%d с %s применен, затронуто портфелей: %d", result.Action.Ticker, result.Action.RatioFrom, result.Action.RatioTo, date, result.Portfolios)
+}
+
+func TransferTargetsResponse(ticker string, portfolios []model.Portfolio) (text string, markup *tele.ReplyMarkup) {
+	markup = &tele.ReplyMarkup{}
+	rows := make([]tele.Row, 0, len(portfolios)+1)
+	for _, portfolio := range portfolios {
+		rows = append(rows, markup.Row(markup.Data(portfolio.PortfolioName, tgCallback.TransferToPortfolioPrefix+strconv.FormatInt(portfolio.PortfolioID, 10))))
+	}
+	rows = append(rows, markup.Row(markup.Data("назад к портфелю", tgCallback.BackToPortolio)))
+	markup.Inline(rows...)
+
+	text = fmt.Sprintf("Выберите портфель, в который перевести %s. Бумаги переносятся вместе с ценами и датами покупки, деньги портфелей не меняются.", ticker)
+	return text, markup
+}
+
+func StockTransferResultMessage(transfer model.StockTransfer) string {
+	return fmt.Sprintf(
+		"%s: %d шт. переведено из «%s» в «%s», стоимость покупки %s ₽ (лотов: %d)",
+		transfer.Ticker,
+		transfer.Quantity,
+		transfer.FromPortfolioName,
+		transfer.ToPortfolioName,
+		transfer.Cost.StringFixed(2),
+		transfer.Lots,
+	)
+}
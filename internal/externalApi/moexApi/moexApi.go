@@ -6,7 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/KotFed0t/invest_helper_bot/config"
 	"github.com/KotFed0t/invest_helper_bot/internal/externalApi"
@@ -16,8 +18,21 @@ import (
 	"github.com/shopspring/decimal"
 )
 
+const (
+	marketShares = "shares"
+	marketBonds  = "bonds"
+)
+
+type board struct {
+	market         string
+	boardID        string
+	instrumentType moexModel.InstrumentType
+}
+
 type MoexApi struct {
 	client *resty.Client
+	// boards - режимы торгов, в которых ищем инструменты. Порядок важен: тикер берется из первого режима, где он найден
+	boards []board
 }
 
 func New(cfg *config.Config) *MoexApi {
@@ -25,18 +40,35 @@ func New(cfg *config.Config) *MoexApi {
 		SetDebug(cfg.API.Debug).
 		SetTimeout(cfg.API.Timeout).
 		SetBaseURL(cfg.API.MoexApi.Url)
-	return &MoexApi{client: client}
+
+	boards := make([]board, 0, len(cfg.API.MoexApi.SharesBoards)+len(cfg.API.MoexApi.FundsBoards)+len(cfg.API.MoexApi.BondsBoards))
+	for _, boardID := range cfg.API.MoexApi.SharesBoards {
+		boards = append(boards, board{market: marketShares, boardID: boardID, instrumentType: moexModel.InstrumentTypeShare})
+	}
+	// фонды торгуются на рынке акций, но в отдельных режимах торгов
+	for _, boardID := range cfg.API.MoexApi.FundsBoards {
+		boards = append(boards, board{market: marketShares, boardID: boardID, instrumentType: moexModel.InstrumentTypeFund})
+	}
+	for _, boardID := range cfg.API.MoexApi.BondsBoards {
+		boards = append(boards, board{market: marketBonds, boardID: boardID, instrumentType: moexModel.InstrumentTypeBond})
+	}
+
+	return &MoexApi{client: client, boards: boards}
 }
 
-func (a *MoexApi) getStocsInfo(ctx context.Context, tickers ...string) (moexModel.RawStocksInfo, error) {
+func (a *MoexApi) getStocsInfo(ctx context.Context, b board, tickers ...string) (moexModel.RawStocksInfo, error) {
 	rqId := utils.GetRequestIDFromCtx(ctx)
-	url := "/iss/engines/stock/markets/shares/boards/TQBR/securities.json"
+	url := fmt.Sprintf("/iss/engines/stock/markets/%s/boards/%s/securities.json", b.market, b.boardID)
 	params := map[string]string{
 		"iss.meta":           "off",
 		"securities.columns": "SECID,SHORTNAME,LOTSIZE,CURRENCYID,STATUS",
 		"marketdata.columns": "SECID,LAST,MARKETPRICE",
 	}
 
+	if b.market == marketBonds {
+		params["securities.columns"] = "SECID,SHORTNAME,LOTSIZE,CURRENCYID,STATUS,FACEVALUE,ACCRUEDINT,COUPONVALUE,NEXTCOUPON,MATDATE"
+	}
+
 	if len(tickers) > 0 {
 		params["securities"] = strings.Join(tickers, ",")
 	}
@@ -70,16 +102,21 @@ func (a *MoexApi) GetAllStocsInfo(ctx context.Context) ([]moexModel.StockInfo, e
 
 	slog.Debug("start MoexApi.GetStocsInfo request", slog.String("rqID", rqId))
 
-	rawStocksInfo, err := a.getStocsInfo(ctx)
-	if err != nil {
-		slog.Error("got error from MoexApi.getStocsInfo", slog.String("err", err.Error()), slog.String("rqID", rqId))
-		return nil, err
-	}
+	res := make([]moexModel.StockInfo, 0)
+	for _, b := range a.boards {
+		rawStocksInfo, err := a.getStocsInfo(ctx, b)
+		if err != nil {
+			slog.Error("got error from MoexApi.getStocsInfo", slog.String("err", err.Error()), slog.String("rqID", rqId), slog.String("board", b.boardID))
+			return nil, err
+		}
 
-	res, err := a.parseRawStocksInfoToSlice(rawStocksInfo)
-	if err != nil {
-		slog.Error("can't parse raw data", slog.String("err", err.Error()), slog.String("rqID", rqId))
-		return nil, err
+		boardStocks, err := a.parseRawStocksInfoToSlice(rawStocksInfo, b)
+		if err != nil {
+			slog.Error("can't parse raw data", slog.String("err", err.Error()), slog.String("rqID", rqId), slog.String("board", b.boardID))
+			return nil, err
+		}
+
+		res = append(res, boardStocks...)
 	}
 
 	slog.Debug("MoexApi.GetStocsInfo request complete", slog.String("rqID", rqId))
@@ -92,21 +129,28 @@ func (a *MoexApi) GetStocInfo(ctx context.Context, ticker string) (moexModel.Sto
 
 	slog.Debug("start MoexApi.GetStocInfo request", slog.String("rqID", rqId))
 
-	rawStocksInfo, err := a.getStocsInfo(ctx, ticker)
-	if err != nil {
-		slog.Error("got error from MoexApi.getStocsInfo", slog.String("err", err.Error()), slog.String("rqID", rqId))
-		return moexModel.StockInfo{}, err
-	}
+	for _, b := range a.boards {
+		rawStocksInfo, err := a.getStocsInfo(ctx, b, ticker)
+		if err != nil {
+			slog.Error("got error from MoexApi.getStocsInfo", slog.String("err", err.Error()), slog.String("rqID", rqId), slog.String("board", b.boardID))
+			return moexModel.StockInfo{}, err
+		}
 
-	res, err := a.parseRawStocksInfoSingle(rawStocksInfo)
-	if err != nil {
-		slog.Error("can't parse raw data", slog.String("err", err.Error()), slog.String("rqID", rqId))
-		return moexModel.StockInfo{}, err
-	}
+		res, err := a.parseRawStocksInfoSingle(rawStocksInfo, b)
+		if err != nil {
+			if errors.Is(err, externalApi.ErrNotFound) { // пробуем следующий режим торгов
+				continue
+			}
+			slog.Error("can't parse raw data", slog.String("err", err.Error()), slog.String("rqID", rqId), slog.String("board", b.boardID))
+			return moexModel.StockInfo{}, err
+		}
 
-	slog.Debug("MoexApi.GetStocInfo request complete", slog.String("rqID", rqId))
+		slog.Debug("MoexApi.GetStocInfo request complete", slog.String("rqID", rqId), slog.String("board", b.boardID))
 
-	return res, nil
+		return res, nil
+	}
+
+	return moexModel.StockInfo{}, externalApi.ErrNotFound
 }
 
 func (a *MoexApi) GetStocsInfo(ctx context.Context, tickers []string) (map[string]moexModel.StockInfo, error) {
@@ -114,16 +158,35 @@ func (a *MoexApi) GetStocsInfo(ctx context.Context, tickers []string) (map[strin
 
 	slog.Debug("start MoexApi.GetStocsInfo request", slog.String("rqID", rqId))
 
-	rawStocksInfo, err := a.getStocsInfo(ctx, tickers...)
-	if err != nil {
-		slog.Error("got error from MoexApi.getStocsInfo", slog.String("err", err.Error()), slog.String("rqID", rqId))
-		return nil, err
-	}
+	stocksInfoMap := make(map[string]moexModel.StockInfo, len(tickers))
+	notFoundTickers := tickers
+	for _, b := range a.boards {
+		if len(notFoundTickers) == 0 {
+			break
+		}
 
-	stocksInfoMap, err := a.parseRawStocksInfoToMap(rawStocksInfo)
-	if err != nil {
-		slog.Error("can't parse raw data to map", slog.String("err", err.Error()), slog.String("rqID", rqId))
-		return nil, err
+		rawStocksInfo, err := a.getStocsInfo(ctx, b, notFoundTickers...)
+		if err != nil {
+			slog.Error("got error from MoexApi.getStocsInfo", slog.String("err", err.Error()), slog.String("rqID", rqId), slog.String("board", b.boardID))
+			return nil, err
+		}
+
+		err = a.handleRawStocksInfo(rawStocksInfo, b, func(stock moexModel.StockInfo) {
+			stocksInfoMap[stock.Ticker] = stock
+		})
+		if err != nil {
+			slog.Error("can't parse raw data to map", slog.String("err", err.Error()), slog.String("rqID", rqId), slog.String("board", b.boardID))
+			return nil, err
+		}
+
+		// в следующих режимах торгов ищем только то, что еще не нашли
+		remaining := make([]string, 0, len(notFoundTickers))
+		for _, ticker := range notFoundTickers {
+			if _, ok := stocksInfoMap[ticker]; !ok {
+				remaining = append(remaining, ticker)
+			}
+		}
+		notFoundTickers = remaining
 	}
 
 	slog.Debug("MoexApi.GetStocsInfo request complete", slog.String("rqID", rqId))
@@ -131,14 +194,14 @@ func (a *MoexApi) GetStocsInfo(ctx context.Context, tickers []string) (map[strin
 	return stocksInfoMap, nil
 }
 
-func (a *MoexApi) parseRawStocksInfoToSlice(rawStocksInfo moexModel.RawStocksInfo) ([]moexModel.StockInfo, error) {
+func (a *MoexApi) parseRawStocksInfoToSlice(rawStocksInfo moexModel.RawStocksInfo, b board) ([]moexModel.StockInfo, error) {
 	if len(rawStocksInfo.Marketdata.Data) != len(rawStocksInfo.Securities.Data) {
 		return nil, errors.New("lengths Marketdata != Securities")
 	}
 
 	res := make([]moexModel.StockInfo, 0, len(rawStocksInfo.Marketdata.Data))
 
-	err := a.handleRawStocksInfo(rawStocksInfo, func(stock moexModel.StockInfo) {
+	err := a.handleRawStocksInfo(rawStocksInfo, b, func(stock moexModel.StockInfo) {
 		res = append(res, stock)
 	})
 	if err != nil {
@@ -148,24 +211,7 @@ func (a *MoexApi) parseRawStocksInfoToSlice(rawStocksInfo moexModel.RawStocksInf
 	return res, nil
 }
 
-func (a *MoexApi) parseRawStocksInfoToMap(rawStocksInfo moexModel.RawStocksInfo) (map[string]moexModel.StockInfo, error) {
-	if len(rawStocksInfo.Marketdata.Data) != len(rawStocksInfo.Securities.Data) {
-		return nil, errors.New("lengths Marketdata != Securities")
-	}
-
-	res := make(map[string]moexModel.StockInfo, len(rawStocksInfo.Marketdata.Data))
-
-	err := a.handleRawStocksInfo(rawStocksInfo, func(stock moexModel.StockInfo) {
-		res[stock.Ticker] = stock
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	return res, nil
-}
-
-func (a *MoexApi) parseRawStocksInfoSingle(rawStocksInfo moexModel.RawStocksInfo) (moexModel.StockInfo, error) {
+func (a *MoexApi) parseRawStocksInfoSingle(rawStocksInfo moexModel.RawStocksInfo, b board) (moexModel.StockInfo, error) {
 	if len(rawStocksInfo.Marketdata.Data) != len(rawStocksInfo.Securities.Data) {
 		return moexModel.StockInfo{}, errors.New("lengths Marketdata != Securities")
 	}
@@ -174,7 +220,7 @@ func (a *MoexApi) parseRawStocksInfoSingle(rawStocksInfo moexModel.RawStocksInfo
 		return moexModel.StockInfo{}, externalApi.ErrNotFound
 	}
 
-	res, err := a.parseRawStocksInfoToSlice(rawStocksInfo)
+	res, err := a.parseRawStocksInfoToSlice(rawStocksInfo, b)
 	if err != nil {
 		return moexModel.StockInfo{}, err
 	}
@@ -186,7 +232,7 @@ func (a *MoexApi) parseRawStocksInfoSingle(rawStocksInfo moexModel.RawStocksInfo
 	return res[0], nil
 }
 
-func (a *MoexApi) handleRawStocksInfo(rawStocksInfo moexModel.RawStocksInfo, handleFn func(stock moexModel.StockInfo)) error {
+func (a *MoexApi) handleRawStocksInfo(rawStocksInfo moexModel.RawStocksInfo, b board, handleFn func(stock moexModel.StockInfo)) error {
 	if len(rawStocksInfo.Marketdata.Data) != len(rawStocksInfo.Securities.Data) {
 		return errors.New("lengths Marketdata != Securities")
 	}
@@ -200,7 +246,10 @@ func (a *MoexApi) handleRawStocksInfo(rawStocksInfo moexModel.RawStocksInfo, han
 			return errors.New("invalid Securities")
 		}
 
-		stockInfo := moexModel.StockInfo{}
+		stockInfo := moexModel.StockInfo{Board: b.boardID, InstrumentType: b.instrumentType}
+		if b.market == marketBonds {
+			stockInfo.Bond = &moexModel.BondInfo{}
+		}
 
 		for j := 0; j < len(rawStocksInfo.Marketdata.Columns); j++ {
 			ok := true
@@ -258,6 +307,16 @@ func (a *MoexApi) handleRawStocksInfo(rawStocksInfo moexModel.RawStocksInfo, han
 				if ok && status == "A" {
 					stockInfo.Status = true
 				}
+			case "FACEVALUE":
+				stockInfo.Bond.FaceValue, ok = a.parseDecimal(rawStocksInfo.Securities.Data[i][j])
+			case "ACCRUEDINT":
+				stockInfo.Bond.AccruedInt, ok = a.parseDecimal(rawStocksInfo.Securities.Data[i][j])
+			case "COUPONVALUE":
+				stockInfo.Bond.CouponValue, ok = a.parseDecimal(rawStocksInfo.Securities.Data[i][j])
+			case "NEXTCOUPON":
+				stockInfo.Bond.NextCoupon, ok = a.parseDate(rawStocksInfo.Securities.Data[i][j])
+			case "MATDATE":
+				stockInfo.Bond.MatDate, ok = a.parseDate(rawStocksInfo.Securities.Data[i][j])
 			default:
 				return fmt.Errorf("unknownd column %s", rawStocksInfo.Securities.Columns[j])
 			}
@@ -266,7 +325,383 @@ func (a *MoexApi) handleRawStocksInfo(rawStocksInfo moexModel.RawStocksInfo, han
 				return fmt.Errorf("invalid type %s = %v", rawStocksInfo.Securities.Columns[j], rawStocksInfo.Securities.Data[i][j])
 			}
 		}
+
+		if stockInfo.Bond != nil {
+			// у облигаций биржа отдает цену в процентах от номинала, цена в рублях считается в сервисе
+			stockInfo.Bond.CleanPrice = stockInfo.Price
+			stockInfo.Price = decimal.Decimal{}
+		}
+
 		handleFn(stockInfo)
 	}
 	return nil
 }
+
+// parseDecimal разбирает необязательное числовое поле (null допустим)
+func (a *MoexApi) parseDecimal(value any) (decimal.Decimal, bool) {
+	if value == nil {
+		return decimal.Decimal{}, true
+	}
+	f, ok := value.(float64)
+	if !ok {
+		return decimal.Decimal{}, false
+	}
+	return decimal.NewFromFloat(f), true
+}
+
+// parseDate разбирает необязательную дату в формате YYYY-MM-DD, "0000-00-00" и null считаются пустой датой
+func (a *MoexApi) parseDate(value any) (time.Time, bool) {
+	if value == nil {
+		return time.Time{}, true
+	}
+	str, ok := value.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	date, err := time.Parse(time.DateOnly, str)
+	if err != nil {
+		return time.Time{}, true
+	}
+	return date, true
+}
+
+func (a *MoexApi) getIndexAnalyticsPage(ctx context.Context, indexID string, start int) (moexModel.RawIndexAnalytics, error) {
+	rqId := utils.GetRequestIDFromCtx(ctx)
+	url := fmt.Sprintf("/iss/statistics/engines/stock/markets/index/analytics/%s.json", indexID)
+	params := map[string]string{
+		"iss.meta":                 "off",
+		"analytics.columns":        "ticker,shortnames,weight,tradedate",
+		"analytics.cursor.columns": "INDEX,TOTAL,PAGESIZE",
+		"limit":                    "100",
+		"start":                    strconv.Itoa(start),
+	}
+
+	slog.Debug("start MoexApi.getIndexAnalyticsPage request", slog.String("rqID", rqId), slog.String("url", url), slog.Any("params", params))
+
+	resp, err := a.client.R().
+		SetHeader("Accept", "application/json").
+		SetQueryParams(params).
+		Get(url)
+
+	if err != nil {
+		slog.Error("error while dialing MoexApi", slog.String("err", err.Error()), slog.String("rqID", rqId))
+		return moexModel.RawIndexAnalytics{}, err
+	}
+
+	rawIndexAnalytics := moexModel.RawIndexAnalytics{}
+	err = json.Unmarshal(resp.Body(), &rawIndexAnalytics)
+	if err != nil {
+		slog.Error("can't unmarshall response into moexModel.RawIndexAnalytics", slog.String("err", err.Error()), slog.String("rqID", rqId))
+		return moexModel.RawIndexAnalytics{}, err
+	}
+
+	slog.Debug("MoexApi.getIndexAnalyticsPage request complete", slog.String("rqID", rqId))
+
+	return rawIndexAnalytics, nil
+}
+
+// GetIndexComposition возвращает актуальный состав индекса (IMOEX, MOEXBC и т.д.) с весами бумаг
+func (a *MoexApi) GetIndexComposition(ctx context.Context, indexID string) ([]moexModel.IndexComponent, error) {
+	rqId := utils.GetRequestIDFromCtx(ctx)
+
+	slog.Debug("start MoexApi.GetIndexComposition request", slog.String("rqID", rqId), slog.String("indexID", indexID))
+
+	res := make([]moexModel.IndexComponent, 0)
+	start := 0
+	for {
+		rawIndexAnalytics, err := a.getIndexAnalyticsPage(ctx, indexID, start)
+		if err != nil {
+			return nil, err
+		}
+
+		components, err := a.parseIndexComponents(rawIndexAnalytics.Analytics)
+		if err != nil {
+			slog.Error("can't parse raw index analytics", slog.String("err", err.Error()), slog.String("rqID", rqId))
+			return nil, err
+		}
+		res = append(res, components...)
+
+		total, pageSize, err := a.parseCursor(rawIndexAnalytics.AnalyticsCursor)
+		if err != nil {
+			slog.Error("can't parse cursor", slog.String("err", err.Error()), slog.String("rqID", rqId))
+			return nil, err
+		}
+
+		start += pageSize
+		if len(components) == 0 || pageSize <= 0 || start >= total {
+			break
+		}
+	}
+
+	if len(res) == 0 {
+		return nil, externalApi.ErrNotFound
+	}
+
+	slog.Debug("MoexApi.GetIndexComposition request complete", slog.String("rqID", rqId), slog.Int("components", len(res)))
+
+	return res, nil
+}
+
+func (a *MoexApi) parseIndexComponents(analytics moexModel.Analytics) ([]moexModel.IndexComponent, error) {
+	res := make([]moexModel.IndexComponent, 0, len(analytics.Data))
+	for i := 0; i < len(analytics.Data); i++ {
+		if len(analytics.Data[i]) != len(analytics.Columns) {
+			return nil, errors.New("invalid Analytics")
+		}
+
+		component := moexModel.IndexComponent{}
+		for j := 0; j < len(analytics.Columns); j++ {
+			ok := true
+			switch analytics.Columns[j] {
+			case "ticker":
+				component.Ticker, ok = analytics.Data[i][j].(string)
+			case "shortnames":
+				component.Shortname, ok = analytics.Data[i][j].(string)
+			case "weight":
+				component.Weight, ok = a.parseDecimal(analytics.Data[i][j])
+			case "tradedate":
+				component.TradeDate, ok = a.parseDate(analytics.Data[i][j])
+			default:
+				return nil, fmt.Errorf("unknown column %s", analytics.Columns[j])
+			}
+
+			if !ok {
+				return nil, fmt.Errorf("invalid type %s = %v", analytics.Columns[j], analytics.Data[i][j])
+			}
+		}
+
+		res = append(res, component)
+	}
+	return res, nil
+}
+
+func (a *MoexApi) parseCursor(cursor moexModel.Cursor) (total, pageSize int, err error) {
+	if len(cursor.Data) == 0 {
+		return 0, 0, nil
+	}
+
+	if len(cursor.Data[0]) != len(cursor.Columns) {
+		return 0, 0, errors.New("invalid Cursor")
+	}
+
+	for j := 0; j < len(cursor.Columns); j++ {
+		f, ok := cursor.Data[0][j].(float64)
+		if !ok {
+			return 0, 0, fmt.Errorf("invalid type %s = %v", cursor.Columns[j], cursor.Data[0][j])
+		}
+
+		switch cursor.Columns[j] {
+		case "TOTAL":
+			total = int(f)
+		case "PAGESIZE":
+			pageSize = int(f)
+		}
+	}
+	return total, pageSize, nil
+}
+
+func (a *MoexApi) getHistoryPage(ctx context.Context, b board, ticker string, from, till time.Time, start int) (moexModel.RawHistory, error) {
+	rqId := utils.GetRequestIDFromCtx(ctx)
+	url := fmt.Sprintf("/iss/history/engines/stock/markets/%s/boards/%s/securities/%s.json", b.market, b.boardID, ticker)
+	params := map[string]string{
+		"iss.meta":               "off",
+		"history.columns":        "TRADEDATE,OPEN,LOW,HIGH,CLOSE,VOLUME",
+		"history.cursor.columns": "INDEX,TOTAL,PAGESIZE",
+		"from":                   from.Format(time.DateOnly),
+		"till":                   till.Format(time.DateOnly),
+		"start":                  strconv.Itoa(start),
+	}
+
+	slog.Debug("start MoexApi.getHistoryPage request", slog.String("rqID", rqId), slog.String("url", url), slog.Any("params", params))
+
+	resp, err := a.client.R().
+		SetHeader("Accept", "application/json").
+		SetQueryParams(params).
+		Get(url)
+
+	if err != nil {
+		slog.Error("error while dialing MoexApi", slog.String("err", err.Error()), slog.String("rqID", rqId))
+		return moexModel.RawHistory{}, err
+	}
+
+	rawHistory := moexModel.RawHistory{}
+	err = json.Unmarshal(resp.Body(), &rawHistory)
+	if err != nil {
+		slog.Error("can't unmarshall response into moexModel.RawHistory", slog.String("err", err.Error()), slog.String("rqID", rqId))
+		return moexModel.RawHistory{}, err
+	}
+
+	slog.Debug("MoexApi.getHistoryPage request complete", slog.String("rqID", rqId))
+
+	return rawHistory, nil
+}
+
+// GetPriceHistory возвращает дневные свечи по тикеру в режиме торгов boardID за период [from, till]
+func (a *MoexApi) GetPriceHistory(ctx context.Context, ticker, boardID string, from, till time.Time) ([]moexModel.PriceCandle, error) {
+	rqId := utils.GetRequestIDFromCtx(ctx)
+
+	slog.Debug("start MoexApi.GetPriceHistory request", slog.String("rqID", rqId), slog.String("ticker", ticker), slog.String("board", boardID))
+
+	b := a.findBoard(boardID)
+
+	res := make([]moexModel.PriceCandle, 0)
+	start := 0
+	for {
+		rawHistory, err := a.getHistoryPage(ctx, b, ticker, from, till, start)
+		if err != nil {
+			return nil, err
+		}
+
+		candles, err := a.parsePriceCandles(rawHistory.History, ticker, b.boardID)
+		if err != nil {
+			slog.Error("can't parse raw history", slog.String("err", err.Error()), slog.String("rqID", rqId))
+			return nil, err
+		}
+		res = append(res, candles...)
+
+		total, pageSize, err := a.parseCursor(rawHistory.HistoryCursor)
+		if err != nil {
+			slog.Error("can't parse cursor", slog.String("err", err.Error()), slog.String("rqID", rqId))
+			return nil, err
+		}
+
+		start += pageSize
+		if len(rawHistory.History.Data) == 0 || pageSize <= 0 || start >= total {
+			break
+		}
+	}
+
+	slog.Debug("MoexApi.GetPriceHistory request complete", slog.String("rqID", rqId), slog.Int("candles", len(res)))
+
+	return res, nil
+}
+
+// findBoard ищет режим торгов среди настроенных, неизвестный считаем режимом рынка акций
+func (a *MoexApi) findBoard(boardID string) board {
+	for _, b := range a.boards {
+		if b.boardID == boardID {
+			return b
+		}
+	}
+	return board{market: marketShares, boardID: boardID, instrumentType: moexModel.InstrumentTypeShare}
+}
+
+func (a *MoexApi) parsePriceCandles(history moexModel.History, ticker, boardID string) ([]moexModel.PriceCandle, error) {
+	res := make([]moexModel.PriceCandle, 0, len(history.Data))
+	for i := 0; i < len(history.Data); i++ {
+		if len(history.Data[i]) != len(history.Columns) {
+			return nil, errors.New("invalid History")
+		}
+
+		candle := moexModel.PriceCandle{Ticker: ticker, Board: boardID}
+		for j := 0; j < len(history.Columns); j++ {
+			ok := true
+			switch history.Columns[j] {
+			case "TRADEDATE":
+				candle.TradeDate, ok = a.parseDate(history.Data[i][j])
+			case "OPEN":
+				candle.Open, ok = a.parseDecimal(history.Data[i][j])
+			case "LOW":
+				candle.Low, ok = a.parseDecimal(history.Data[i][j])
+			case "HIGH":
+				candle.High, ok = a.parseDecimal(history.Data[i][j])
+			case "CLOSE":
+				candle.Close, ok = a.parseDecimal(history.Data[i][j])
+			case "VOLUME":
+				var volume decimal.Decimal
+				volume, ok = a.parseDecimal(history.Data[i][j])
+				candle.Volume = volume.IntPart()
+			default:
+				return nil, fmt.Errorf("unknown column %s", history.Columns[j])
+			}
+
+			if !ok {
+				return nil, fmt.Errorf("invalid type %s = %v", history.Columns[j], history.Data[i][j])
+			}
+		}
+
+		// в дни без сделок биржа отдает пустую цену закрытия
+		if candle.TradeDate.IsZero() || candle.Close.IsZero() {
+			continue
+		}
+
+		res = append(res, candle)
+	}
+	return res, nil
+}
+
+// GetDividends возвращает прошлые и объявленные дивиденды по тикеру
+func (a *MoexApi) GetDividends(ctx context.Context, ticker string) ([]moexModel.Dividend, error) {
+	rqId := utils.GetRequestIDFromCtx(ctx)
+	url := fmt.Sprintf("/iss/securities/%s/dividends.json", ticker)
+	params := map[string]string{
+		"iss.meta":          "off",
+		"dividends.columns": "secid,registryclosedate,value,currencyid",
+	}
+
+	slog.Debug("start MoexApi.GetDividends request", slog.String("rqID", rqId), slog.String("url", url), slog.Any("params", params))
+
+	resp, err := a.client.R().
+		SetHeader("Accept", "application/json").
+		SetQueryParams(params).
+		Get(url)
+
+	if err != nil {
+		slog.Error("error while dialing MoexApi", slog.String("err", err.Error()), slog.String("rqID", rqId))
+		return nil, err
+	}
+
+	rawDividends := moexModel.RawDividends{}
+	err = json.Unmarshal(resp.Body(), &rawDividends)
+	if err != nil {
+		slog.Error("can't unmarshall response into moexModel.RawDividends", slog.String("err", err.Error()), slog.String("rqID", rqId))
+		return nil, err
+	}
+
+	dividends, err := a.parseDividends(rawDividends.Dividends)
+	if err != nil {
+		slog.Error("can't parse raw dividends", slog.String("err", err.Error()), slog.String("rqID", rqId))
+		return nil, err
+	}
+
+	slog.Debug("MoexApi.GetDividends request complete", slog.String("rqID", rqId), slog.Int("dividends", len(dividends)))
+
+	return dividends, nil
+}
+
+func (a *MoexApi) parseDividends(dividends moexModel.Dividends) ([]moexModel.Dividend, error) {
+	res := make([]moexModel.Dividend, 0, len(dividends.Data))
+	for i := 0; i < len(dividends.Data); i++ {
+		if len(dividends.Data[i]) != len(dividends.Columns) {
+			return nil, errors.New("invalid Dividends")
+		}
+
+		dividend := moexModel.Dividend{}
+		for j := 0; j < len(dividends.Columns); j++ {
+			ok := true
+			switch dividends.Columns[j] {
+			case "secid":
+				dividend.Ticker, ok = dividends.Data[i][j].(string)
+			case "registryclosedate":
+				dividend.RegistryCloseDate, ok = a.parseDate(dividends.Data[i][j])
+			case "value":
+				dividend.Value, ok = a.parseDecimal(dividends.Data[i][j])
+			case "currencyid":
+				dividend.CurrencyID, ok = dividends.Data[i][j].(string)
+			default:
+				return nil, fmt.Errorf("unknown column %s", dividends.Columns[j])
+			}
+
+			if !ok {
+				return nil, fmt.Errorf("invalid type %s = %v", dividends.Columns[j], dividends.Data[i][j])
+			}
+		}
+
+		if dividend.RegistryCloseDate.IsZero() || dividend.Value.IsZero() {
+			continue
+		}
+
+		res = append(res, dividend)
+	}
+	return res, nil
+}
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type Broker string
+
+const (
+	BrokerTinkoff Broker = "tinkoff"
+	BrokerSber    Broker = "sber"
+	BrokerVTB     Broker = "vtb"
+	BrokerAlfa    Broker = "alfa"
+)
+
+// BrokerTrade - сделка из отчета брокера
+type BrokerTrade struct {
+	TradeID    string // номер сделки у брокера (может быть пустым)
+	Ticker     string
+	Quantity   int // у продажи отрицательное
+	Price      decimal.Decimal
+	Commission decimal.Decimal // комиссия брокера и биржи вместе
+	TradeDate  time.Time
+
+	Duplicate     bool // сделка уже есть в истории портфеля
+	UnknownTicker bool // бумага не найдена на бирже, сделка не будет загружена
+}
+
+// BrokerDividend - выплата дивидендов из отчета брокера
+type BrokerDividend struct {
+	Ticker      string
+	PaymentDate time.Time
+	NetAmount   decimal.Decimal // зачислено на счет
+	TaxAmount   decimal.Decimal // удержанный налог (0, если в отчете не указан)
+
+	Duplicate bool // дивиденд уже учтен в журнале
+}
+
+// BrokerFee - списание брокера, не относящееся к конкретной сделке (обслуживание счета, депозитарий и т.п.)
+type BrokerFee struct {
+	Date        time.Time
+	Amount      decimal.Decimal // положительная сумма списания
+	Description string
+
+	Duplicate bool
+}
+
+type BrokerReport struct {
+	Broker    Broker
+	Trades    []BrokerTrade
+	Dividends []BrokerDividend
+	Fees      []BrokerFee
+}
+
+// BrokerImportPreview - что будет загружено из отчета в портфель
+type BrokerImportPreview struct {
+	PortfolioName string
+	Report        BrokerReport
+	Problem       string // почему загрузить отчет нельзя (пусто, если можно)
+}
+
+// BrokerImportResult - сколько записей загружено из отчета
+type BrokerImportResult struct {
+	Trades    int
+	Dividends int
+	Fees      int
+}
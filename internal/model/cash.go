@@ -0,0 +1,39 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type CashOperationType string
+
+const (
+	CashOperationDeposit    CashOperationType = "deposit"    // пополнение
+	CashOperationWithdrawal CashOperationType = "withdrawal" // вывод
+	CashOperationBuy        CashOperationType = "buy"        // оплата покупки бумаг
+	CashOperationSell       CashOperationType = "sell"       // поступление от продажи бумаг
+	CashOperationDividend   CashOperationType = "dividend"   // дивиденды до налога
+	CashOperationCoupon     CashOperationType = "coupon"     // купоны по облигациям
+	CashOperationCommission CashOperationType = "commission" // комиссия брокера
+	CashOperationTax        CashOperationType = "tax"        // удержанный налог
+)
+
+// CashOperation - запись в журнале денежных средств портфеля. Amount со знаком: поступления положительные, списания отрицательные.
+type CashOperation struct {
+	RowID         int64
+	PortfolioID   int64
+	OperationType CashOperationType
+	Amount        decimal.Decimal
+	Ticker        string
+	OperationID   int64 // сделка, по которой сделана проводка (0 - не связана со сделкой)
+	DtCreate      time.Time
+}
+
+// PortfolioCash - свободные деньги портфеля и последние операции по ним
+type PortfolioCash struct {
+	PortfolioID   int64
+	PortfolioName string
+	Balance       decimal.Decimal
+	Operations    []CashOperation
+}
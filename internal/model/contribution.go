@@ -0,0 +1,30 @@
+package model
+
+import "github.com/shopspring/decimal"
+
+// ContributionAllocation - доля пополнения, которая уходит в портфель
+type ContributionAllocation struct {
+	PortfolioID   int64
+	PortfolioName string
+	Percent       decimal.Decimal
+}
+
+// PortfolioContribution - часть пополнения, рассчитанная для одного портфеля
+type PortfolioContribution struct {
+	PortfolioID   int64
+	PortfolioName string
+	Allocated     decimal.Decimal // доля пополнения
+	CarriedOver   decimal.Decimal // остаток предыдущего портфеля
+	Purchases     []StockPurchase
+	Spent         decimal.Decimal
+	Remainder     decimal.Decimal // переходит в следующий портфель
+	Skipped       bool            // закуп по пустому портфелю не рассчитан, вся сумма перешла в следующий
+}
+
+// ContributionPlan - распределение пополнения по портфелям с закупом в каждом
+type ContributionPlan struct {
+	Sum         decimal.Decimal
+	Portfolios  []PortfolioContribution
+	Unallocated decimal.Decimal // часть суммы, не распределенная долями
+	Remainder   decimal.Decimal // остаток последнего портфеля вместе с нераспределенной частью
+}
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+type CorporateActionType string
+
+const (
+	CorporateActionSplit  CorporateActionType = "split"  // сплит или обратный сплит
+	CorporateActionRename CorporateActionType = "rename" // смена тикера
+)
+
+// CorporateAction - корпоративное действие эмитента, которое применяется ко всем портфелям с бумагой
+type CorporateAction struct {
+	Type          CorporateActionType
+	Ticker        string
+	NewTicker     string // для смены тикера
+	RatioFrom     int    // для сплита: RatioFrom старых бумаг превращаются в RatioTo новых
+	RatioTo       int
+	EffectiveDate time.Time
+	ChatID        int64 // кто применил
+}
+
+type CorporateActionResult struct {
+	Action     CorporateAction
+	Portfolios int // сколько портфелей затронуто
+}
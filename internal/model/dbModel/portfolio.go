@@ -1,6 +1,51 @@
 package dbModel
 
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
 type Portfolio struct {
-	PortfolioID int64  `db:"portfolio_id"`
-	Name        string `db:"name"`
+	PortfolioID int64   `db:"portfolio_id"`
+	Name        string  `db:"name"`
+	IndexID     *string `db:"index_id"`
+
+	DividendNotifications bool            `db:"dividend_notifications"`
+	CommissionPercent     decimal.Decimal `db:"commission_percent"`
+	PurchaseStrategy      string          `db:"purchase_strategy"`
+	AllowSells            bool            `db:"allow_sells"`
+}
+
+type LinkedPortfolio struct {
+	Portfolio
+	ChatID              int64  `db:"chat_id"`
+	IndexDriftSignature string `db:"index_drift_signature"`
+}
+
+type PortfolioSnapshot struct {
+	SnapshotID            int64           `db:"snapshot_id"`
+	PortfolioID           int64           `db:"portfolio_id"`
+	SnapshotDate          time.Time       `db:"snapshot_date"`
+	BalanceInsideIndex    decimal.Decimal `db:"balance_inside_index"`
+	BalanceOutsideIndex   decimal.Decimal `db:"balance_outside_index"`
+	GrowthSumInsideIndex  decimal.Decimal `db:"growth_sum_inside_index"`
+	GrowthSumOutsideIndex decimal.Decimal `db:"growth_sum_outside_index"`
+	IndexOffset           decimal.Decimal `db:"index_offset"`
+}
+
+type CashOperation struct {
+	RowID         int64           `db:"row_id"`
+	PortfolioID   int64           `db:"portfolio_id"`
+	OperationType string          `db:"operation_type"`
+	Amount        decimal.Decimal `db:"amount"`
+	Ticker        string          `db:"ticker"`
+	OperationID   int64           `db:"operation_id"`
+	DtCreate      time.Time       `db:"dt_create"`
+}
+
+type ContributionAllocation struct {
+	PortfolioID   int64           `db:"portfolio_id"`
+	PortfolioName string          `db:"name"`
+	Percent       decimal.Decimal `db:"percent"`
 }
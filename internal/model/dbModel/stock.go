@@ -7,21 +7,32 @@ import (
 )
 
 type Stock struct {
-	PortfolioID int64           `db:"portfolio_id"`
-	Ticker      string          `db:"ticker"`
-	Weight      decimal.Decimal `db:"weight"`
-	Quantity    int             `db:"quantity"`
+	PortfolioID    int64           `db:"portfolio_id"`
+	Ticker         string          `db:"ticker"`
+	Weight         decimal.Decimal `db:"weight"`
+	Quantity       int             `db:"quantity"`
+	Board          string          `db:"board"`
+	InstrumentType string          `db:"instrument_type"`
 }
 
 type StockOperation struct {
-	PortfolioID int64           `db:"portfolio_id"`
-	Ticker      string          `db:"ticker"`
-	Shortname   string          `db:"shortname"`
-	Quantity    int             `db:"quantity"`
-	Price       decimal.Decimal `db:"price"`
-	TotalPrice  decimal.Decimal `db:"total_price"`
-	Currency    string          `db:"currency"`
-	DtCreate    time.Time       `db:"dt_create"`
+	OperationID         int64           `db:"operation_id"`
+	PortfolioID         int64           `db:"portfolio_id"`
+	Ticker              string          `db:"ticker"`
+	Shortname           string          `db:"shortname"`
+	Quantity            int             `db:"quantity"`
+	Price               decimal.Decimal `db:"price"`
+	TotalPrice          decimal.Decimal `db:"total_price"`
+	Commission          decimal.Decimal `db:"commission"`
+	Currency            string          `db:"currency"`
+	DtCreate            time.Time       `db:"dt_create"`
+	ExternalID          string          `db:"external_id"`
+	OperationType       string          `db:"operation_type"`
+	DtAcquired          *time.Time      `db:"dt_acquired"`
+	TransferPortfolioID int64           `db:"transfer_portfolio_id"`
+	RatioFrom           int             `db:"ratio_from"`
+	RatioTo             int             `db:"ratio_to"`
+	DtInsert            time.Time       `db:"dt_insert"`
 }
 
 type StockRemaining struct {
@@ -33,3 +44,51 @@ type StockRemaining struct {
 	DtCreate    time.Time       `db:"dt_create"`
 	DtUpdate    time.Time       `db:"dt_update"`
 }
+
+type TickerRealizedPnL struct {
+	Year     int             `db:"year"`
+	Ticker   string          `db:"ticker"`
+	Quantity int             `db:"quantity"`
+	Profit   decimal.Decimal `db:"profit"`
+}
+
+type RealizedLot struct {
+	PortfolioID int64           `db:"portfolio_id"`
+	Ticker      string          `db:"ticker"`
+	Quantity    int             `db:"quantity"`
+	BuyPrice    decimal.Decimal `db:"buy_price"`
+	SellPrice   decimal.Decimal `db:"sell_price"`
+	BuyDate     time.Time       `db:"buy_date"`
+	SellDate    time.Time       `db:"sell_date"`
+}
+
+type Dividend struct {
+	Ticker            string          `db:"ticker"`
+	RegistryCloseDate time.Time       `db:"registry_close_date"`
+	Value             decimal.Decimal `db:"value"`
+	Currency          string          `db:"currency"`
+}
+
+type DividendIncome struct {
+	RowID          int64           `db:"row_id"`
+	PortfolioID    int64           `db:"portfolio_id"`
+	Ticker         string          `db:"ticker"`
+	DividendDate   time.Time       `db:"dividend_date"`
+	Quantity       int             `db:"quantity"`
+	AmountPerShare decimal.Decimal `db:"amount_per_share"`
+	GrossAmount    decimal.Decimal `db:"gross_amount"`
+	TaxAmount      decimal.Decimal `db:"tax_amount"`
+	NetAmount      decimal.Decimal `db:"net_amount"`
+	Source         string          `db:"source"`
+}
+
+type UpcomingDividend struct {
+	PortfolioID       int64           `db:"portfolio_id"`
+	PortfolioName     string          `db:"name"`
+	ChatID            int64           `db:"chat_id"`
+	Ticker            string          `db:"ticker"`
+	Quantity          int             `db:"quantity"`
+	RegistryCloseDate time.Time       `db:"registry_close_date"`
+	Value             decimal.Decimal `db:"value"`
+	Currency          string          `db:"currency"`
+}
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model/moexModel"
+	"github.com/shopspring/decimal"
+)
+
+type DividendSource string
+
+const (
+	DividendSourceAuto   DividendSource = "auto"   // начислено по количеству акций на дату закрытия реестра
+	DividendSourceManual DividendSource = "manual" // введено пользователем (сумма после налога)
+	DividendSourceImport DividendSource = "import" // загружено из отчета брокера
+)
+
+// DividendIncome - запись в журнале полученных дивидендов портфеля
+type DividendIncome struct {
+	RowID          int64
+	PortfolioID    int64
+	Ticker         string
+	DividendDate   time.Time
+	Quantity       int
+	AmountPerShare decimal.Decimal
+	GrossAmount    decimal.Decimal
+	TaxAmount      decimal.Decimal
+	NetAmount      decimal.Decimal
+	Source         DividendSource
+}
+
+// StockDividends - дивидендная информация по бумаге в портфеле
+type StockDividends struct {
+	TrailingYearValue decimal.Decimal     // сумма дивидендов на акцию с отсечкой за последние 12 месяцев
+	Yield             decimal.Decimal     // дивдоходность за 12 месяцев от текущей цены, %
+	Next              *moexModel.Dividend // ближайшая объявленная отсечка
+	Received          decimal.Decimal     // получено в портфеле после налога
+	Income            []DividendIncome
+}
+
+// UpcomingDividend - приближающаяся отсечка по бумаге из портфеля, о которой уведомляем владельца
+type UpcomingDividend struct {
+	PortfolioID       int64
+	PortfolioName     string
+	ChatID            int64
+	Ticker            string
+	Quantity          int
+	RegistryCloseDate time.Time
+	LastBuyDate       time.Time // последний день покупки, чтобы попасть в реестр с учетом режима T+1
+	AmountPerShare    decimal.Decimal
+	Currency          string
+	ExpectedNet       decimal.Decimal // ожидаемая выплата после налога по текущему количеству
+}
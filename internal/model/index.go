@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// IndexSyncResult - итог синхронизации целевых весов портфеля с составом индекса
+type IndexSyncResult struct {
+	IndexID   string
+	TradeDate time.Time
+	Added     []string // тикеры, добавленные в портфель
+	Removed   []string // тикеры, выбывшие из индекса (вес обнулен)
+	Updated   int      // кол-во акций портфеля, которым проставлен вес из индекса
+	Skipped   []string // тикеры индекса, которые не удалось найти на бирже
+}
+
+// IndexDrift - расхождение целевых весов портфеля с актуальным составом индекса
+type IndexDrift struct {
+	PortfolioID   int64
+	PortfolioName string
+	ChatID        int64
+	IndexID       string
+	TradeDate     time.Time
+	Added         []IndexWeightChange // вошли в индекс
+	Removed       []IndexWeightChange // выбыли из индекса
+	Changed       []IndexWeightChange // вес изменился больше порога
+	Signature     string
+}
+
+type IndexWeightChange struct {
+	Ticker    string
+	OldWeight decimal.Decimal
+	NewWeight decimal.Decimal
+}
@@ -1,6 +1,18 @@
 package moexModel
 
-import "github.com/shopspring/decimal"
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type InstrumentType string
+
+const (
+	InstrumentTypeShare InstrumentType = "share"
+	InstrumentTypeFund  InstrumentType = "fund"
+	InstrumentTypeBond  InstrumentType = "bond"
+)
 
 type RawStocksInfo struct {
 	Securities Securities `json:"securities"`
@@ -18,10 +30,85 @@ type Marketdata struct {
 }
 
 type StockInfo struct {
-	Ticker     string
-	Shortname  string
-	Lotsize    int
-	CurrencyID string
-	Status     bool
-	Price      decimal.Decimal
+	Ticker         string
+	Shortname      string
+	Lotsize        int
+	CurrencyID     string
+	Status         bool
+	Price          decimal.Decimal
+	Board          string
+	InstrumentType InstrumentType
+	Bond           *BondInfo // заполняется только для облигаций
+}
+
+type BondInfo struct {
+	CleanPrice  decimal.Decimal // чистая цена в процентах от номинала
+	FaceValue   decimal.Decimal
+	AccruedInt  decimal.Decimal // НКД
+	CouponValue decimal.Decimal
+	NextCoupon  time.Time
+	MatDate     time.Time
+}
+
+type RawIndexAnalytics struct {
+	Analytics       Analytics `json:"analytics"`
+	AnalyticsCursor Cursor    `json:"analytics.cursor"`
+}
+
+type Analytics struct {
+	Columns []string `json:"columns"`
+	Data    [][]any  `json:"data"`
+}
+
+// Cursor - блок пагинации ISS (*.cursor)
+type Cursor struct {
+	Columns []string `json:"columns"`
+	Data    [][]any  `json:"data"`
+}
+
+// IndexComponent - бумага из состава индекса с ее весом в процентах
+type IndexComponent struct {
+	Ticker    string
+	Shortname string
+	Weight    decimal.Decimal
+	TradeDate time.Time
+}
+
+type RawHistory struct {
+	History       History `json:"history"`
+	HistoryCursor Cursor  `json:"history.cursor"`
+}
+
+type History struct {
+	Columns []string `json:"columns"`
+	Data    [][]any  `json:"data"`
+}
+
+// PriceCandle - дневная свеча из истории торгов. У облигаций цены в процентах от номинала, как их отдает биржа.
+type PriceCandle struct {
+	Ticker    string
+	Board     string
+	TradeDate time.Time
+	Open      decimal.Decimal
+	High      decimal.Decimal
+	Low       decimal.Decimal
+	Close     decimal.Decimal
+	Volume    int64
+}
+
+type RawDividends struct {
+	Dividends Dividends `json:"dividends"`
+}
+
+type Dividends struct {
+	Columns []string `json:"columns"`
+	Data    [][]any  `json:"data"`
+}
+
+// Dividend - дивиденд из календаря ISS на одну акцию
+type Dividend struct {
+	Ticker            string
+	RegistryCloseDate time.Time // дата закрытия реестра
+	Value             decimal.Decimal
+	CurrencyID        string
 }
@@ -11,6 +11,15 @@ type PortfolioPage struct {
 	Stocks     []Stock
 }
 
+// OperationsHistoryPage - страница истории сделок портфеля, от новых к старым
+type OperationsHistoryPage struct {
+	PortfolioID   int64
+	PortfolioName string
+	CurPage       int
+	HasNextPage   bool
+	Operations    []StockOperation
+}
+
 type PortfolioSummary struct {
 	Portfolio
 	BalanceInsideIndex        decimal.Decimal
@@ -23,15 +32,35 @@ type PortfolioSummary struct {
 	GrowthSumOutsideIndex     decimal.Decimal
 	GrowthPercentInsideIndex  decimal.Decimal
 	GrowthPercentOutsideIndex decimal.Decimal
+	Returns                   PortfolioReturns
+	RealizedPnL               []YearRealizedPnL // по календарным годам, от последнего к первому
+	DividendsReceived         decimal.Decimal   // получено дивидендов после налога за все время
+	DividendsReceivedThisYear decimal.Decimal
+	CashBalance               decimal.Decimal // свободные деньги портфеля
+	TotalBalance              decimal.Decimal // бумаги в индексе и вне индекса вместе со свободными деньгами
 }
 
 type Portfolio struct {
 	PortfolioID   int64
 	PortfolioName string
+	IndexID       string // индекс, за которым следит портфель (пусто, если не привязан)
+
+	DividendNotifications bool            // уведомлять о приближающихся отсечках
+	CommissionPercent     decimal.Decimal // комиссия по умолчанию, % от суммы сделки
+	PurchaseStrategy      PurchaseStrategy
+	AllowSells            bool // предлагать продажи при ребалансировке
+}
+
+// LinkedPortfolio - портфель, привязанный к индексу, вместе с чатом владельца для уведомлений
+type LinkedPortfolio struct {
+	Portfolio
+	ChatID              int64
+	IndexDriftSignature string // отпечаток последнего отправленного расхождения с индексом
 }
 
 type PortfolioFullInfo struct {
 	PortfolioSummary
 	Stocks          []Stock
 	StockOperations []StockOperation
+	TaxReports      []TaxReport // по годам, от последнего к первому
 }
@@ -0,0 +1,51 @@
+package model
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+type CsvKind string
+
+const (
+	CsvKindPositions  CsvKind = "positions"  // бумаги портфеля с целевыми весами
+	CsvKindOperations CsvKind = "operations" // история покупок и продаж
+)
+
+// CsvLineError - ошибка в строке CSV файла (строки нумеруются с 1, заголовок - первая строка)
+type CsvLineError struct {
+	Line    int
+	Message string
+}
+
+type CsvPosition struct {
+	Line   int
+	Ticker string
+	Weight decimal.Decimal
+}
+
+type CsvOperation struct {
+	Line      int
+	Operation StockOperation
+}
+
+// CsvImport - разобранный CSV файл. Если есть ошибки, файл не загружается целиком.
+type CsvImport struct {
+	Kind       CsvKind
+	Positions  []CsvPosition
+	Operations []CsvOperation
+	Errors     []CsvLineError
+}
+
+type CsvImportResult struct {
+	PortfolioID   int64
+	PortfolioName string
+	Kind          CsvKind
+	Positions     int
+	Operations    int
+	Errors        []CsvLineError // если не пусто, портфель не изменен
+}
+
+type CsvFile struct {
+	Filename string
+	Content  []byte
+}
@@ -0,0 +1,10 @@
+package model
+
+import "time"
+
+// PriceHistoryState - тикер из портфелей и дата последней сохраненной свечи (нулевая, если истории еще нет)
+type PriceHistoryState struct {
+	Ticker        string
+	Board         string
+	LastTradeDate time.Time
+}
@@ -0,0 +1,56 @@
+package model
+
+import "github.com/shopspring/decimal"
+
+type PurchaseStrategy string
+
+const (
+	PurchaseStrategyLargestLot      PurchaseStrategy = "largest_lot"      // сначала самые дорогие лоты
+	PurchaseStrategyMostUnderweight PurchaseStrategy = "most_underweight" // сначала самые недокупленные бумаги
+	PurchaseStrategyMinOffset       PurchaseStrategy = "min_offset"       // минимальное отклонение от индекса после закупа
+)
+
+// PurchaseStrategies - все стратегии в порядке показа пользователю
+var PurchaseStrategies = []PurchaseStrategy{
+	PurchaseStrategyLargestLot,
+	PurchaseStrategyMostUnderweight,
+	PurchaseStrategyMinOffset,
+}
+
+// PurchaseConstraints - ограничения расчета закупа, нулевые значения - без ограничений
+type PurchaseConstraints struct {
+	ExcludedTickers []string        // бумаги, которые не покупаются (отсечка, личное вето)
+	MaxPerTicker    decimal.Decimal // не больше этой суммы на одну бумагу
+	MinOrderSum     decimal.Decimal // заявки на меньшую сумму не предлагаются
+}
+
+func (c PurchaseConstraints) IsEmpty() bool {
+	return len(c.ExcludedTickers) == 0 && !c.MaxPerTicker.IsPositive() && !c.MinOrderSum.IsPositive()
+}
+
+// PurchaseInput - данные для распределения суммы закупа по бумагам портфеля
+type PurchaseInput struct {
+	Stocks      []Stock         // бумаги с ненулевым целевым весом, с актуальной ценой, стоимостью и весами
+	Balance     decimal.Decimal // стоимость бумаг портфеля внутри индекса
+	PurchaseSum decimal.Decimal
+	Constraints PurchaseConstraints
+}
+
+// RebalanceInput - данные для плана ребалансировки
+type RebalanceInput struct {
+	PurchaseInput                 // PurchaseSum - сумма довнесения, может быть нулевой
+	Tolerance     decimal.Decimal // допустимое отклонение веса бумаги от целевого, п.п.
+	AllowSells    bool
+}
+
+// RebalancePlan - покупки и продажи целыми лотами, приводящие веса бумаг к целевым с допуском.
+// У продаж в Trades отрицательный LotsQuantity.
+type RebalancePlan struct {
+	Trades       []StockPurchase
+	Contribution decimal.Decimal
+	BuySum       decimal.Decimal
+	SellSum      decimal.Decimal
+	Tolerance    decimal.Decimal
+	AllowSells   bool
+	OutOfBand    []string // тикеры, веса которых после плана остались вне допуска
+}
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// RealizedLot - часть покупки (лота FIFO), закрытая продажей
+type RealizedLot struct {
+	PortfolioID int64
+	Ticker      string
+	Quantity    int
+	BuyPrice    decimal.Decimal
+	SellPrice   decimal.Decimal
+	BuyDate     time.Time
+	SellDate    time.Time
+}
+
+// TickerRealizedPnL - реализованный результат по тикеру за год
+type TickerRealizedPnL struct {
+	Year     int
+	Ticker   string
+	Quantity int
+	Profit   decimal.Decimal
+}
+
+// YearRealizedPnL - реализованный результат портфеля за календарный год
+type YearRealizedPnL struct {
+	Year     int
+	Profit   decimal.Decimal
+	ByTicker []TickerRealizedPnL
+}
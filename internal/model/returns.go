@@ -0,0 +1,16 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// PortfolioReturns - доходность портфеля с учетом времени и размера пополнений
+type PortfolioReturns struct {
+	XIRR          decimal.Decimal // денежно-взвешенная доходность, % годовых
+	XIRRAvailable bool
+	TWR           decimal.Decimal // время-взвешенная доходность с TWRSince, %
+	TWRSince      time.Time
+	TWRAvailable  bool
+}
@@ -1,5 +1,7 @@
 package model
 
+import "github.com/shopspring/decimal"
+
 type action int
 
 const (
@@ -11,6 +13,24 @@ const (
 	ExpectingSellStockQuantity
 	ExpectingChangePrice
 	ExpectingPurchaseSum
+	ExpectingIndexID
+	ExpectingDividendAmount
+	ExpectingCashAmount
+	ExpectingCommission
+	ExpectingCommissionPercent
+	ExpectingOperationQuantity
+	ExpectingOperationPrice
+	ExpectingOperationCommission
+	ExpectingTradeDate
+	ExpectingBrokerReport
+	ExpectingPortfolioCsv
+	ExpectingTransferQuantity
+	ExpectingRebalanceContribution
+	ExpectingPurchaseMaxPerTicker
+	ExpectingPurchaseMinOrder
+	ExpectingContributionAllocations
+	ExpectingContributionSum
+	ExpectingSimulationScenario
 )
 
 type Session struct {
@@ -21,4 +41,12 @@ type Session struct {
 	CurPortfolioListPage    int
 	CurPortfolioDetailsPage int
 	StocksToPurchase        []StockPurchase
+	PurchaseSum             decimal.Decimal     // сумма последнего расчета закупа, для пересчета с другими ограничениями
+	PurchaseConstraints     PurchaseConstraints // ограничения расчета закупа, сбрасываются при новом расчете
+	CashOperationType       CashOperationType
+	OperationID             int64 // операция из истории, которую редактирует пользователь
+	CurOperationsPage       int
+	BrokerReport            *BrokerReport     // разобранный отчет брокера, ожидающий подтверждения загрузки
+	TransferPortfolioID     int64             // портфель, в который переводится бумага
+	ContributionPlan        *ContributionPlan // рассчитанное распределение пополнения, ожидающее применения
 }
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// SimulationTrade - сделка сценария: Quantity > 0 - покупка, < 0 - продажа (в штуках)
+type SimulationTrade struct {
+	Ticker   string
+	Quantity int
+}
+
+// SimulationPriceChange - изменение цены бумаги в сценарии, в процентах (-20 - падение на 20%)
+type SimulationPriceChange struct {
+	Ticker  string
+	Percent decimal.Decimal
+}
+
+// SimulationScenario - сценарий "что если": сначала меняются цены, затем по новым ценам проводятся сделки
+type SimulationScenario struct {
+	Trades       []SimulationTrade
+	PriceChanges []SimulationPriceChange
+}
+
+// SimulationResult - портфель до и после сценария. Результат считается в памяти и нигде не сохраняется.
+type SimulationResult struct {
+	Scenario     SimulationScenario
+	Before       PortfolioSummary
+	After        PortfolioSummary
+	BeforeStocks []Stock
+	AfterStocks  []Stock
+	BuySum       decimal.Decimal
+	SellSum      decimal.Decimal
+	// RealizedProfit и ExemptProfit - результат продаж сценария и его часть, освобожденная по ЛДВ
+	RealizedProfit decimal.Decimal
+	ExemptProfit   decimal.Decimal
+	TaxBefore      decimal.Decimal // оценка НДФЛ по всем портфелям пользователя за текущий год без сценария
+	TaxAfter       decimal.Decimal // оценка НДФЛ по всем портфелям пользователя за текущий год с продажами сценария
+}
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// PortfolioSnapshot - оценка портфеля на конец дня
+type PortfolioSnapshot struct {
+	SnapshotID            int64
+	PortfolioID           int64
+	SnapshotDate          time.Time
+	BalanceInsideIndex    decimal.Decimal
+	BalanceOutsideIndex   decimal.Decimal
+	GrowthSumInsideIndex  decimal.Decimal
+	GrowthSumOutsideIndex decimal.Decimal
+	IndexOffset           decimal.Decimal
+	Stocks                []SnapshotStock
+}
+
+type SnapshotStock struct {
+	Ticker   string
+	Quantity int
+	Price    decimal.Decimal
+}
+
+// PortfolioHistory - изменение стоимости портфеля за стандартные периоды
+type PortfolioHistory struct {
+	PortfolioID   int64
+	PortfolioName string
+	CurrentValue  decimal.Decimal
+	Periods       []PortfolioPeriodChange
+}
+
+type PortfolioPeriodChange struct {
+	Label         string
+	SnapshotDate  time.Time // дата снапшота, с которым сравниваем
+	StartValue    decimal.Decimal
+	Change        decimal.Decimal
+	ChangePercent decimal.Decimal
+	Available     bool // false - снапшотов за период еще нет
+}
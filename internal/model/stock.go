@@ -3,6 +3,7 @@ package model
 import (
 	"time"
 
+	"github.com/KotFed0t/invest_helper_bot/internal/model/moexModel"
 	"github.com/shopspring/decimal"
 )
 
@@ -16,29 +17,84 @@ type Stock struct {
 	AvgPrice      decimal.Decimal
 	GrowthPercent decimal.Decimal
 	GrowthSum     decimal.Decimal
+	Bond          *moexModel.BondInfo
+	Dividends     StockDividends
 }
 
 type StockBase struct {
-	PortfolioID  int64
-	Ticker       string
-	TargetWeight decimal.Decimal
-	Quantity     int
+	PortfolioID    int64
+	Ticker         string
+	TargetWeight   decimal.Decimal
+	Quantity       int
+	Board          string
+	InstrumentType moexModel.InstrumentType
 }
 
 type StockChanges struct {
 	Quantity        *int
 	NewTargetWeight *decimal.Decimal
 	CustomPrice     *decimal.Decimal
+	Commission      *decimal.Decimal // комиссия за всю сделку, введенная вручную
+	TradeDate       *time.Time       // дата и время сделки, если она была не сейчас
 }
 
+type StockOperationType string
+
+const (
+	StockOperationTrade  StockOperationType = "trade"  // покупка или продажа
+	StockOperationSplit  StockOperationType = "split"  // сплит или обратный сплит: Quantity - изменение количества на дату сплита, цена 0
+	StockOperationRename StockOperationType = "rename" // смена тикера: списание старого тикера и зачисление нового, цена 0
+	// StockOperationTransfer - перевод между портфелями: списание и зачисление по лотам с ценой и датой покупки.
+	// TotalPrice у перевода - рыночная стоимость на момент перевода, по ней считается доходность портфелей.
+	StockOperationTransfer StockOperationType = "transfer"
+)
+
 type StockOperation struct {
-	Ticker     string
-	Shortname  string
-	Quantity   int
-	Price      decimal.Decimal
-	TotalPrice decimal.Decimal
-	Currency   string
-	DtCreate   time.Time
+	OperationID int64
+	Ticker      string
+	Shortname   string
+	Quantity    int
+	Price       decimal.Decimal
+	TotalPrice  decimal.Decimal
+	Commission  decimal.Decimal // комиссия брокера и биржи за сделку, в TotalPrice не входит
+	Currency    string
+	DtCreate    time.Time
+	ExternalID  string // номер сделки у брокера, если она загружена из отчета
+	// OperationType - тип операции, пустой у новых сделок (сохраняется как trade)
+	OperationType StockOperationType
+	// DtAcquired - дата покупки лота, зачисленного переводом из другого портфеля
+	DtAcquired time.Time
+	// TransferPortfolioID - портфель, откуда или куда переведены бумаги (0, если портфель удален)
+	TransferPortfolioID int64
+	// RatioFrom и RatioTo - коэффициент сплита (RatioFrom бумаг превращаются в RatioTo), 0 у остальных операций
+	// и у сплитов, загруженных без коэффициента
+	RatioFrom int
+	RatioTo   int
+}
+
+// IsCorporateAction - сплит или смена тикера, а не сделка
+func (o StockOperation) IsCorporateAction() bool {
+	return o.OperationType == StockOperationSplit || o.OperationType == StockOperationRename
+}
+
+// IsTrade - покупка или продажа, по которой двигаются деньги портфеля
+func (o StockOperation) IsTrade() bool {
+	return o.OperationType == "" || o.OperationType == StockOperationTrade
+}
+
+// LastOperation - записанная операция пользователя, кандидат на отмену
+type LastOperation struct {
+	PortfolioID   int64
+	PortfolioName string
+	Operation     StockOperation
+	DtInsert      time.Time // когда операция записана в историю
+}
+
+// OperationChanges - исправление операции из истории. Количество передается без знака, знак берется из исходной операции.
+type OperationChanges struct {
+	Quantity   *int
+	Price      *decimal.Decimal
+	Commission *decimal.Decimal
 }
 
 type StockPurchase struct {
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// TaxReport - оценка НДФЛ по продажам портфеля за календарный год.
+// Налог считается со всех портфелей пользователя вместе, Tax - доля портфеля в нем.
+type TaxReport struct {
+	PortfolioID    int64
+	PortfolioName  string
+	Year           int
+	RealizedProfit decimal.Decimal // результат по всем продажам года
+	ExemptProfit   decimal.Decimal // прибыль по лотам с ЛДВ, освобождается от налога
+	TaxBase        decimal.Decimal
+	Tax            decimal.Decimal // доля портфеля в UserTax пропорционально его положительной базе
+	UserTaxBase    decimal.Decimal // налоговая база по всем портфелям пользователя, с зачетом убытков
+	UserTax        decimal.Decimal // налог по всем портфелям пользователя
+	ExemptLots     []RealizedLot   // проданные в году лоты, подпадающие под ЛДВ
+	EligibleLots   []LdvLot        // открытые лоты, которые уже можно продать без налога
+	UpcomingLots   []LdvLot        // открытые лоты, по которым ЛДВ наступит в ближайшее время
+}
+
+// LdvLot - открытый лот (или его часть) с датой наступления льготы за долгосрочное владение
+type LdvLot struct {
+	Ticker       string
+	Quantity     int
+	BuyPrice     decimal.Decimal
+	BuyDate      time.Time
+	EligibleFrom time.Time
+}
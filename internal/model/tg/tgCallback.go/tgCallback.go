@@ -20,10 +20,52 @@ const (
 	GenerateReport                     string = "generate_report"
 	ApplyCalculatedPurchaseToPortfolio string = "apply_calculated_purchase_to_portolio"
 	CreatePortfolio                    string = "create_portolio"
+	SyncWithIndex                      string = "sync_with_index"
+	UnlinkIndex                        string = "unlink_index"
+	PortfolioHistory                   string = "portfolio_history"
+	TaxReport                          string = "tax_report"
+	StockDividends                     string = "stock_dividends"
+	AddDividend                        string = "add_dividend"
+	ToggleDividendNotifications        string = "toggle_dividend_notifications"
+	PortfolioCash                      string = "portfolio_cash"
+	CalculatePurchaseWithCash          string = "calculate_purchase_with_cash"
+	ChangeCommission                   string = "change_commission"
+	ChangeTradeDate                    string = "change_trade_date"
+	SetCommissionPercent               string = "set_commission_percent"
+	OperationsHistory                  string = "operations_history"
+	EditOperationQuantity              string = "edit_operation_quantity"
+	EditOperationPrice                 string = "edit_operation_price"
+	EditOperationCommission            string = "edit_operation_commission"
+	InitDeleteOperation                string = "init_delete_operation"
+	ProcessDeleteOperation             string = "process_delete_operation"
+	ImportBrokerReport                 string = "import_broker_report"
+	ApplyBrokerImport                  string = "apply_broker_import"
+	PortfolioCsv                       string = "portfolio_csv"
+	ExportPortfolioCsv                 string = "export_portfolio_csv"
+	ImportPortfolioCsv                 string = "import_portfolio_csv"
+	CreatePortfolioFromCsv             string = "create_portfolio_from_csv"
+	TransferStock                      string = "transfer_stock"
+	RebalancePortfolio                 string = "rebalance_portfolio"
+	ToggleAllowSells                   string = "toggle_allow_sells"
+	SetPurchaseMaxPerTicker            string = "set_purchase_max_per_ticker"
+	SetPurchaseMinOrder                string = "set_purchase_min_order"
+	DistributeContribution             string = "distribute_contribution"
+	SetContributionAllocations         string = "set_contribution_allocations"
+	ApplyContributionPlan              string = "apply_contribution_plan"
+	SimulatePortfolio                  string = "simulate_portfolio"
 
 	// prefixes
-	EditStockPrefix     string = "edit_stock:"
-	ToPortfolioPage     string = "to_portfolio_page:"
-	EditPortfolioPrefix string = "edit_portfolio:"
-	ToPortfolioListPage string = "to_portfolio_list_page:"
+	EditStockPrefix           string = "edit_stock:"
+	ToPortfolioPage           string = "to_portfolio_page:"
+	EditPortfolioPrefix       string = "edit_portfolio:"
+	ToPortfolioListPage       string = "to_portfolio_list_page:"
+	ApplyIndexWeightsPrefix   string = "apply_index_weights:"
+	TaxReportYearPrefix       string = "tax_report_year:"
+	CashOperationPrefix       string = "cash_operation:"
+	ToOperationsPage          string = "to_operations_page:"
+	EditOperationPrefix       string = "edit_operation:"
+	TransferToPortfolioPrefix string = "transfer_to_portfolio:"
+	PurchaseStrategyPrefix    string = "purchase_strategy:"
+	PurchaseExclusionPrefix   string = "purchase_exclusion:"
+	UndoOperationPrefix       string = "undo_operation:"
 )
@@ -0,0 +1,15 @@
+package model
+
+import "github.com/shopspring/decimal"
+
+// StockTransfer - перевод бумаг между портфелями одного пользователя
+type StockTransfer struct {
+	Ticker            string
+	Quantity          int
+	FromPortfolioID   int64
+	FromPortfolioName string
+	ToPortfolioID     int64
+	ToPortfolioName   string
+	Lots              int             // сколько лотов перенесено с исходными датами покупки
+	Cost              decimal.Decimal // стоимость покупки перенесенных бумаг
+}
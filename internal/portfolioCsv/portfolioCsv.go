@@ -0,0 +1,383 @@
+// Package portfolioCsv - CSV форматы для обмена портфелями между ботом, таблицами и скриптами.
+//
+// Файл кодируется в UTF-8, первая строка - заголовок, разделитель ";" (при загрузке принимается и ",").
+// Дробные числа записываются через точку, при загрузке с разделителем ";" допускается и запятая.
+// Колонки можно называть по-английски или по-русски, порядок колонок не важен.
+//
+// Позиции - бумаги портфеля с целевыми весами в процентах:
+//
+//	ticker;weight
+//	SBER;10.5
+//	LKOH;7
+//
+// Операции - история покупок и продаж (side: buy/sell или покупка/продажа, commission можно не указывать):
+//
+//	date;ticker;side;quantity;price;commission
+//	2024-03-01 10:15:00;SBER;buy;10;300.50;1.50
+//	2024-05-20;SBER;sell;5;320;0
+//
+// Корпоративные действия выгружаются с side split (сплит) или rename (смена тикера), количество у них со знаком -
+// на сколько изменилось количество бумаг, цена 0. Смена тикера - пара строк: списание старого тикера и зачисление нового.
+// Переводы между портфелями выгружаются с side transfer и количеством со знаком, у зачисления цена - цена покупки лота.
+// У переводов заполняются еще две колонки: dt_acquired - дата покупки зачисленного лота и transfer_portfolio_id -
+// портфель, откуда или куда переведены бумаги. При загрузке зачисленный лот встает в очередь FIFO по dt_acquired,
+// а без нее - по дате строки. У сплита в колонке ratio записывается коэффициент, например 1:10: при загрузке количество
+// после сплита считается по нему, а без него количество меняется на число из строки. Колонки необязательные,
+// у остальных операций они пустые.
+//
+// Дата - "2006-01-02 15:04:05", "2006-01-02", "02.01.2006 15:04" или "02.01.2006" по местному времени.
+package portfolioCsv
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/internal/service"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	colTicker     = "ticker"
+	colWeight     = "weight"
+	colDate       = "date"
+	colSide       = "side"
+	colQuantity   = "quantity"
+	colPrice      = "price"
+	colCommission = "commission"
+	// colDtAcquired и colTransferPortfolioID заполняются только у переводов
+	colDtAcquired          = "dt_acquired"
+	colTransferPortfolioID = "transfer_portfolio_id"
+	// colRatio заполняется только у сплитов
+	colRatio = "ratio"
+)
+
+// columnAliases - русские названия колонок
+var columnAliases = map[string]string{
+	"тикер":             colTicker,
+	"вес":               colWeight,
+	"дата":              colDate,
+	"операция":          colSide,
+	"количество":        colQuantity,
+	"цена":              colPrice,
+	"комиссия":          colCommission,
+	"дата покупки":      colDtAcquired,
+	"портфель перевода": colTransferPortfolioID,
+	"коэффициент":       colRatio,
+}
+
+var dateLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"02.01.2006 15:04:05",
+	"02.01.2006 15:04",
+	"02.01.2006",
+}
+
+type PortfolioCsv struct{}
+
+func New() *PortfolioCsv {
+	return &PortfolioCsv{}
+}
+
+func (p *PortfolioCsv) EncodePositions(stocks []model.StockBase) ([]byte, error) {
+	records := make([][]string, 0, len(stocks)+1)
+	records = append(records, []string{colTicker, colWeight})
+	for _, stock := range stocks {
+		records = append(records, []string{stock.Ticker, stock.TargetWeight.String()})
+	}
+	return encode(records)
+}
+
+func (p *PortfolioCsv) EncodeOperations(operations []model.StockOperation) ([]byte, error) {
+	records := make([][]string, 0, len(operations)+1)
+	records = append(records, []string{colDate, colTicker, colSide, colQuantity, colPrice, colCommission, colDtAcquired, colTransferPortfolioID, colRatio})
+	for _, operation := range operations {
+		side, quantity := "buy", operation.Quantity
+		switch {
+		case !operation.IsTrade():
+			side = string(operation.OperationType)
+		case quantity < 0:
+			side, quantity = "sell", -quantity
+		}
+		var dtAcquired, transferPortfolioID, ratio string
+		if !operation.DtAcquired.IsZero() {
+			dtAcquired = operation.DtAcquired.Local().Format(time.DateTime)
+		}
+		if operation.TransferPortfolioID != 0 {
+			transferPortfolioID = strconv.FormatInt(operation.TransferPortfolioID, 10)
+		}
+		if operation.RatioFrom > 0 && operation.RatioTo > 0 {
+			ratio = fmt.Sprintf("%d:%d", operation.RatioFrom, operation.RatioTo)
+		}
+		records = append(records, []string{
+			operation.DtCreate.Local().Format(time.DateTime),
+			operation.Ticker,
+			side,
+			strconv.Itoa(quantity),
+			operation.Price.String(),
+			operation.Commission.String(),
+			dtAcquired,
+			transferPortfolioID,
+			ratio,
+		})
+	}
+	return encode(records)
+}
+
+func encode(records [][]string) ([]byte, error) {
+	buf := bytes.Buffer{}
+	w := csv.NewWriter(&buf)
+	w.Comma = ';'
+	err := w.WriteAll(records)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode определяет формат файла по заголовку и разбирает строки. Ошибки в строках собираются в CsvImport.Errors,
+// ошибка возвращается, только если формат не распознан.
+func (p *PortfolioCsv) Decode(content []byte) (model.CsvImport, error) {
+	content = bytes.TrimPrefix(content, []byte("\ufeff"))
+
+	r := csv.NewReader(bytes.NewReader(content))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	firstLine, _, _ := bytes.Cut(content, []byte("\n"))
+	if bytes.Contains(firstLine, []byte(";")) {
+		r.Comma = ';'
+	}
+
+	header, err := r.Read()
+	if err != nil {
+		return model.CsvImport{}, fmt.Errorf("%w: %s", service.ErrUnsupportedCsv, err.Error())
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if alias, ok := columnAliases[name]; ok {
+			name = alias
+		}
+		columns[name] = i
+	}
+
+	var result model.CsvImport
+	switch {
+	case hasColumns(columns, colDate, colTicker, colSide, colQuantity, colPrice):
+		result.Kind = model.CsvKindOperations
+	case hasColumns(columns, colTicker, colWeight):
+		result.Kind = model.CsvKindPositions
+	default:
+		return model.CsvImport{}, service.ErrUnsupportedCsv
+	}
+
+	decimalComma := r.Comma == ';'
+	tickers := make(map[string]int)
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				result.Errors = append(result.Errors, model.CsvLineError{Line: parseErr.Line, Message: "строка не разобрана: " + parseErr.Err.Error()})
+				continue
+			}
+			return model.CsvImport{}, err
+		}
+		line, _ := r.FieldPos(0)
+
+		row := make(map[string]string, len(columns))
+		empty := true
+		for name, idx := range columns {
+			if idx < len(record) {
+				row[name] = strings.TrimSpace(record[idx])
+				empty = empty && row[name] == ""
+			}
+		}
+		if empty {
+			continue
+		}
+
+		ticker := strings.ToUpper(row[colTicker])
+		if ticker == "" {
+			result.Errors = append(result.Errors, model.CsvLineError{Line: line, Message: "не указан тикер"})
+			continue
+		}
+
+		if result.Kind == model.CsvKindPositions {
+			if firstLine, ok := tickers[ticker]; ok {
+				result.Errors = append(result.Errors, model.CsvLineError{Line: line, Message: fmt.Sprintf("%s уже указан в строке %d", ticker, firstLine)})
+				continue
+			}
+			tickers[ticker] = line
+
+			weight, err := parseDecimal(row[colWeight], decimalComma)
+			if err != nil || weight.IsNegative() || weight.GreaterThan(decimal.NewFromInt(100)) {
+				result.Errors = append(result.Errors, model.CsvLineError{Line: line, Message: "вес должен быть числом от 0 до 100"})
+				continue
+			}
+			result.Positions = append(result.Positions, model.CsvPosition{Line: line, Ticker: ticker, Weight: weight})
+			continue
+		}
+
+		operation, lineErr := parseOperation(row, decimalComma)
+		if lineErr != "" {
+			result.Errors = append(result.Errors, model.CsvLineError{Line: line, Message: lineErr})
+			continue
+		}
+		operation.Ticker = ticker
+		result.Operations = append(result.Operations, model.CsvOperation{Line: line, Operation: operation})
+	}
+
+	return result, nil
+}
+
+func hasColumns(columns map[string]int, names ...string) bool {
+	for _, name := range names {
+		if _, ok := columns[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// parseOperation разбирает строку истории операций, вместо ошибки возвращает ее описание для пользователя
+func parseOperation(row map[string]string, decimalComma bool) (model.StockOperation, string) {
+	dtCreate, parsed := parseDate(row[colDate])
+	if !parsed {
+		return model.StockOperation{}, "дата не распознана"
+	}
+	if dtCreate.After(time.Now()) {
+		return model.StockOperation{}, "дата в будущем"
+	}
+
+	var sign int
+	switch strings.ToLower(row[colSide]) {
+	case "buy", "покупка":
+		sign = 1
+	case "sell", "продажа":
+		sign = -1
+	case "split", "сплит":
+		return parseCorporateActionOperation(row, model.StockOperationSplit, dtCreate)
+	case "rename", "смена тикера":
+		return parseCorporateActionOperation(row, model.StockOperationRename, dtCreate)
+	case "transfer", "перевод":
+		operation, lineErr := parseCorporateActionOperation(row, model.StockOperationTransfer, dtCreate)
+		if lineErr != "" {
+			return operation, lineErr
+		}
+		price, err := parseDecimal(row[colPrice], decimalComma)
+		if err != nil || price.IsNegative() {
+			return model.StockOperation{}, "цена должна быть числом не меньше 0"
+		}
+		operation.Price = price
+		operation.TotalPrice = price.Mul(decimal.NewFromInt(int64(operation.Quantity)))
+		return operation, ""
+	default:
+		return model.StockOperation{}, "операция должна быть buy, sell, split, rename или transfer"
+	}
+
+	quantity, err := strconv.Atoi(row[colQuantity])
+	if err != nil || quantity <= 0 {
+		return model.StockOperation{}, "количество должно быть целым числом больше 0"
+	}
+
+	price, err := parseDecimal(row[colPrice], decimalComma)
+	if err != nil || !price.IsPositive() {
+		return model.StockOperation{}, "цена должна быть числом больше 0"
+	}
+
+	var commission decimal.Decimal
+	if row[colCommission] != "" {
+		commission, err = parseDecimal(row[colCommission], decimalComma)
+		if err != nil || commission.IsNegative() {
+			return model.StockOperation{}, "комиссия должна быть числом не меньше 0"
+		}
+	}
+
+	quantity *= sign
+	return model.StockOperation{
+		Quantity:   quantity,
+		Price:      price,
+		TotalPrice: price.Mul(decimal.NewFromInt(int64(quantity))),
+		Commission: commission,
+		Currency:   "RUB",
+		DtCreate:   dtCreate,
+	}, ""
+}
+
+// parseCorporateActionOperation разбирает строку сплита, смены тикера или перевода: количество со знаком, цена и комиссия не учитываются.
+// У перевода дополнительно разбираются дата покупки лота и связанный портфель, у сплита - коэффициент, если они указаны.
+func parseCorporateActionOperation(row map[string]string, operationType model.StockOperationType, dtCreate time.Time) (model.StockOperation, string) {
+	quantity, err := strconv.Atoi(row[colQuantity])
+	if err != nil || quantity == 0 {
+		return model.StockOperation{}, "количество должно быть целым числом, не равным 0"
+	}
+	operation := model.StockOperation{
+		OperationType: operationType,
+		Quantity:      quantity,
+		Currency:      "RUB",
+		DtCreate:      dtCreate,
+	}
+	if operationType == model.StockOperationSplit && row[colRatio] != "" {
+		from, to, found := strings.Cut(row[colRatio], ":")
+		operation.RatioFrom, err = strconv.Atoi(strings.TrimSpace(from))
+		if err == nil {
+			operation.RatioTo, err = strconv.Atoi(strings.TrimSpace(to))
+		}
+		if !found || err != nil || operation.RatioFrom <= 0 || operation.RatioTo <= 0 || operation.RatioFrom == operation.RatioTo {
+			return model.StockOperation{}, "коэффициент сплита должен быть в виде 1:10"
+		}
+	}
+	if operationType != model.StockOperationTransfer {
+		return operation, ""
+	}
+
+	if row[colDtAcquired] != "" {
+		dtAcquired, parsed := parseDate(row[colDtAcquired])
+		if !parsed {
+			return model.StockOperation{}, "дата покупки не распознана"
+		}
+		if dtAcquired.After(dtCreate) {
+			return model.StockOperation{}, "дата покупки позже даты перевода"
+		}
+		operation.DtAcquired = dtAcquired
+	}
+	if row[colTransferPortfolioID] != "" {
+		operation.TransferPortfolioID, err = strconv.ParseInt(row[colTransferPortfolioID], 10, 64)
+		if err != nil || operation.TransferPortfolioID <= 0 {
+			return model.StockOperation{}, "портфель перевода должен быть целым числом больше 0"
+		}
+	}
+
+	return operation, ""
+}
+
+func parseDate(s string) (time.Time, bool) {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func parseDecimal(s string, decimalComma bool) (decimal.Decimal, error) {
+	s = strings.ReplaceAll(s, " ", "")
+	s = strings.ReplaceAll(s, "\u00a0", "")
+	if decimalComma {
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	return decimal.NewFromString(s)
+}
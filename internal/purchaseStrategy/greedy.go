@@ -0,0 +1,102 @@
+package purchaseStrategy
+
+import (
+	"slices"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/shopspring/decimal"
+)
+
+// largestLotFirst докупает бумаги до целевого веса, начиная с самых дорогих лотов: дешевыми лотами проще
+// добрать остаток суммы
+type largestLotFirst struct{}
+
+func (largestLotFirst) Allocate(input model.PurchaseInput) []model.StockPurchase {
+	stocks := slices.Clone(input.Stocks)
+
+	// сортируем список акций по убыванию цены лота
+	slices.SortStableFunc(stocks, func(a, b model.Stock) int {
+		return lotPrice(b).Cmp(lotPrice(a))
+	})
+
+	return allocateInOrder(stocks, input)
+}
+
+// mostUnderweightFirst докупает бумаги до целевого веса, начиная с самых недокупленных относительно портфеля
+type mostUnderweightFirst struct{}
+
+func (mostUnderweightFirst) Allocate(input model.PurchaseInput) []model.StockPurchase {
+	stocks := slices.Clone(input.Stocks)
+
+	// сортируем список акций по убыванию недокупленности относительно портфеля
+	slices.SortStableFunc(stocks, func(a, b model.Stock) int {
+		aWeightDiffrence := a.TargetWeight.Sub(a.ActualWeight)
+		bWeightDiffrence := b.TargetWeight.Sub(b.ActualWeight)
+		return bWeightDiffrence.Cmp(aWeightDiffrence)
+	})
+
+	return allocateInOrder(stocks, input)
+}
+
+// allocateInOrder проходит по бумагам в заданном порядке и докупает каждую до целевого веса, пока хватает суммы
+func allocateInOrder(stocks []model.Stock, input model.PurchaseInput) []model.StockPurchase {
+	// итерируемся и заполняем stocksPurchase сначала целыми лотами и считаем общую сумму покупки целых лотов
+	stocksToPurchase := make([]model.StockPurchase, 0, len(stocks))
+	purchaseRemainder := input.PurchaseSum
+	for _, stock := range stocks {
+		needToBuySum := targetSum(stock, input).Sub(stock.TotalPrice)
+		if needToBuySum.LessThanOrEqual(decimal.NewFromInt(0)) {
+			continue
+		}
+
+		stockLotPrice := lotPrice(stock)
+		if stockLotPrice.LessThanOrEqual(decimal.NewFromInt(0)) {
+			continue
+		}
+
+		if purchaseRemainder.LessThan(needToBuySum) {
+			needToBuySum = purchaseRemainder
+		}
+		if !withinMaxPerTicker(needToBuySum, input.Constraints) {
+			needToBuySum = input.Constraints.MaxPerTicker
+		}
+
+		lotsToBuy := needToBuySum.Div(stockLotPrice)
+		wholeLots := lotsToBuy.IntPart()
+		if wholeLots <= 0 {
+			continue
+		}
+
+		stocksToPurchase = append(stocksToPurchase, model.StockPurchase{
+			Ticker:       stock.Ticker,
+			Shortname:    stock.Shortname,
+			LotSize:      stock.Lotsize,
+			LotsQuantity: lotsToBuy,
+			StockPrice:   stock.Price,
+		})
+		purchaseRemainder = purchaseRemainder.Sub(stock.Price.Mul(decimal.NewFromInt(wholeLots * int64(stock.Lotsize))))
+	}
+
+	// теперь зная остаток средств после покупки целых лотов, итерируемся еще раз и считаем докупку остаточной части лотов (округляя математически)
+	for i := range stocksToPurchase {
+		purchaseStock := &stocksToPurchase[i]
+		// округляем к целой части и проверяем в какую сторону округлилось
+		if purchaseStock.LotsQuantity.Round(0).LessThanOrEqual(purchaseStock.LotsQuantity) {
+			continue
+		}
+
+		// добавить еще +1 лот к покупке, если хватает остатка средств
+		stockLotPrice := purchaseStock.StockPrice.Mul(decimal.NewFromInt(int64(purchaseStock.LotSize)))
+		if purchaseRemainder.LessThan(stockLotPrice) {
+			continue
+		}
+		if !withinMaxPerTicker(stockLotPrice.Mul(purchaseStock.LotsQuantity.Round(0)), input.Constraints) {
+			continue
+		}
+
+		purchaseStock.LotsQuantity = purchaseStock.LotsQuantity.Round(0)
+		purchaseRemainder = purchaseRemainder.Sub(stockLotPrice)
+	}
+
+	return stocksToPurchase
+}
@@ -0,0 +1,208 @@
+package purchaseStrategy
+
+import (
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/shopspring/decimal"
+)
+
+// maxOptimizationSteps ограничивает число замен лотов, каждая из которых уменьшает IndexOffset
+const maxOptimizationSteps = 10000
+
+// offsetPrecision - изменения IndexOffset меньше этого (в п.п.) считаются равенством, тогда выбирается закуп с меньшим остатком
+var offsetPrecision = decimal.New(1, -6)
+
+// minOffset подбирает целые лоты так, чтобы IndexOffset портфеля после закупа был минимальным при условии,
+// что сумма закупа вложена: остаток меньше любого лота, который еще можно купить.
+//
+// IndexOffset считается как в сводке портфеля: сумма модулей разниц фактических и целевых весов, где веса считаются
+// от стоимости бумаг после закупа без остатка. Без условия на остаток по нему часто выгоднее ничего не покупать
+// (например, когда портфель точно в весах), поэтому остаток ограничен, а при равном IndexOffset выбирается закуп
+// с меньшим остатком.
+//
+// Закуп дополняется лотами, пока остатка хватает хотя бы на один: каждый раз берется лот, сильнее всего
+// приближающий стоимость бумаги к целевой на весь баланс вместе с суммой закупа. Затем перебираются продажа
+// одного купленного лота, возможно с покупкой лота другой бумаги, с дополнением освободившегося остатка,
+// и применяется перебор с наименьшим IndexOffset. Поиск запускается еще и от результатов жадных стратегий,
+// берется лучший из результатов.
+type minOffset struct{}
+
+func (minOffset) Allocate(input model.PurchaseInput) []model.StockPurchase {
+	starts := [][]model.StockPurchase{
+		nil,
+		largestLotFirst{}.Allocate(input),
+		mostUnderweightFirst{}.Allocate(input),
+	}
+
+	var best *offsetAllocation
+	for _, start := range starts {
+		allocation := newOffsetAllocation(input, start)
+		allocation.optimize()
+		if best == nil || allocation.betterThan(best) {
+			best = allocation
+		}
+	}
+
+	stocksToPurchase := make([]model.StockPurchase, 0, len(input.Stocks))
+	for i, stock := range input.Stocks {
+		if best.lots[i] == 0 {
+			continue
+		}
+		stocksToPurchase = append(stocksToPurchase, model.StockPurchase{
+			Ticker:       stock.Ticker,
+			Shortname:    stock.Shortname,
+			LotSize:      stock.Lotsize,
+			LotsQuantity: decimal.NewFromInt(best.lots[i]),
+			StockPrice:   stock.Price,
+		})
+	}
+
+	return stocksToPurchase
+}
+
+// offsetAllocation - закуп целыми лотами по индексам input.Stocks
+type offsetAllocation struct {
+	input      model.PurchaseInput
+	lotPrices  []decimal.Decimal
+	deviations []decimal.Decimal // стоимость бумаги с учетом закупа минус целевая на весь баланс вместе с суммой закупа
+	lots       []int64
+	remainder  decimal.Decimal
+	offset     decimal.Decimal // IndexOffset после закупа
+}
+
+// newOffsetAllocation покупает лоты закупа start, пока хватает суммы, и дополняет его до вложенной суммы
+func newOffsetAllocation(input model.PurchaseInput, start []model.StockPurchase) *offsetAllocation {
+	n := len(input.Stocks)
+	a := &offsetAllocation{
+		input:      input,
+		lotPrices:  make([]decimal.Decimal, n),
+		deviations: make([]decimal.Decimal, n),
+		lots:       make([]int64, n),
+		remainder:  input.PurchaseSum,
+	}
+
+	startLots := make(map[string]int64, len(start))
+	for _, stockPurchase := range start {
+		startLots[stockPurchase.Ticker] += stockPurchase.LotsQuantity.IntPart()
+	}
+	for i, stock := range input.Stocks {
+		a.lotPrices[i] = lotPrice(stock)
+		a.deviations[i] = stock.TotalPrice.Sub(targetSum(stock, input))
+		for range startLots[stock.Ticker] {
+			if !a.affordable(i) {
+				break
+			}
+			a.buy(i)
+		}
+	}
+	a.fill()
+
+	return a
+}
+
+func (a *offsetAllocation) clone() *offsetAllocation {
+	c := *a
+	c.deviations = append([]decimal.Decimal(nil), a.deviations...)
+	c.lots = append([]int64(nil), a.lots...)
+	return &c
+}
+
+func (a *offsetAllocation) affordable(i int) bool {
+	return a.lotPrices[i].IsPositive() && a.lotPrices[i].LessThanOrEqual(a.remainder) &&
+		withinMaxPerTicker(a.lotPrices[i].Mul(decimal.NewFromInt(a.lots[i]+1)), a.input.Constraints)
+}
+
+func (a *offsetAllocation) buy(i int) {
+	a.lots[i]++
+	a.deviations[i] = a.deviations[i].Add(a.lotPrices[i])
+	a.remainder = a.remainder.Sub(a.lotPrices[i])
+}
+
+func (a *offsetAllocation) sell(i int) {
+	a.lots[i]--
+	a.deviations[i] = a.deviations[i].Sub(a.lotPrices[i])
+	a.remainder = a.remainder.Add(a.lotPrices[i])
+}
+
+// fill докупает лоты, пока остатка хватает хотя бы на один, и пересчитывает IndexOffset.
+// Берется лот с наибольшим уменьшением (или наименьшим ростом) отклонения в рублях, при равном эффекте - более дорогой.
+func (a *offsetAllocation) fill() {
+	for {
+		best := -1
+		var bestChange decimal.Decimal
+		for i := range a.lots {
+			if !a.affordable(i) {
+				continue
+			}
+			c := a.deviations[i].Add(a.lotPrices[i]).Abs().Sub(a.deviations[i].Abs())
+			if best == -1 || c.LessThan(bestChange) || c.Equal(bestChange) && a.lotPrices[i].GreaterThan(a.lotPrices[best]) {
+				best, bestChange = i, c
+			}
+		}
+		if best == -1 {
+			break
+		}
+		a.buy(best)
+	}
+
+	a.offset = a.indexOffset()
+}
+
+// indexOffset - IndexOffset после закупа, как его считает сводка портфеля
+func (a *offsetAllocation) indexOffset() decimal.Decimal {
+	balance := a.input.Balance.Add(a.input.PurchaseSum).Sub(a.remainder)
+	if !balance.IsPositive() {
+		return decimal.Zero
+	}
+
+	hundred := decimal.NewFromInt(100)
+	var offset decimal.Decimal
+	for i, stock := range a.input.Stocks {
+		value := stock.TotalPrice.Add(a.lotPrices[i].Mul(decimal.NewFromInt(a.lots[i])))
+		offset = offset.Add(value.Div(balance).Mul(hundred).Sub(stock.TargetWeight).Abs())
+	}
+	return offset
+}
+
+// betterThan - IndexOffset меньше, а при равном - меньше остаток
+func (a *offsetAllocation) betterThan(b *offsetAllocation) bool {
+	diff := a.offset.Sub(b.offset)
+	if diff.Abs().LessThan(offsetPrecision) {
+		return a.remainder.LessThan(b.remainder)
+	}
+	return diff.IsNegative()
+}
+
+// optimize применяет лучшую продажу одного лота, возможно с покупкой лота другой бумаги и дополнением остатка,
+// пока она уменьшает IndexOffset или, при равном IndexOffset, остаток
+func (a *offsetAllocation) optimize() {
+	for step := 0; step < maxOptimizationSteps; step++ {
+		var best *offsetAllocation
+		for from := range a.lots {
+			if a.lots[from] == 0 {
+				continue
+			}
+			// to == -1 - только продажа, освободившийся остаток уходит в fill
+			for to := -1; to < len(a.lots); to++ {
+				if to == from {
+					continue
+				}
+				candidate := a.clone()
+				candidate.sell(from)
+				if to != -1 {
+					if !candidate.affordable(to) {
+						continue
+					}
+					candidate.buy(to)
+				}
+				candidate.fill()
+				if candidate.betterThan(a) && (best == nil || candidate.betterThan(best)) {
+					best = candidate
+				}
+			}
+		}
+		if best == nil {
+			return
+		}
+		*a = *best
+	}
+}
@@ -0,0 +1,94 @@
+// Package purchaseStrategy - стратегии распределения суммы закупа по бумагам портфеля.
+//
+// Каждая стратегия получает бумаги портфеля с ненулевым целевым весом и решает, сколько лотов каждой бумаги купить
+// на заданную сумму. Покупаются только целые лоты, сумма покупки не превышает сумму закупа.
+package purchaseStrategy
+
+import (
+	"fmt"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/shopspring/decimal"
+)
+
+// Strategy - алгоритм распределения суммы закупа
+type Strategy interface {
+	Allocate(input model.PurchaseInput) []model.StockPurchase
+}
+
+type PurchaseStrategy struct {
+	strategies map[model.PurchaseStrategy]Strategy
+}
+
+func New() *PurchaseStrategy {
+	return &PurchaseStrategy{
+		strategies: map[model.PurchaseStrategy]Strategy{
+			model.PurchaseStrategyLargestLot:      largestLotFirst{},
+			model.PurchaseStrategyMostUnderweight: mostUnderweightFirst{},
+			model.PurchaseStrategyMinOffset:       minOffset{},
+		},
+	}
+}
+
+// Allocate распределяет сумму закупа выбранной стратегией
+func (p *PurchaseStrategy) Allocate(strategy model.PurchaseStrategy, input model.PurchaseInput) ([]model.StockPurchase, error) {
+	s, ok := p.strategies[strategy]
+	if !ok {
+		return nil, fmt.Errorf("unknown purchase strategy %q", strategy)
+	}
+
+	excluded := make(map[string]bool, len(input.Constraints.ExcludedTickers))
+	for _, ticker := range input.Constraints.ExcludedTickers {
+		excluded[ticker] = true
+	}
+
+	for {
+		stocks := make([]model.Stock, 0, len(input.Stocks))
+		for _, stock := range input.Stocks {
+			if !excluded[stock.Ticker] {
+				stocks = append(stocks, stock)
+			}
+		}
+		allocationInput := input
+		allocationInput.Stocks = stocks
+
+		stocksToPurchase := s.Allocate(allocationInput)
+		if !input.Constraints.MinOrderSum.IsPositive() {
+			return stocksToPurchase, nil
+		}
+
+		// бумаги с заявкой меньше минимальной исключаем и пересчитываем, чтобы их сумма ушла в другие бумаги
+		smallOrders := false
+		for _, stockPurchase := range stocksToPurchase {
+			if purchaseSum(stockPurchase).LessThan(input.Constraints.MinOrderSum) {
+				excluded[stockPurchase.Ticker] = true
+				smallOrders = true
+			}
+		}
+		if !smallOrders {
+			return stocksToPurchase, nil
+		}
+	}
+}
+
+func lotPrice(stock model.Stock) decimal.Decimal {
+	return stock.Price.Mul(decimal.NewFromInt(int64(stock.Lotsize)))
+}
+
+// purchaseSum - сумма покупки целых лотов
+func purchaseSum(stockPurchase model.StockPurchase) decimal.Decimal {
+	return stockPurchase.StockPrice.Mul(decimal.NewFromInt(stockPurchase.LotsQuantity.IntPart() * int64(stockPurchase.LotSize)))
+}
+
+// withinMaxPerTicker - укладывается ли покупка на sum в ограничение суммы на одну бумагу
+func withinMaxPerTicker(sum decimal.Decimal, constraints model.PurchaseConstraints) bool {
+	return !constraints.MaxPerTicker.IsPositive() || sum.LessThanOrEqual(constraints.MaxPerTicker)
+}
+
+// targetSum - сколько должно стоить бумаги после закупа, чтобы вес совпал с целевым
+func targetSum(stock model.Stock, input model.PurchaseInput) decimal.Decimal {
+	return input.Balance.
+		Add(input.PurchaseSum).
+		Mul(stock.TargetWeight).
+		Div(decimal.NewFromInt(100))
+}
@@ -0,0 +1,258 @@
+package purchaseStrategy
+
+import (
+	"testing"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/shopspring/decimal"
+)
+
+// newInput собирает вход стратегии: стоимость, баланс и фактические веса считаются так же, как в сервисе
+func newInput(purchaseSum int64, stocks ...model.Stock) model.PurchaseInput {
+	input := model.PurchaseInput{PurchaseSum: decimal.NewFromInt(purchaseSum)}
+	for i := range stocks {
+		stocks[i].TotalPrice = stocks[i].Price.Mul(decimal.NewFromInt(int64(stocks[i].Quantity)))
+		input.Balance = input.Balance.Add(stocks[i].TotalPrice)
+	}
+	for i := range stocks {
+		stocks[i].ActualWeight = stocks[i].TotalPrice.Div(input.Balance).Mul(decimal.NewFromInt(100))
+	}
+	input.Stocks = stocks
+	return input
+}
+
+func newStock(ticker string, price int64, lotSize, quantity int, targetWeight int64) model.Stock {
+	return model.Stock{
+		StockBase: model.StockBase{
+			Ticker:       ticker,
+			TargetWeight: decimal.NewFromInt(targetWeight),
+			Quantity:     quantity,
+		},
+		Lotsize: lotSize,
+		Price:   decimal.NewFromInt(price),
+	}
+}
+
+// indexOffset - IndexOffset портфеля после закупа, как его считает calculatePortfolioSummary
+func indexOffset(input model.PurchaseInput, purchases []model.StockPurchase) decimal.Decimal {
+	values := make(map[string]decimal.Decimal, len(input.Stocks))
+	var balance decimal.Decimal
+	for _, stock := range input.Stocks {
+		values[stock.Ticker] = stock.TotalPrice
+		balance = balance.Add(stock.TotalPrice)
+	}
+	for _, purchase := range purchases {
+		values[purchase.Ticker] = values[purchase.Ticker].Add(purchaseSum(purchase))
+		balance = balance.Add(purchaseSum(purchase))
+	}
+
+	var offset decimal.Decimal
+	for _, stock := range input.Stocks {
+		weight := values[stock.Ticker].Div(balance).Mul(decimal.NewFromInt(100))
+		offset = offset.Add(weight.Sub(stock.TargetWeight).Abs())
+	}
+	return offset
+}
+
+// lotsByTicker - купленные целые лоты по тикерам, без нулевых
+func lotsByTicker(purchases []model.StockPurchase) map[string]int64 {
+	lots := make(map[string]int64, len(purchases))
+	for _, purchase := range purchases {
+		if purchase.LotsQuantity.IntPart() != 0 {
+			lots[purchase.Ticker] = purchase.LotsQuantity.IntPart()
+		}
+	}
+	return lots
+}
+
+func spent(purchases []model.StockPurchase) decimal.Decimal {
+	var sum decimal.Decimal
+	for _, purchase := range purchases {
+		sum = sum.Add(purchaseSum(purchase))
+	}
+	return sum
+}
+
+func equalLots(a, b map[string]int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for ticker, lots := range a {
+		if b[ticker] != lots {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name  string
+		input func() model.PurchaseInput
+		want  map[model.PurchaseStrategy]map[string]int64
+	}{
+		{
+			// дорогие лоты недобираются до целого лота, жадные стратегии их пропускают и оставляют треть суммы,
+			// min_offset покупает LKOH и вкладывает остаток в GAZP
+			name: "expensive lots next to cheap ones",
+			input: func() model.PurchaseInput {
+				return newInput(30000,
+					newStock("LKOH", 7000, 1, 3, 30),
+					newStock("PLZL", 12000, 1, 1, 20),
+					newStock("SBER", 300, 10, 5*10, 30),
+					newStock("GAZP", 150, 10, 8*10, 20),
+				)
+			},
+			want: map[model.PurchaseStrategy]map[string]int64{
+				model.PurchaseStrategyLargestLot:      {"SBER": 4, "GAZP": 4},
+				model.PurchaseStrategyMostUnderweight: {"SBER": 4, "GAZP": 4},
+				model.PurchaseStrategyMinOffset:       {"LKOH": 1, "SBER": 4, "GAZP": 7},
+			},
+		},
+		{
+			// сумма не покрывает обе бумаги: первым идет дорогой лот PLZL или самая недокупленная TATN
+			name: "order of greedy strategies",
+			input: func() model.PurchaseInput {
+				return newInput(6000,
+					newStock("PLZL", 3000, 1, 3, 40),
+					newStock("TATN", 500, 1, 10, 30),
+					newStock("MOEX", 100, 10, 160, 30),
+				)
+			},
+			want: map[model.PurchaseStrategy]map[string]int64{
+				model.PurchaseStrategyLargestLot:      {"PLZL": 1, "TATN": 6},
+				model.PurchaseStrategyMostUnderweight: {"TATN": 12},
+				// 2 лота PLZL дают больший перевес PLZL, чем недобор TATN
+				model.PurchaseStrategyMinOffset: {"PLZL": 1, "TATN": 6},
+			},
+		},
+		{
+			// портфель точно в весах, лот SBER дороже его доли в сумме
+			name: "portfolio in target weights",
+			input: func() model.PurchaseInput {
+				return newInput(5000,
+					newStock("SBER", 300, 10, 100, 50),
+					newStock("VTBR", 100, 1, 200, 30),
+					newStock("ALRS", 50, 10, 200, 20),
+				)
+			},
+			want: map[model.PurchaseStrategy]map[string]int64{
+				model.PurchaseStrategyLargestLot:      {"ALRS": 6},
+				model.PurchaseStrategyMostUnderweight: {"ALRS": 6},
+				model.PurchaseStrategyMinOffset:       {"SBER": 1, "ALRS": 4},
+			},
+		},
+	}
+
+	strategies := New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offsets := make(map[model.PurchaseStrategy]decimal.Decimal, len(model.PurchaseStrategies))
+			for _, strategy := range model.PurchaseStrategies {
+				input := tt.input()
+				purchases, err := strategies.Allocate(strategy, input)
+				if err != nil {
+					t.Fatalf("%s: unexpected error: %v", strategy, err)
+				}
+
+				if got := lotsByTicker(purchases); !equalLots(got, tt.want[strategy]) {
+					t.Errorf("%s: lots = %v, want %v", strategy, got, tt.want[strategy])
+				}
+				if spent(purchases).GreaterThan(input.PurchaseSum) {
+					t.Errorf("%s: spent %s, more than purchase sum %s", strategy, spent(purchases), input.PurchaseSum)
+				}
+				offsets[strategy] = indexOffset(input, purchases)
+			}
+
+			// на этих данных вложить сумму можно, не ухудшая IndexOffset относительно жадных стратегий
+			for _, greedy := range []model.PurchaseStrategy{model.PurchaseStrategyLargestLot, model.PurchaseStrategyMostUnderweight} {
+				if offsets[model.PurchaseStrategyMinOffset].GreaterThan(offsets[greedy]) {
+					t.Errorf("min_offset IndexOffset %s is worse than %s %s",
+						offsets[model.PurchaseStrategyMinOffset].StringFixed(4), greedy, offsets[greedy].StringFixed(4))
+				}
+			}
+		})
+	}
+}
+
+// TestMinOffsetSpendsSum фиксирует условие на остаток: портфель точно в весах, по одному IndexOffset выгоднее
+// ничего не покупать, но остатка хватает на лот, поэтому minOffset его покупает.
+func TestMinOffsetSpendsSum(t *testing.T) {
+	input := newInput(150,
+		newStock("SBER", 100, 1, 10, 50),
+		newStock("GAZP", 100, 1, 10, 50),
+	)
+
+	purchases, err := New().Allocate(model.PurchaseStrategyMinOffset, input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := spent(purchases); !got.Equal(decimal.NewFromInt(100)) {
+		t.Errorf("spent = %s, want 100", got)
+	}
+
+	// 1100 и 1000 из 2100: |52.38-50| + |47.62-50|
+	want := decimal.RequireFromString("4.7619")
+	if got := indexOffset(input, purchases).Round(4); !got.Equal(want) {
+		t.Errorf("IndexOffset after purchase = %s, want %s (0 without purchase)", got, want)
+	}
+}
+
+func TestAllocateConstraints(t *testing.T) {
+	tests := []struct {
+		name        string
+		constraints model.PurchaseConstraints
+		want        map[string]int64
+	}{
+		{
+			name:        "excluded ticker",
+			constraints: model.PurchaseConstraints{ExcludedTickers: []string{"PLZL"}},
+			want:        map[string]int64{"LKOH": 1, "SBER": 4, "GAZP": 7},
+		},
+		{
+			name:        "max per ticker",
+			constraints: model.PurchaseConstraints{MaxPerTicker: decimal.NewFromInt(6000)},
+			want:        map[string]int64{"LKOH": 0, "SBER": 2, "GAZP": 4},
+		},
+		{
+			name:        "min order sum",
+			constraints: model.PurchaseConstraints{MinOrderSum: decimal.NewFromInt(8000)},
+			want:        map[string]int64{"PLZL": 1, "SBER": 6},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := newInput(30000,
+				newStock("LKOH", 7000, 1, 3, 30),
+				newStock("PLZL", 12000, 1, 1, 20),
+				newStock("SBER", 300, 10, 5*10, 30),
+				newStock("GAZP", 150, 10, 8*10, 20),
+			)
+			input.Constraints = tt.constraints
+
+			purchases, err := New().Allocate(model.PurchaseStrategyMinOffset, input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			want := make(map[string]int64, len(tt.want))
+			for ticker, lots := range tt.want {
+				if lots != 0 {
+					want[ticker] = lots
+				}
+			}
+			if got := lotsByTicker(purchases); !equalLots(got, want) {
+				t.Errorf("lots = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestAllocateUnknownStrategy(t *testing.T) {
+	_, err := New().Allocate("unknown", model.PurchaseInput{})
+	if err == nil {
+		t.Error("expected error for unknown strategy")
+	}
+}
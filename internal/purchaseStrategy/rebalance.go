@@ -0,0 +1,100 @@
+package purchaseStrategy
+
+import (
+	"slices"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/shopspring/decimal"
+)
+
+// Rebalance строит план ребалансировки: если продажи разрешены, бумаги с весом выше допуска продаются целыми лотами
+// до попадания в допуск, затем довнесение вместе с выручкой от продаж распределяется стратегией минимального
+// отклонения. Проданные бумаги в том же плане не докупаются. Целевые суммы считаются от стоимости бумаг внутри
+// индекса вместе с довнесением.
+func (p *PurchaseStrategy) Rebalance(input model.RebalanceInput) model.RebalancePlan {
+	plan := model.RebalancePlan{
+		Contribution: input.PurchaseSum,
+		Tolerance:    input.Tolerance,
+		AllowSells:   input.AllowSells,
+	}
+
+	band := input.Balance.Add(input.PurchaseSum).Mul(input.Tolerance).Div(decimal.NewFromInt(100))
+	stocks := slices.Clone(input.Stocks)
+	sold := make(map[string]bool)
+
+	if input.AllowSells {
+		for i, stock := range stocks {
+			stockLotPrice := lotPrice(stock)
+			if !stockLotPrice.IsPositive() || stock.Lotsize <= 0 {
+				continue
+			}
+
+			deviation := stock.TotalPrice.Sub(targetSum(stock, input.PurchaseInput))
+			excess := deviation.Sub(band)
+			if !excess.IsPositive() {
+				continue
+			}
+
+			maxLots := int64(stock.Quantity / stock.Lotsize)
+			lots := min(excess.Div(stockLotPrice).Ceil().IntPart(), maxLots)
+			// продаем еще один лот, если он приближает стоимость бумаги к целевой
+			if lots < maxLots {
+				afterLots := deviation.Sub(stockLotPrice.Mul(decimal.NewFromInt(lots))).Abs()
+				afterNextLot := deviation.Sub(stockLotPrice.Mul(decimal.NewFromInt(lots + 1))).Abs()
+				if afterNextLot.LessThan(afterLots) {
+					lots++
+				}
+			}
+			if lots <= 0 {
+				continue
+			}
+
+			sum := stockLotPrice.Mul(decimal.NewFromInt(lots))
+			stocks[i].TotalPrice = stock.TotalPrice.Sub(sum)
+			plan.SellSum = plan.SellSum.Add(sum)
+			sold[stock.Ticker] = true
+			plan.Trades = append(plan.Trades, model.StockPurchase{
+				Ticker:       stock.Ticker,
+				Shortname:    stock.Shortname,
+				LotSize:      stock.Lotsize,
+				LotsQuantity: decimal.NewFromInt(-lots),
+				StockPrice:   stock.Price,
+			})
+		}
+	}
+
+	toBuy := make([]model.Stock, 0, len(stocks))
+	for _, stock := range stocks {
+		if !sold[stock.Ticker] {
+			toBuy = append(toBuy, stock)
+		}
+	}
+
+	buys := minOffset{}.Allocate(model.PurchaseInput{
+		Stocks:      toBuy,
+		Balance:     input.Balance.Sub(plan.SellSum),
+		PurchaseSum: input.PurchaseSum.Add(plan.SellSum),
+	})
+
+	bought := make(map[string]decimal.Decimal, len(buys))
+	for _, buy := range buys {
+		sum := purchaseSum(buy)
+		bought[buy.Ticker] = sum
+		plan.BuySum = plan.BuySum.Add(sum)
+	}
+	plan.Trades = append(plan.Trades, buys...)
+
+	// проверяем, какие веса после плана остались вне допуска
+	balanceAfter := input.Balance.Sub(plan.SellSum).Add(plan.BuySum)
+	if !balanceAfter.IsPositive() {
+		return plan
+	}
+	for _, stock := range stocks {
+		weight := stock.TotalPrice.Add(bought[stock.Ticker]).Div(balanceAfter).Mul(decimal.NewFromInt(100))
+		if weight.Sub(stock.TargetWeight).Abs().GreaterThan(input.Tolerance) {
+			plan.OutOfBand = append(plan.OutOfBand, stock.Ticker)
+		}
+	}
+
+	return plan
+}
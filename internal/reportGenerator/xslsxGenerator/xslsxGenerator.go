@@ -42,6 +42,11 @@ func (g *XSLSXGenerator) Generate(ctx context.Context, portfolios []model.Portfo
 		}
 	}
 
+	err = g.fillTaxSheet(ctx, f, portfolios)
+	if err != nil {
+		return nil, "", err
+	}
+
 	// Удаляем лист по умолчанию "Sheet1"
 	if err := f.DeleteSheet("Sheet1"); err != nil {
 		slog.Error("got error while deleting Sheet1", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
@@ -244,6 +249,58 @@ func (g *XSLSXGenerator) fillSheet(ctx context.Context, f *excelize.File, portfo
 	_ = f.SetCellStr(sheetName, "M2", "процент роста")
 	_ = f.SetCellStr(sheetName, "N2", "сумма роста")
 
+	// доходность
+	err = f.MergeCell(sheetName, "P1", "Q1")
+	if err != nil {
+		return err
+	}
+
+	f.SetCellValue(sheetName, "P1", "Доходность")
+
+	styleID, err = f.NewStyle(&excelize.Style{
+		Alignment: &excelize.Alignment{
+			Horizontal: "center",
+			Vertical:   "center",
+		},
+		Font: &excelize.Font{
+			Bold: true,
+			Size: 11,
+		},
+		Fill: excelize.Fill{
+			Type:    "pattern",
+			Pattern: 1,
+			Color:   []string{"#fff2cc"}, // Светло-желтый цвет
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := f.SetCellStyle(sheetName, "P1", "P1", styleID); err != nil {
+		return fmt.Errorf("ошибка применения стиля: %w", err)
+	}
+
+	_ = f.SetCellStr(sheetName, "P2", "XIRR, % годовых")
+	if portfolio.Returns.XIRRAvailable {
+		_ = f.SetCellValue(sheetName, "Q2", portfolio.Returns.XIRR.Round(2).InexactFloat64())
+	} else {
+		_ = f.SetCellStr(sheetName, "Q2", "нет данных")
+	}
+
+	_ = f.SetCellStr(sheetName, "P3", "TWR, %")
+	if portfolio.Returns.TWRAvailable {
+		_ = f.SetCellValue(sheetName, "Q3", portfolio.Returns.TWR.Round(2).InexactFloat64())
+		_ = f.SetCellStr(sheetName, "P4", "TWR считается с")
+		_ = f.SetCellValue(sheetName, "Q4", portfolio.Returns.TWRSince.Format("02.01.2006"))
+	} else {
+		_ = f.SetCellStr(sheetName, "Q3", "нет данных")
+	}
+
+	_ = f.SetCellStr(sheetName, "P5", "свободные деньги, ₽")
+	_ = f.SetCellValue(sheetName, "Q5", portfolio.CashBalance.InexactFloat64())
+	_ = f.SetCellStr(sheetName, "P6", "всего с деньгами, ₽")
+	_ = f.SetCellValue(sheetName, "Q6", portfolio.TotalBalance.InexactFloat64())
+
 	for i, stock := range portfolio.Stocks {
 		_ = f.SetCellStr(sheetName, fmt.Sprintf("A%d", i+3), stock.Shortname)
 		_ = f.SetCellStr(sheetName, fmt.Sprintf("B%d", i+3), stock.Ticker)
@@ -269,8 +326,9 @@ func (g *XSLSXGenerator) fillSheet(ctx context.Context, f *excelize.File, portfo
 
 	// история операций
 	rowNum := len(portfolio.Stocks) + 6
+	historyStartRow := rowNum
 
-	err = f.MergeCell(sheetName, fmt.Sprintf("A%d", rowNum), fmt.Sprintf("G%d", rowNum))
+	err = f.MergeCell(sheetName, fmt.Sprintf("A%d", rowNum), fmt.Sprintf("H%d", rowNum))
 	if err != nil {
 		return err
 	}
@@ -307,7 +365,8 @@ func (g *XSLSXGenerator) fillSheet(ctx context.Context, f *excelize.File, portfo
 	_ = f.SetCellStr(sheetName, fmt.Sprintf("D%d", rowNum), "цена акции")
 	_ = f.SetCellStr(sheetName, fmt.Sprintf("E%d", rowNum), "сумма покупки")
 	_ = f.SetCellStr(sheetName, fmt.Sprintf("F%d", rowNum), "валюта")
-	_ = f.SetCellStr(sheetName, fmt.Sprintf("G%d", rowNum), "дата")
+	_ = f.SetCellStr(sheetName, fmt.Sprintf("G%d", rowNum), "комиссия")
+	_ = f.SetCellStr(sheetName, fmt.Sprintf("H%d", rowNum), "дата")
 
 	for _, operation := range portfolio.StockOperations {
 		rowNum++
@@ -317,7 +376,183 @@ func (g *XSLSXGenerator) fillSheet(ctx context.Context, f *excelize.File, portfo
 		_ = f.SetCellValue(sheetName, fmt.Sprintf("D%d", rowNum), operation.Price.InexactFloat64())
 		_ = f.SetCellValue(sheetName, fmt.Sprintf("E%d", rowNum), operation.TotalPrice.InexactFloat64())
 		_ = f.SetCellStr(sheetName, fmt.Sprintf("F%d", rowNum), operation.Currency)
-		_ = f.SetCellValue(sheetName, fmt.Sprintf("G%d", rowNum), operation.DtCreate)
+		_ = f.SetCellValue(sheetName, fmt.Sprintf("G%d", rowNum), operation.Commission.InexactFloat64())
+		_ = f.SetCellValue(sheetName, fmt.Sprintf("H%d", rowNum), operation.DtCreate)
+	}
+
+	// реализованный результат - справа от истории операций
+	err = g.fillRealizedPnL(f, sheetName, portfolio.RealizedPnL, historyStartRow)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (g *XSLSXGenerator) fillRealizedPnL(f *excelize.File, sheetName string, realizedPnL []model.YearRealizedPnL, rowNum int) error {
+	err := f.MergeCell(sheetName, fmt.Sprintf("I%d", rowNum), fmt.Sprintf("L%d", rowNum))
+	if err != nil {
+		return err
+	}
+
+	f.SetCellValue(sheetName, fmt.Sprintf("I%d", rowNum), "Реализованный результат")
+
+	styleID, err := f.NewStyle(&excelize.Style{
+		Alignment: &excelize.Alignment{
+			Horizontal: "center",
+			Vertical:   "center",
+		},
+		Font: &excelize.Font{
+			Bold: true,
+			Size: 11,
+		},
+		Fill: excelize.Fill{
+			Type:    "pattern",
+			Pattern: 1,
+			Color:   []string{"#d9ead3"}, // Светло-зеленый цвет
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := f.SetCellStyle(sheetName, fmt.Sprintf("I%d", rowNum), fmt.Sprintf("I%d", rowNum), styleID); err != nil {
+		return fmt.Errorf("ошибка применения стиля: %w", err)
+	}
+
+	rowNum++
+	_ = f.SetCellStr(sheetName, fmt.Sprintf("I%d", rowNum), "год")
+	_ = f.SetCellStr(sheetName, fmt.Sprintf("J%d", rowNum), "тикер")
+	_ = f.SetCellStr(sheetName, fmt.Sprintf("K%d", rowNum), "продано шт.")
+	_ = f.SetCellStr(sheetName, fmt.Sprintf("L%d", rowNum), "результат")
+
+	for _, year := range realizedPnL {
+		for _, tickerPnL := range year.ByTicker {
+			rowNum++
+			_ = f.SetCellInt(sheetName, fmt.Sprintf("I%d", rowNum), int64(year.Year))
+			_ = f.SetCellStr(sheetName, fmt.Sprintf("J%d", rowNum), tickerPnL.Ticker)
+			_ = f.SetCellInt(sheetName, fmt.Sprintf("K%d", rowNum), int64(tickerPnL.Quantity))
+			_ = f.SetCellValue(sheetName, fmt.Sprintf("L%d", rowNum), tickerPnL.Profit.InexactFloat64())
+		}
+
+		rowNum++
+		_ = f.SetCellInt(sheetName, fmt.Sprintf("I%d", rowNum), int64(year.Year))
+		_ = f.SetCellStr(sheetName, fmt.Sprintf("J%d", rowNum), "итого за год")
+		_ = f.SetCellValue(sheetName, fmt.Sprintf("L%d", rowNum), year.Profit.InexactFloat64())
+	}
+
+	return nil
+}
+
+func (g *XSLSXGenerator) fillTaxSheet(ctx context.Context, f *excelize.File, portfolios []model.PortfolioFullInfo) error {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "XSLSXGenerator.fillTaxSheet"
+
+	sheetName := "НДФЛ"
+	_, err := f.NewSheet(sheetName)
+	if err != nil {
+		slog.Error("got error while creating NewSheet", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return err
+	}
+
+	styleID, err := f.NewStyle(&excelize.Style{
+		Alignment: &excelize.Alignment{
+			Horizontal: "center",
+			Vertical:   "center",
+		},
+		Font: &excelize.Font{
+			Bold: true,
+			Size: 11,
+		},
+		Fill: excelize.Fill{
+			Type:    "pattern",
+			Pattern: 1,
+			Color:   []string{"#f4cccc"}, // Светло-розовый цвет
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// оценка налога по годам
+	err = f.MergeCell(sheetName, "A1", "H1")
+	if err != nil {
+		return err
+	}
+
+	f.SetCellValue(sheetName, "A1", "Оценка НДФЛ по продажам (13% / 15%, порог и убытки по всем портфелям, без учета лимита ЛДВ)")
+
+	if err := f.SetCellStyle(sheetName, "A1", "A1", styleID); err != nil {
+		return fmt.Errorf("ошибка применения стиля: %w", err)
+	}
+
+	_ = f.SetCellStr(sheetName, "A2", "портфель")
+	_ = f.SetCellStr(sheetName, "B2", "год")
+	_ = f.SetCellStr(sheetName, "C2", "результат продаж")
+	_ = f.SetCellStr(sheetName, "D2", "освобождено по ЛДВ")
+	_ = f.SetCellStr(sheetName, "E2", "налоговая база")
+	_ = f.SetCellStr(sheetName, "F2", "налог портфеля")
+	_ = f.SetCellStr(sheetName, "G2", "база по всем портфелям")
+	_ = f.SetCellStr(sheetName, "H2", "налог по всем портфелям")
+
+	rowNum := 2
+	for _, portfolio := range portfolios {
+		for _, report := range portfolio.TaxReports {
+			rowNum++
+			_ = f.SetCellStr(sheetName, fmt.Sprintf("A%d", rowNum), portfolio.PortfolioName)
+			_ = f.SetCellInt(sheetName, fmt.Sprintf("B%d", rowNum), int64(report.Year))
+			_ = f.SetCellValue(sheetName, fmt.Sprintf("C%d", rowNum), report.RealizedProfit.InexactFloat64())
+			_ = f.SetCellValue(sheetName, fmt.Sprintf("D%d", rowNum), report.ExemptProfit.InexactFloat64())
+			_ = f.SetCellValue(sheetName, fmt.Sprintf("E%d", rowNum), report.TaxBase.InexactFloat64())
+			_ = f.SetCellValue(sheetName, fmt.Sprintf("F%d", rowNum), report.Tax.InexactFloat64())
+			_ = f.SetCellValue(sheetName, fmt.Sprintf("G%d", rowNum), report.UserTaxBase.InexactFloat64())
+			_ = f.SetCellValue(sheetName, fmt.Sprintf("H%d", rowNum), report.UserTax.InexactFloat64())
+		}
+	}
+
+	// открытые лоты с наступившей или скорой ЛДВ
+	rowNum += 3
+
+	err = f.MergeCell(sheetName, fmt.Sprintf("A%d", rowNum), fmt.Sprintf("G%d", rowNum))
+	if err != nil {
+		return err
+	}
+
+	f.SetCellValue(sheetName, fmt.Sprintf("A%d", rowNum), "Льгота за долгосрочное владение (ЛДВ)")
+
+	if err := f.SetCellStyle(sheetName, fmt.Sprintf("A%d", rowNum), fmt.Sprintf("A%d", rowNum), styleID); err != nil {
+		return fmt.Errorf("ошибка применения стиля: %w", err)
+	}
+
+	rowNum++
+	_ = f.SetCellStr(sheetName, fmt.Sprintf("A%d", rowNum), "портфель")
+	_ = f.SetCellStr(sheetName, fmt.Sprintf("B%d", rowNum), "тикер")
+	_ = f.SetCellStr(sheetName, fmt.Sprintf("C%d", rowNum), "кол-во")
+	_ = f.SetCellStr(sheetName, fmt.Sprintf("D%d", rowNum), "цена покупки")
+	_ = f.SetCellStr(sheetName, fmt.Sprintf("E%d", rowNum), "дата покупки")
+	_ = f.SetCellStr(sheetName, fmt.Sprintf("F%d", rowNum), "ЛДВ с")
+	_ = f.SetCellStr(sheetName, fmt.Sprintf("G%d", rowNum), "статус")
+
+	writeLot := func(portfolioName string, lot model.LdvLot, status string) {
+		rowNum++
+		_ = f.SetCellStr(sheetName, fmt.Sprintf("A%d", rowNum), portfolioName)
+		_ = f.SetCellStr(sheetName, fmt.Sprintf("B%d", rowNum), lot.Ticker)
+		_ = f.SetCellInt(sheetName, fmt.Sprintf("C%d", rowNum), int64(lot.Quantity))
+		_ = f.SetCellValue(sheetName, fmt.Sprintf("D%d", rowNum), lot.BuyPrice.InexactFloat64())
+		_ = f.SetCellValue(sheetName, fmt.Sprintf("E%d", rowNum), lot.BuyDate.Format("02.01.2006"))
+		_ = f.SetCellValue(sheetName, fmt.Sprintf("F%d", rowNum), lot.EligibleFrom.Format("02.01.2006"))
+		_ = f.SetCellStr(sheetName, fmt.Sprintf("G%d", rowNum), status)
+	}
+
+	for _, portfolio := range portfolios {
+		for _, report := range portfolio.TaxReports {
+			for _, lot := range report.EligibleLots {
+				writeLot(portfolio.PortfolioName, lot, "можно продать без налога")
+			}
+			for _, lot := range report.UpcomingLots {
+				writeLot(portfolio.PortfolioName, lot, "скоро")
+			}
+		}
 	}
 
 	return nil
@@ -0,0 +1,52 @@
+package brokerReportParser
+
+import (
+	"strings"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+)
+
+// alfaParser - брокерский отчет Альфа-Инвестиций в XML: сделки и движение денег записаны атрибутами элементов
+type alfaParser struct{}
+
+var alfaTrades = tableLayout{
+	columns: map[field][]string{
+		fieldTradeID:     {"trade_no", "deal_no"},
+		fieldDate:        {"db_time", "conclusion_date", "trade_date"},
+		fieldSide:        {"direction", "deal_type"},
+		fieldTicker:      {"p_code", "ticker"},
+		fieldQuantity:    {"qty", "quantity"},
+		fieldPrice:       {"price"},
+		fieldBrokerFee:   {"bank_tax", "broker_fee"},
+		fieldExchangeFee: {"exch_tax", "exchange_fee"},
+	},
+	required: []field{fieldDate, fieldTicker, fieldQuantity, fieldPrice},
+}
+
+var alfaCash = tableLayout{
+	columns: map[field][]string{
+		fieldDate:      {"settlement_date", "oper_date", "date"},
+		fieldOperation: {"oper_type", "operation"},
+		fieldAmount:    {"amount", "volume", "summ"},
+		fieldComment:   {"comment", "description"},
+		fieldTicker:    {"p_code", "ticker"},
+	},
+	required: []field{fieldDate, fieldOperation, fieldAmount},
+}
+
+func (alfaParser) broker() model.Broker {
+	return model.BrokerAlfa
+}
+
+func (alfaParser) supports(ext, text string) bool {
+	return ext == "xml" && (strings.Contains(text, "альфа") || strings.Contains(text, "alfa"))
+}
+
+func (alfaParser) parse(rows [][]string) model.BrokerReport {
+	dividends, fees := cashFromRecords(findRecords(rows, alfaCash))
+	return model.BrokerReport{
+		Trades:    tradesFromRecords(findRecords(rows, alfaTrades)),
+		Dividends: dividends,
+		Fees:      fees,
+	}
+}
@@ -0,0 +1,89 @@
+package brokerReportParser
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/internal/service"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+)
+
+// brokerParser - разбор отчета конкретного брокера. Чтобы поддержать нового брокера, достаточно реализовать
+// интерфейс и добавить парсер в New.
+type brokerParser interface {
+	broker() model.Broker
+	// supports - подходит ли отчет этому брокеру по расширению файла и характерному тексту
+	supports(ext, text string) bool
+	parse(rows [][]string) model.BrokerReport
+}
+
+type BrokerReportParser struct {
+	parsers []brokerParser
+}
+
+func New() *BrokerReportParser {
+	return &BrokerReportParser{
+		parsers: []brokerParser{
+			tinkoffParser{},
+			sberParser{},
+			vtbParser{},
+			alfaParser{},
+		},
+	}
+}
+
+// Parse определяет брокера по файлу отчета и извлекает из него сделки, дивиденды и прочие списания
+func (p *BrokerReportParser) Parse(ctx context.Context, filename string, content []byte) (report model.BrokerReport, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "BrokerReportParser.Parse"
+
+	slog.Debug("Parse start", slog.String("rqID", rqID), slog.String("op", op), slog.String("filename", filename))
+
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+
+	var rows [][]string
+	switch ext {
+	case "xlsx":
+		rows, err = xlsxRows(content)
+	case "html", "htm":
+		rows, err = htmlRows(content)
+	case "xml":
+		rows, err = xmlRows(content)
+	default:
+		return model.BrokerReport{}, service.ErrUnsupportedBrokerReport
+	}
+	if err != nil {
+		return model.BrokerReport{}, fmt.Errorf("%w: %s", service.ErrUnsupportedBrokerReport, err.Error())
+	}
+
+	text := rowsText(rows)
+	if ext != "xlsx" {
+		// в HTML и XML название брокера бывает вне таблиц, например в заголовке страницы
+		text += normalize(string(content))
+	}
+	for _, parser := range p.parsers {
+		if !parser.supports(ext, text) {
+			continue
+		}
+
+		report = parser.parse(rows)
+		report.Broker = parser.broker()
+
+		slog.Debug(
+			"Parse completed",
+			slog.String("rqID", rqID),
+			slog.String("op", op),
+			slog.String("broker", string(report.Broker)),
+			slog.Int("trades", len(report.Trades)),
+			slog.Int("dividends", len(report.Dividends)),
+			slog.Int("fees", len(report.Fees)),
+		)
+		return report, nil
+	}
+
+	return model.BrokerReport{}, service.ErrUnsupportedBrokerReport
+}
@@ -0,0 +1,213 @@
+package brokerReportParser
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/shopspring/decimal"
+)
+
+func date(year int, month time.Month, day, hour, min, sec int) time.Time {
+	return time.Date(year, month, day, hour, min, sec, 0, time.Local)
+}
+
+func dec(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+// TestParse разбирает обезличенные отчеты из testdata: по одному на брокера
+func TestParse(t *testing.T) {
+	tests := []struct {
+		file string
+		want model.BrokerReport
+	}{
+		{
+			file: "tinkoff.xlsx",
+			want: model.BrokerReport{
+				Broker: model.BrokerTinkoff,
+				Trades: []model.BrokerTrade{
+					{TradeID: "1001", Ticker: "SBER", Quantity: 10, Price: dec("280.50"), Commission: dec("1.12"), TradeDate: date(2024, 3, 15, 10, 15, 30)},
+					{TradeID: "1002", Ticker: "LKOH", Quantity: -2, Price: dec("7450"), Commission: dec("5.96"), TradeDate: date(2024, 3, 20, 14, 2, 11)},
+				},
+				Dividends: []model.BrokerDividend{
+					{Ticker: "SBER", PaymentDate: date(2024, 7, 25, 0, 0, 0), NetAmount: dec("330"), TaxAmount: dec("43")},
+				},
+				Fees: []model.BrokerFee{
+					{Date: date(2024, 7, 31, 0, 0, 0), Amount: dec("99"), Description: "Комиссия за обслуживание счета"},
+				},
+			},
+		},
+		{
+			file: "sber.html",
+			want: model.BrokerReport{
+				Broker: model.BrokerSber,
+				Trades: []model.BrokerTrade{
+					{TradeID: "7001", Ticker: "GAZP", Quantity: 100, Price: dec("162.35"), Commission: dec("6.49"), TradeDate: date(2024, 2, 5, 11, 30, 0)},
+					{TradeID: "7002", Ticker: "GAZP", Quantity: -50, Price: dec("165.10"), Commission: dec("3.31"), TradeDate: date(2024, 2, 12, 15, 5, 41)},
+				},
+				Dividends: []model.BrokerDividend{
+					{Ticker: "GAZP", PaymentDate: date(2024, 7, 18, 0, 0, 0), NetAmount: dec("870"), TaxAmount: dec("130")},
+				},
+				Fees: []model.BrokerFee{
+					{Date: date(2024, 7, 31, 0, 0, 0), Amount: dec("150"), Description: "Плата за депозитарное обслуживание"},
+				},
+			},
+		},
+		{
+			file: "vtb.xlsx",
+			want: model.BrokerReport{
+				Broker: model.BrokerVTB,
+				Trades: []model.BrokerTrade{
+					{TradeID: "8001", Ticker: "YDEX", Quantity: 3, Price: dec("3950.50"), Commission: dec("7.12"), TradeDate: date(2024, 4, 10, 12, 1, 5)},
+					{TradeID: "8002", Ticker: "TATN", Quantity: -10, Price: dec("690.20"), Commission: dec("2.76"), TradeDate: date(2024, 4, 11, 16, 45, 0)},
+				},
+				Dividends: []model.BrokerDividend{
+					{Ticker: "TATN", PaymentDate: date(2024, 8, 5, 0, 0, 0), NetAmount: dec("1044"), TaxAmount: dec("156")},
+				},
+				Fees: []model.BrokerFee{
+					{Date: date(2024, 8, 15, 0, 0, 0), Amount: dec("50"), Description: "Комиссия"},
+				},
+			},
+		},
+		{
+			file: "alfa.xml",
+			want: model.BrokerReport{
+				Broker: model.BrokerAlfa,
+				Trades: []model.BrokerTrade{
+					{TradeID: "9001", Ticker: "MGNT", Quantity: 2, Price: dec("7100.5"), Commission: dec("5.68"), TradeDate: date(2024, 5, 14, 11, 20, 0)},
+					{TradeID: "9002", Ticker: "MTSS", Quantity: -30, Price: dec("275.15"), Commission: dec("3.31"), TradeDate: date(2024, 5, 15, 16, 31, 7)},
+				},
+				Dividends: []model.BrokerDividend{
+					{Ticker: "MGNT", PaymentDate: date(2024, 6, 20, 0, 0, 0), NetAmount: dec("823"), TaxAmount: dec("123")},
+				},
+				Fees: []model.BrokerFee{
+					{Date: date(2024, 6, 30, 0, 0, 0), Amount: dec("75"), Description: "Комиссия за депозитарное хранение"},
+				},
+			},
+		},
+	}
+
+	parser := New()
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			content, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := parser.Parse(context.Background(), tt.file, content)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+
+			if got.Broker != tt.want.Broker {
+				t.Errorf("broker = %s, want %s", got.Broker, tt.want.Broker)
+			}
+
+			if len(got.Trades) != len(tt.want.Trades) {
+				t.Fatalf("trades = %+v, want %+v", got.Trades, tt.want.Trades)
+			}
+			for i, want := range tt.want.Trades {
+				trade := got.Trades[i]
+				if trade.TradeID != want.TradeID || trade.Ticker != want.Ticker || trade.Quantity != want.Quantity ||
+					!trade.Price.Equal(want.Price) || !trade.Commission.Equal(want.Commission) || !trade.TradeDate.Equal(want.TradeDate) {
+					t.Errorf("trade %d = %+v, want %+v", i, trade, want)
+				}
+			}
+
+			if len(got.Dividends) != len(tt.want.Dividends) {
+				t.Fatalf("dividends = %+v, want %+v", got.Dividends, tt.want.Dividends)
+			}
+			for i, want := range tt.want.Dividends {
+				dividend := got.Dividends[i]
+				if dividend.Ticker != want.Ticker || !dividend.PaymentDate.Equal(want.PaymentDate) ||
+					!dividend.NetAmount.Equal(want.NetAmount) || !dividend.TaxAmount.Equal(want.TaxAmount) {
+					t.Errorf("dividend %d = %+v, want %+v", i, dividend, want)
+				}
+			}
+
+			if len(got.Fees) != len(tt.want.Fees) {
+				t.Fatalf("fees = %+v, want %+v", got.Fees, tt.want.Fees)
+			}
+			for i, want := range tt.want.Fees {
+				fee := got.Fees[i]
+				if !fee.Date.Equal(want.Date) || !fee.Amount.Equal(want.Amount) || fee.Description != want.Description {
+					t.Errorf("fee %d = %+v, want %+v", i, fee, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		row    []string
+		layout tableLayout
+		want   map[field]int
+		ok     bool
+	}{
+		{
+			// "Время" совпадает точно, поэтому "Время расчетов" раньше по вхождению не забирает поле
+			name:   "exact match wins over substring",
+			row:    []string{"Время расчетов", "Дата заключения", "Время"},
+			layout: tableLayout{columns: map[field][]string{fieldDate: {"дата заключения"}, fieldTime: {"время"}}, required: []field{fieldDate}},
+			want:   map[field]int{fieldDate: 1, fieldTime: 2},
+			ok:     true,
+		},
+		{
+			name: "credit and debit are not taken by amount",
+			row:  []string{"Дата", "Операция", "Сумма зачисления, руб.", "Сумма списания, руб."},
+			layout: tableLayout{
+				columns: map[field][]string{
+					fieldDate:      {"дата"},
+					fieldOperation: {"операция"},
+					fieldCredit:    {"сумма зачисления"},
+					fieldDebit:     {"сумма списания"},
+					fieldAmount:    {"сумма"},
+				},
+				required: []field{fieldDate, fieldOperation},
+			},
+			want: map[field]int{fieldDate: 0, fieldOperation: 1, fieldCredit: 2, fieldDebit: 3},
+			ok:   true,
+		},
+		{
+			name:   "substring match with extra symbols",
+			row:    []string{"Цена**", "Количество, шт."},
+			layout: tableLayout{columns: map[field][]string{fieldPrice: {"цена"}, fieldQuantity: {"количество"}}, required: []field{fieldPrice, fieldQuantity}},
+			want:   map[field]int{fieldPrice: 0, fieldQuantity: 1},
+			ok:     true,
+		},
+		{
+			name:   "required column missing",
+			row:    []string{"Дата", "Сумма"},
+			layout: tableLayout{columns: map[field][]string{fieldDate: {"дата"}, fieldOperation: {"операция"}}, required: []field{fieldDate, fieldOperation}},
+			ok:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := matchHeader(tt.row, tt.layout)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("columns = %v, want %v", got, tt.want)
+			}
+			for f, idx := range tt.want {
+				if got[f] != idx {
+					t.Errorf("columns = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
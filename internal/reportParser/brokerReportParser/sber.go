@@ -0,0 +1,54 @@
+package brokerReportParser
+
+import (
+	"strings"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+)
+
+// sberParser - брокерский отчет СберБанка в HTML: таблицы "Сделки купли/продажи ценных бумаг" и "Движение денежных средств"
+type sberParser struct{}
+
+var sberTrades = tableLayout{
+	columns: map[field][]string{
+		fieldTradeID:     {"номер сделки"},
+		fieldDate:        {"дата заключения"},
+		fieldTime:        {"время заключения", "время"},
+		fieldSide:        {"вид"},
+		fieldTicker:      {"код финансового инструмента", "код инструмента"},
+		fieldQuantity:    {"количество, шт.", "количество"},
+		fieldPrice:       {"цена"},
+		fieldBrokerFee:   {"комиссия брокера"},
+		fieldExchangeFee: {"комиссия биржи", "комиссия торговой системы"},
+	},
+	required: []field{fieldDate, fieldSide, fieldTicker, fieldQuantity, fieldPrice},
+}
+
+var sberCash = tableLayout{
+	columns: map[field][]string{
+		fieldDate:      {"дата"},
+		fieldOperation: {"описание операции", "операция"},
+		fieldCredit:    {"сумма зачисления"},
+		fieldDebit:     {"сумма списания"},
+		fieldAmount:    {"сумма"},
+		fieldComment:   {"комментарий"},
+	},
+	required: []field{fieldDate, fieldOperation},
+}
+
+func (sberParser) broker() model.Broker {
+	return model.BrokerSber
+}
+
+func (sberParser) supports(ext, text string) bool {
+	return (ext == "html" || ext == "htm") && strings.Contains(text, "сбер")
+}
+
+func (sberParser) parse(rows [][]string) model.BrokerReport {
+	dividends, fees := cashFromRecords(findRecords(rows, sberCash))
+	return model.BrokerReport{
+		Trades:    tradesFromRecords(findRecords(rows, sberTrades)),
+		Dividends: dividends,
+		Fees:      fees,
+	}
+}
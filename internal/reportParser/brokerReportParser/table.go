@@ -0,0 +1,480 @@
+package brokerReportParser
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/shopspring/decimal"
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/net/html"
+)
+
+type field int
+
+const (
+	fieldTradeID field = iota
+	fieldDate
+	fieldTime
+	fieldSide
+	fieldTicker
+	fieldQuantity
+	fieldPrice
+	fieldBrokerFee
+	fieldExchangeFee
+	fieldOperation
+	fieldCredit
+	fieldDebit
+	fieldAmount
+	fieldComment
+)
+
+// tableLayout - варианты заголовков колонок таблицы отчета. Таблица находится по строке, в которой есть все обязательные колонки.
+type tableLayout struct {
+	columns  map[field][]string
+	required []field
+}
+
+type record map[field]string
+
+func (r record) filled(fields []field) bool {
+	for _, f := range fields {
+		if r[f] == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// findRecords возвращает строки всех таблиц отчета с заголовком по layout.
+// Таблица заканчивается на строке, в которой не заполнены обязательные колонки (пустая строка, итоги, новый раздел).
+func findRecords(rows [][]string, layout tableLayout) []record {
+	var records []record
+	for i := 0; i < len(rows); i++ {
+		columns, ok := matchHeader(rows[i], layout)
+		if !ok {
+			continue
+		}
+
+		for i+1 < len(rows) {
+			row := rows[i+1]
+			rec := make(record, len(columns))
+			for f, idx := range columns {
+				if idx < len(row) {
+					rec[f] = strings.TrimSpace(row[idx])
+				}
+			}
+			if !rec.filled(layout.required) {
+				break
+			}
+			records = append(records, rec)
+			i++
+		}
+	}
+	return records
+}
+
+// matchHeader сопоставляет колонки строки с полями layout: сначала по точному совпадению заголовка, затем по вхождению.
+// Поля перебираются в порядке объявления, чтобы при вхождении одного заголовка в несколько колонок результат
+// не зависел от порядка обхода map: например, "сумма зачисления" раньше достается fieldCredit, чем fieldAmount.
+func matchHeader(row []string, layout tableLayout) (map[field]int, bool) {
+	cells := make([]string, len(row))
+	for i, cell := range row {
+		cells[i] = normalize(cell)
+	}
+
+	fields := slices.Sorted(maps.Keys(layout.columns))
+	columns := make(map[field]int, len(layout.columns))
+	used := make(map[int]bool, len(layout.columns))
+	for _, exact := range []bool{true, false} {
+		for _, f := range fields {
+			if _, ok := columns[f]; ok {
+				continue
+			}
+		aliasLoop:
+			for _, alias := range layout.columns[f] {
+				for i, cell := range cells {
+					if used[i] || cell == "" {
+						continue
+					}
+					if (exact && cell == alias) || (!exact && strings.Contains(cell, alias)) {
+						columns[f] = i
+						used[i] = true
+						break aliasLoop
+					}
+				}
+			}
+		}
+	}
+
+	for _, f := range layout.required {
+		if _, ok := columns[f]; !ok {
+			return nil, false
+		}
+	}
+	return columns, true
+}
+
+func normalize(s string) string {
+	s = strings.ToLower(s)
+	s = strings.ReplaceAll(s, "ё", "е")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func rowsText(rows [][]string) string {
+	sb := strings.Builder{}
+	for _, row := range rows {
+		for _, cell := range row {
+			sb.WriteString(normalize(cell))
+			sb.WriteString(" ")
+		}
+	}
+	return sb.String()
+}
+
+// xlsxRows - строки всех листов книги, листы разделены пустой строкой
+func xlsxRows(content []byte) ([][]string, error) {
+	f, err := excelize.OpenReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rows [][]string
+	for _, sheet := range f.GetSheetList() {
+		sheetRows, err := f.GetRows(sheet)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, sheetRows...)
+		rows = append(rows, nil)
+	}
+	return rows, nil
+}
+
+// htmlRows - строки всех таблиц страницы, таблицы разделены пустой строкой. Объединенные ячейки раскрываются в пустые.
+func htmlRows(content []byte) ([][]string, error) {
+	tokenizer := html.NewTokenizer(bytes.NewReader(content))
+
+	var rows [][]string
+	var row []string
+	var cell *strings.Builder
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if errors.Is(tokenizer.Err(), io.EOF) {
+				return rows, nil
+			}
+			return nil, tokenizer.Err()
+		case html.StartTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "tr":
+				row = nil
+			case "td", "th":
+				cell = &strings.Builder{}
+				for _, attr := range token.Attr {
+					if attr.Key == "colspan" {
+						for span := colspan(attr.Val); span > 1; span-- {
+							row = append(row, "")
+						}
+					}
+				}
+			case "br":
+				if cell != nil {
+					cell.WriteString(" ")
+				}
+			}
+		case html.TextToken:
+			if cell != nil {
+				cell.Write(tokenizer.Text())
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "td", "th":
+				if cell != nil {
+					row = append(row, strings.TrimSpace(html.UnescapeString(cell.String())))
+					cell = nil
+				}
+			case "tr":
+				rows = append(rows, row)
+				row = nil
+			case "table":
+				rows = append(rows, nil)
+			}
+		}
+	}
+}
+
+func colspan(val string) int {
+	n := 0
+	for _, r := range val {
+		if r < '0' || r > '9' {
+			break
+		}
+		n = n*10 + int(r-'0')
+	}
+	return n
+}
+
+// xmlRows превращает каждый элемент XML с атрибутами или вложенными значениями в пару строк "заголовок - значения",
+// чтобы искать в нем поля так же, как в табличных отчетах
+func xmlRows(content []byte) ([][]string, error) {
+	type frame struct {
+		names       []string
+		values      []string
+		text        strings.Builder
+		hasChildren bool
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) { return input, nil }
+
+	var rows [][]string
+	var stack []*frame
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return rows, nil
+			}
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if len(stack) > 0 {
+				stack[len(stack)-1].hasChildren = true
+			}
+			f := &frame{}
+			for _, attr := range t.Attr {
+				f.names = append(f.names, attr.Name.Local)
+				f.values = append(f.values, attr.Value)
+			}
+			stack = append(stack, f)
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		case xml.EndElement:
+			f := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			text := strings.TrimSpace(f.text.String())
+			if !f.hasChildren && len(f.names) == 0 && text != "" && len(stack) > 0 {
+				// простое значение становится полем родительского элемента
+				parent := stack[len(stack)-1]
+				parent.names = append(parent.names, t.Name.Local)
+				parent.values = append(parent.values, text)
+				continue
+			}
+			if len(f.names) > 0 {
+				rows = append(rows, f.names, f.values, nil)
+			}
+		}
+	}
+}
+
+// parseDecimal разбирает число из отчета: пробелы-разделители разрядов, запятая вместо точки, валюта после суммы
+func parseDecimal(s string) (decimal.Decimal, error) {
+	sb := strings.Builder{}
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9', r == '-':
+			sb.WriteRune(r)
+		case r == ',' || r == '.':
+			sb.WriteRune('.')
+		}
+	}
+	return decimal.NewFromString(sb.String())
+}
+
+var dateLayouts = []string{
+	"02.01.2006 15:04:05",
+	"02.01.2006 15:04",
+	"02.01.2006",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"01-02-06 15:04",
+	"01-02-06",
+}
+
+// parseDate разбирает дату (и время, если оно в отдельной колонке) сделки или операции
+func parseDate(date, clock string) (time.Time, bool) {
+	value := strings.TrimSpace(date)
+	if clock = strings.TrimSpace(clock); clock != "" {
+		value += " " + clock
+	}
+
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, true
+		}
+	}
+	if clock != "" {
+		return parseDate(date, "")
+	}
+	return time.Time{}, false
+}
+
+// parseSide - 1 для покупки, -1 для продажи, 0 если направление сделки не распознано
+func parseSide(s string) int {
+	s = normalize(s)
+	switch {
+	case strings.Contains(s, "покуп"), s == "buy", s == "b", s == "к":
+		return 1
+	case strings.Contains(s, "продаж"), s == "sell", s == "s", s == "п":
+		return -1
+	}
+	return 0
+}
+
+func tradesFromRecords(records []record) []model.BrokerTrade {
+	trades := make([]model.BrokerTrade, 0, len(records))
+	for _, rec := range records {
+		side := parseSide(rec[fieldSide])
+		quantity, err := parseDecimal(rec[fieldQuantity])
+		if err != nil || quantity.IsZero() {
+			continue
+		}
+		if side == 0 { // направление может быть передано знаком количества
+			side = 1
+			if quantity.IsNegative() {
+				side = -1
+			}
+		}
+
+		price, err := parseDecimal(rec[fieldPrice])
+		if err != nil {
+			continue
+		}
+
+		tradeDate, ok := parseDate(rec[fieldDate], rec[fieldTime])
+		if !ok {
+			continue
+		}
+
+		var commission decimal.Decimal
+		for _, f := range []field{fieldBrokerFee, fieldExchangeFee} {
+			if fee, err := parseDecimal(rec[f]); err == nil {
+				commission = commission.Add(fee.Abs())
+			}
+		}
+
+		trades = append(trades, model.BrokerTrade{
+			TradeID:    rec[fieldTradeID],
+			Ticker:     strings.ToUpper(strings.TrimSpace(rec[fieldTicker])),
+			Quantity:   int(quantity.Abs().IntPart()) * side,
+			Price:      price.Abs(),
+			Commission: commission,
+			TradeDate:  tradeDate,
+		})
+	}
+	return trades
+}
+
+var tickerRe = regexp.MustCompile(`\b[A-Z][A-Z0-9]{2,5}\b`)
+
+var notTickers = map[string]bool{"RUB": true, "USD": true, "EUR": true, "CNY": true, "ISIN": true, "NDFL": true}
+
+// extractTicker ищет тикер в описании операции
+func extractTicker(text string) string {
+	for _, candidate := range tickerRe.FindAllString(text, -1) {
+		if !notTickers[candidate] {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// cashFromRecords разбирает движение денег: дивиденды (с удержанным налогом) и списания брокера, не связанные со сделками.
+// Оплата сделок и комиссии по ним пропускаются - они учитываются по самим сделкам.
+func cashFromRecords(records []record) (dividends []model.BrokerDividend, fees []model.BrokerFee) {
+	type tax struct {
+		ticker string
+		date   time.Time
+		amount decimal.Decimal
+	}
+	var taxes []tax
+
+	for _, rec := range records {
+		description := normalize(rec[fieldOperation] + " " + rec[fieldComment])
+
+		// раздельные колонки зачисления и списания точнее общей суммы, если в отчете есть и то и другое
+		_, hasCredit := rec[fieldCredit]
+		_, hasDebit := rec[fieldDebit]
+
+		var amount decimal.Decimal
+		if hasCredit || hasDebit {
+			credit, _ := parseDecimal(rec[fieldCredit])
+			debit, _ := parseDecimal(rec[fieldDebit])
+			amount = credit.Sub(debit.Abs())
+		} else {
+			parsed, err := parseDecimal(rec[fieldAmount])
+			if err != nil {
+				continue
+			}
+			amount = parsed
+		}
+		if amount.IsZero() {
+			continue
+		}
+
+		date, ok := parseDate(rec[fieldDate], "")
+		if !ok {
+			continue
+		}
+
+		ticker := strings.ToUpper(strings.TrimSpace(rec[fieldTicker]))
+		if ticker == "" {
+			ticker = extractTicker(rec[fieldOperation] + " " + rec[fieldComment])
+		}
+
+		switch {
+		case strings.Contains(description, "налог"):
+			taxes = append(taxes, tax{ticker: ticker, date: date, amount: amount.Abs()})
+		case strings.Contains(description, "дивиденд") && amount.IsPositive() && ticker != "":
+			dividends = append(dividends, model.BrokerDividend{Ticker: ticker, PaymentDate: date, NetAmount: amount})
+		case amount.IsNegative() && isBrokerFee(description):
+			fees = append(fees, model.BrokerFee{Date: date, Amount: amount.Abs(), Description: strings.TrimSpace(rec[fieldOperation])})
+		}
+	}
+
+	// налог привязываем к выплате по той же бумаге в пределах нескольких дней
+	for _, t := range taxes {
+		for i := range dividends {
+			dividend := &dividends[i]
+			if t.ticker != "" && dividend.Ticker != "" && t.ticker != dividend.Ticker {
+				continue
+			}
+			if diff := dividend.PaymentDate.Sub(t.date); diff < -72*time.Hour || diff > 72*time.Hour {
+				continue
+			}
+			dividend.TaxAmount = dividend.TaxAmount.Add(t.amount)
+			break
+		}
+	}
+
+	return dividends, fees
+}
+
+func isBrokerFee(description string) bool {
+	if strings.Contains(description, "сделк") || strings.Contains(description, "комиссия брокера") || strings.Contains(description, "биржи") {
+		return false
+	}
+	for _, marker := range []string{"комисс", "плата за", "обслуживан", "депозитар"} {
+		if strings.Contains(description, marker) {
+			return true
+		}
+	}
+	return false
+}
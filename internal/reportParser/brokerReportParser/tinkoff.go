@@ -0,0 +1,53 @@
+package brokerReportParser
+
+import (
+	"strings"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+)
+
+// tinkoffParser - брокерский отчет Т-Банка (Тинькофф) в XLSX: раздел "Заключенные сделки" и "Операции с денежными средствами"
+type tinkoffParser struct{}
+
+var tinkoffTrades = tableLayout{
+	columns: map[field][]string{
+		fieldTradeID:     {"номер сделки"},
+		fieldDate:        {"дата заключения"},
+		fieldTime:        {"время"},
+		fieldSide:        {"вид сделки"},
+		fieldTicker:      {"код актива"},
+		fieldQuantity:    {"количество"},
+		fieldPrice:       {"цена за единицу"},
+		fieldBrokerFee:   {"комиссия брокера"},
+		fieldExchangeFee: {"комиссия биржи"},
+	},
+	required: []field{fieldDate, fieldSide, fieldTicker, fieldQuantity, fieldPrice},
+}
+
+var tinkoffCash = tableLayout{
+	columns: map[field][]string{
+		fieldDate:      {"дата исполнения", "дата"},
+		fieldOperation: {"операция"},
+		fieldCredit:    {"сумма зачисления"},
+		fieldDebit:     {"сумма списания"},
+		fieldComment:   {"примечание"},
+	},
+	required: []field{fieldDate, fieldOperation},
+}
+
+func (tinkoffParser) broker() model.Broker {
+	return model.BrokerTinkoff
+}
+
+func (tinkoffParser) supports(ext, text string) bool {
+	return ext == "xlsx" && (strings.Contains(text, "тинькофф") || strings.Contains(text, "т-банк") || strings.Contains(text, "тбанк"))
+}
+
+func (tinkoffParser) parse(rows [][]string) model.BrokerReport {
+	dividends, fees := cashFromRecords(findRecords(rows, tinkoffCash))
+	return model.BrokerReport{
+		Trades:    tradesFromRecords(findRecords(rows, tinkoffTrades)),
+		Dividends: dividends,
+		Fees:      fees,
+	}
+}
@@ -0,0 +1,53 @@
+package brokerReportParser
+
+import (
+	"strings"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+)
+
+// vtbParser - брокерский отчет ВТБ в XLSX: "Завершенные в отчетном периоде сделки" и "Движение денежных средств"
+type vtbParser struct{}
+
+var vtbTrades = tableLayout{
+	columns: map[field][]string{
+		fieldTradeID:     {"№ сделки", "номер сделки"},
+		fieldDate:        {"дата и время заключения сделки", "дата заключения"},
+		fieldSide:        {"вид сделки"},
+		fieldTicker:      {"тикер", "код ценной бумаги"},
+		fieldQuantity:    {"количество"},
+		fieldPrice:       {"цена"},
+		fieldBrokerFee:   {"комиссия банка", "комиссия брокера"},
+		fieldExchangeFee: {"комиссия биржи", "комиссия торговой системы"},
+	},
+	required: []field{fieldDate, fieldSide, fieldTicker, fieldQuantity, fieldPrice},
+}
+
+var vtbCash = tableLayout{
+	columns: map[field][]string{
+		fieldDate:      {"дата"},
+		fieldOperation: {"тип операции", "операция"},
+		fieldCredit:    {"сумма зачисления"},
+		fieldDebit:     {"сумма списания"},
+		fieldAmount:    {"сумма"},
+		fieldComment:   {"комментарий", "примечание"},
+	},
+	required: []field{fieldDate, fieldOperation},
+}
+
+func (vtbParser) broker() model.Broker {
+	return model.BrokerVTB
+}
+
+func (vtbParser) supports(ext, text string) bool {
+	return ext == "xlsx" && strings.Contains(text, "втб")
+}
+
+func (vtbParser) parse(rows [][]string) model.BrokerReport {
+	dividends, fees := cashFromRecords(findRecords(rows, vtbCash))
+	return model.BrokerReport{
+		Trades:    tradesFromRecords(findRecords(rows, vtbTrades)),
+		Dividends: dividends,
+		Fees:      fees,
+	}
+}
@@ -6,4 +6,15 @@ var (
 	ErrNotFound = errors.New("error not found")
 	ErrStockNotActive = errors.New("error stock is not active")
 	ErrActualStockInfoUnavailable = errors.New("error actual stock info unavailable")
+	ErrNotEnoughCash = errors.New("error not enough cash")
+	ErrInvalidOperationHistory = errors.New("error invalid operation history")
+	ErrUnsupportedBrokerReport = errors.New("error unsupported broker report")
+	ErrUnsupportedCsv = errors.New("error unsupported csv")
+	ErrOperationNotEditable = errors.New("error operation can not be edited")
+	ErrCorporateActionApplied = errors.New("error corporate action already applied")
+	ErrNotEnoughStocks = errors.New("error not enough stocks")
+	ErrNothingToUndo = errors.New("error nothing to undo")
+	ErrInvalidAllocations = errors.New("error invalid contribution allocations")
+	ErrTickerNotInPortfolio = errors.New("error ticker not in portfolio")
+	ErrDividendAlreadyRecorded = errors.New("error dividend already recorded")
 )
\ No newline at end of file
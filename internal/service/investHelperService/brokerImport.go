@@ -0,0 +1,388 @@
+package investHelperService
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/data/repository"
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/internal/model/moexModel"
+	"github.com/KotFed0t/invest_helper_bot/internal/service"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+	"github.com/shopspring/decimal"
+)
+
+// dividendDuplicateWindow - насколько раньше выплаты могла быть записана та же выплата дивиденда
+// (начисление по отсечке приходит раньше зачисления денег брокером)
+const dividendDuplicateWindow = 40 * 24 * time.Hour
+
+// PreviewBrokerReport разбирает отчет брокера и помечает, что из него уже есть в портфеле и что загрузить нельзя.
+// Если после загрузки история сделок станет противоречивой, причина возвращается в Problem.
+func (s *InvestHelperService) PreviewBrokerReport(
+	ctx context.Context,
+	portfolioID int64,
+	filename string,
+	content []byte,
+) (model.BrokerImportPreview, error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.PreviewBrokerReport"
+
+	slog.Debug("PreviewBrokerReport start", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", portfolioID), slog.String("filename", filename))
+
+	portfolio, err := s.repo.GetPortfolio(ctx, portfolioID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return model.BrokerImportPreview{}, service.ErrNotFound
+		}
+		return model.BrokerImportPreview{}, err
+	}
+
+	report, err := s.reportParser.Parse(ctx, filename, content)
+	if err != nil {
+		return model.BrokerImportPreview{}, err
+	}
+
+	preview := model.BrokerImportPreview{PortfolioName: portfolio.PortfolioName}
+
+	operations, _, err := s.markBrokerReport(ctx, portfolioID, &report)
+	if err != nil {
+		return model.BrokerImportPreview{}, err
+	}
+	preview.Report = report
+
+	if len(report.Trades) == 0 && len(report.Dividends) == 0 && len(report.Fees) == 0 {
+		preview.Problem = "в отчете не найдено сделок, дивидендов и комиссий"
+		return preview, nil
+	}
+
+	// проверяем, что с новыми сделками продажи не превышают количество бумаг
+	_, _, _, err = rebuildLots(portfolioID, operations)
+	if err != nil {
+		var sellErr invalidSellError
+		if !errors.As(err, &sellErr) {
+			return model.BrokerImportPreview{}, err
+		}
+		preview.Problem = fmt.Sprintf(
+			"продажа %d шт. %s %s превышает количество бумаг в портфеле на эту дату (%d шт.)",
+			-sellErr.operation.Quantity, sellErr.operation.Ticker, sellErr.operation.DtCreate.Format("02.01.2006"), sellErr.holdings,
+		)
+	}
+
+	return preview, nil
+}
+
+// ImportBrokerReport загружает в портфель новые сделки, дивиденды и комиссии из отчета одной транзакцией.
+// Дубли перепроверяются, сделки проходят через историю операций с пересборкой лотов и денег.
+func (s *InvestHelperService) ImportBrokerReport(ctx context.Context, portfolioID int64, report model.BrokerReport) (model.BrokerImportResult, error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.ImportBrokerReport"
+
+	slog.Debug("ImportBrokerReport start", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", portfolioID), slog.String("broker", string(report.Broker)))
+
+	var result model.BrokerImportResult
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		err := s.repo.LockPortfolio(ctx, portfolioID)
+		if err != nil {
+			return err
+		}
+
+		_, stocksInfo, err := s.markBrokerReport(ctx, portfolioID, &report)
+		if err != nil {
+			return err
+		}
+
+		stocks, err := s.repo.GetStocksFromPortfolio(ctx, portfolioID)
+		if err != nil {
+			return err
+		}
+		inPortfolio := make(map[string]struct{}, len(stocks))
+		for _, stock := range stocks {
+			inPortfolio[stock.Ticker] = struct{}{}
+		}
+
+		stockOperations := make([]model.StockOperation, 0, len(report.Trades))
+		for _, trade := range report.Trades {
+			if trade.Duplicate || trade.UnknownTicker {
+				continue
+			}
+
+			if _, ok := inPortfolio[trade.Ticker]; !ok {
+				stockInfo := stocksInfo[trade.Ticker]
+				err = s.repo.InsertStockToPortfolio(ctx, portfolioID, trade.Ticker, stockInfo.Board, stockInfo.InstrumentType)
+				if err != nil && !errors.Is(err, repository.ErrAlreadyExists) {
+					return err
+				}
+				inPortfolio[trade.Ticker] = struct{}{}
+			}
+
+			stockOperations = append(stockOperations, brokerTradeOperation(report.Broker, trade, stocksInfo[trade.Ticker]))
+		}
+
+		if len(stockOperations) > 0 {
+			operationIDs, err := s.repo.InsertStockOperationsToHistory(ctx, portfolioID, stockOperations)
+			if err != nil {
+				return err
+			}
+			for i := range stockOperations {
+				stockOperations[i].OperationID = operationIDs[i]
+			}
+
+			err = s.recordTradesCash(ctx, portfolioID, stockOperations)
+			if err != nil {
+				return err
+			}
+		}
+
+		incomes := make([]model.DividendIncome, 0, len(report.Dividends))
+		cashOperations := make([]model.CashOperation, 0, len(report.Dividends)*2+len(report.Fees))
+		for _, dividend := range report.Dividends {
+			if dividend.Duplicate {
+				continue
+			}
+			income := brokerDividendIncome(portfolioID, dividend)
+			incomes = append(incomes, income)
+			cashOperations = append(cashOperations, dividendCashOperations(income)...)
+		}
+		if len(incomes) > 0 {
+			err = s.repo.InsertDividendIncomes(ctx, incomes)
+			if err != nil {
+				return err
+			}
+		}
+
+		fees := 0
+		for _, fee := range report.Fees {
+			if fee.Duplicate {
+				continue
+			}
+			cashOperations = append(cashOperations, model.CashOperation{
+				PortfolioID:   portfolioID,
+				OperationType: model.CashOperationCommission,
+				Amount:        fee.Amount.Neg(),
+				DtCreate:      fee.Date,
+			})
+			fees++
+		}
+		if len(cashOperations) > 0 {
+			err = s.repo.InsertCashOperations(ctx, portfolioID, cashOperations)
+			if err != nil {
+				return err
+			}
+		}
+
+		result = model.BrokerImportResult{Trades: len(stockOperations), Dividends: len(incomes), Fees: fees}
+
+		if len(stockOperations) == 0 {
+			return nil
+		}
+		return s.replayPortfolioHistory(ctx, portfolioID)
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return model.BrokerImportResult{}, service.ErrNotFound
+		}
+		return model.BrokerImportResult{}, err
+	}
+
+	s.refreshPortfolioAfterReplay(ctx, portfolioID)
+
+	slog.Debug(
+		"ImportBrokerReport completed",
+		slog.String("rqID", rqID),
+		slog.String("op", op),
+		slog.Int("trades", result.Trades),
+		slog.Int("dividends", result.Dividends),
+		slog.Int("fees", result.Fees),
+	)
+
+	return result, nil
+}
+
+// markBrokerReport помечает в отчете дубли и сделки по неизвестным бумагам. Возвращает историю операций портфеля
+// вместе с новыми сделками из отчета, упорядоченную по времени, и данные биржи по бумагам из сделок.
+// Бумаги портфеля, которых уже нет на бирже, считаются известными, данных по ним в результате нет.
+func (s *InvestHelperService) markBrokerReport(
+	ctx context.Context,
+	portfolioID int64,
+	report *model.BrokerReport,
+) ([]model.StockOperation, map[string]moexModel.StockInfo, error) {
+	operations, err := s.repo.GetStockOperations(ctx, portfolioID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	slices.SortStableFunc(report.Trades, func(a, b model.BrokerTrade) int {
+		return a.TradeDate.Compare(b.TradeDate)
+	})
+
+	inPortfolio := make(map[string]struct{})
+	stocks, err := s.repo.GetStocksFromPortfolio(ctx, portfolioID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, stock := range stocks {
+		inPortfolio[stock.Ticker] = struct{}{}
+	}
+
+	knownTickers := make(map[string]bool)
+	stocksInfo := make(map[string]moexModel.StockInfo)
+	newOperations := make([]model.StockOperation, 0, len(report.Trades))
+	externalIDs := make(map[string]struct{}, len(operations)+len(report.Trades))
+	for _, operation := range operations {
+		if operation.ExternalID != "" {
+			externalIDs[operation.ExternalID] = struct{}{}
+		}
+	}
+	for i := range report.Trades {
+		trade := &report.Trades[i]
+		trade.Duplicate = isDuplicateTrade(report.Broker, *trade, operations, externalIDs)
+
+		known, checked := knownTickers[trade.Ticker]
+		if !checked {
+			stockInfo, err := s.GetStockInfo(ctx, trade.Ticker)
+			if err != nil && !errors.Is(err, service.ErrNotFound) {
+				return nil, nil, err
+			}
+			if err == nil {
+				stocksInfo[trade.Ticker] = stockInfo
+			}
+			_, ok := inPortfolio[trade.Ticker]
+			known = err == nil || ok
+			knownTickers[trade.Ticker] = known
+		}
+		trade.UnknownTicker = !known
+
+		if trade.Duplicate || trade.UnknownTicker {
+			continue
+		}
+		operation := brokerTradeOperation(report.Broker, *trade, stocksInfo[trade.Ticker])
+		if operation.ExternalID != "" {
+			externalIDs[operation.ExternalID] = struct{}{}
+		}
+		newOperations = append(newOperations, operation)
+	}
+
+	incomes, err := s.repo.GetDividendIncome(ctx, portfolioID, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range report.Dividends {
+		dividend := &report.Dividends[i]
+		for _, income := range incomes {
+			if income.Ticker == dividend.Ticker &&
+				!income.DividendDate.After(dividend.PaymentDate) &&
+				dividend.PaymentDate.Sub(income.DividendDate) <= dividendDuplicateWindow {
+				dividend.Duplicate = true
+				break
+			}
+		}
+		if !dividend.Duplicate {
+			incomes = append(incomes, brokerDividendIncome(portfolioID, *dividend))
+		}
+	}
+
+	cashOperations, err := s.repo.GetAllCashOperations(ctx, portfolioID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range report.Fees {
+		fee := &report.Fees[i]
+		for _, operation := range cashOperations {
+			if operation.OperationType == model.CashOperationCommission && operation.OperationID == 0 &&
+				sameDay(operation.DtCreate, fee.Date) && operation.Amount.Neg().Equal(fee.Amount) {
+				fee.Duplicate = true
+				break
+			}
+		}
+		if !fee.Duplicate {
+			cashOperations = append(cashOperations, model.CashOperation{
+				OperationType: model.CashOperationCommission,
+				Amount:        fee.Amount.Neg(),
+				DtCreate:      fee.Date,
+			})
+		}
+	}
+
+	history := append(operations, newOperations...)
+	slices.SortStableFunc(history, func(a, b model.StockOperation) int {
+		return a.DtCreate.Compare(b.DtCreate)
+	})
+
+	return history, stocksInfo, nil
+}
+
+// isDuplicateTrade - сделка уже загружена (по номеру у брокера) или введена вручную (та же бумага, количество и цена в тот же день)
+func isDuplicateTrade(broker model.Broker, trade model.BrokerTrade, operations []model.StockOperation, externalIDs map[string]struct{}) bool {
+	if externalID := brokerTradeExternalID(broker, trade); externalID != "" {
+		if _, ok := externalIDs[externalID]; ok {
+			return true
+		}
+	}
+
+	for _, operation := range operations {
+		if operation.ExternalID == "" &&
+			operation.Ticker == trade.Ticker &&
+			operation.Quantity == trade.Quantity &&
+			operation.Price.Equal(trade.Price) &&
+			sameDay(operation.DtCreate, trade.TradeDate) {
+			return true
+		}
+	}
+	return false
+}
+
+func brokerTradeExternalID(broker model.Broker, trade model.BrokerTrade) string {
+	if trade.TradeID == "" {
+		return ""
+	}
+	return string(broker) + ":" + trade.TradeID
+}
+
+// brokerTradeOperation - операция истории по сделке из отчета. Название и валюта берутся с биржи,
+// для бумаги, которой на бирже уже нет, валюта - рубли.
+func brokerTradeOperation(broker model.Broker, trade model.BrokerTrade, stockInfo moexModel.StockInfo) model.StockOperation {
+	currency := stockInfo.CurrencyID
+	if currency == "" {
+		currency = "RUB"
+	}
+
+	return model.StockOperation{
+		Ticker:     trade.Ticker,
+		Shortname:  stockInfo.Shortname,
+		Quantity:   trade.Quantity,
+		Price:      trade.Price,
+		TotalPrice: trade.Price.Mul(decimal.NewFromInt(int64(trade.Quantity))),
+		Commission: trade.Commission,
+		Currency:   currency,
+		DtCreate:   trade.TradeDate,
+		ExternalID: brokerTradeExternalID(broker, trade),
+	}
+}
+
+// brokerDividendIncome - запись о дивиденде из отчета. Если брокер не указал налог, считаем его по базовой ставке НДФЛ.
+func brokerDividendIncome(portfolioID int64, dividend model.BrokerDividend) model.DividendIncome {
+	grossAmount := dividend.NetAmount.Add(dividend.TaxAmount)
+	if dividend.TaxAmount.IsZero() {
+		grossAmount = dividend.NetAmount.Div(decimal.NewFromInt(1).Sub(ndflBaseRate)).Round(2)
+	}
+
+	return model.DividendIncome{
+		PortfolioID:  portfolioID,
+		Ticker:       dividend.Ticker,
+		DividendDate: dividend.PaymentDate,
+		GrossAmount:  grossAmount,
+		TaxAmount:    grossAmount.Sub(dividend.NetAmount),
+		NetAmount:    dividend.NetAmount,
+		Source:       model.DividendSourceImport,
+	}
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Local().Date()
+	by, bm, bd := b.Local().Date()
+	return ay == by && am == bm && ad == bd
+}
@@ -0,0 +1,198 @@
+package investHelperService
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/internal/service"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+	"github.com/shopspring/decimal"
+)
+
+// cashOperationsLimit - сколько последних операций с деньгами показывать
+const cashOperationsLimit = 10
+
+// AddCashOperation записывает операцию с деньгами, введенную пользователем. amount передается положительным,
+// знак определяется типом операции. Вывести можно не больше свободного остатка.
+func (s *InvestHelperService) AddCashOperation(
+	ctx context.Context,
+	portfolioID int64,
+	operationType model.CashOperationType,
+	amount decimal.Decimal,
+) error {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.AddCashOperation"
+
+	slog.Debug("AddCashOperation start", slog.String("rqID", rqID), slog.String("op", op), slog.String("type", string(operationType)), slog.String("amount", amount.String()))
+
+	operation := model.CashOperation{
+		PortfolioID:   portfolioID,
+		OperationType: operationType,
+		Amount:        amount,
+		DtCreate:      time.Now(),
+	}
+
+	switch operationType {
+	case model.CashOperationDeposit, model.CashOperationCoupon, model.CashOperationDividend:
+	case model.CashOperationWithdrawal, model.CashOperationCommission, model.CashOperationTax:
+		operation.Amount = amount.Neg()
+	default:
+		return fmt.Errorf("unsupported cash operation type %s", operationType)
+	}
+
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		// блокировка портфеля не дает двум параллельным выводам пройти проверку по одному и тому же остатку
+		err := s.repo.LockPortfolio(ctx, portfolioID)
+		if err != nil {
+			return err
+		}
+
+		if operationType == model.CashOperationWithdrawal {
+			balance, err := s.repo.GetCashBalance(ctx, portfolioID)
+			if err != nil {
+				return err
+			}
+			if balance.LessThan(amount) {
+				return service.ErrNotEnoughCash
+			}
+		}
+
+		return s.repo.InsertCashOperations(ctx, portfolioID, []model.CashOperation{operation})
+	})
+	if err != nil {
+		return err
+	}
+
+	_ = s.cache.FlushPortfolioCache(ctx, portfolioID) // вызываем синхронно, так как конкурентно может не успеть удалиться и получим старую инфу
+
+	return nil
+}
+
+func (s *InvestHelperService) GetCashBalance(ctx context.Context, portfolioID int64) (decimal.Decimal, error) {
+	return s.repo.GetCashBalance(ctx, portfolioID)
+}
+
+func (s *InvestHelperService) GetPortfolioCash(ctx context.Context, portfolioID int64) (model.PortfolioCash, error) {
+	portfolio, err := s.repo.GetPortfolio(ctx, portfolioID)
+	if err != nil {
+		return model.PortfolioCash{}, err
+	}
+
+	balance, err := s.repo.GetCashBalance(ctx, portfolioID)
+	if err != nil {
+		return model.PortfolioCash{}, err
+	}
+
+	operations, err := s.repo.GetCashOperations(ctx, portfolioID, cashOperationsLimit)
+	if err != nil {
+		return model.PortfolioCash{}, err
+	}
+
+	return model.PortfolioCash{
+		PortfolioID:   portfolioID,
+		PortfolioName: portfolio.PortfolioName,
+		Balance:       balance,
+		Operations:    operations,
+	}, nil
+}
+
+// recordTradesCash проводит покупки, продажи и комиссии по ним по журналу денег. Если свободных денег на покупку не хватает,
+// недостающая сумма считается внесенной извне и записывается пополнением, чтобы остаток не уходил в минус.
+// Должен вызываться внутри транзакции.
+func (s *InvestHelperService) recordTradesCash(ctx context.Context, portfolioID int64, stockOperations []model.StockOperation) error {
+	operations := make([]model.CashOperation, 0, len(stockOperations)+1)
+	var total decimal.Decimal
+	for _, stockOperation := range stockOperations {
+		for _, operation := range tradeCashOperations(portfolioID, stockOperation) {
+			operations = append(operations, operation)
+			total = total.Add(operation.Amount)
+		}
+	}
+
+	if len(operations) == 0 {
+		return nil
+	}
+
+	if total.IsNegative() {
+		balance, err := s.repo.GetCashBalance(ctx, portfolioID)
+		if err != nil {
+			return err
+		}
+
+		if shortfall := total.Add(balance); shortfall.IsNegative() {
+			deposit := model.CashOperation{
+				PortfolioID:   portfolioID,
+				OperationType: model.CashOperationDeposit,
+				Amount:        shortfall.Neg(),
+				OperationID:   stockOperations[0].OperationID,
+				DtCreate:      operations[0].DtCreate,
+			}
+			operations = append([]model.CashOperation{deposit}, operations...)
+		}
+	}
+
+	return s.repo.InsertCashOperations(ctx, portfolioID, operations)
+}
+
+// tradeCashOperations - оплата или выручка по сделке и комиссия по ней. Корпоративные действия и переводы денег не двигают.
+func tradeCashOperations(portfolioID int64, stockOperation model.StockOperation) []model.CashOperation {
+	if !stockOperation.IsTrade() {
+		return nil
+	}
+
+	// у продажи количество и сумма отрицательные, деньги при этом поступают
+	operation := model.CashOperation{
+		PortfolioID:   portfolioID,
+		OperationType: model.CashOperationBuy,
+		Amount:        stockOperation.TotalPrice.Neg(),
+		Ticker:        stockOperation.Ticker,
+		OperationID:   stockOperation.OperationID,
+		DtCreate:      stockOperation.DtCreate,
+	}
+	if stockOperation.Quantity < 0 {
+		operation.OperationType = model.CashOperationSell
+	}
+	operations := []model.CashOperation{operation}
+
+	if !stockOperation.Commission.IsZero() {
+		operations = append(operations, model.CashOperation{
+			PortfolioID:   portfolioID,
+			OperationType: model.CashOperationCommission,
+			Amount:        stockOperation.Commission.Neg(),
+			Ticker:        stockOperation.Ticker,
+			OperationID:   stockOperation.OperationID,
+			DtCreate:      stockOperation.DtCreate,
+		})
+	}
+	return operations
+}
+
+// dividendCashOperations - начисление дивиденда до налога и удержанный налог
+func dividendCashOperations(income model.DividendIncome) []model.CashOperation {
+	operations := []model.CashOperation{{
+		PortfolioID:   income.PortfolioID,
+		OperationType: model.CashOperationDividend,
+		Amount:        income.GrossAmount,
+		Ticker:        income.Ticker,
+		DtCreate:      income.DividendDate,
+	}}
+	if !income.TaxAmount.IsZero() {
+		operations = append(operations, model.CashOperation{
+			PortfolioID:   income.PortfolioID,
+			OperationType: model.CashOperationTax,
+			Amount:        income.TaxAmount.Neg(),
+			Ticker:        income.Ticker,
+			DtCreate:      income.DividendDate,
+		})
+	}
+	return operations
+}
+
+// applyCashBalance заполняет в сводке свободные деньги и общий баланс
+func (s *InvestHelperService) applyCashBalance(summary *model.PortfolioSummary, balance decimal.Decimal) {
+	summary.CashBalance = balance
+	summary.TotalBalance = summary.BalanceInsideIndex.Add(summary.BalanceOutsideIndex).Add(balance)
+}
@@ -0,0 +1,40 @@
+package investHelperService
+
+import (
+	"context"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/shopspring/decimal"
+)
+
+// calculateCommission - комиссия по проценту портфеля от суммы сделки, округленная до копеек
+func calculateCommission(totalPrice, percent decimal.Decimal) decimal.Decimal {
+	if !percent.IsPositive() {
+		return decimal.Decimal{}
+	}
+	return totalPrice.Abs().Mul(percent).Div(decimal.NewFromInt(100)).Round(2)
+}
+
+// operationUnitCost - цена одной бумаги с учетом комиссии: при покупке комиссия увеличивает стоимость лота,
+// при продаже уменьшает выручку
+func operationUnitCost(operation model.StockOperation) decimal.Decimal {
+	if operation.Quantity == 0 || operation.Commission.IsZero() {
+		return operation.Price
+	}
+
+	commissionPerUnit := operation.Commission.Div(decimal.NewFromInt(int64(operation.Quantity)))
+	// у продажи количество отрицательное, поэтому комиссия на бумагу вычитается
+	return operation.Price.Add(commissionPerUnit)
+}
+
+// SetCommissionPercent задает комиссию по умолчанию для сделок портфеля
+func (s *InvestHelperService) SetCommissionPercent(ctx context.Context, portfolioID int64, percent decimal.Decimal) error {
+	err := s.repo.SetCommissionPercent(ctx, portfolioID, percent)
+	if err != nil {
+		return err
+	}
+
+	_ = s.cache.FlushPortfolioCache(ctx, portfolioID) // вызываем синхронно, так как конкурентно может не успеть удалиться и получим старую инфу
+
+	return nil
+}
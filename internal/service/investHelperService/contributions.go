@@ -0,0 +1,205 @@
+package investHelperService
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"slices"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/internal/service"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+	"github.com/shopspring/decimal"
+)
+
+// GetContributionSettings возвращает доли пополнения пользователя в порядке расчета и все его портфели по алфавиту
+func (s *InvestHelperService) GetContributionSettings(ctx context.Context, chatID int64) ([]model.ContributionAllocation, []model.Portfolio, error) {
+	userID, portfolios, err := s.getUserPortfolios(ctx, chatID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	allocations, err := s.repo.GetContributionAllocations(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return allocations, portfolios, nil
+}
+
+// SetContributionAllocations заменяет доли пополнения пользователя. Порядок долей - порядок расчета: остаток
+// портфеля переходит в следующий. Доли должны быть положительными, а в сумме не больше 100%.
+func (s *InvestHelperService) SetContributionAllocations(ctx context.Context, chatID int64, allocations []model.ContributionAllocation) error {
+	userID, portfolios, err := s.getUserPortfolios(ctx, chatID)
+	if err != nil {
+		return err
+	}
+
+	var total decimal.Decimal
+	seen := make(map[int64]bool, len(allocations))
+	for _, allocation := range allocations {
+		owned := slices.ContainsFunc(portfolios, func(portfolio model.Portfolio) bool {
+			return portfolio.PortfolioID == allocation.PortfolioID
+		})
+		if !owned || seen[allocation.PortfolioID] || !allocation.Percent.IsPositive() {
+			return service.ErrInvalidAllocations
+		}
+		seen[allocation.PortfolioID] = true
+		total = total.Add(allocation.Percent)
+	}
+	if total.GreaterThan(decimal.NewFromInt(100)) {
+		return service.ErrInvalidAllocations
+	}
+
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		return s.repo.SetContributionAllocations(ctx, userID, allocations)
+	})
+}
+
+// CalculateContribution распределяет пополнение по портфелям пользователя согласно долям и рассчитывает закуп
+// в каждом стратегией портфеля. Остаток портфеля переходит в следующий по порядку долей. Пустой портфель,
+// по которому закуп не рассчитывается, пропускается, и его доля целиком переходит в следующий.
+func (s *InvestHelperService) CalculateContribution(ctx context.Context, chatID int64, sum decimal.Decimal) (model.ContributionPlan, error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.CalculateContribution"
+
+	slog.Debug("CalculateContribution start", slog.String("rqID", rqID), slog.String("op", op), slog.String("sum", sum.StringFixed(2)))
+
+	allocations, _, err := s.GetContributionSettings(ctx, chatID)
+	if err != nil {
+		return model.ContributionPlan{}, err
+	}
+	if len(allocations) == 0 {
+		return model.ContributionPlan{}, service.ErrNotFound
+	}
+
+	plan := model.ContributionPlan{Sum: sum, Unallocated: sum}
+	var carriedOver decimal.Decimal
+	for _, allocation := range allocations {
+		allocated := sum.Mul(allocation.Percent).Div(decimal.NewFromInt(100)).Round(2)
+		plan.Unallocated = plan.Unallocated.Sub(allocated)
+
+		portfolioSum := allocated.Add(carriedOver)
+		contribution := model.PortfolioContribution{
+			PortfolioID:   allocation.PortfolioID,
+			PortfolioName: allocation.PortfolioName,
+			Allocated:     allocated,
+			CarriedOver:   carriedOver,
+		}
+
+		purchases, err := s.CalculatePurchase(ctx, allocation.PortfolioID, portfolioSum, model.PurchaseConstraints{})
+		if err != nil {
+			empty, emptyErr := s.isPortfolioEmpty(ctx, allocation.PortfolioID)
+			if emptyErr != nil || !empty {
+				return model.ContributionPlan{}, err
+			}
+			slog.Warn(
+				"skip empty portfolio in contribution",
+				slog.String("rqID", rqID),
+				slog.String("op", op),
+				slog.Int64("portfolioID", allocation.PortfolioID),
+				slog.String("err", err.Error()),
+			)
+			contribution.Skipped = true
+			contribution.Remainder = portfolioSum
+			carriedOver = portfolioSum
+			plan.Portfolios = append(plan.Portfolios, contribution)
+			continue
+		}
+		contribution.Purchases = purchases
+		for _, purchase := range purchases {
+			contribution.Spent = contribution.Spent.Add(purchase.StockPrice.Mul(decimal.NewFromInt(purchase.LotsQuantity.IntPart() * int64(purchase.LotSize))))
+		}
+		contribution.Remainder = portfolioSum.Sub(contribution.Spent)
+		carriedOver = contribution.Remainder
+
+		plan.Portfolios = append(plan.Portfolios, contribution)
+	}
+	plan.Remainder = carriedOver.Add(plan.Unallocated)
+
+	slog.Info("result for contribution", slog.String("rqID", rqID), slog.String("op", op), slog.Any("plan", plan))
+
+	return plan, nil
+}
+
+// isPortfolioEmpty - в портфеле нет ни одной бумаги на руках
+func (s *InvestHelperService) isPortfolioEmpty(ctx context.Context, portfolioID int64) (bool, error) {
+	stocks, err := s.repo.GetStocksFromPortfolio(ctx, portfolioID)
+	if err != nil {
+		return false, err
+	}
+
+	return !slices.ContainsFunc(stocks, func(stock model.StockBase) bool {
+		return stock.Quantity > 0
+	}), nil
+}
+
+// ApplyContributionPlan применяет закуп по всем портфелям плана пополнения в одной транзакции
+func (s *InvestHelperService) ApplyContributionPlan(ctx context.Context, chatID int64, plan model.ContributionPlan) error {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.ApplyContributionPlan"
+
+	slog.Debug("ApplyContributionPlan start", slog.String("rqID", rqID), slog.String("op", op))
+
+	_, portfolios, err := s.getUserPortfolios(ctx, chatID)
+	if err != nil {
+		return err
+	}
+
+	type portfolioOperations struct {
+		portfolioID     int64
+		stockOperations []model.StockOperation
+		stockRemainings []model.StockRemaining
+	}
+	operations := make([]portfolioOperations, 0, len(plan.Portfolios))
+	for _, contribution := range plan.Portfolios {
+		if len(contribution.Purchases) == 0 {
+			continue
+		}
+
+		owned := slices.ContainsFunc(portfolios, func(portfolio model.Portfolio) bool {
+			return portfolio.PortfolioID == contribution.PortfolioID
+		})
+		if !owned {
+			return service.ErrNotFound
+		}
+
+		portfolio, err := s.repo.GetPortfolio(ctx, contribution.PortfolioID)
+		if err != nil {
+			return err
+		}
+
+		stockOperations, stockRemainings := purchaseOperations(contribution.PortfolioID, portfolio.CommissionPercent, contribution.Purchases)
+		operations = append(operations, portfolioOperations{
+			portfolioID:     contribution.PortfolioID,
+			stockOperations: stockOperations,
+			stockRemainings: stockRemainings,
+		})
+	}
+
+	// блокируем в порядке возрастания id, чтобы параллельные изменения портфелей не взаимоблокировались
+	slices.SortFunc(operations, func(a, b portfolioOperations) int {
+		return cmp.Compare(a.portfolioID, b.portfolioID)
+	})
+
+	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		for _, portfolio := range operations {
+			err := s.applyPurchaseOperations(ctx, portfolio.portfolioID, portfolio.stockOperations, portfolio.stockRemainings)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, portfolio := range operations {
+		s.refreshPortfolioAfterReplay(ctx, portfolio.portfolioID)
+	}
+
+	slog.Debug("ApplyContributionPlan completed", slog.String("rqID", rqID), slog.String("op", op))
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package investHelperService
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/data/repository"
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/internal/model/moexModel"
+	"github.com/KotFed0t/invest_helper_bot/internal/service"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+)
+
+// ApplyCorporateAction применяет сплит или смену тикера ко всем портфелям, в составе которых есть бумага.
+// В историю каждого портфеля, где на дату действия были бумаги, записывается операция сплита или пара операций
+// смены тикера, после чего лоты пересобираются с сохранением стоимости покупки. Дробные остатки при обратном
+// сплите отбрасываются, денежная компенсация за них не начисляется.
+func (s *InvestHelperService) ApplyCorporateAction(ctx context.Context, action model.CorporateAction) (model.CorporateActionResult, error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.ApplyCorporateAction"
+
+	slog.Debug(
+		"ApplyCorporateAction start",
+		slog.String("rqID", rqID),
+		slog.String("op", op),
+		slog.String("type", string(action.Type)),
+		slog.String("ticker", action.Ticker),
+		slog.String("newTicker", action.NewTicker),
+	)
+
+	action.EffectiveDate = corporateActionDate(action.EffectiveDate)
+
+	var newStockInfo moexModel.StockInfo
+	switch action.Type {
+	case model.CorporateActionSplit:
+		if action.RatioFrom <= 0 || action.RatioTo <= 0 || action.RatioFrom == action.RatioTo {
+			return model.CorporateActionResult{}, fmt.Errorf("invalid split ratio %d:%d", action.RatioFrom, action.RatioTo)
+		}
+	case model.CorporateActionRename:
+		if action.NewTicker == "" || action.NewTicker == action.Ticker {
+			return model.CorporateActionResult{}, fmt.Errorf("invalid new ticker %q", action.NewTicker)
+		}
+		var err error
+		newStockInfo, err = s.GetStockInfo(ctx, action.NewTicker)
+		if err != nil {
+			return model.CorporateActionResult{}, err
+		}
+	default:
+		return model.CorporateActionResult{}, fmt.Errorf("unknown corporate action type %q", action.Type)
+	}
+
+	result := model.CorporateActionResult{Action: action}
+	var portfolioIDs []int64
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		portfolioIDs, err = s.repo.GetPortfoliosWithTicker(ctx, action.Ticker)
+		if err != nil {
+			return err
+		}
+
+		for _, portfolioID := range portfolioIDs {
+			err = s.repo.LockPortfolio(ctx, portfolioID)
+			if err != nil {
+				return err
+			}
+
+			applied, err := s.applyCorporateActionToPortfolio(ctx, portfolioID, action, newStockInfo)
+			if err != nil {
+				return fmt.Errorf("portfolio %d: %w", portfolioID, err)
+			}
+			if applied {
+				result.Portfolios++
+			}
+		}
+
+		return s.repo.InsertCorporateAction(ctx, action, result.Portfolios)
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrAlreadyExists) {
+			return model.CorporateActionResult{}, service.ErrCorporateActionApplied
+		}
+		return model.CorporateActionResult{}, err
+	}
+
+	for _, portfolioID := range portfolioIDs {
+		s.refreshPortfolioAfterReplay(ctx, portfolioID)
+	}
+
+	slog.Info(
+		"corporate action applied",
+		slog.String("rqID", rqID),
+		slog.String("op", op),
+		slog.String("type", string(action.Type)),
+		slog.String("ticker", action.Ticker),
+		slog.Int("portfolios", result.Portfolios),
+	)
+
+	return result, nil
+}
+
+// applyCorporateActionToPortfolio записывает корпоративное действие в историю портфеля и пересобирает лоты.
+// Возвращает false, если на дату действия бумаг в портфеле не было. Должен вызываться внутри транзакции.
+func (s *InvestHelperService) applyCorporateActionToPortfolio(
+	ctx context.Context,
+	portfolioID int64,
+	action model.CorporateAction,
+	newStockInfo moexModel.StockInfo,
+) (bool, error) {
+	operations, err := s.repo.GetStockOperations(ctx, portfolioID)
+	if err != nil {
+		return false, err
+	}
+
+	before := slices.IndexFunc(operations, func(operation model.StockOperation) bool {
+		return !operation.DtCreate.Before(action.EffectiveDate)
+	})
+	if before < 0 {
+		before = len(operations)
+	}
+	_, quantities, _, err := rebuildLots(portfolioID, operations[:before])
+	if err != nil {
+		return false, err
+	}
+	holdings := quantities[action.Ticker]
+
+	shortname := action.Ticker
+	for _, operation := range operations[:before] {
+		if operation.Ticker == action.Ticker && operation.Shortname != "" {
+			shortname = operation.Shortname
+		}
+	}
+
+	applied := holdings > 0
+	if applied {
+		var actionOperations []model.StockOperation
+		switch action.Type {
+		case model.CorporateActionSplit:
+			actionOperations = []model.StockOperation{{
+				OperationType: model.StockOperationSplit,
+				Ticker:        action.Ticker,
+				Shortname:     shortname,
+				Quantity:      holdings*action.RatioTo/action.RatioFrom - holdings,
+				RatioFrom:     action.RatioFrom,
+				RatioTo:       action.RatioTo,
+				Currency:      "RUB",
+				DtCreate:      action.EffectiveDate,
+			}}
+		case model.CorporateActionRename:
+			actionOperations = []model.StockOperation{
+				{
+					OperationType: model.StockOperationRename,
+					Ticker:        action.Ticker,
+					Shortname:     shortname,
+					Quantity:      -holdings,
+					Currency:      "RUB",
+					DtCreate:      action.EffectiveDate,
+				},
+				{
+					OperationType: model.StockOperationRename,
+					Ticker:        action.NewTicker,
+					Shortname:     newStockInfo.Shortname,
+					Quantity:      holdings,
+					Currency:      "RUB",
+					DtCreate:      action.EffectiveDate,
+				},
+			}
+		}
+
+		if actionOperations[0].Quantity != 0 {
+			_, err = s.repo.InsertStockOperationsToHistory(ctx, portfolioID, actionOperations)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if action.Type == model.CorporateActionRename {
+		err = s.repo.RenamePortfolioStock(ctx, portfolioID, action.Ticker, action.NewTicker, newStockInfo.Board, newStockInfo.InstrumentType)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if !applied {
+		return false, nil
+	}
+
+	return true, s.replayPortfolioHistory(ctx, portfolioID)
+}
+
+// corporateActionDate - начало дня действия по местному времени, операции до этого момента считаются совершенными до него
+func corporateActionDate(date time.Time) time.Time {
+	year, month, day := date.Local().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+}
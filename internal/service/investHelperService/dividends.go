@@ -0,0 +1,286 @@
+package investHelperService
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/internal/model/moexModel"
+	"github.com/KotFed0t/invest_helper_bot/internal/service"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+	"github.com/shopspring/decimal"
+)
+
+// UpdateDividends загружает календарь дивидендов по акциям и фондам из портфелей и начисляет дивиденды с прошедшей отсечкой
+func (s *InvestHelperService) UpdateDividends(ctx context.Context) error {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.UpdateDividends"
+
+	slog.Debug("UpdateDividends start", slog.String("rqID", rqID), slog.String("op", op))
+
+	stocksByPortfolios, err := s.repo.GetAllStocks(ctx)
+	if err != nil {
+		return err
+	}
+
+	// по облигациям выплачиваются купоны, а не дивиденды
+	m := make(map[string]struct{})
+	for _, stocks := range stocksByPortfolios {
+		for _, stock := range stocks {
+			if stock.InstrumentType == moexModel.InstrumentTypeBond {
+				continue
+			}
+			m[stock.Ticker] = struct{}{}
+		}
+	}
+
+	for ticker := range m {
+		dividends, err := s.moexApi.GetDividends(ctx, ticker)
+		if err != nil {
+			// ошибка по одному тикеру не должна останавливать загрузку остальных
+			slog.Error("can't get dividends", slog.String("rqID", rqID), slog.String("op", op), slog.String("ticker", ticker), slog.String("err", err.Error()))
+			continue
+		}
+
+		if len(dividends) == 0 {
+			continue
+		}
+
+		err = s.repo.UpsertDividends(ctx, dividends)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.accrueDividends(ctx)
+	if err != nil {
+		return err
+	}
+
+	slog.Debug("UpdateDividends completed", slog.String("rqID", rqID), slog.String("op", op), slog.Int("tickers", len(m)))
+
+	return nil
+}
+
+// accrueDividends записывает в журнал дивиденды по количеству акций на дату отсечки. Налог удерживается брокером по ставке 13%.
+func (s *InvestHelperService) accrueDividends(ctx context.Context) error {
+	// выплата, уже загруженная из отчета брокера или введенная вручную, повторно не начисляется
+	accruals, err := s.repo.GetDividendAccruals(ctx, int(dividendDuplicateWindow/(24*time.Hour)))
+	if err != nil {
+		return err
+	}
+
+	if len(accruals) == 0 {
+		return nil
+	}
+
+	portfolioIDs := make(map[int64]struct{})
+	for i := range accruals {
+		accruals[i].GrossAmount = accruals[i].AmountPerShare.Mul(decimal.NewFromInt(int64(accruals[i].Quantity))).Round(2)
+		accruals[i].TaxAmount = accruals[i].GrossAmount.Mul(ndflBaseRate).Round(2)
+		accruals[i].NetAmount = accruals[i].GrossAmount.Sub(accruals[i].TaxAmount)
+		portfolioIDs[accruals[i].PortfolioID] = struct{}{}
+	}
+
+	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		err := s.repo.InsertDividendIncomes(ctx, accruals)
+		if err != nil {
+			return err
+		}
+
+		for _, accrual := range accruals {
+			err = s.repo.InsertCashOperations(ctx, accrual.PortfolioID, dividendCashOperations(accrual))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for portfolioID := range portfolioIDs {
+		_ = s.cache.FlushPortfolioCache(ctx, portfolioID)
+	}
+
+	return nil
+}
+
+// AddManualDividend записывает полученный дивиденд, введенный вручную суммой после удержания налога, на дату выплаты.
+// Если выплата уже начислена по отсечке или загружена из отчета брокера, возвращает service.ErrDividendAlreadyRecorded.
+func (s *InvestHelperService) AddManualDividend(ctx context.Context, portfolioID int64, ticker string, netAmount decimal.Decimal, paymentDate time.Time) error {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.AddManualDividend"
+
+	slog.Debug(
+		"AddManualDividend start",
+		slog.String("rqID", rqID),
+		slog.String("op", op),
+		slog.String("ticker", ticker),
+		slog.String("netAmount", netAmount.String()),
+		slog.Time("paymentDate", paymentDate),
+	)
+
+	grossAmount := netAmount.Div(decimal.NewFromInt(1).Sub(ndflBaseRate)).Round(2)
+	income := model.DividendIncome{
+		PortfolioID:  portfolioID,
+		Ticker:       ticker,
+		DividendDate: paymentDate,
+		GrossAmount:  grossAmount,
+		TaxAmount:    grossAmount.Sub(netAmount),
+		NetAmount:    netAmount,
+		Source:       model.DividendSourceManual,
+	}
+
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		err := s.repo.LockPortfolio(ctx, portfolioID)
+		if err != nil {
+			return err
+		}
+
+		// начисление по отсечке датируется днем закрытия реестра, а выплата приходит позже
+		incomes, err := s.repo.GetDividendIncome(ctx, portfolioID, ticker)
+		if err != nil {
+			return err
+		}
+		for _, recorded := range incomes {
+			if recorded.Source != model.DividendSourceManual &&
+				!recorded.DividendDate.After(paymentDate) &&
+				paymentDate.Sub(recorded.DividendDate) <= dividendDuplicateWindow {
+				return service.ErrDividendAlreadyRecorded
+			}
+		}
+
+		err = s.repo.InsertDividendIncomes(ctx, []model.DividendIncome{income})
+		if err != nil {
+			return err
+		}
+
+		return s.repo.InsertCashOperations(ctx, portfolioID, dividendCashOperations(income))
+	})
+	if err != nil {
+		return err
+	}
+
+	_ = s.cache.FlushPortfolioCache(ctx, portfolioID) // вызываем синхронно, так как конкурентно может не успеть удалиться и получим старую инфу
+
+	return nil
+}
+
+// getStockDividends собирает дивдоходность, ближайшую отсечку и полученные дивиденды по бумаге в портфеле
+func (s *InvestHelperService) getStockDividends(ctx context.Context, portfolioID int64, ticker string, price decimal.Decimal) (model.StockDividends, error) {
+	dividends, err := s.repo.GetDividends(ctx, ticker)
+	if err != nil {
+		return model.StockDividends{}, err
+	}
+
+	incomes, err := s.repo.GetDividendIncome(ctx, portfolioID, ticker)
+	if err != nil {
+		return model.StockDividends{}, err
+	}
+
+	stockDividends := model.StockDividends{Income: incomes}
+
+	now := time.Now()
+	today := now.Truncate(24 * time.Hour)
+	yearAgo := now.AddDate(-1, 0, 0)
+	for _, dividend := range dividends {
+		if dividend.RegistryCloseDate.Before(today) {
+			if dividend.RegistryCloseDate.After(yearAgo) {
+				stockDividends.TrailingYearValue = stockDividends.TrailingYearValue.Add(dividend.Value)
+			}
+			continue
+		}
+
+		if stockDividends.Next == nil {
+			next := dividend
+			stockDividends.Next = &next
+		}
+	}
+
+	if price.IsPositive() {
+		stockDividends.Yield = stockDividends.TrailingYearValue.Div(price).Mul(decimal.NewFromInt(100))
+	}
+
+	for _, income := range incomes {
+		stockDividends.Received = stockDividends.Received.Add(income.NetAmount)
+	}
+
+	return stockDividends, nil
+}
+
+// applyDividendIncome заполняет в сводке суммы полученных дивидендов
+func (s *InvestHelperService) applyDividendIncome(summary *model.PortfolioSummary, incomes []model.DividendIncome) {
+	currentYear := time.Now().Year()
+	for _, income := range incomes {
+		summary.DividendsReceived = summary.DividendsReceived.Add(income.NetAmount)
+		if income.DividendDate.Year() == currentYear {
+			summary.DividendsReceivedThisYear = summary.DividendsReceivedThisYear.Add(income.NetAmount)
+		}
+	}
+}
+
+// DetectUpcomingDividends возвращает отсечки в ближайшие DividendDaysBefore дней по бумагам из портфелей с включенными уведомлениями.
+// О каждой отсечке владельцу сообщаем один раз.
+func (s *InvestHelperService) DetectUpcomingDividends(ctx context.Context) ([]model.UpcomingDividend, error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.DetectUpcomingDividends"
+
+	slog.Debug("DetectUpcomingDividends start", slog.String("rqID", rqID), slog.String("op", op))
+	defer func() {
+		slog.Debug("DetectUpcomingDividends finished", slog.String("rqID", rqID), slog.String("op", op))
+	}()
+
+	until := time.Now().AddDate(0, 0, s.cfg.Notifications.DividendDaysBefore)
+
+	dividends, err := s.repo.GetUpcomingDividends(ctx, until)
+	if err != nil {
+		return nil, err
+	}
+
+	oneMinusTax := decimal.NewFromInt(1).Sub(ndflBaseRate)
+	for i := range dividends {
+		dividends[i].LastBuyDate = lastBuyDateForRegistry(dividends[i].RegistryCloseDate)
+		dividends[i].ExpectedNet = dividends[i].AmountPerShare.
+			Mul(decimal.NewFromInt(int64(dividends[i].Quantity))).
+			Mul(oneMinusTax).
+			Round(2)
+	}
+
+	return dividends, nil
+}
+
+func (s *InvestHelperService) MarkUpcomingDividendNotified(ctx context.Context, dividend model.UpcomingDividend) error {
+	return s.repo.MarkDividendNotified(ctx, dividend.PortfolioID, dividend.Ticker, dividend.RegistryCloseDate)
+}
+
+// ToggleDividendNotifications включает или выключает уведомления об отсечках по портфелю, возвращает новое состояние
+func (s *InvestHelperService) ToggleDividendNotifications(ctx context.Context, portfolioID int64) (enabled bool, err error) {
+	portfolio, err := s.repo.GetPortfolio(ctx, portfolioID)
+	if err != nil {
+		return false, err
+	}
+
+	enabled = !portfolio.DividendNotifications
+	err = s.repo.SetDividendNotifications(ctx, portfolioID, enabled)
+	if err != nil {
+		return false, err
+	}
+
+	_ = s.cache.FlushPortfolioCache(ctx, portfolioID) // вызываем синхронно, так как конкурентно может не успеть удалиться и получим старую инфу
+
+	return enabled, nil
+}
+
+// lastBuyDateForRegistry - последний торговый день, покупка в который попадает в реестр: расчеты проходят в режиме T+1,
+// поэтому купить нужно за рабочий день до закрытия реестра
+func lastBuyDateForRegistry(registryCloseDate time.Time) time.Time {
+	date := registryCloseDate.AddDate(0, 0, -1)
+	for date.Weekday() == time.Saturday || date.Weekday() == time.Sunday {
+		date = date.AddDate(0, 0, -1)
+	}
+	return date
+}
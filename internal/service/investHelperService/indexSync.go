@@ -0,0 +1,289 @@
+package investHelperService
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/KotFed0t/invest_helper_bot/data/repository"
+	"github.com/KotFed0t/invest_helper_bot/internal/externalApi"
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/internal/model/moexModel"
+	"github.com/KotFed0t/invest_helper_bot/internal/service"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+	"github.com/shopspring/decimal"
+)
+
+// SyncWeightsWithIndex приводит целевые веса портфеля к составу индекса:
+// добавляет недостающие тикеры, обнуляет вес выбывшим и выравнивает веса до 100% в одной транзакции.
+// Портфель привязывается к индексу для отслеживания расхождений.
+func (s *InvestHelperService) SyncWeightsWithIndex(ctx context.Context, portfolioID int64, indexID string) (result model.IndexSyncResult, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.SyncWeightsWithIndex"
+
+	slog.Debug("SyncWeightsWithIndex start", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", portfolioID), slog.String("indexID", indexID))
+	defer func() {
+		slog.Debug("SyncWeightsWithIndex finished", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", portfolioID), slog.String("indexID", indexID))
+	}()
+
+	components, err := s.moexApi.GetIndexComposition(ctx, indexID)
+	if err != nil {
+		if errors.Is(err, externalApi.ErrNotFound) {
+			slog.Warn("index not found in moexApi", slog.String("rqID", rqID), slog.String("op", op), slog.String("indexID", indexID))
+			return model.IndexSyncResult{}, service.ErrNotFound
+		}
+		slog.Error("got error from moexApi.GetIndexComposition", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return model.IndexSyncResult{}, err
+	}
+
+	stocks, err := s.repo.GetStocksFromPortfolio(ctx, portfolioID)
+	if err != nil {
+		return model.IndexSyncResult{}, err
+	}
+
+	existingStocks := make(map[string]model.StockBase, len(stocks))
+	for _, stock := range stocks {
+		existingStocks[stock.Ticker] = stock
+	}
+
+	result.IndexID = indexID
+	missingTickers := make([]string, 0)
+	for _, component := range components {
+		if component.TradeDate.After(result.TradeDate) {
+			result.TradeDate = component.TradeDate
+		}
+		if _, ok := existingStocks[component.Ticker]; !ok {
+			missingTickers = append(missingTickers, component.Ticker)
+		}
+	}
+
+	// для новых тикеров нужны режим торгов и тип инструмента
+	newStocks := make([]model.StockBase, 0, len(missingTickers))
+	if len(missingTickers) > 0 {
+		stocksInfoMap, err := s.getStocksInfo(ctx, missingTickers)
+		if err != nil {
+			return model.IndexSyncResult{}, err
+		}
+
+		for _, ticker := range missingTickers {
+			stockInfo, ok := stocksInfoMap[ticker]
+			if !ok {
+				slog.Warn("index ticker not found on exchange", slog.String("rqID", rqID), slog.String("op", op), slog.String("ticker", ticker))
+				result.Skipped = append(result.Skipped, ticker)
+				continue
+			}
+			newStocks = append(newStocks, model.StockBase{
+				PortfolioID:    portfolioID,
+				Ticker:         ticker,
+				Board:          stockInfo.Board,
+				InstrumentType: stockInfo.InstrumentType,
+			})
+			result.Added = append(result.Added, ticker)
+		}
+	}
+
+	weights := make(map[string]decimal.Decimal, len(components))
+	for _, component := range components {
+		_, existing := existingStocks[component.Ticker]
+		if !existing && !slices.Contains(result.Added, component.Ticker) {
+			continue
+		}
+		weights[component.Ticker] = component.Weight
+	}
+	result.Updated = len(weights)
+
+	for _, stock := range stocks {
+		if _, ok := weights[stock.Ticker]; !ok && stock.TargetWeight.IsPositive() {
+			result.Removed = append(result.Removed, stock.Ticker)
+		}
+	}
+
+	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if len(newStocks) > 0 {
+			err := s.repo.InsertStocksToPortfolio(ctx, portfolioID, newStocks)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := s.repo.SetPortfolioWeights(ctx, portfolioID, weights)
+		if err != nil {
+			return err
+		}
+
+		err = s.repo.RebalanceWeights(ctx, portfolioID)
+		if err != nil {
+			return err
+		}
+
+		// после синхронизации портфель следит за индексом
+		return s.repo.SetPortfolioIndex(ctx, portfolioID, &indexID)
+	})
+	if err != nil {
+		slog.Error("SyncWeightsWithIndex transaction failed", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return model.IndexSyncResult{}, err
+	}
+
+	_ = s.cache.FlushPortfolioCache(ctx, portfolioID) // вызываем синхронно, так как конкурентно может не успеть удалиться и получим старую инфу
+
+	return result, nil
+}
+
+// SyncWeightsWithLinkedIndex синхронизирует веса с индексом, к которому уже привязан портфель
+func (s *InvestHelperService) SyncWeightsWithLinkedIndex(ctx context.Context, portfolioID int64) (model.IndexSyncResult, error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.SyncWeightsWithLinkedIndex"
+
+	portfolio, err := s.repo.GetPortfolio(ctx, portfolioID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return model.IndexSyncResult{}, service.ErrNotFound
+		}
+		return model.IndexSyncResult{}, err
+	}
+
+	if portfolio.IndexID == "" {
+		slog.Warn("portfolio is not linked to index", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", portfolioID))
+		return model.IndexSyncResult{}, service.ErrNotFound
+	}
+
+	return s.SyncWeightsWithIndex(ctx, portfolioID, portfolio.IndexID)
+}
+
+func (s *InvestHelperService) UnlinkPortfolioIndex(ctx context.Context, portfolioID int64) error {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.UnlinkPortfolioIndex"
+
+	slog.Debug("UnlinkPortfolioIndex start", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", portfolioID))
+
+	err := s.repo.SetPortfolioIndex(ctx, portfolioID, nil)
+	if err != nil {
+		slog.Error("got error from repo.SetPortfolioIndex", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return err
+	}
+
+	_ = s.cache.FlushPortfolioCache(ctx, portfolioID)
+
+	return nil
+}
+
+// DetectIndexDrifts сравнивает целевые веса привязанных к индексу портфелей с актуальным составом индекса.
+// Возвращает только новые расхождения: о тех, что уже отправлялись владельцу, повторно не сообщаем.
+func (s *InvestHelperService) DetectIndexDrifts(ctx context.Context) ([]model.IndexDrift, error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.DetectIndexDrifts"
+
+	slog.Debug("DetectIndexDrifts start", slog.String("rqID", rqID), slog.String("op", op))
+	defer func() {
+		slog.Debug("DetectIndexDrifts finished", slog.String("rqID", rqID), slog.String("op", op))
+	}()
+
+	portfolios, err := s.repo.GetPortfoliosLinkedToIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	compositions := make(map[string][]moexModel.IndexComponent)
+	drifts := make([]model.IndexDrift, 0)
+	for _, portfolio := range portfolios {
+		components, ok := compositions[portfolio.IndexID]
+		if !ok {
+			components, err = s.moexApi.GetIndexComposition(ctx, portfolio.IndexID)
+			if err != nil {
+				// один недоступный индекс не должен мешать проверке остальных
+				slog.Warn("can't get index composition", slog.String("rqID", rqID), slog.String("op", op), slog.String("indexID", portfolio.IndexID), slog.String("err", err.Error()))
+				continue
+			}
+			compositions[portfolio.IndexID] = components
+		}
+
+		stocks, err := s.repo.GetStocksFromPortfolio(ctx, portfolio.PortfolioID)
+		if err != nil {
+			return nil, err
+		}
+
+		drift := s.calculateIndexDrift(portfolio, stocks, components)
+
+		if drift.Signature == "" && portfolio.IndexDriftSignature != "" {
+			// расхождение устранено, при повторном появлении снова уведомим
+			err = s.repo.SetIndexDriftSignature(ctx, portfolio.PortfolioID, "")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if drift.Signature == "" || drift.Signature == portfolio.IndexDriftSignature {
+			continue
+		}
+
+		drifts = append(drifts, drift)
+	}
+
+	return drifts, nil
+}
+
+func (s *InvestHelperService) MarkIndexDriftNotified(ctx context.Context, portfolioID int64, signature string) error {
+	return s.repo.SetIndexDriftSignature(ctx, portfolioID, signature)
+}
+
+func (s *InvestHelperService) calculateIndexDrift(portfolio model.LinkedPortfolio, stocks []model.StockBase, components []moexModel.IndexComponent) model.IndexDrift {
+	drift := model.IndexDrift{
+		PortfolioID:   portfolio.PortfolioID,
+		PortfolioName: portfolio.PortfolioName,
+		ChatID:        portfolio.ChatID,
+		IndexID:       portfolio.IndexID,
+	}
+
+	targetWeights := make(map[string]decimal.Decimal, len(stocks))
+	for _, stock := range stocks {
+		if stock.TargetWeight.IsPositive() {
+			targetWeights[stock.Ticker] = stock.TargetWeight
+		}
+	}
+
+	indexTickers := make(map[string]struct{}, len(components))
+	for _, component := range components {
+		indexTickers[component.Ticker] = struct{}{}
+		if component.TradeDate.After(drift.TradeDate) {
+			drift.TradeDate = component.TradeDate
+		}
+
+		targetWeight, ok := targetWeights[component.Ticker]
+		if !ok {
+			drift.Added = append(drift.Added, model.IndexWeightChange{Ticker: component.Ticker, NewWeight: component.Weight})
+			continue
+		}
+
+		if component.Weight.Sub(targetWeight).Abs().GreaterThan(s.cfg.Notifications.IndexDriftThreshold) {
+			drift.Changed = append(drift.Changed, model.IndexWeightChange{Ticker: component.Ticker, OldWeight: targetWeight, NewWeight: component.Weight})
+		}
+	}
+
+	for ticker, targetWeight := range targetWeights {
+		if _, ok := indexTickers[ticker]; !ok {
+			drift.Removed = append(drift.Removed, model.IndexWeightChange{Ticker: ticker, OldWeight: targetWeight})
+		}
+	}
+
+	sortByTicker := func(a, b model.IndexWeightChange) int { return strings.Compare(a.Ticker, b.Ticker) }
+	slices.SortFunc(drift.Added, sortByTicker)
+	slices.SortFunc(drift.Removed, sortByTicker)
+	slices.SortFunc(drift.Changed, sortByTicker)
+
+	// в отпечаток попадают только тикеры: веса меняются каждый день и без этого уведомления шли бы ежедневно
+	parts := make([]string, 0, len(drift.Added)+len(drift.Removed)+len(drift.Changed))
+	for _, change := range drift.Added {
+		parts = append(parts, "+"+change.Ticker)
+	}
+	for _, change := range drift.Removed {
+		parts = append(parts, "-"+change.Ticker)
+	}
+	for _, change := range drift.Changed {
+		parts = append(parts, "~"+change.Ticker)
+	}
+	drift.Signature = strings.Join(parts, ",")
+
+	return drift
+}
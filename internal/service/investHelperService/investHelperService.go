@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
-	"slices"
 	"strconv"
 	"time"
 
@@ -24,6 +23,9 @@ type MoexApi interface {
 	GetStocInfo(ctx context.Context, ticker string) (moexModel.StockInfo, error)
 	GetStocsInfo(ctx context.Context, tickers []string) (map[string]moexModel.StockInfo, error)
 	GetAllStocsInfo(ctx context.Context) ([]moexModel.StockInfo, error)
+	GetIndexComposition(ctx context.Context, indexID string) ([]moexModel.IndexComponent, error)
+	GetPriceHistory(ctx context.Context, ticker, boardID string, from, till time.Time) ([]moexModel.PriceCandle, error)
+	GetDividends(ctx context.Context, ticker string) ([]moexModel.Dividend, error)
 }
 
 type Cache interface {
@@ -56,11 +58,17 @@ type Repository interface {
 	GetStocksFromPortfolio(ctx context.Context, portfolioID int64) (stocks []model.StockBase, err error)
 	GetOnlyInIndexStocksFromPortfolio(ctx context.Context, portfolioID int64) (stocks []model.StockBase, err error)
 	GetPageStocksFromPortfolio(ctx context.Context, portfolioID int64, limit, offset int) (stocks []model.StockBase, err error)
-	InsertStockToPortfolio(ctx context.Context, portfolioID int64, ticker string) (err error)
+	InsertStockToPortfolio(ctx context.Context, portfolioID int64, ticker, board string, instrumentType moexModel.InstrumentType) (err error)
 	DeleteStockFromPortfolio(ctx context.Context, portfolioID int64, ticker string) (err error)
 	UpdatePortfolioStock(ctx context.Context, portfolioID int64, ticker string, weight *decimal.Decimal, quantity *int) (err error)
-	InsertStockOperationToHistory(ctx context.Context, portfolioID int64, stockOperation model.StockOperation) (err error)
+	InsertStockOperationToHistory(ctx context.Context, portfolioID int64, stockOperation model.StockOperation) (operationID int64, err error)
 	GetPortfolioName(ctx context.Context, portfolioID int64) (name string, err error)
+	GetPortfolio(ctx context.Context, portfolioID int64) (portfolio model.Portfolio, err error)
+	SetPortfolioIndex(ctx context.Context, portfolioID int64, indexID *string) (err error)
+	GetPortfoliosLinkedToIndex(ctx context.Context) (portfolios []model.LinkedPortfolio, err error)
+	SetIndexDriftSignature(ctx context.Context, portfolioID int64, signature string) (err error)
+	GetPriceHistoryStates(ctx context.Context) (states []model.PriceHistoryState, err error)
+	UpsertPriceHistory(ctx context.Context, candles []moexModel.PriceCandle) (err error)
 	GetPortfolios(ctx context.Context, chatID int64, limit, offset int) (portfolios []model.Portfolio, hasNextPage bool, err error)
 	RebalanceWeights(ctx context.Context, portfolioID int64) (err error)
 	DeletePortfolio(ctx context.Context, portfolioID int64) (err error)
@@ -68,7 +76,7 @@ type Repository interface {
 	GetAllStockOperationsByUserID(ctx context.Context, userID int64) (stockOperationsByPortfolios map[int64][]model.StockOperation, err error)
 	GetAllPortfolioNamesByUserID(ctx context.Context, userID int64) (portfolioNames map[int64]string, err error)
 	UpdateQuantityPortfolioStocks(ctx context.Context, portfolioID int64, stocks []model.StockOperation) (err error)
-	InsertStockOperationsToHistory(ctx context.Context, portfolioID int64, stockOperation []model.StockOperation) (err error)
+	InsertStockOperationsToHistory(ctx context.Context, portfolioID int64, stockOperation []model.StockOperation) (operationIDs []int64, err error)
 	GetStockRemainingsForUpdate(ctx context.Context, portfolioID int64, ticker string) (stockRemainings []model.StockRemaining, err error)
 	DecreaseStockRemaining(ctx context.Context, rowID int64, quantity int) (err error)
 	DeleteStockRemainings(ctx context.Context, rowIDs ...int64) (err error)
@@ -76,35 +84,111 @@ type Repository interface {
 	GetAverageStockPurchasePrice(ctx context.Context, portfolioID int64, ticker string) (avgPrice decimal.Decimal, err error)
 	GetAverageStockPurchasePrices(ctx context.Context, portfolioID int64, tickers ...string) (avgPrices map[string]decimal.Decimal, err error)
 	InsertStockRemainings(ctx context.Context, portfolioID int64, stockRemainings []model.StockRemaining) (err error)
+	InsertStocksToPortfolio(ctx context.Context, portfolioID int64, stocks []model.StockBase) (err error)
+	SetPortfolioWeights(ctx context.Context, portfolioID int64, weights map[string]decimal.Decimal) (err error)
+	InsertRealizedLots(ctx context.Context, portfolioID int64, lots []model.RealizedLot) (err error)
+	GetRealizedPnL(ctx context.Context, portfolioID int64) (pnl []model.TickerRealizedPnL, err error)
+	GetUserRealizedLots(ctx context.Context, portfolioID int64) (lots []model.RealizedLot, err error)
+	GetStockRemainings(ctx context.Context, portfolioID int64) (stockRemainings []model.StockRemaining, err error)
+	UpsertDividends(ctx context.Context, dividends []moexModel.Dividend) (err error)
+	GetDividends(ctx context.Context, ticker string) (dividends []moexModel.Dividend, err error)
+	GetDividendAccruals(ctx context.Context, duplicateWindowDays int) (accruals []model.DividendIncome, err error)
+	InsertDividendIncomes(ctx context.Context, incomes []model.DividendIncome) (err error)
+	GetDividendIncome(ctx context.Context, portfolioID int64, ticker string) (incomes []model.DividendIncome, err error)
+	GetUpcomingDividends(ctx context.Context, until time.Time) (dividends []model.UpcomingDividend, err error)
+	MarkDividendNotified(ctx context.Context, portfolioID int64, ticker string, registryCloseDate time.Time) (err error)
+	SetDividendNotifications(ctx context.Context, portfolioID int64, enabled bool) (err error)
+	InsertCashOperations(ctx context.Context, portfolioID int64, operations []model.CashOperation) (err error)
+	GetCashBalance(ctx context.Context, portfolioID int64) (balance decimal.Decimal, err error)
+	GetCashOperations(ctx context.Context, portfolioID int64, limit int) (operations []model.CashOperation, err error)
+	SetCommissionPercent(ctx context.Context, portfolioID int64, percent decimal.Decimal) (err error)
+	SetPurchaseStrategy(ctx context.Context, portfolioID int64, strategy model.PurchaseStrategy) (err error)
+	SetAllowSells(ctx context.Context, portfolioID int64, allowed bool) (err error)
+	GetAllStocks(ctx context.Context) (stocksByPortfolios map[int64][]model.StockBase, err error)
+	SavePortfolioSnapshot(ctx context.Context, snapshot model.PortfolioSnapshot) (err error)
+	GetPortfolioSnapshotOnDate(ctx context.Context, portfolioID int64, date time.Time) (snapshot model.PortfolioSnapshot, err error)
+	GetPortfolioSnapshots(ctx context.Context, portfolioID int64) (snapshots []model.PortfolioSnapshot, err error)
+	GetStockOperations(ctx context.Context, portfolioID int64) (stockOperations []model.StockOperation, err error)
+	LockPortfolio(ctx context.Context, portfolioID int64) (err error)
+	GetStockOperationsPage(ctx context.Context, portfolioID int64, limit, offset int) (stockOperations []model.StockOperation, hasNextPage bool, err error)
+	GetStockOperation(ctx context.Context, portfolioID, operationID int64) (stockOperation model.StockOperation, err error)
+	UpdateStockOperation(ctx context.Context, portfolioID int64, stockOperation model.StockOperation) (err error)
+	DeleteStockOperation(ctx context.Context, portfolioID, operationID int64) (err error)
+	DeletePortfolioStockRemainings(ctx context.Context, portfolioID int64) (err error)
+	DeletePortfolioRealizedLots(ctx context.Context, portfolioID int64) (err error)
+	SetPortfolioStockQuantities(ctx context.Context, portfolioID int64, quantities map[string]int) (err error)
+	DeleteTradeCashOperations(ctx context.Context, portfolioID int64) (operationIDs []int64, err error)
+	GetAllCashOperations(ctx context.Context, portfolioID int64) (operations []model.CashOperation, err error)
+	GetLastStockOperationDate(ctx context.Context, portfolioID int64) (dtCreate time.Time, err error)
+	DeletePortfolioStockOperations(ctx context.Context, portfolioID int64) (err error)
+	GetPortfoliosWithTicker(ctx context.Context, ticker string) (portfolioIDs []int64, err error)
+	RenamePortfolioStock(ctx context.Context, portfolioID int64, ticker, newTicker, board string, instrumentType moexModel.InstrumentType) (err error)
+	InsertCorporateAction(ctx context.Context, action model.CorporateAction, portfoliosCnt int) (err error)
+	GetLastEnteredStockOperations(ctx context.Context, chatID int64) (lastOperations []model.LastOperation, err error)
+	GetEnteredStockOperation(ctx context.Context, chatID, operationID int64) (enteredOperation model.LastOperation, err error)
+	GetContributionAllocations(ctx context.Context, userID int64) (allocations []model.ContributionAllocation, err error)
+	SetContributionAllocations(ctx context.Context, userID int64, allocations []model.ContributionAllocation) (err error)
 }
 
 type ReportGenerator interface {
 	Generate(ctx context.Context, portfolios []model.PortfolioFullInfo) (fileBytes []byte, fileExtension string, err error)
 }
 
+type ReportParser interface {
+	Parse(ctx context.Context, filename string, content []byte) (report model.BrokerReport, err error)
+}
+
+type PortfolioCsv interface {
+	EncodePositions(stocks []model.StockBase) (content []byte, err error)
+	EncodeOperations(operations []model.StockOperation) (content []byte, err error)
+	Decode(content []byte) (csvImport model.CsvImport, err error)
+}
+
+type PurchaseStrategy interface {
+	Allocate(strategy model.PurchaseStrategy, input model.PurchaseInput) (stocksToPurchase []model.StockPurchase, err error)
+	Rebalance(input model.RebalanceInput) (plan model.RebalancePlan)
+}
+
 type CloudStorageApi interface {
 	UploadFile(ctx context.Context, reader io.Reader, filename string) (downloadLink string, err error)
 }
 
 type InvestHelperService struct {
-	cfg             *config.Config
-	repo            Repository
-	cache           Cache
-	moexApi         MoexApi
-	reportGenerator ReportGenerator
-	cloudStorageApi CloudStorageApi
-	transactor      Transactor
+	cfg              *config.Config
+	repo             Repository
+	cache            Cache
+	moexApi          MoexApi
+	reportGenerator  ReportGenerator
+	reportParser     ReportParser
+	portfolioCsv     PortfolioCsv
+	purchaseStrategy PurchaseStrategy
+	cloudStorageApi  CloudStorageApi
+	transactor       Transactor
 }
 
-func New(cfg *config.Config, repo Repository, cache Cache, moexApi MoexApi, reportGenerator ReportGenerator, cloudStorageApi CloudStorageApi, transactor Transactor) *InvestHelperService {
+func New(
+	cfg *config.Config,
+	repo Repository,
+	cache Cache,
+	moexApi MoexApi,
+	reportGenerator ReportGenerator,
+	reportParser ReportParser,
+	portfolioCsv PortfolioCsv,
+	purchaseStrategy PurchaseStrategy,
+	cloudStorageApi CloudStorageApi,
+	transactor Transactor,
+) *InvestHelperService {
 	return &InvestHelperService{
-		cfg:             cfg,
-		repo:            repo,
-		cache:           cache,
-		moexApi:         moexApi,
-		reportGenerator: reportGenerator,
-		cloudStorageApi: cloudStorageApi,
-		transactor:      transactor,
+		cfg:              cfg,
+		repo:             repo,
+		cache:            cache,
+		moexApi:          moexApi,
+		reportGenerator:  reportGenerator,
+		reportParser:     reportParser,
+		portfolioCsv:     portfolioCsv,
+		purchaseStrategy: purchaseStrategy,
+		cloudStorageApi:  cloudStorageApi,
+		transactor:       transactor,
 	}
 }
 
@@ -179,7 +263,7 @@ func (s *InvestHelperService) getPortfolioStocksForPage(ctx context.Context, por
 		return nil, err
 	}
 
-	stocks, err = s.enrichStocks(ctx, stocksDb, portfolioBalance, nil, portfolioID)
+	stocks, err = s.enrichStocks(ctx, stocksDb, portfolioBalance, nil, nil, portfolioID)
 	if err != nil {
 		return nil, err
 	}
@@ -242,7 +326,7 @@ func (s *InvestHelperService) GetStockInfo(ctx context.Context, ticker string) (
 
 	stockInfo, err = s.cache.GetStockInfo(ctx, ticker)
 	if err == nil {
-		return stockInfo, nil
+		return s.valueBond(stockInfo), nil
 	}
 
 	slog.Warn("can't get stock info from cache", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
@@ -257,7 +341,7 @@ func (s *InvestHelperService) GetStockInfo(ctx context.Context, ticker string) (
 		return moexModel.StockInfo{}, err
 	}
 
-	return stockInfo, nil
+	return s.valueBond(stockInfo), nil
 }
 
 func (s *InvestHelperService) getStocksInfo(ctx context.Context, tickers []string) (map[string]moexModel.StockInfo, error) {
@@ -267,7 +351,7 @@ func (s *InvestHelperService) getStocksInfo(ctx context.Context, tickers []strin
 	stocksInfoMap, err := s.cache.GetStocksInfo(ctx, tickers)
 	if err == nil {
 		slog.Debug("got stocksInfoMap from cache", slog.String("rqID", rqID), slog.String("op", op), slog.Any("stocksInfoMap", stocksInfoMap))
-		return stocksInfoMap, nil
+		return s.valueBonds(stocksInfoMap), nil
 	}
 
 	slog.Warn("can't get stocks info from cache", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
@@ -279,14 +363,42 @@ func (s *InvestHelperService) getStocksInfo(ctx context.Context, tickers []strin
 	}
 	slog.Debug("got stocksInfoMap from moexApi", slog.String("rqID", rqID), slog.String("op", op), slog.Any("stocksInfoMap", stocksInfoMap))
 
-	return stocksInfoMap, nil
+	return s.valueBonds(stocksInfoMap), nil
+}
+
+// valueBond оценивает облигацию в рублях: чистая цена (% от номинала) * номинал + НКД.
+// После этого облигация участвует в расчетах портфеля так же, как и акция.
+func (s *InvestHelperService) valueBond(stockInfo moexModel.StockInfo) moexModel.StockInfo {
+	if stockInfo.InstrumentType != moexModel.InstrumentTypeBond || stockInfo.Bond == nil || stockInfo.Bond.CleanPrice.IsZero() {
+		return stockInfo
+	}
+
+	stockInfo.Price = stockInfo.Bond.CleanPrice.
+		Mul(stockInfo.Bond.FaceValue).
+		Div(decimal.NewFromInt(100)).
+		Add(stockInfo.Bond.AccruedInt)
+
+	return stockInfo
+}
+
+func (s *InvestHelperService) valueBonds(stocksInfoMap map[string]moexModel.StockInfo) map[string]moexModel.StockInfo {
+	for ticker, stockInfo := range stocksInfoMap {
+		stocksInfoMap[ticker] = s.valueBond(stockInfo)
+	}
+	return stocksInfoMap
 }
 
 func (s *InvestHelperService) addStockToPortfolio(ctx context.Context, ticker string, portfolioID, chatID int64) error {
 	rqID := utils.GetRequestIDFromCtx(ctx)
 	op := "InvestHelperService.addStockToPortfolio"
 
-	err := s.repo.InsertStockToPortfolio(ctx, portfolioID, ticker)
+	// режим торгов и тип инструмента сохраняем вместе с акцией
+	stockInfo, err := s.GetStockInfo(ctx, ticker)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.InsertStockToPortfolio(ctx, portfolioID, ticker, stockInfo.Board, stockInfo.InstrumentType)
 	if err != nil {
 		if errors.Is(err, repository.ErrAlreadyExists) {
 			return nil
@@ -342,14 +454,32 @@ func (s *InvestHelperService) GetPortfolioSummaryInfo(ctx context.Context, portf
 	slog.Debug("got stocks from DB", slog.String("rqID", rqID), slog.String("op", op), slog.Any("stocks", stocks))
 
 	if len(stocks) == 0 { // пока в портфель не добавлено акций
-		name, err := s.repo.GetPortfolioName(ctx, portfolioID)
+		portfolio, err := s.repo.GetPortfolio(ctx, portfolioID)
 		if err != nil {
-			slog.Warn("got error from repo.GetPortfolioName", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+			slog.Warn("got error from repo.GetPortfolio", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
 		}
 
-		summary.PortfolioName = name
+		summary.Portfolio = portfolio
 		summary.PortfolioID = portfolioID
 
+		// акций может не остаться после продажи всех позиций, а реализованный результат при этом есть
+		summary.RealizedPnL, err = s.getRealizedPnL(ctx, portfolioID)
+		if err != nil {
+			slog.Warn("can't get realized pnl", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		}
+
+		dividendIncome, err := s.repo.GetDividendIncome(ctx, portfolioID, "")
+		if err != nil {
+			slog.Warn("can't get dividend income", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		}
+		s.applyDividendIncome(&summary, dividendIncome)
+
+		cashBalance, err := s.repo.GetCashBalance(ctx, portfolioID)
+		if err != nil {
+			return model.PortfolioSummary{}, err
+		}
+		s.applyCashBalance(&summary, cashBalance)
+
 		go s.cache.SetPortfolioSummary(context.WithoutCancel(ctx), portfolioID, summary)
 
 		return summary, nil
@@ -366,22 +496,48 @@ func (s *InvestHelperService) GetPortfolioSummaryInfo(ctx context.Context, portf
 		return model.PortfolioSummary{}, err
 	}
 
-	summary, err = s.calculatePortfolioSummary(ctx, portfolioID, stocks, stocksInfoMap, nil)
+	summary, err = s.calculatePortfolioSummary(ctx, portfolioID, stocks, stocksInfoMap, nil, nil)
 	if err != nil {
 		return model.PortfolioSummary{}, err
 	}
 
+	dividendIncome, err := s.repo.GetDividendIncome(ctx, portfolioID, "")
+	if err != nil {
+		slog.Warn("can't get dividend income", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+	}
+	s.applyDividendIncome(&summary, dividendIncome)
+
+	cashBalance, err := s.repo.GetCashBalance(ctx, portfolioID)
+	if err != nil {
+		return model.PortfolioSummary{}, err
+	}
+	s.applyCashBalance(&summary, cashBalance)
+
+	// доходность не критична для экрана портфеля, при ошибке показываем сводку без нее
+	summary.Returns, err = s.getPortfolioReturns(ctx, portfolioID, summary.BalanceInsideIndex.Add(summary.BalanceOutsideIndex), dividendIncome)
+	if err != nil {
+		slog.Warn("can't calculate portfolio returns", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+	}
+
+	summary.RealizedPnL, err = s.getRealizedPnL(ctx, portfolioID)
+	if err != nil {
+		slog.Warn("can't get realized pnl", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+	}
+
 	// сохраняем в кэш
 	go s.cache.SetPortfolioSummary(context.WithoutCancel(ctx), portfolioID, summary)
 
 	return summary, nil
 }
 
+// calculatePortfolioSummary считает сводку по бумагам портфеля. Можно передать nil avgPrices, чтобы подгрузить
+// средние цены покупки, либо передать уже свою map. С переданными avgPrices и portfolioName хранилище не читается.
 func (s *InvestHelperService) calculatePortfolioSummary(
 	ctx context.Context,
 	PortfolioID int64,
 	stocks []model.StockBase,
 	stocksInfoMap map[string]moexModel.StockInfo,
+	avgPrices map[string]decimal.Decimal,
 	portfolioName *string,
 ) (summary model.PortfolioSummary, err error) {
 	rqID := utils.GetRequestIDFromCtx(ctx)
@@ -396,9 +552,11 @@ func (s *InvestHelperService) calculatePortfolioSummary(
 		tickers = append(tickers, stock.Ticker)
 	}
 
-	avgPrices, err := s.getStockAvgPrices(ctx, PortfolioID, tickers...)
-	if err != nil {
-		return model.PortfolioSummary{}, fmt.Errorf("can't get avg prices: %w", err)
+	if avgPrices == nil {
+		avgPrices, err = s.getStockAvgPrices(ctx, PortfolioID, tickers...)
+		if err != nil {
+			return model.PortfolioSummary{}, fmt.Errorf("can't get avg prices: %w", err)
+		}
 	}
 
 	for _, stock := range stocks {
@@ -454,11 +612,16 @@ func (s *InvestHelperService) calculatePortfolioSummary(
 	if portfolioName != nil {
 		summary.PortfolioName = *portfolioName
 	} else {
-		name, err := s.repo.GetPortfolioName(ctx, PortfolioID)
+		portfolio, err := s.repo.GetPortfolio(ctx, PortfolioID)
 		if err != nil {
-			slog.Warn("got error from repo.GetPortfolioName", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+			slog.Warn("got error from repo.GetPortfolio", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
 		}
-		summary.PortfolioName = name
+		summary.PortfolioName = portfolio.PortfolioName
+		summary.IndexID = portfolio.IndexID
+		summary.DividendNotifications = portfolio.DividendNotifications
+		summary.CommissionPercent = portfolio.CommissionPercent
+		summary.PurchaseStrategy = portfolio.PurchaseStrategy
+		summary.AllowSells = portfolio.AllowSells
 	}
 
 	return summary, nil
@@ -516,12 +679,18 @@ func (s *InvestHelperService) GetPortfolioStockInfo(ctx context.Context, ticker
 		AvgPrice:      avgPrice,
 		GrowthPercent: s.calculateGrowthPercent(avgPrice, stockInfo.Price),
 		GrowthSum:     s.calculateGrowthSum(avgPrice, stockInfo.Price, stockDB.Quantity),
+		Bond:          stockInfo.Bond,
 	}
 
 	if !portfolioSummary.BalanceInsideIndex.IsZero() {
 		stock.ActualWeight = stock.TotalPrice.Div(portfolioSummary.BalanceInsideIndex).Mul(decimal.NewFromInt(100))
 	}
 
+	stock.Dividends, err = s.getStockDividends(ctx, portfolioID, ticker, stockInfo.Price)
+	if err != nil {
+		slog.Warn("can't get stock dividends", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+	}
+
 	// в конце сохранить в кэш
 	go s.cache.SetPortfolioStock(context.WithoutCancel(ctx), portfolioID, stock)
 
@@ -562,12 +731,11 @@ func (s *InvestHelperService) SaveStockChangesToPortfolio(
 	ctx context.Context,
 	portfolioID int64,
 	ticker string,
-	weight *decimal.Decimal,
-	quantity *int,
-	price *decimal.Decimal,
-) (model.Stock, error) {
+	changes model.StockChanges,
+) (stock model.Stock, operationID int64, err error) {
 	rqID := utils.GetRequestIDFromCtx(ctx)
 	op := "InvestHelperService.SaveStockChangesToPortfolio"
+	weight, quantity, price := changes.NewTargetWeight, changes.Quantity, changes.CustomPrice
 
 	slog.Debug("SaveStockChangesToPortfolio start", slog.String("rqID", rqID), slog.String("op", op), slog.String("ticker", ticker))
 	defer func() {
@@ -575,25 +743,31 @@ func (s *InvestHelperService) SaveStockChangesToPortfolio(
 	}()
 
 	if quantity == nil { // если было только изменение веса
-		err := s.repo.UpdatePortfolioStock(ctx, portfolioID, ticker, weight, quantity)
+		err = s.repo.UpdatePortfolioStock(ctx, portfolioID, ticker, weight, quantity)
 		if err != nil {
-			return model.Stock{}, err
+			return model.Stock{}, 0, err
 		}
 		_ = s.cache.FlushPortfolioCache(ctx, portfolioID) // вызываем синхронно, так как конкурентно может не успеть удалиться и получим старую инфу
 
-		return s.GetPortfolioStockInfo(ctx, ticker, portfolioID)
+		stock, err = s.GetPortfolioStockInfo(ctx, ticker, portfolioID)
+		return stock, 0, err
 	}
 
 	// если была покупка/продажа
 	stockInfo, err := s.GetStockInfo(ctx, ticker)
 	if err != nil {
-		return model.Stock{}, err
+		return model.Stock{}, 0, err
 	}
 
 	if price == nil { // если не передали кастомный price - используем актульный
 		price = &stockInfo.Price
 	}
 
+	tradeDate := time.Now()
+	if changes.TradeDate != nil {
+		tradeDate = *changes.TradeDate
+	}
+
 	stockOperation := model.StockOperation{
 		Ticker:     stockInfo.Ticker,
 		Shortname:  stockInfo.Shortname,
@@ -601,63 +775,65 @@ func (s *InvestHelperService) SaveStockChangesToPortfolio(
 		Price:      *price,
 		TotalPrice: price.Mul(decimal.NewFromInt(int64(*quantity))),
 		Currency:   stockInfo.CurrencyID,
-		DtCreate:   time.Now(),
+		DtCreate:   tradeDate,
+	}
+
+	if changes.Commission != nil {
+		stockOperation.Commission = *changes.Commission
+	} else {
+		portfolio, err := s.repo.GetPortfolio(ctx, portfolioID)
+		if err != nil {
+			return model.Stock{}, 0, err
+		}
+		stockOperation.Commission = calculateCommission(stockOperation.TotalPrice, portfolio.CommissionPercent)
+	}
+
+	// сделка задним числом, раньше уже записанных: лоты, реализованный результат и деньги пересобираются по всей истории
+	backdated := false
+	if changes.TradeDate != nil {
+		lastOperationDate, err := s.repo.GetLastStockOperationDate(ctx, portfolioID)
+		if err != nil {
+			return model.Stock{}, 0, err
+		}
+		backdated = tradeDate.Before(lastOperationDate)
 	}
 
 	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
-		err = s.repo.UpdatePortfolioStock(ctx, portfolioID, ticker, weight, quantity)
+		if backdated {
+			err = s.repo.UpdatePortfolioStock(ctx, portfolioID, ticker, weight, nil)
+		} else {
+			err = s.repo.UpdatePortfolioStock(ctx, portfolioID, ticker, weight, quantity)
+		}
+		if err != nil {
+			return err
+		}
+
+		stockOperation.OperationID, err = s.repo.InsertStockOperationToHistory(ctx, portfolioID, stockOperation)
 		if err != nil {
 			return err
 		}
 
-		err = s.repo.InsertStockOperationToHistory(ctx, portfolioID, stockOperation)
+		err = s.recordTradesCash(ctx, portfolioID, []model.StockOperation{stockOperation})
 		if err != nil {
 			return err
 		}
 
+		if backdated {
+			return s.replayPortfolioHistory(ctx, portfolioID)
+		}
+
 		if *quantity < 0 { // продажа
-			stockRemainings, err := s.repo.GetStockRemainingsForUpdate(ctx, portfolioID, ticker)
+			err = s.sellStockRemainings(ctx, portfolioID, stockOperation)
 			if err != nil {
 				return err
 			}
-
-			rowsToDelete := make([]int64, 0)
-			sellQuantity := *quantity * -1 // make it positive to convenient calculation
-			for _, stockRemaining := range stockRemainings {
-				if sellQuantity <= 0 {
-					break
-				}
-
-				if sellQuantity-stockRemaining.Quantity >= 0 {
-					rowsToDelete = append(rowsToDelete, stockRemaining.RowID)
-					sellQuantity -= stockRemaining.Quantity
-				} else {
-					err = s.repo.DecreaseStockRemaining(ctx, stockRemaining.RowID, sellQuantity)
-					if err != nil {
-						return err
-					}
-					sellQuantity = 0
-					break
-				}
-			}
-
-			if sellQuantity > 0 {
-				return fmt.Errorf("not enough stock remainings")
-			}
-
-			if len(rowsToDelete) > 0 {
-				err = s.repo.DeleteStockRemainings(ctx, rowsToDelete...)
-				if err != nil {
-					return err
-				}
-			}
 		} else { // покупка
 			stockRemaining := model.StockRemaining{
 				PortfolioID: portfolioID,
 				Ticker:      ticker,
 				Quantity:    *quantity,
-				Price:       *price,
-				DtCreate:    time.Now(),
+				Price:       operationUnitCost(stockOperation),
+				DtCreate:    stockOperation.DtCreate,
 				DtUpdate:    time.Now(),
 			}
 
@@ -671,7 +847,13 @@ func (s *InvestHelperService) SaveStockChangesToPortfolio(
 	})
 
 	if err != nil {
-		return model.Stock{}, err
+		return model.Stock{}, 0, err
+	}
+
+	if backdated {
+		s.refreshPortfolioAfterReplay(ctx, portfolioID)
+		stock, err = s.GetPortfolioStockInfo(ctx, ticker, portfolioID)
+		return stock, stockOperation.OperationID, err
 	}
 
 	avgPrice, err := s.repo.GetAverageStockPurchasePrice(ctx, portfolioID, ticker)
@@ -681,7 +863,78 @@ func (s *InvestHelperService) SaveStockChangesToPortfolio(
 
 	_ = s.cache.FlushPortfolioCache(ctx, portfolioID) // вызываем синхронно, так как конкурентно может не успеть удалиться и получим старую инфу
 
-	return s.GetPortfolioStockInfo(ctx, ticker, portfolioID)
+	stock, err = s.GetPortfolioStockInfo(ctx, ticker, portfolioID)
+	return stock, stockOperation.OperationID, err
+}
+
+// sellStockRemainings списывает проданные бумаги из лотов по FIFO и фиксирует реализованный результат по списанным лотам.
+// Должен вызываться внутри транзакции.
+func (s *InvestHelperService) sellStockRemainings(ctx context.Context, portfolioID int64, stockOperation model.StockOperation) error {
+	consumedLots, err := s.consumeStockRemainings(ctx, portfolioID, stockOperation.Ticker, stockOperation.Quantity*-1)
+	if err != nil {
+		return err
+	}
+
+	realizedLots := make([]model.RealizedLot, 0, len(consumedLots))
+	for _, lot := range consumedLots {
+		realizedLots = append(realizedLots, model.RealizedLot{
+			PortfolioID: portfolioID,
+			Ticker:      stockOperation.Ticker,
+			Quantity:    lot.Quantity,
+			BuyPrice:    lot.Price,
+			SellPrice:   operationUnitCost(stockOperation),
+			BuyDate:     lot.DtCreate,
+			SellDate:    stockOperation.DtCreate,
+		})
+	}
+
+	return s.repo.InsertRealizedLots(ctx, portfolioID, realizedLots)
+}
+
+// consumeStockRemainings списывает sellQuantity акций из лотов по FIFO и возвращает списанные части лотов
+// (Quantity в возвращаемых лотах - сколько списано из лота, Price и DtCreate - цена и дата покупки).
+// Должен вызываться внутри транзакции.
+func (s *InvestHelperService) consumeStockRemainings(ctx context.Context, portfolioID int64, ticker string, sellQuantity int) ([]model.StockRemaining, error) {
+	stockRemainings, err := s.repo.GetStockRemainingsForUpdate(ctx, portfolioID, ticker)
+	if err != nil {
+		return nil, err
+	}
+
+	consumed := make([]model.StockRemaining, 0)
+	rowsToDelete := make([]int64, 0)
+	for _, stockRemaining := range stockRemainings {
+		if sellQuantity <= 0 {
+			break
+		}
+
+		if sellQuantity-stockRemaining.Quantity >= 0 {
+			rowsToDelete = append(rowsToDelete, stockRemaining.RowID)
+			sellQuantity -= stockRemaining.Quantity
+			consumed = append(consumed, stockRemaining)
+		} else {
+			err = s.repo.DecreaseStockRemaining(ctx, stockRemaining.RowID, sellQuantity)
+			if err != nil {
+				return nil, err
+			}
+			stockRemaining.Quantity = sellQuantity
+			consumed = append(consumed, stockRemaining)
+			sellQuantity = 0
+			break
+		}
+	}
+
+	if sellQuantity > 0 {
+		return nil, fmt.Errorf("not enough stock remainings")
+	}
+
+	if len(rowsToDelete) > 0 {
+		err = s.repo.DeleteStockRemainings(ctx, rowsToDelete...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return consumed, nil
 }
 
 func (s *InvestHelperService) deleteStockFromPortfolio(ctx context.Context, portfolioID int64, ticker string) error {
@@ -719,11 +972,13 @@ func (s *InvestHelperService) DeleteStockFromPortfolio(ctx context.Context, port
 }
 
 // enrichStocks обогащает акции актуальной инфой. Можно передать nil stocksInfoMap, чтобы подгрузить инфу по акциям, либо передать уже свою map.
+// Так же и с avgPrices - средними ценами покупки.
 func (s *InvestHelperService) enrichStocks(
 	ctx context.Context,
 	stocksDb []model.StockBase,
 	portfolioBalance decimal.Decimal,
 	stocksInfoMap map[string]moexModel.StockInfo,
+	avgPrices map[string]decimal.Decimal,
 	portfolioID int64,
 ) (stocks []model.Stock, err error) {
 	rqID := utils.GetRequestIDFromCtx(ctx)
@@ -745,9 +1000,11 @@ func (s *InvestHelperService) enrichStocks(
 		}
 	}
 
-	avgPrices, err := s.getStockAvgPrices(ctx, portfolioID, tickers...)
-	if err != nil {
-		return nil, fmt.Errorf("failed on getting avg prices: %w", err)
+	if avgPrices == nil {
+		avgPrices, err = s.getStockAvgPrices(ctx, portfolioID, tickers...)
+		if err != nil {
+			return nil, fmt.Errorf("failed on getting avg prices: %w", err)
+		}
 	}
 
 	stocks = make([]model.Stock, 0, len(stocksDb))
@@ -768,6 +1025,7 @@ func (s *InvestHelperService) enrichStocks(
 			AvgPrice:      avgPrice,
 			GrowthPercent: s.calculateGrowthPercent(avgPrice, stockInfo.Price),
 			GrowthSum:     s.calculateGrowthSum(avgPrice, stockInfo.Price, stockDb.Quantity),
+			Bond:          stockInfo.Bond,
 		}
 
 		if !portfolioBalance.IsZero() {
@@ -802,7 +1060,13 @@ func (s *InvestHelperService) getStockAvgPrices(ctx context.Context, portfolioID
 	return avgPrices, nil
 }
 
-func (s *InvestHelperService) CalculatePurchase(ctx context.Context, portfolioID int64, purchaseSum decimal.Decimal) ([]model.StockPurchase, error) {
+// CalculatePurchase распределяет сумму закупа по бумагам портфеля стратегией портфеля с учетом ограничений
+func (s *InvestHelperService) CalculatePurchase(
+	ctx context.Context,
+	portfolioID int64,
+	purchaseSum decimal.Decimal,
+	constraints model.PurchaseConstraints,
+) ([]model.StockPurchase, error) {
 	rqID := utils.GetRequestIDFromCtx(ctx)
 	op := "InvestHelperService.CalculatePurchase"
 
@@ -811,119 +1075,64 @@ func (s *InvestHelperService) CalculatePurchase(ctx context.Context, portfolioID
 		slog.Debug("CalculatePurchase finished", slog.String("rqID", rqID), slog.String("op", op), slog.String("purchaseSum", purchaseSum.StringFixed(2)), slog.Int64("portfolioID", portfolioID))
 	}()
 
-	// получить из БД акции где вес > 0
-	stocksDb, err := s.repo.GetOnlyInIndexStocksFromPortfolio(ctx, portfolioID)
+	input, err := s.getPurchaseInput(ctx, portfolioID, purchaseSum)
 	if err != nil {
-		slog.Error("got error from repo.GetOnlyInIndexStocksFromPortfolio", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
 		return nil, err
 	}
 
-	if len(stocksDb) == 0 {
+	if len(input.Stocks) == 0 {
 		return []model.StockPurchase{}, nil
 	}
 
-	// получить баланс портфеля
-	portfolioSummary, err := s.GetPortfolioSummaryInfo(ctx, portfolioID)
+	strategy, err := s.GetPurchaseStrategy(ctx, portfolioID)
 	if err != nil {
 		return nil, err
 	}
 
-	stocks, err := s.enrichStocks(ctx, stocksDb, portfolioSummary.BalanceInsideIndex, nil, portfolioID)
+	input.Constraints = constraints
+	stocksToPurchase, err := s.purchaseStrategy.Allocate(strategy, input)
 	if err != nil {
+		slog.Error("got error from purchaseStrategy.Allocate", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
 		return nil, err
 	}
 
-	// сортируем список акций по убыванию цены лота
-	slices.SortFunc(stocks, func(a, b model.Stock) int {
-		aLotPrice := a.Price.Mul(decimal.NewFromInt(int64(a.Lotsize)))
-		bLotPrice := b.Price.Mul(decimal.NewFromInt(int64(b.Lotsize)))
-
-		switch {
-		case aLotPrice.LessThan(bLotPrice):
-			return 1
-		case aLotPrice.GreaterThan(bLotPrice):
-			return -1
-		default:
-			return 0
-		}
-	})
-
-	// либо сортируем список акций по убыванию недокупленности относительно портфеля
-	// slices.SortFunc(stocks, func(a, b model.Stock) int {
-	// 	aWeightDiffrence := a.TargetWeight.Sub(a.ActualWeight)
-	// 	bWeightDiffrence := b.TargetWeight.Sub(b.ActualWeight)
-
-	// 	switch {
-	// 	case aWeightDiffrence.LessThan(bWeightDiffrence):
-	// 		return 1
-	// 	case aWeightDiffrence.GreaterThan(bWeightDiffrence):
-	// 		return -1
-	// 	default:
-	// 		return 0
-	// 	}
-	// })
-
-	// итерируемся и заполняем stocksPurchase сначала целыми лотами и считаем общую сумму покупки целых лотов
-	stocksToPurchase := make([]model.StockPurchase, 0, len(stocks))
-	purchaseRemainder := purchaseSum
-	for _, stock := range stocks {
-		needToBuySum := portfolioSummary.BalanceInsideIndex.
-			Add(purchaseSum).
-			Mul(stock.TargetWeight).
-			Div(decimal.NewFromInt(100)).
-			Sub(stock.TotalPrice)
-
-		if needToBuySum.LessThanOrEqual(decimal.NewFromInt(0)) {
-			continue
-		}
-
-		lotPrice := stock.Price.Mul(decimal.NewFromInt(int64(stock.Lotsize)))
-		if lotPrice.LessThanOrEqual(decimal.NewFromInt(0)) {
-			continue
-		}
+	slog.Info("result for purchase", slog.String("strategy", string(strategy)), slog.Any("constraints", constraints), slog.Any("purchaseStocks", stocksToPurchase))
 
-		if purchaseRemainder.LessThan(needToBuySum) {
-			needToBuySum = purchaseRemainder
-		}
+	return stocksToPurchase, nil
+}
 
-		lotsToBuy := needToBuySum.Div(lotPrice)
-		wholeLots := lotsToBuy.IntPart()
-		if wholeLots <= 0 {
-			continue
-		}
+// getPurchaseInput собирает бумаги портфеля с ненулевым весом с актуальными ценами и стоимость бумаг внутри индекса
+func (s *InvestHelperService) getPurchaseInput(ctx context.Context, portfolioID int64, purchaseSum decimal.Decimal) (model.PurchaseInput, error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.getPurchaseInput"
 
-		stockToPurchase := model.StockPurchase{
-			Ticker:       stock.Ticker,
-			Shortname:    stock.Shortname,
-			LotSize:      stock.Lotsize,
-			LotsQuantity: lotsToBuy,
-			StockPrice:   stock.Price,
-		}
-		stocksToPurchase = append(stocksToPurchase, stockToPurchase)
-		purchaseRemainder = purchaseRemainder.Sub(stock.Price.Mul(decimal.NewFromInt(wholeLots * int64(stock.Lotsize))))
+	// получить из БД акции где вес > 0
+	stocksDb, err := s.repo.GetOnlyInIndexStocksFromPortfolio(ctx, portfolioID)
+	if err != nil {
+		slog.Error("got error from repo.GetOnlyInIndexStocksFromPortfolio", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return model.PurchaseInput{}, err
 	}
 
-	// теперь зная остаток средств после покупки целых лотов, итерируемся еще раз и считаем докупку остаточной части лотов (округляя математически)
-	for i := range stocksToPurchase {
-		purchaseStock := &stocksToPurchase[i]
-		// округляем к целой части и проверяем в какую сторону округлилось
-		if purchaseStock.LotsQuantity.Round(0).LessThanOrEqual(purchaseStock.LotsQuantity) {
-			continue
-		}
-
-		// добавить еще +1 лот к покупке, если хватает остатка средств
-		lotPrice := purchaseStock.StockPrice.Mul(decimal.NewFromInt(int64(purchaseStock.LotSize)))
-		if purchaseRemainder.LessThan(lotPrice) {
-			continue
-		}
+	if len(stocksDb) == 0 {
+		return model.PurchaseInput{PurchaseSum: purchaseSum}, nil
+	}
 
-		purchaseStock.LotsQuantity = purchaseStock.LotsQuantity.Round(0)
-		purchaseRemainder = purchaseRemainder.Sub(lotPrice)
+	// получить баланс портфеля
+	portfolioSummary, err := s.GetPortfolioSummaryInfo(ctx, portfolioID)
+	if err != nil {
+		return model.PurchaseInput{}, err
 	}
 
-	slog.Info("result for purchase", slog.Any("purchaseStocks", stocksToPurchase))
+	stocks, err := s.enrichStocks(ctx, stocksDb, portfolioSummary.BalanceInsideIndex, nil, nil, portfolioID)
+	if err != nil {
+		return model.PurchaseInput{}, err
+	}
 
-	return stocksToPurchase, nil
+	return model.PurchaseInput{
+		Stocks:      stocks,
+		Balance:     portfolioSummary.BalanceInsideIndex,
+		PurchaseSum: purchaseSum,
+	}, nil
 }
 
 func (s *InvestHelperService) GetPortfolios(ctx context.Context, chatID int64, page int) (portfolios []model.Portfolio, hasNextPage bool, err error) {
@@ -1063,13 +1272,51 @@ func (s *InvestHelperService) GeneratePortfoliosReport(ctx context.Context, chat
 	for portfolioID, portfolioStocks := range stocksByPortfolios {
 		portfolioName := portfolioNames[portfolioID]
 
-		portfolioSummary, err := s.calculatePortfolioSummary(ctx, portfolioID, portfolioStocks, stocksInfoMap, &portfolioName)
+		portfolioSummary, err := s.calculatePortfolioSummary(ctx, portfolioID, portfolioStocks, stocksInfoMap, nil, &portfolioName)
 		if err != nil {
 			slog.Error("GeneratePortfolioReport failed on calculatePortfolioSummary", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()), slog.Int64("portfolioID", portfolioID))
 			return nil, "", err
 		}
 
-		enrichedStocks, err := s.enrichStocks(ctx, portfolioStocks, portfolioSummary.BalanceInsideIndex, stocksInfoMap, portfolioID)
+		dividendIncome, err := s.repo.GetDividendIncome(ctx, portfolioID, "")
+		if err != nil {
+			slog.Error("GeneratePortfolioReport failed on repo.GetDividendIncome", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()), slog.Int64("portfolioID", portfolioID))
+			return nil, "", err
+		}
+		s.applyDividendIncome(&portfolioSummary, dividendIncome)
+
+		cashBalance, err := s.repo.GetCashBalance(ctx, portfolioID)
+		if err != nil {
+			slog.Error("GeneratePortfolioReport failed on repo.GetCashBalance", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()), slog.Int64("portfolioID", portfolioID))
+			return nil, "", err
+		}
+		s.applyCashBalance(&portfolioSummary, cashBalance)
+
+		portfolioSummary.Returns, err = s.calculatePortfolioReturns(
+			ctx,
+			portfolioID,
+			portfolioSummary.BalanceInsideIndex.Add(portfolioSummary.BalanceOutsideIndex),
+			stockOperationsByPortfolios[portfolioID],
+			dividendIncome,
+		)
+		if err != nil {
+			slog.Error("GeneratePortfolioReport failed on calculatePortfolioReturns", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()), slog.Int64("portfolioID", portfolioID))
+			return nil, "", err
+		}
+
+		portfolioSummary.RealizedPnL, err = s.getRealizedPnL(ctx, portfolioID)
+		if err != nil {
+			slog.Error("GeneratePortfolioReport failed on getRealizedPnL", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()), slog.Int64("portfolioID", portfolioID))
+			return nil, "", err
+		}
+
+		taxReports, err := s.getTaxReports(ctx, portfolioID, portfolioName)
+		if err != nil {
+			slog.Error("GeneratePortfolioReport failed on getTaxReports", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()), slog.Int64("portfolioID", portfolioID))
+			return nil, "", err
+		}
+
+		enrichedStocks, err := s.enrichStocks(ctx, portfolioStocks, portfolioSummary.BalanceInsideIndex, stocksInfoMap, nil, portfolioID)
 		if err != nil {
 			slog.Error("GeneratePortfolioReport failed on enrichStocks", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()), slog.Int64("portfolioID", portfolioID))
 			return nil, "", err
@@ -1079,6 +1326,7 @@ func (s *InvestHelperService) GeneratePortfoliosReport(ctx context.Context, chat
 			PortfolioSummary: portfolioSummary,
 			Stocks:           enrichedStocks,
 			StockOperations:  stockOperationsByPortfolios[portfolioID],
+			TaxReports:       taxReports,
 		}
 		portfoliosFullInfo = append(portfoliosFullInfo, portfolioInfo)
 	}
@@ -1113,15 +1361,59 @@ func (s *InvestHelperService) UploadFileToCloud(ctx context.Context, reader io.R
 	return downloadLink, nil
 }
 
+// ApplyCalculatedPurchaseToPortfolio записывает рассчитанный закуп или план ребалансировки как сделки по ценам расчета.
+// Продажи (отрицательный LotsQuantity) списываются из лотов по FIFO с фиксацией реализованного результата.
 func (s *InvestHelperService) ApplyCalculatedPurchaseToPortfolio(ctx context.Context, portfolioID int64, stocksToPurchase []model.StockPurchase) error {
 	rqID := utils.GetRequestIDFromCtx(ctx)
 	op := "InvestHelperService.ApplyCalculatedPurchaseToPortfolio"
 
 	slog.Debug("ApplyCalculatedPurchaseToPortfolio start", slog.String("rqID", rqID), slog.String("op", op))
 
+	portfolio, err := s.repo.GetPortfolio(ctx, portfolioID)
+	if err != nil {
+		return err
+	}
+
+	stockOperations, stockRemainings := purchaseOperations(portfolioID, portfolio.CommissionPercent, stocksToPurchase)
+	tickers := make([]string, 0, len(stocksToPurchase))
+	for _, stockPurchase := range stocksToPurchase {
+		tickers = append(tickers, stockPurchase.Ticker)
+	}
+
+	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		return s.applyPurchaseOperations(ctx, portfolioID, stockOperations, stockRemainings)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		avgPrices, err := s.repo.GetAverageStockPurchasePrices(context.WithoutCancel(ctx), portfolioID, tickers...)
+		if err == nil {
+			avgPricesToCache := make([]model.StockAvgPrice, 0, len(stocksToPurchase))
+			for _, stock := range stocksToPurchase {
+				avgPricesToCache = append(avgPricesToCache, model.StockAvgPrice{Ticker: stock.Ticker, AvgPrice: avgPrices[stock.Ticker]})
+			}
+			_ = s.cache.SetStockAvgPrices(context.WithoutCancel(ctx), portfolioID, avgPricesToCache...)
+		}
+	}()
+
+	go s.cache.FlushPortfolioCache(context.WithoutCancel(ctx), portfolioID)
+
+	slog.Debug("ApplyCalculatedPurchaseToPortfolio completed", slog.String("rqID", rqID), slog.String("op", op))
+
+	return nil
+}
+
+// purchaseOperations готовит сделки и новые лоты по рассчитанному закупу, у продаж лоты не готовятся
+func purchaseOperations(
+	portfolioID int64,
+	commissionPercent decimal.Decimal,
+	stocksToPurchase []model.StockPurchase,
+) ([]model.StockOperation, []model.StockRemaining) {
 	stockOperations := make([]model.StockOperation, 0, len(stocksToPurchase))
 	stockRemainings := make([]model.StockRemaining, 0, len(stocksToPurchase))
-	tickers := make([]string, 0, len(stocksToPurchase))
 	for _, stockPurchase := range stocksToPurchase {
 		quantity := stockPurchase.LotsQuantity.IntPart() * int64(stockPurchase.LotSize)
 		stockOperation := model.StockOperation{
@@ -1133,58 +1425,87 @@ func (s *InvestHelperService) ApplyCalculatedPurchaseToPortfolio(ctx context.Con
 			Currency:   "RUB",
 			DtCreate:   time.Now(),
 		}
+		stockOperation.Commission = calculateCommission(stockOperation.TotalPrice, commissionPercent)
 		stockOperations = append(stockOperations, stockOperation)
 
+		if quantity < 0 { // продажа из плана ребалансировки, лоты списываются в транзакции
+			continue
+		}
+
 		stockRemaining := model.StockRemaining{
 			PortfolioID: portfolioID,
 			Ticker:      stockPurchase.Ticker,
 			Quantity:    int(quantity),
-			Price:       stockPurchase.StockPrice,
-			DtCreate:    time.Now(),
+			Price:       operationUnitCost(stockOperation),
+			DtCreate:    stockOperation.DtCreate,
 			DtUpdate:    time.Now(),
 		}
 		stockRemainings = append(stockRemainings, stockRemaining)
+	}
 
-		tickers = append(tickers, stockPurchase.Ticker)
+	return stockOperations, stockRemainings
+}
+
+// applyPurchaseOperations записывает сделки закупа в портфель: количество, историю, лоты, реализованный результат
+// и деньги. Должен вызываться внутри транзакции.
+func (s *InvestHelperService) applyPurchaseOperations(
+	ctx context.Context,
+	portfolioID int64,
+	stockOperations []model.StockOperation,
+	stockRemainings []model.StockRemaining,
+) error {
+	err := s.repo.LockPortfolio(ctx, portfolioID)
+	if err != nil {
+		return err
 	}
 
-	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
-		err := s.repo.UpdateQuantityPortfolioStocks(ctx, portfolioID, stockOperations)
-		if err != nil {
-			return err
+	// бумаги могли продать после расчета плана
+	for _, stockOperation := range stockOperations {
+		if stockOperation.Quantity >= 0 {
+			continue
 		}
-
-		err = s.repo.InsertStockOperationsToHistory(ctx, portfolioID, stockOperations)
+		stock, err := s.repo.GetStockFromPortfolio(ctx, stockOperation.Ticker, portfolioID)
 		if err != nil {
 			return err
 		}
-
-		err = s.repo.InsertStockRemainings(ctx, portfolioID, stockRemainings)
-		if err != nil {
-			return err
+		if stock.Quantity < -stockOperation.Quantity {
+			return service.ErrNotEnoughStocks
 		}
+	}
 
-		return nil
-	})
+	err = s.repo.UpdateQuantityPortfolioStocks(ctx, portfolioID, stockOperations)
+	if err != nil {
+		return err
+	}
 
+	operationIDs, err := s.repo.InsertStockOperationsToHistory(ctx, portfolioID, stockOperations)
 	if err != nil {
 		return err
 	}
+	for i := range stockOperations {
+		stockOperations[i].OperationID = operationIDs[i]
+	}
 
-	go func() {
-		avgPrices, err := s.repo.GetAverageStockPurchasePrices(context.WithoutCancel(ctx), portfolioID, tickers...)
-		if err == nil {
-			avgPricesToCache := make([]model.StockAvgPrice, 0, len(stocksToPurchase))
-			for _, stock := range stocksToPurchase {
-				avgPricesToCache = append(avgPricesToCache, model.StockAvgPrice{Ticker: stock.Ticker, AvgPrice: avgPrices[stock.Ticker]})
-			}
-			_ = s.cache.SetStockAvgPrices(context.WithoutCancel(ctx), portfolioID, avgPricesToCache...)
+	if len(stockRemainings) > 0 {
+		err = s.repo.InsertStockRemainings(ctx, portfolioID, stockRemainings)
+		if err != nil {
+			return err
 		}
-	}()
+	}
 
-	go s.cache.FlushPortfolioCache(context.WithoutCancel(ctx), portfolioID)
+	for _, stockOperation := range stockOperations {
+		if stockOperation.Quantity < 0 {
+			err = s.sellStockRemainings(ctx, portfolioID, stockOperation)
+			if err != nil {
+				return err
+			}
+		}
+	}
 
-	slog.Debug("ApplyCalculatedPurchaseToPortfolio completed", slog.String("rqID", rqID), slog.String("op", op))
+	err = s.recordTradesCash(ctx, portfolioID, stockOperations)
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -0,0 +1,633 @@
+package investHelperService
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/data/repository"
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/internal/service"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+	"github.com/shopspring/decimal"
+)
+
+func (s *InvestHelperService) GetOperationsHistoryPage(ctx context.Context, portfolioID int64, page int) (model.OperationsHistoryPage, error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.GetOperationsHistoryPage"
+
+	slog.Debug("GetOperationsHistoryPage start", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", portfolioID), slog.Int("page", page))
+
+	portfolio, err := s.repo.GetPortfolio(ctx, portfolioID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return model.OperationsHistoryPage{}, service.ErrNotFound
+		}
+		return model.OperationsHistoryPage{}, err
+	}
+
+	operations, hasNextPage, err := s.repo.GetStockOperationsPage(ctx, portfolioID, s.cfg.OperationsPerPage, (page-1)*s.cfg.OperationsPerPage)
+	if err != nil {
+		return model.OperationsHistoryPage{}, err
+	}
+
+	return model.OperationsHistoryPage{
+		PortfolioID:   portfolioID,
+		PortfolioName: portfolio.PortfolioName,
+		CurPage:       page,
+		HasNextPage:   hasNextPage,
+		Operations:    operations,
+	}, nil
+}
+
+func (s *InvestHelperService) GetStockOperation(ctx context.Context, portfolioID, operationID int64) (model.StockOperation, error) {
+	operation, err := s.repo.GetStockOperation(ctx, portfolioID, operationID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return model.StockOperation{}, service.ErrNotFound
+		}
+		return model.StockOperation{}, err
+	}
+	return operation, nil
+}
+
+// UpdateStockOperation исправляет операцию из истории и пересобирает по истории лоты, количество и деньги портфеля.
+// Если после исправления продажа превышает количество бумаг на ее дату, изменения не сохраняются (ErrInvalidOperationHistory).
+// Сделки с бумагой до ее перевода в другой портфель не исправляются (ErrOperationNotEditable).
+func (s *InvestHelperService) UpdateStockOperation(
+	ctx context.Context,
+	portfolioID, operationID int64,
+	changes model.OperationChanges,
+) (model.StockOperation, error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.UpdateStockOperation"
+
+	slog.Debug("UpdateStockOperation start", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("operationID", operationID))
+
+	var operation model.StockOperation
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		operation, err = s.repo.GetStockOperation(ctx, portfolioID, operationID)
+		if err != nil {
+			return err
+		}
+		if !operation.IsTrade() {
+			return service.ErrOperationNotEditable
+		}
+		err = s.checkNotTransferredLater(ctx, portfolioID, operation)
+		if err != nil {
+			return err
+		}
+
+		if changes.Quantity != nil {
+			if operation.Quantity < 0 {
+				operation.Quantity = -*changes.Quantity
+			} else {
+				operation.Quantity = *changes.Quantity
+			}
+		}
+		if changes.Price != nil {
+			operation.Price = *changes.Price
+		}
+		if changes.Commission != nil {
+			operation.Commission = *changes.Commission
+		}
+		operation.TotalPrice = operation.Price.Mul(decimal.NewFromInt(int64(operation.Quantity)))
+
+		err = s.repo.UpdateStockOperation(ctx, portfolioID, operation)
+		if err != nil {
+			return err
+		}
+
+		return s.replayPortfolioHistory(ctx, portfolioID)
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return model.StockOperation{}, service.ErrNotFound
+		}
+		return model.StockOperation{}, err
+	}
+
+	s.refreshPortfolioAfterReplay(ctx, portfolioID)
+
+	return operation, nil
+}
+
+// DeleteStockOperation удаляет операцию из истории и пересобирает по истории лоты, количество и деньги портфеля.
+// Сделки с бумагой до ее перевода в другой портфель не удаляются (ErrOperationNotEditable).
+func (s *InvestHelperService) DeleteStockOperation(ctx context.Context, portfolioID, operationID int64) error {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.DeleteStockOperation"
+
+	slog.Debug("DeleteStockOperation start", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("operationID", operationID))
+
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		operation, err := s.repo.GetStockOperation(ctx, portfolioID, operationID)
+		if err != nil {
+			return err
+		}
+		if !operation.IsTrade() {
+			return service.ErrOperationNotEditable
+		}
+		err = s.checkNotTransferredLater(ctx, portfolioID, operation)
+		if err != nil {
+			return err
+		}
+
+		err = s.repo.DeleteStockOperation(ctx, portfolioID, operationID)
+		if err != nil {
+			return err
+		}
+
+		return s.replayPortfolioHistory(ctx, portfolioID)
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return service.ErrNotFound
+		}
+		return err
+	}
+
+	s.refreshPortfolioAfterReplay(ctx, portfolioID)
+
+	return nil
+}
+
+// checkNotTransferredLater возвращает ErrOperationNotEditable, если после сделки бумага переводилась из портфеля.
+// Переведенные лоты с ценой и датой покупки уже зачислены в другой портфель, и пересборка одного портфеля
+// разошлась бы с ним. Должен вызываться внутри транзакции.
+func (s *InvestHelperService) checkNotTransferredLater(ctx context.Context, portfolioID int64, operation model.StockOperation) error {
+	operations, err := s.repo.GetStockOperations(ctx, portfolioID)
+	if err != nil {
+		return err
+	}
+
+	for _, other := range operations {
+		if other.OperationType == model.StockOperationTransfer && other.Quantity < 0 && other.Ticker == operation.Ticker &&
+			!other.DtCreate.Before(operation.DtCreate) {
+			return service.ErrOperationNotEditable
+		}
+	}
+
+	return nil
+}
+
+// replayPortfolioHistory пересобирает количество бумаг, лоты FIFO, реализованный результат и денежные проводки по сделкам,
+// проигрывая историю операций портфеля с начала. Бумаги, удаленные из портфеля, не восстанавливаются.
+// Начисленные дивиденды не пересчитываются. Должен вызываться внутри транзакции.
+func (s *InvestHelperService) replayPortfolioHistory(ctx context.Context, portfolioID int64) error {
+	err := s.repo.LockPortfolio(ctx, portfolioID)
+	if err != nil {
+		return err
+	}
+
+	operations, err := s.repo.GetStockOperations(ctx, portfolioID)
+	if err != nil {
+		return err
+	}
+
+	stocks, err := s.repo.GetStocksFromPortfolio(ctx, portfolioID)
+	if err != nil {
+		return err
+	}
+	inPortfolio := make(map[string]struct{}, len(stocks))
+	for _, stock := range stocks {
+		inPortfolio[stock.Ticker] = struct{}{}
+	}
+
+	lots, quantities, realizedLots, err := rebuildLots(portfolioID, operations)
+	if err != nil {
+		return err
+	}
+
+	stockRemainings := make([]model.StockRemaining, 0, len(lots))
+	for ticker, tickerLots := range lots {
+		if _, ok := inPortfolio[ticker]; ok {
+			stockRemainings = append(stockRemainings, tickerLots...)
+		}
+	}
+
+	err = s.repo.DeletePortfolioStockRemainings(ctx, portfolioID)
+	if err != nil {
+		return err
+	}
+	if len(stockRemainings) > 0 {
+		err = s.repo.InsertStockRemainings(ctx, portfolioID, stockRemainings)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.repo.DeletePortfolioRealizedLots(ctx, portfolioID)
+	if err != nil {
+		return err
+	}
+	if len(realizedLots) > 0 {
+		err = s.repo.InsertRealizedLots(ctx, portfolioID, realizedLots)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.repo.SetPortfolioStockQuantities(ctx, portfolioID, quantities)
+	if err != nil {
+		return err
+	}
+
+	return s.replayTradesCash(ctx, portfolioID, operations)
+}
+
+// UndoLastOperation отменяет последнюю пачку сделок пользователя, если с ее записи прошло не больше UndoWindow.
+// Пачка - все операции с одинаковым dt_insert: одна сделка с карточки бумаги или сразу несколько при закупе по расчету,
+// распределении взноса, импорте CSV или отчета брокера. Если в пачке есть корпоративное действие или перевод,
+// отмена отклоняется целиком. Операции удаляются из истории, портфели пересобираются по оставшейся истории.
+func (s *InvestHelperService) UndoLastOperation(ctx context.Context, chatID int64) ([]model.LastOperation, error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.UndoLastOperation"
+
+	slog.Debug("UndoLastOperation start", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("chatID", chatID))
+
+	var lastOperations []model.LastOperation
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		lastOperations, err = s.repo.GetLastEnteredStockOperations(ctx, chatID)
+		if err != nil {
+			return err
+		}
+		if len(lastOperations) == 0 || time.Since(lastOperations[0].DtInsert) > s.cfg.UndoWindow {
+			return service.ErrNothingToUndo
+		}
+		for _, lastOperation := range lastOperations {
+			if !lastOperation.Operation.IsTrade() {
+				return service.ErrNothingToUndo
+			}
+		}
+
+		return s.undoOperations(ctx, lastOperations)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.refreshUndonePortfolios(ctx, lastOperations)
+
+	slog.Info(
+		"last operations undone",
+		slog.String("rqID", rqID),
+		slog.String("op", op),
+		slog.Int64("chatID", chatID),
+		slog.Int("operationsCnt", len(lastOperations)),
+	)
+
+	return lastOperations, nil
+}
+
+// UndoOperation отменяет сделку operationID пользователя, если с ее записи прошло не больше UndoWindow.
+// Используется кнопкой отмены после сохранения сделки: отменяется ровно та операция, под которой показана кнопка.
+func (s *InvestHelperService) UndoOperation(ctx context.Context, chatID, operationID int64) (model.LastOperation, error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.UndoOperation"
+
+	slog.Debug("UndoOperation start", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("chatID", chatID), slog.Int64("operationID", operationID))
+
+	var undone []model.LastOperation
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		enteredOperation, err := s.repo.GetEnteredStockOperation(ctx, chatID, operationID)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) { // уже отменена или удалена
+				return service.ErrNothingToUndo
+			}
+			return err
+		}
+		if !enteredOperation.Operation.IsTrade() || time.Since(enteredOperation.DtInsert) > s.cfg.UndoWindow {
+			return service.ErrNothingToUndo
+		}
+
+		undone = []model.LastOperation{enteredOperation}
+		return s.undoOperations(ctx, undone)
+	})
+	if err != nil {
+		return model.LastOperation{}, err
+	}
+
+	s.refreshUndonePortfolios(ctx, undone)
+
+	slog.Info(
+		"operation undone",
+		slog.String("rqID", rqID),
+		slog.String("op", op),
+		slog.Int64("portfolioID", undone[0].PortfolioID),
+		slog.Int64("operationID", operationID),
+	)
+
+	return undone[0], nil
+}
+
+// undoOperations удаляет операции из истории и пересобирает их портфели, заполняя PortfolioName.
+// Операции должны быть отсортированы по портфелю, чтобы портфели блокировались в одном порядке.
+// Должен вызываться внутри транзакции.
+func (s *InvestHelperService) undoOperations(ctx context.Context, operations []model.LastOperation) error {
+	portfolioNames := make(map[int64]string)
+	portfolioIDs := make([]int64, 0)
+	for i, operation := range operations {
+		if _, ok := portfolioNames[operation.PortfolioID]; !ok {
+			err := s.repo.LockPortfolio(ctx, operation.PortfolioID)
+			if err != nil {
+				return err
+			}
+
+			portfolioNames[operation.PortfolioID], err = s.repo.GetPortfolioName(ctx, operation.PortfolioID)
+			if err != nil {
+				return err
+			}
+			portfolioIDs = append(portfolioIDs, operation.PortfolioID)
+		}
+		operations[i].PortfolioName = portfolioNames[operation.PortfolioID]
+
+		err := s.checkNotTransferredLater(ctx, operation.PortfolioID, operation.Operation)
+		if err != nil {
+			return err
+		}
+
+		err = s.repo.DeleteStockOperation(ctx, operation.PortfolioID, operation.Operation.OperationID)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) { // отменили параллельно
+				return service.ErrNothingToUndo
+			}
+			return err
+		}
+	}
+
+	for _, portfolioID := range portfolioIDs {
+		err := s.replayPortfolioHistory(ctx, portfolioID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// refreshUndonePortfolios сбрасывает кэши портфелей после отмены операций
+func (s *InvestHelperService) refreshUndonePortfolios(ctx context.Context, operations []model.LastOperation) {
+	refreshed := make(map[int64]bool)
+	for _, operation := range operations {
+		if !refreshed[operation.PortfolioID] {
+			s.refreshPortfolioAfterReplay(ctx, operation.PortfolioID)
+			refreshed[operation.PortfolioID] = true
+		}
+	}
+}
+
+// invalidSellError - продажа в истории превышает количество бумаг на ее дату
+type invalidSellError struct {
+	operation model.StockOperation
+	holdings  int
+}
+
+func (e invalidSellError) Error() string {
+	return fmt.Sprintf(
+		"%s: sell of %d %s on %s exceeds holdings %d",
+		service.ErrInvalidOperationHistory.Error(), -e.operation.Quantity, e.operation.Ticker, e.operation.DtCreate.Format(time.DateOnly), e.holdings,
+	)
+}
+
+func (e invalidSellError) Unwrap() error {
+	return service.ErrInvalidOperationHistory
+}
+
+// rebuildLots проигрывает операции по FIFO и возвращает открытые лоты, количество и закрытые лоты по каждой бумаге.
+// Операции должны быть упорядочены по времени.
+func rebuildLots(
+	portfolioID int64,
+	operations []model.StockOperation,
+) (lots map[string][]model.StockRemaining, quantities map[string]int, realizedLots []model.RealizedLot, err error) {
+	now := time.Now()
+	lots = make(map[string][]model.StockRemaining)
+	quantities = make(map[string]int)
+	realizedLots = make([]model.RealizedLot, 0)
+	var renamedLots []model.StockRemaining // лоты старого тикера между списанием и зачислением при смене тикера
+	renamedQuantity := 0
+	for _, operation := range operations {
+		ticker := operation.Ticker
+		switch operation.OperationType {
+		case model.StockOperationSplit:
+			// количество после сплита считается от бумаг на его дату, чтобы сделки задним числом тоже им пересчитывались.
+			// Сплит без коэффициента (загружен из CSV) меняет количество на записанное число бумаг.
+			target := quantities[ticker] + operation.Quantity
+			if operation.RatioFrom > 0 && operation.RatioTo > 0 {
+				target = quantities[ticker] * operation.RatioTo / operation.RatioFrom
+			}
+			if target < 0 {
+				return nil, nil, nil, invalidSellError{operation: operation, holdings: quantities[ticker]}
+			}
+			if quantities[ticker] > 0 {
+				lots[ticker] = scaleLots(lots[ticker], quantities[ticker], target)
+			}
+			quantities[ticker] = target
+			continue
+		case model.StockOperationRename:
+			// переносятся все бумаги на дату смены тикера, а не записанное в операции количество
+			if operation.Quantity < 0 {
+				renamedLots = lots[ticker]
+				renamedQuantity = quantities[ticker]
+				delete(lots, ticker)
+				quantities[ticker] = 0
+			} else {
+				for _, lot := range renamedLots {
+					lot.Ticker = ticker
+					lots[ticker] = append(lots[ticker], lot)
+				}
+				slices.SortStableFunc(lots[ticker], func(a, b model.StockRemaining) int {
+					return a.DtCreate.Compare(b.DtCreate)
+				})
+				quantities[ticker] += renamedQuantity
+				renamedLots, renamedQuantity = nil, 0
+			}
+			continue
+		case model.StockOperationTransfer:
+			if operation.Quantity > 0 {
+				// зачисленный лот встает в очередь FIFO по дате исходной покупки
+				lot := model.StockRemaining{
+					PortfolioID: portfolioID,
+					Ticker:      ticker,
+					Quantity:    operation.Quantity,
+					Price:       operation.Price,
+					DtCreate:    operation.DtAcquired,
+					DtUpdate:    now,
+				}
+				if lot.DtCreate.IsZero() {
+					lot.DtCreate = operation.DtCreate
+				}
+				idx, _ := slices.BinarySearchFunc(lots[ticker], lot, func(a, b model.StockRemaining) int {
+					if a.DtCreate.After(b.DtCreate) {
+						return 1
+					}
+					return -1
+				})
+				lots[ticker] = slices.Insert(lots[ticker], idx, lot)
+				quantities[ticker] += operation.Quantity
+				continue
+			}
+		}
+
+		if operation.Quantity > 0 {
+			lots[ticker] = append(lots[ticker], model.StockRemaining{
+				PortfolioID: portfolioID,
+				Ticker:      ticker,
+				Quantity:    operation.Quantity,
+				Price:       operationUnitCost(operation),
+				DtCreate:    operation.DtCreate,
+				DtUpdate:    now,
+			})
+			quantities[ticker] += operation.Quantity
+			continue
+		}
+
+		sellQuantity := -operation.Quantity
+		if sellQuantity > quantities[ticker] {
+			return nil, nil, nil, invalidSellError{operation: operation, holdings: quantities[ticker]}
+		}
+
+		for sellQuantity > 0 {
+			lot := &lots[ticker][0]
+			consumed := min(lot.Quantity, sellQuantity)
+			// при переводе в другой портфель лоты списываются без фиксации результата
+			if operation.OperationType != model.StockOperationTransfer {
+				realizedLots = append(realizedLots, model.RealizedLot{
+					PortfolioID: portfolioID,
+					Ticker:      ticker,
+					Quantity:    consumed,
+					BuyPrice:    lot.Price,
+					SellPrice:   operationUnitCost(operation),
+					BuyDate:     lot.DtCreate,
+					SellDate:    operation.DtCreate,
+				})
+			}
+			lot.Quantity -= consumed
+			sellQuantity -= consumed
+			if lot.Quantity == 0 {
+				lots[ticker] = lots[ticker][1:]
+			}
+		}
+		quantities[ticker] += operation.Quantity
+	}
+
+	return lots, quantities, realizedLots, nil
+}
+
+// scaleLots пересчитывает лоты при сплите: количество умножается на target/holdings, стоимость каждого лота сохраняется.
+// Остаток от округления достается последнему лоту, стоимость лота, округлившегося до нуля, переходит на следующий.
+func scaleLots(lots []model.StockRemaining, holdings, target int) []model.StockRemaining {
+	scaled := make([]model.StockRemaining, 0, len(lots))
+	var carriedCost decimal.Decimal
+	assigned := 0
+	for i, lot := range lots {
+		cost := lot.Price.Mul(decimal.NewFromInt(int64(lot.Quantity))).Add(carriedCost)
+		quantity := lot.Quantity * target / holdings
+		if i == len(lots)-1 {
+			quantity = target - assigned
+		}
+		if quantity == 0 {
+			carriedCost = cost
+			continue
+		}
+
+		carriedCost = decimal.Zero
+		lot.Quantity = quantity
+		lot.Price = cost.Div(decimal.NewFromInt(int64(quantity)))
+		scaled = append(scaled, lot)
+		assigned += quantity
+	}
+	return scaled
+}
+
+// replayTradesCash заново проводит по журналу денег сделки, по которым уже были проводки. Сделки, совершенные до появления
+// журнала денег, в нем не отражаются. Недостающие на покупку суммы, как и при обычной сделке, записываются пополнением.
+// Должен вызываться внутри транзакции.
+func (s *InvestHelperService) replayTradesCash(ctx context.Context, portfolioID int64, stockOperations []model.StockOperation) error {
+	linkedIDs, err := s.repo.DeleteTradeCashOperations(ctx, portfolioID)
+	if err != nil {
+		return err
+	}
+	if len(linkedIDs) == 0 {
+		return nil
+	}
+	linked := make(map[int64]struct{}, len(linkedIDs))
+	for _, id := range linkedIDs {
+		linked[id] = struct{}{}
+	}
+
+	// остались только пополнения, выводы, дивиденды и прочие проводки не по сделкам
+	otherOperations, err := s.repo.GetAllCashOperations(ctx, portfolioID)
+	if err != nil {
+		return err
+	}
+
+	var balance decimal.Decimal
+	i := 0
+	operations := make([]model.CashOperation, 0, len(linkedIDs)*2)
+	for _, stockOperation := range stockOperations {
+		if _, ok := linked[stockOperation.OperationID]; !ok {
+			continue
+		}
+
+		for i < len(otherOperations) && !otherOperations[i].DtCreate.After(stockOperation.DtCreate) {
+			balance = balance.Add(otherOperations[i].Amount)
+			i++
+		}
+
+		tradeOperations := tradeCashOperations(portfolioID, stockOperation)
+		var total decimal.Decimal
+		for _, operation := range tradeOperations {
+			total = total.Add(operation.Amount)
+		}
+
+		if total.IsNegative() {
+			if shortfall := total.Add(balance); shortfall.IsNegative() {
+				operations = append(operations, model.CashOperation{
+					PortfolioID:   portfolioID,
+					OperationType: model.CashOperationDeposit,
+					Amount:        shortfall.Neg(),
+					OperationID:   stockOperation.OperationID,
+					DtCreate:      stockOperation.DtCreate,
+				})
+				balance = balance.Add(shortfall.Neg())
+			}
+		}
+
+		operations = append(operations, tradeOperations...)
+		balance = balance.Add(total)
+	}
+
+	if len(operations) == 0 {
+		return nil
+	}
+
+	return s.repo.InsertCashOperations(ctx, portfolioID, operations)
+}
+
+// refreshPortfolioAfterReplay сбрасывает кэш портфеля и заново кэширует средние цены всех бумаг после пересборки истории
+func (s *InvestHelperService) refreshPortfolioAfterReplay(ctx context.Context, portfolioID int64) {
+	stocks, err := s.repo.GetStocksFromPortfolio(ctx, portfolioID)
+	if err == nil && len(stocks) > 0 {
+		tickers := make([]string, 0, len(stocks))
+		for _, stock := range stocks {
+			tickers = append(tickers, stock.Ticker)
+		}
+
+		avgPrices, err := s.repo.GetAverageStockPurchasePrices(ctx, portfolioID, tickers...)
+		if err == nil {
+			avgPricesToCache := make([]model.StockAvgPrice, 0, len(tickers))
+			for _, ticker := range tickers {
+				avgPricesToCache = append(avgPricesToCache, model.StockAvgPrice{Ticker: ticker, AvgPrice: avgPrices[ticker]})
+			}
+			_ = s.cache.SetStockAvgPrices(ctx, portfolioID, avgPricesToCache...)
+		}
+	}
+
+	_ = s.cache.FlushPortfolioCache(ctx, portfolioID) // вызываем синхронно, так как конкурентно может не успеть удалиться и получим старую инфу
+}
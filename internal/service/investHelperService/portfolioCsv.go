@@ -0,0 +1,342 @@
+package investHelperService
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/data/repository"
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/internal/model/moexModel"
+	"github.com/KotFed0t/invest_helper_bot/internal/service"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+	"github.com/shopspring/decimal"
+)
+
+// ExportPortfolioCsv выгружает бумаги портфеля с весами и историю операций в два CSV файла
+func (s *InvestHelperService) ExportPortfolioCsv(ctx context.Context, portfolioID int64) ([]model.CsvFile, error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.ExportPortfolioCsv"
+
+	slog.Debug("ExportPortfolioCsv start", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", portfolioID))
+
+	portfolio, err := s.repo.GetPortfolio(ctx, portfolioID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, service.ErrNotFound
+		}
+		return nil, err
+	}
+
+	stocks, err := s.repo.GetStocksFromPortfolio(ctx, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+
+	operations, err := s.repo.GetStockOperations(ctx, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+
+	positionsContent, err := s.portfolioCsv.EncodePositions(stocks)
+	if err != nil {
+		return nil, err
+	}
+
+	operationsContent, err := s.portfolioCsv.EncodeOperations(operations)
+	if err != nil {
+		return nil, err
+	}
+
+	return []model.CsvFile{
+		{Filename: fmt.Sprintf("%s_positions.csv", portfolio.PortfolioName), Content: positionsContent},
+		{Filename: fmt.Sprintf("%s_operations.csv", portfolio.PortfolioName), Content: operationsContent},
+	}, nil
+}
+
+// ImportPortfolioCsv загружает CSV файл в портфель: позиции заменяют состав и веса, операции заменяют историю сделок
+// с пересборкой лотов и денег. Если portfolioID = 0, создается новый портфель с именем portfolioName.
+// При ошибках в строках портфель не меняется, ошибки возвращаются в результате.
+func (s *InvestHelperService) ImportPortfolioCsv(
+	ctx context.Context,
+	chatID, portfolioID int64,
+	portfolioName string,
+	content []byte,
+) (model.CsvImportResult, error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.ImportPortfolioCsv"
+
+	slog.Debug("ImportPortfolioCsv start", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", portfolioID))
+
+	csvImport, err := s.portfolioCsv.Decode(content)
+	if err != nil {
+		return model.CsvImportResult{}, err
+	}
+
+	result := model.CsvImportResult{
+		PortfolioID:   portfolioID,
+		PortfolioName: portfolioName,
+		Kind:          csvImport.Kind,
+		Positions:     len(csvImport.Positions),
+		Operations:    len(csvImport.Operations),
+		Errors:        csvImport.Errors,
+	}
+
+	// проверяем тикеры по бирже, заодно берем режим торгов и тип инструмента для добавления в портфель.
+	// Старые тикеры, которые встречаются только до смены тикера, на бирже уже не торгуются: их переносит пересборка истории
+	renamedTickers := renamedAwayTickers(csvImport.Operations)
+	lines := make(map[string][]int)
+	for _, position := range csvImport.Positions {
+		lines[position.Ticker] = append(lines[position.Ticker], position.Line)
+	}
+	for _, operation := range csvImport.Operations {
+		if _, ok := renamedTickers[operation.Operation.Ticker]; ok {
+			continue
+		}
+		lines[operation.Operation.Ticker] = append(lines[operation.Operation.Ticker], operation.Line)
+	}
+	stocksInfo := make(map[string]moexModel.StockInfo, len(lines))
+	for ticker, tickerLines := range lines {
+		stockInfo, err := s.GetStockInfo(ctx, ticker)
+		if err != nil {
+			if !errors.Is(err, service.ErrNotFound) {
+				return model.CsvImportResult{}, err
+			}
+			for _, line := range tickerLines {
+				result.Errors = append(result.Errors, model.CsvLineError{Line: line, Message: fmt.Sprintf("бумага %s не найдена на бирже", ticker)})
+			}
+			continue
+		}
+		stocksInfo[ticker] = stockInfo
+	}
+
+	err = s.unlinkForeignTransfers(ctx, chatID, portfolioID, csvImport.Operations)
+	if err != nil {
+		return model.CsvImportResult{}, err
+	}
+
+	if len(result.Errors) > 0 {
+		slices.SortFunc(result.Errors, func(a, b model.CsvLineError) int { return a.Line - b.Line })
+		return result, nil
+	}
+
+	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if result.PortfolioID == 0 {
+			userID, err := s.repo.GetUserID(ctx, chatID)
+			if err != nil {
+				return err
+			}
+			result.PortfolioID, err = s.repo.CreateStocksPortfolio(ctx, portfolioName, userID)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := s.repo.LockPortfolio(ctx, result.PortfolioID)
+		if err != nil {
+			return err
+		}
+
+		if csvImport.Kind == model.CsvKindPositions {
+			return s.importCsvPositions(ctx, result.PortfolioID, csvImport.Positions, stocksInfo)
+		}
+		return s.importCsvOperations(ctx, result.PortfolioID, csvImport.Operations, stocksInfo, renamedTickers)
+	})
+	if err != nil {
+		var sellErr invalidSellError
+		if errors.As(err, &sellErr) {
+			result.Errors = append(result.Errors, model.CsvLineError{
+				Line:    csvOperationLine(csvImport.Operations, sellErr.operation),
+				Message: fmt.Sprintf("продажа превышает количество %s на эту дату (%d шт.)", sellErr.operation.Ticker, sellErr.holdings),
+			})
+			return result, nil
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			return model.CsvImportResult{}, service.ErrNotFound
+		}
+		return model.CsvImportResult{}, err
+	}
+
+	if csvImport.Kind == model.CsvKindOperations {
+		s.refreshPortfolioAfterReplay(ctx, result.PortfolioID)
+	} else {
+		_ = s.cache.FlushPortfolioCache(ctx, result.PortfolioID) // вызываем синхронно, так как конкурентно может не успеть удалиться и получим старую инфу
+	}
+
+	return result, nil
+}
+
+// importCsvPositions приводит состав портфеля к файлу. Бумаги, которых нет в файле, удаляются, а если они еще
+// есть на руках - остаются с нулевым весом. Должен вызываться внутри транзакции.
+func (s *InvestHelperService) importCsvPositions(
+	ctx context.Context,
+	portfolioID int64,
+	positions []model.CsvPosition,
+	stocksInfo map[string]moexModel.StockInfo,
+) error {
+	stocks, err := s.repo.GetStocksFromPortfolio(ctx, portfolioID)
+	if err != nil {
+		return err
+	}
+
+	weights := make(map[string]decimal.Decimal, len(positions))
+	for _, position := range positions {
+		weights[position.Ticker] = position.Weight
+	}
+
+	inPortfolio := make(map[string]struct{}, len(stocks))
+	for _, stock := range stocks {
+		inPortfolio[stock.Ticker] = struct{}{}
+		if _, ok := weights[stock.Ticker]; ok {
+			continue
+		}
+		if stock.Quantity > 0 {
+			weights[stock.Ticker] = decimal.Zero
+			continue
+		}
+		err = s.repo.DeleteStockFromPortfolio(ctx, portfolioID, stock.Ticker)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, position := range positions {
+		if _, ok := inPortfolio[position.Ticker]; ok {
+			continue
+		}
+		stockInfo := stocksInfo[position.Ticker]
+		err = s.repo.InsertStockToPortfolio(ctx, portfolioID, position.Ticker, stockInfo.Board, stockInfo.InstrumentType)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.repo.SetPortfolioWeights(ctx, portfolioID, weights)
+}
+
+// importCsvOperations заменяет историю сделок портфеля операциями из файла. Бумаги из файла, которых нет в портфеле,
+// добавляются с нулевым весом, кроме старых тикеров из renamedTickers. Должен вызываться внутри транзакции.
+func (s *InvestHelperService) importCsvOperations(
+	ctx context.Context,
+	portfolioID int64,
+	csvOperations []model.CsvOperation,
+	stocksInfo map[string]moexModel.StockInfo,
+	renamedTickers map[string]struct{},
+) error {
+	stocks, err := s.repo.GetStocksFromPortfolio(ctx, portfolioID)
+	if err != nil {
+		return err
+	}
+	inPortfolio := make(map[string]struct{}, len(stocks))
+	for _, stock := range stocks {
+		inPortfolio[stock.Ticker] = struct{}{}
+	}
+
+	operations := make([]model.StockOperation, 0, len(csvOperations))
+	for _, csvOperation := range csvOperations {
+		operation := csvOperation.Operation
+		_, renamed := renamedTickers[operation.Ticker]
+		if _, ok := inPortfolio[operation.Ticker]; !ok && !renamed {
+			stockInfo := stocksInfo[operation.Ticker]
+			err = s.repo.InsertStockToPortfolio(ctx, portfolioID, operation.Ticker, stockInfo.Board, stockInfo.InstrumentType)
+			if err != nil {
+				return err
+			}
+			inPortfolio[operation.Ticker] = struct{}{}
+		}
+		operations = append(operations, operation)
+	}
+	slices.SortStableFunc(operations, func(a, b model.StockOperation) int {
+		return a.DtCreate.Compare(b.DtCreate)
+	})
+
+	err = s.repo.DeletePortfolioStockOperations(ctx, portfolioID)
+	if err != nil {
+		return err
+	}
+
+	if len(operations) > 0 {
+		operationIDs, err := s.repo.InsertStockOperationsToHistory(ctx, portfolioID, operations)
+		if err != nil {
+			return err
+		}
+		for i := range operations {
+			operations[i].OperationID = operationIDs[i]
+		}
+
+		err = s.recordTradesCash(ctx, portfolioID, operations)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.replayPortfolioHistory(ctx, portfolioID)
+}
+
+// csvOperationLine - строка файла, из которой взята операция
+func csvOperationLine(csvOperations []model.CsvOperation, operation model.StockOperation) int {
+	for _, csvOperation := range csvOperations {
+		if csvOperation.Operation.Ticker == operation.Ticker &&
+			csvOperation.Operation.Quantity == operation.Quantity &&
+			csvOperation.Operation.DtCreate.Equal(operation.DtCreate) {
+			return csvOperation.Line
+		}
+	}
+	return 0
+}
+
+// renamedAwayTickers возвращает старые тикеры: у них есть списание при смене тикера, и все их строки не позже последнего
+// такого списания. Таких бумаг после пересборки истории в портфеле нет.
+func renamedAwayTickers(csvOperations []model.CsvOperation) map[string]struct{} {
+	renamedAt := make(map[string]time.Time)
+	for _, csvOperation := range csvOperations {
+		operation := csvOperation.Operation
+		if operation.OperationType == model.StockOperationRename && operation.Quantity < 0 && operation.DtCreate.After(renamedAt[operation.Ticker]) {
+			renamedAt[operation.Ticker] = operation.DtCreate
+		}
+	}
+
+	renamed := make(map[string]struct{}, len(renamedAt))
+	for ticker := range renamedAt {
+		renamed[ticker] = struct{}{}
+	}
+	for _, csvOperation := range csvOperations {
+		operation := csvOperation.Operation
+		if dt, ok := renamedAt[operation.Ticker]; ok && operation.DtCreate.After(dt) {
+			delete(renamed, operation.Ticker)
+		}
+	}
+
+	return renamed
+}
+
+// unlinkForeignTransfers обнуляет связанный портфель у переводов из файла, если это не другой портфель пользователя
+// (файл выгружен из чужого аккаунта или портфель уже удален)
+func (s *InvestHelperService) unlinkForeignTransfers(ctx context.Context, chatID, portfolioID int64, csvOperations []model.CsvOperation) error {
+	if !slices.ContainsFunc(csvOperations, func(csvOperation model.CsvOperation) bool {
+		return csvOperation.Operation.TransferPortfolioID != 0
+	}) {
+		return nil
+	}
+
+	userID, err := s.repo.GetUserID(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	portfolioNames, err := s.repo.GetAllPortfolioNamesByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for i := range csvOperations {
+		transferPortfolioID := csvOperations[i].Operation.TransferPortfolioID
+		if _, ok := portfolioNames[transferPortfolioID]; !ok || transferPortfolioID == portfolioID {
+			csvOperations[i].Operation.TransferPortfolioID = 0
+		}
+	}
+
+	return nil
+}
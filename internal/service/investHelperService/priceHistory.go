@@ -0,0 +1,55 @@
+package investHelperService
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/utils"
+)
+
+// UpdatePriceHistory догружает дневные свечи по всем тикерам из портфелей.
+// Для тикеров без истории загружается период PRICE_HISTORY_BACKFILL_DAYS, для остальных - дни после последней свечи.
+func (s *InvestHelperService) UpdatePriceHistory(ctx context.Context) error {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.UpdatePriceHistory"
+
+	slog.Debug("UpdatePriceHistory start", slog.String("rqID", rqID), slog.String("op", op))
+
+	states, err := s.repo.GetPriceHistoryStates(ctx)
+	if err != nil {
+		return err
+	}
+
+	today := time.Now().Truncate(24 * time.Hour)
+	for _, state := range states {
+		from := today.AddDate(0, 0, -s.cfg.PriceHistory.BackfillDays)
+		if !state.LastTradeDate.IsZero() {
+			from = state.LastTradeDate.AddDate(0, 0, 1)
+		}
+
+		if from.After(today) {
+			continue
+		}
+
+		candles, err := s.moexApi.GetPriceHistory(ctx, state.Ticker, state.Board, from, today)
+		if err != nil {
+			// ошибка по одному тикеру не должна останавливать загрузку остальных
+			slog.Error("can't get price history", slog.String("rqID", rqID), slog.String("op", op), slog.String("ticker", state.Ticker), slog.String("err", err.Error()))
+			continue
+		}
+
+		if len(candles) == 0 {
+			continue
+		}
+
+		err = s.repo.UpsertPriceHistory(ctx, candles)
+		if err != nil {
+			return err
+		}
+	}
+
+	slog.Debug("UpdatePriceHistory completed", slog.String("rqID", rqID), slog.String("op", op), slog.Int("tickers", len(states)))
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package investHelperService
+
+import (
+	"context"
+	"slices"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/internal/service"
+)
+
+// GetPurchaseStrategy возвращает стратегию распределения закупа портфеля
+func (s *InvestHelperService) GetPurchaseStrategy(ctx context.Context, portfolioID int64) (model.PurchaseStrategy, error) {
+	portfolio, err := s.repo.GetPortfolio(ctx, portfolioID)
+	if err != nil {
+		return "", err
+	}
+
+	if portfolio.PurchaseStrategy == "" {
+		return model.PurchaseStrategyLargestLot, nil
+	}
+
+	return portfolio.PurchaseStrategy, nil
+}
+
+func (s *InvestHelperService) SetPurchaseStrategy(ctx context.Context, portfolioID int64, strategy model.PurchaseStrategy) error {
+	if !slices.Contains(model.PurchaseStrategies, strategy) {
+		return service.ErrNotFound
+	}
+
+	err := s.repo.SetPurchaseStrategy(ctx, portfolioID, strategy)
+	if err != nil {
+		return err
+	}
+
+	_ = s.cache.FlushPortfolioCache(ctx, portfolioID) // вызываем синхронно, так как конкурентно может не успеть удалиться и получим старую инфу
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package investHelperService
+
+import (
+	"context"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+)
+
+// getRealizedPnL возвращает реализованный результат портфеля по календарным годам (от последнего к первому)
+func (s *InvestHelperService) getRealizedPnL(ctx context.Context, portfolioID int64) ([]model.YearRealizedPnL, error) {
+	tickersPnL, err := s.repo.GetRealizedPnL(ctx, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+
+	return groupRealizedPnLByYear(tickersPnL), nil
+}
+
+// groupRealizedPnLByYear ожидает строки, отсортированные по году
+func groupRealizedPnLByYear(tickersPnL []model.TickerRealizedPnL) []model.YearRealizedPnL {
+	years := make([]model.YearRealizedPnL, 0)
+	for _, tickerPnL := range tickersPnL {
+		if len(years) == 0 || years[len(years)-1].Year != tickerPnL.Year {
+			years = append(years, model.YearRealizedPnL{Year: tickerPnL.Year})
+		}
+
+		year := &years[len(years)-1]
+		year.Profit = year.Profit.Add(tickerPnL.Profit)
+		year.ByTicker = append(year.ByTicker, tickerPnL)
+	}
+
+	return years
+}
@@ -0,0 +1,58 @@
+package investHelperService
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+	"github.com/shopspring/decimal"
+)
+
+// CalculateRebalance рассчитывает план покупок и продаж целыми лотами, который приводит веса бумаг портфеля
+// к целевым с допуском из конфига. Продажи предлагаются, только если они разрешены в настройках портфеля.
+// contribution - сумма довнесения, может быть нулевой.
+func (s *InvestHelperService) CalculateRebalance(ctx context.Context, portfolioID int64, contribution decimal.Decimal) (model.RebalancePlan, error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.CalculateRebalance"
+
+	slog.Debug("CalculateRebalance start", slog.String("rqID", rqID), slog.String("op", op), slog.String("contribution", contribution.StringFixed(2)), slog.Int64("portfolioID", portfolioID))
+
+	portfolio, err := s.repo.GetPortfolio(ctx, portfolioID)
+	if err != nil {
+		return model.RebalancePlan{}, err
+	}
+
+	input, err := s.getPurchaseInput(ctx, portfolioID, contribution)
+	if err != nil {
+		return model.RebalancePlan{}, err
+	}
+
+	plan := s.purchaseStrategy.Rebalance(model.RebalanceInput{
+		PurchaseInput: input,
+		Tolerance:     s.cfg.Rebalance.WeightTolerance,
+		AllowSells:    portfolio.AllowSells,
+	})
+
+	slog.Info("result for rebalance", slog.String("rqID", rqID), slog.String("op", op), slog.Any("plan", plan))
+
+	return plan, nil
+}
+
+// ToggleAllowSells разрешает или запрещает продажи при ребалансировке портфеля, возвращает новое состояние
+func (s *InvestHelperService) ToggleAllowSells(ctx context.Context, portfolioID int64) (allowed bool, err error) {
+	portfolio, err := s.repo.GetPortfolio(ctx, portfolioID)
+	if err != nil {
+		return false, err
+	}
+
+	allowed = !portfolio.AllowSells
+	err = s.repo.SetAllowSells(ctx, portfolioID, allowed)
+	if err != nil {
+		return false, err
+	}
+
+	_ = s.cache.FlushPortfolioCache(ctx, portfolioID) // вызываем синхронно, так как конкурентно может не успеть удалиться и получим старую инфу
+
+	return allowed, nil
+}
@@ -0,0 +1,257 @@
+package investHelperService
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+	"github.com/shopspring/decimal"
+)
+
+// cashFlow - денежный поток с точки зрения инвестора: покупка отрицательная, продажа и текущая стоимость положительные
+type cashFlow struct {
+	date   time.Time
+	amount float64
+}
+
+const (
+	xirrMaxIterations = 100
+	xirrTolerance     = 1e-7
+)
+
+// getPortfolioReturns загружает историю операций и считает доходность портфеля
+func (s *InvestHelperService) getPortfolioReturns(
+	ctx context.Context,
+	portfolioID int64,
+	currentValue decimal.Decimal,
+	dividendIncome []model.DividendIncome,
+) (model.PortfolioReturns, error) {
+	operations, err := s.repo.GetStockOperations(ctx, portfolioID)
+	if err != nil {
+		return model.PortfolioReturns{}, err
+	}
+
+	return s.calculatePortfolioReturns(ctx, portfolioID, currentValue, operations, dividendIncome)
+}
+
+// calculatePortfolioReturns считает XIRR по операциям из stocks_operations_history и полученным дивидендам,
+// TWR - по дневным снапшотам
+func (s *InvestHelperService) calculatePortfolioReturns(
+	ctx context.Context,
+	portfolioID int64,
+	currentValue decimal.Decimal,
+	operations []model.StockOperation,
+	dividendIncome []model.DividendIncome,
+) (returns model.PortfolioReturns, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.calculatePortfolioReturns"
+
+	operations = sortedOperations(operations)
+	now := time.Now()
+
+	flows := make([]cashFlow, 0, len(operations)+1)
+	for _, operation := range operations {
+		if operation.TotalPrice.IsZero() {
+			continue
+		}
+		// комиссия увеличивает вложения при покупке и уменьшает выручку при продаже
+		flows = append(flows, cashFlow{date: operation.DtCreate, amount: operation.TotalPrice.Add(operation.Commission).Neg().InexactFloat64()})
+	}
+	for _, income := range dividendIncome {
+		flows = append(flows, cashFlow{date: income.DividendDate, amount: income.NetAmount.InexactFloat64()})
+	}
+	if currentValue.IsPositive() {
+		flows = append(flows, cashFlow{date: now, amount: currentValue.InexactFloat64()})
+	}
+
+	if rate, ok := calculateXIRR(flows); ok {
+		returns.XIRR = decimal.NewFromFloat(rate * 100)
+		returns.XIRRAvailable = true
+	}
+
+	snapshots, err := s.repo.GetPortfolioSnapshots(ctx, portfolioID)
+	if err != nil {
+		return model.PortfolioReturns{}, err
+	}
+
+	returns.TWR, returns.TWRSince, returns.TWRAvailable = calculateTWR(snapshots, operations, dividendIncome, currentValue, now)
+
+	slog.Debug("portfolio returns calculated", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", portfolioID), slog.Any("returns", returns))
+
+	return returns, nil
+}
+
+func sortedOperations(operations []model.StockOperation) []model.StockOperation {
+	sorted := make([]model.StockOperation, len(operations))
+	copy(sorted, operations)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].DtCreate.Before(sorted[j].DtCreate)
+	})
+	return sorted
+}
+
+// calculateXIRR находит годовую ставку, при которой сумма дисконтированных потоков равна нулю.
+// Сначала метод Ньютона, если он не сошелся - деление отрезка пополам.
+func calculateXIRR(flows []cashFlow) (float64, bool) {
+	if len(flows) < 2 {
+		return 0, false
+	}
+
+	hasNegative, hasPositive := false, false
+	for _, flow := range flows {
+		if flow.amount < 0 {
+			hasNegative = true
+		}
+		if flow.amount > 0 {
+			hasPositive = true
+		}
+	}
+	if !hasNegative || !hasPositive {
+		return 0, false
+	}
+
+	start := flows[0].date
+	for _, flow := range flows {
+		if flow.date.Before(start) {
+			start = flow.date
+		}
+	}
+
+	years := make([]float64, len(flows))
+	for i, flow := range flows {
+		years[i] = flow.date.Sub(start).Hours() / 24 / 365
+	}
+
+	npv := func(rate float64) float64 {
+		var sum float64
+		for i, flow := range flows {
+			sum += flow.amount / math.Pow(1+rate, years[i])
+		}
+		return sum
+	}
+
+	derivative := func(rate float64) float64 {
+		var sum float64
+		for i, flow := range flows {
+			sum -= years[i] * flow.amount / math.Pow(1+rate, years[i]+1)
+		}
+		return sum
+	}
+
+	rate := 0.1
+	for i := 0; i < xirrMaxIterations; i++ {
+		d := derivative(rate)
+		if d == 0 || math.IsNaN(d) || math.IsInf(d, 0) {
+			break
+		}
+		next := rate - npv(rate)/d
+		if next <= -1 || math.IsNaN(next) || math.IsInf(next, 0) {
+			break
+		}
+		if math.Abs(next-rate) < xirrTolerance {
+			return next, true
+		}
+		rate = next
+	}
+
+	low, high := -0.9999, 1.0
+	for npv(low)*npv(high) > 0 && high < 1e6 {
+		high *= 10
+	}
+	if npv(low)*npv(high) > 0 {
+		return 0, false
+	}
+
+	for i := 0; i < 200; i++ {
+		mid := (low + high) / 2
+		if npv(low)*npv(mid) <= 0 {
+			high = mid
+		} else {
+			low = mid
+		}
+		if high-low < xirrTolerance {
+			break
+		}
+	}
+
+	return (low + high) / 2, true
+}
+
+// calculateTWR связывает доходности отрезков между снапшотами, исключая из каждого отрезка пополнения и выводы.
+// Выплаченные дивиденды уходят из стоимости портфеля, поэтому возвращаются в доходность отрезка.
+// Комиссии учитываются в потоке, поэтому снижают доходность.
+// Снапшот снимается в конце дня, поэтому операции дня снапшота относятся к отрезку, который им заканчивается.
+func calculateTWR(
+	snapshots []model.PortfolioSnapshot,
+	operations []model.StockOperation,
+	dividendIncome []model.DividendIncome,
+	currentValue decimal.Decimal,
+	now time.Time,
+) (twr decimal.Decimal, since time.Time, ok bool) {
+	if len(snapshots) == 0 {
+		return decimal.Decimal{}, time.Time{}, false
+	}
+
+	type point struct {
+		date  time.Time
+		value decimal.Decimal
+	}
+
+	points := make([]point, 0, len(snapshots)+1)
+	for _, snapshot := range snapshots {
+		points = append(points, point{
+			date:  snapshot.SnapshotDate.Truncate(24 * time.Hour),
+			value: snapshot.BalanceInsideIndex.Add(snapshot.BalanceOutsideIndex),
+		})
+	}
+
+	today := now.Truncate(24 * time.Hour)
+	if today.After(points[len(points)-1].date) {
+		points = append(points, point{date: today, value: currentValue})
+	}
+
+	growth := decimal.NewFromInt(1)
+	opIdx := 0
+	for i := 1; i < len(points); i++ {
+		prev, cur := points[i-1], points[i]
+
+		var netFlow decimal.Decimal
+		for opIdx < len(operations) {
+			opDay := operations[opIdx].DtCreate.Truncate(24 * time.Hour)
+			if opDay.After(cur.date) {
+				break
+			}
+			if opDay.After(prev.date) {
+				netFlow = netFlow.Add(operations[opIdx].TotalPrice).Add(operations[opIdx].Commission)
+			}
+			opIdx++
+		}
+
+		var dividends decimal.Decimal
+		for _, income := range dividendIncome {
+			incomeDay := income.DividendDate.Truncate(24 * time.Hour)
+			if incomeDay.After(prev.date) && !incomeDay.After(cur.date) {
+				dividends = dividends.Add(income.NetAmount)
+			}
+		}
+
+		if !prev.value.IsPositive() {
+			// портфель был пуст - отрезок не несет информации о доходности
+			continue
+		}
+
+		periodGrowth := cur.value.Add(dividends).Sub(netFlow).Div(prev.value)
+		growth = growth.Mul(periodGrowth)
+		ok = true
+	}
+
+	if !ok {
+		return decimal.Decimal{}, time.Time{}, false
+	}
+
+	return growth.Sub(decimal.NewFromInt(1)).Mul(decimal.NewFromInt(100)), points[0].date, true
+}
@@ -0,0 +1,224 @@
+package investHelperService
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/internal/model/moexModel"
+	"github.com/KotFed0t/invest_helper_bot/internal/service"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+	"github.com/shopspring/decimal"
+)
+
+// SimulatePortfolio считает, каким станет портфель после сценария "что если": сводку, веса бумаг, рост
+// и налог с продаж сценария. Текущее состояние портфеля только читается, сценарий применяется в памяти
+// и ничего не записывает ни в БД, ни в кэш. Сделки сценария проводятся по ценам после изменений цен, без комиссии.
+func (s *InvestHelperService) SimulatePortfolio(ctx context.Context, portfolioID int64, scenario model.SimulationScenario) (result model.SimulationResult, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.SimulatePortfolio"
+
+	slog.Debug("SimulatePortfolio start", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", portfolioID), slog.Any("scenario", scenario))
+	defer func() {
+		slog.Debug("SimulatePortfolio finished", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", portfolioID))
+	}()
+
+	portfolio, err := s.repo.GetPortfolio(ctx, portfolioID)
+	if err != nil {
+		return model.SimulationResult{}, err
+	}
+
+	stocks, err := s.repo.GetStocksFromPortfolio(ctx, portfolioID)
+	if err != nil {
+		return model.SimulationResult{}, err
+	}
+	if len(stocks) == 0 {
+		return model.SimulationResult{}, service.ErrNotFound
+	}
+
+	quantities := make(map[string]int, len(stocks))
+	tickers := make([]string, 0, len(stocks))
+	for _, stock := range stocks {
+		quantities[stock.Ticker] = stock.Quantity
+		tickers = append(tickers, stock.Ticker)
+	}
+
+	for _, priceChange := range scenario.PriceChanges {
+		if _, ok := quantities[priceChange.Ticker]; !ok {
+			return model.SimulationResult{}, fmt.Errorf("%w: %s", service.ErrTickerNotInPortfolio, priceChange.Ticker)
+		}
+	}
+	for _, trade := range scenario.Trades {
+		if _, ok := quantities[trade.Ticker]; !ok {
+			return model.SimulationResult{}, fmt.Errorf("%w: %s", service.ErrTickerNotInPortfolio, trade.Ticker)
+		}
+		quantities[trade.Ticker] += trade.Quantity
+		if quantities[trade.Ticker] < 0 {
+			return model.SimulationResult{}, service.ErrNotEnoughStocks
+		}
+	}
+
+	stocksInfoMap, err := s.getStocksInfo(ctx, tickers)
+	if err != nil {
+		return model.SimulationResult{}, err
+	}
+
+	stockRemainings, err := s.repo.GetStockRemainings(ctx, portfolioID)
+	if err != nil {
+		return model.SimulationResult{}, err
+	}
+
+	userRealizedLots, err := s.repo.GetUserRealizedLots(ctx, portfolioID)
+	if err != nil {
+		return model.SimulationResult{}, err
+	}
+
+	cashBalance, err := s.repo.GetCashBalance(ctx, portfolioID)
+	if err != nil {
+		return model.SimulationResult{}, err
+	}
+
+	result.Scenario = scenario
+	result.Before, result.BeforeStocks, err = s.simulatedSummary(ctx, portfolio, stocks, stocksInfoMap, stockRemainings, cashBalance)
+	if err != nil {
+		return model.SimulationResult{}, err
+	}
+
+	// дальше работаем только с копиями текущего состояния
+	stocksInfoMap = maps.Clone(stocksInfoMap)
+	for _, priceChange := range scenario.PriceChanges {
+		stockInfo := stocksInfoMap[priceChange.Ticker]
+		stockInfo.Price = stockInfo.Price.Mul(decimal.NewFromInt(100).Add(priceChange.Percent)).Div(decimal.NewFromInt(100))
+		stocksInfoMap[priceChange.Ticker] = stockInfo
+	}
+
+	now := time.Now()
+	stockRemainings = slices.Clone(stockRemainings)
+	simulatedLots := make([]model.RealizedLot, 0)
+	for _, trade := range scenario.Trades {
+		price := stocksInfoMap[trade.Ticker].Price
+		sum := price.Mul(decimal.NewFromInt(int64(trade.Quantity)).Abs())
+
+		if trade.Quantity > 0 {
+			result.BuySum = result.BuySum.Add(sum)
+			stockRemainings = append(stockRemainings, model.StockRemaining{
+				PortfolioID: portfolioID,
+				Ticker:      trade.Ticker,
+				Quantity:    trade.Quantity,
+				Price:       price,
+				DtCreate:    now,
+			})
+			continue
+		}
+
+		result.SellSum = result.SellSum.Add(sum)
+		var consumed []model.StockRemaining
+		stockRemainings, consumed = consumeStockRemainingsInMemory(stockRemainings, trade.Ticker, -trade.Quantity)
+		for _, lot := range consumed {
+			simulatedLots = append(simulatedLots, model.RealizedLot{
+				PortfolioID: portfolioID,
+				Ticker:      trade.Ticker,
+				Quantity:    lot.Quantity,
+				BuyPrice:    lot.Price,
+				SellPrice:   price,
+				BuyDate:     lot.DtCreate,
+				SellDate:    now,
+			})
+		}
+	}
+
+	stocksAfter := slices.Clone(stocks)
+	for i := range stocksAfter {
+		stocksAfter[i].Quantity = quantities[stocksAfter[i].Ticker]
+	}
+
+	cashAfter := cashBalance.Sub(result.BuySum).Add(result.SellSum)
+	result.After, result.AfterStocks, err = s.simulatedSummary(ctx, portfolio, stocksAfter, stocksInfoMap, stockRemainings, cashAfter)
+	if err != nil {
+		return model.SimulationResult{}, err
+	}
+
+	// налог считаем за текущий год целиком и по всем портфелям пользователя, чтобы учесть прогрессивную ставку,
+	// уже зафиксированный результат и убытки других портфелей
+	taxBefore := s.buildTaxReport(now.Year(), portfolioID, userRealizedLots, nil, now)
+	taxAfter := s.buildTaxReport(now.Year(), portfolioID, append(slices.Clone(userRealizedLots), simulatedLots...), nil, now)
+	result.RealizedProfit = taxAfter.RealizedProfit.Sub(taxBefore.RealizedProfit)
+	result.ExemptProfit = taxAfter.ExemptProfit.Sub(taxBefore.ExemptProfit)
+	result.TaxBefore = taxBefore.UserTax
+	result.TaxAfter = taxAfter.UserTax
+
+	return result, nil
+}
+
+// simulatedSummary считает сводку и бумаги портфеля по переданному состоянию, не обращаясь к хранилищу
+func (s *InvestHelperService) simulatedSummary(
+	ctx context.Context,
+	portfolio model.Portfolio,
+	stocks []model.StockBase,
+	stocksInfoMap map[string]moexModel.StockInfo,
+	stockRemainings []model.StockRemaining,
+	cashBalance decimal.Decimal,
+) (model.PortfolioSummary, []model.Stock, error) {
+	avgPrices := avgPricesFromRemainings(stockRemainings)
+
+	summary, err := s.calculatePortfolioSummary(ctx, portfolio.PortfolioID, stocks, stocksInfoMap, avgPrices, &portfolio.PortfolioName)
+	if err != nil {
+		return model.PortfolioSummary{}, nil, err
+	}
+	summary.Portfolio = portfolio
+	s.applyCashBalance(&summary, cashBalance)
+
+	enrichedStocks, err := s.enrichStocks(ctx, stocks, summary.BalanceInsideIndex, stocksInfoMap, avgPrices, portfolio.PortfolioID)
+	if err != nil {
+		return model.PortfolioSummary{}, nil, err
+	}
+
+	return summary, enrichedStocks, nil
+}
+
+// avgPricesFromRemainings - средние цены покупки по открытым лотам, как их считает repo.GetAverageStockPurchasePrices
+func avgPricesFromRemainings(stockRemainings []model.StockRemaining) map[string]decimal.Decimal {
+	sums := make(map[string]decimal.Decimal)
+	quantities := make(map[string]int64)
+	for _, stockRemaining := range stockRemainings {
+		sums[stockRemaining.Ticker] = sums[stockRemaining.Ticker].Add(stockRemaining.Price.Mul(decimal.NewFromInt(int64(stockRemaining.Quantity))))
+		quantities[stockRemaining.Ticker] += int64(stockRemaining.Quantity)
+	}
+
+	avgPrices := make(map[string]decimal.Decimal, len(sums))
+	for ticker, sum := range sums {
+		if quantities[ticker] > 0 {
+			avgPrices[ticker] = sum.Div(decimal.NewFromInt(quantities[ticker]))
+		}
+	}
+
+	return avgPrices
+}
+
+// consumeStockRemainingsInMemory списывает sellQuantity акций из лотов по FIFO так же, как consumeStockRemainings,
+// но без записи в БД. Возвращает оставшиеся лоты и списанные части лотов.
+func consumeStockRemainingsInMemory(stockRemainings []model.StockRemaining, ticker string, sellQuantity int) (left, consumed []model.StockRemaining) {
+	left = make([]model.StockRemaining, 0, len(stockRemainings))
+	for _, stockRemaining := range stockRemainings {
+		if stockRemaining.Ticker != ticker || sellQuantity <= 0 {
+			left = append(left, stockRemaining)
+			continue
+		}
+
+		part := stockRemaining
+		part.Quantity = min(sellQuantity, stockRemaining.Quantity)
+		consumed = append(consumed, part)
+		sellQuantity -= part.Quantity
+
+		if stockRemaining.Quantity > part.Quantity {
+			stockRemaining.Quantity -= part.Quantity
+			left = append(left, stockRemaining)
+		}
+	}
+
+	return left, consumed
+}
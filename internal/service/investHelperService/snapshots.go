@@ -0,0 +1,151 @@
+package investHelperService
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/data/repository"
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+	"github.com/shopspring/decimal"
+)
+
+// TakePortfolioSnapshots считает сводку по каждому портфелю и сохраняет ее как снапшот за текущий день
+func (s *InvestHelperService) TakePortfolioSnapshots(ctx context.Context) error {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.TakePortfolioSnapshots"
+
+	slog.Debug("TakePortfolioSnapshots start", slog.String("rqID", rqID), slog.String("op", op))
+
+	stocksByPortfolios, err := s.repo.GetAllStocks(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(stocksByPortfolios) == 0 {
+		return nil
+	}
+
+	// берем уникальные тикеры и получаем по ним stocksInfoMap
+	m := make(map[string]struct{})
+	uniqueTickers := make([]string, 0)
+	for _, stocks := range stocksByPortfolios {
+		for _, stock := range stocks {
+			if _, ok := m[stock.Ticker]; ok {
+				continue
+			}
+			m[stock.Ticker] = struct{}{}
+			uniqueTickers = append(uniqueTickers, stock.Ticker)
+		}
+	}
+
+	stocksInfoMap, err := s.getStocksInfo(ctx, uniqueTickers)
+	if err != nil {
+		return err
+	}
+
+	snapshotDate := time.Now().Truncate(24 * time.Hour)
+	// имя портфеля в снапшоте не нужно, пустая строка избавляет от лишнего запроса в БД
+	portfolioName := ""
+	var saveErrs []error
+	for portfolioID, stocks := range stocksByPortfolios {
+		summary, err := s.calculatePortfolioSummary(ctx, portfolioID, stocks, stocksInfoMap, nil, &portfolioName)
+		if err != nil {
+			slog.Error("can't calculate portfolio summary", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", portfolioID), slog.String("err", err.Error()))
+			continue
+		}
+
+		snapshot := model.PortfolioSnapshot{
+			PortfolioID:           portfolioID,
+			SnapshotDate:          snapshotDate,
+			BalanceInsideIndex:    summary.BalanceInsideIndex,
+			BalanceOutsideIndex:   summary.BalanceOutsideIndex,
+			GrowthSumInsideIndex:  summary.GrowthSumInsideIndex,
+			GrowthSumOutsideIndex: summary.GrowthSumOutsideIndex,
+			IndexOffset:           summary.IndexOffset,
+			Stocks:                make([]model.SnapshotStock, 0, len(stocks)),
+		}
+		for _, stock := range stocks {
+			snapshot.Stocks = append(snapshot.Stocks, model.SnapshotStock{
+				Ticker:   stock.Ticker,
+				Quantity: stock.Quantity,
+				Price:    stocksInfoMap[stock.Ticker].Price,
+			})
+		}
+
+		err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+			return s.repo.SavePortfolioSnapshot(ctx, snapshot)
+		})
+		if err != nil {
+			// ошибка по одному портфелю не должна оставлять без снапшота остальные
+			slog.Error("can't save portfolio snapshot", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", portfolioID), slog.String("err", err.Error()))
+			saveErrs = append(saveErrs, fmt.Errorf("portfolio %d: %w", portfolioID, err))
+			continue
+		}
+	}
+
+	slog.Debug("TakePortfolioSnapshots completed", slog.String("rqID", rqID), slog.String("op", op), slog.Int("portfolios", len(stocksByPortfolios)))
+
+	return errors.Join(saveErrs...)
+}
+
+// GetPortfolioHistory сравнивает текущую стоимость портфеля со снапшотами за 1W/1M/YTD/1Y
+func (s *InvestHelperService) GetPortfolioHistory(ctx context.Context, portfolioID int64) (history model.PortfolioHistory, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.GetPortfolioHistory"
+
+	slog.Debug("GetPortfolioHistory start", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", portfolioID))
+	defer func() {
+		slog.Debug("GetPortfolioHistory finished", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", portfolioID))
+	}()
+
+	summary, err := s.GetPortfolioSummaryInfo(ctx, portfolioID)
+	if err != nil {
+		return model.PortfolioHistory{}, err
+	}
+
+	history = model.PortfolioHistory{
+		PortfolioID:   portfolioID,
+		PortfolioName: summary.PortfolioName,
+		CurrentValue:  summary.BalanceInsideIndex.Add(summary.BalanceOutsideIndex),
+	}
+
+	today := time.Now().Truncate(24 * time.Hour)
+	periods := []struct {
+		label string
+		date  time.Time
+	}{
+		{label: "1W", date: today.AddDate(0, 0, -7)},
+		{label: "1M", date: today.AddDate(0, -1, 0)},
+		{label: "YTD", date: time.Date(today.Year(), time.January, 1, 0, 0, 0, 0, today.Location())},
+		{label: "1Y", date: today.AddDate(-1, 0, 0)},
+	}
+
+	for _, period := range periods {
+		change := model.PortfolioPeriodChange{Label: period.label}
+
+		snapshot, err := s.repo.GetPortfolioSnapshotOnDate(ctx, portfolioID, period.date)
+		if err != nil {
+			if !errors.Is(err, repository.ErrNotFound) {
+				return model.PortfolioHistory{}, err
+			}
+			history.Periods = append(history.Periods, change)
+			continue
+		}
+
+		change.Available = true
+		change.SnapshotDate = snapshot.SnapshotDate
+		change.StartValue = snapshot.BalanceInsideIndex.Add(snapshot.BalanceOutsideIndex)
+		change.Change = history.CurrentValue.Sub(change.StartValue)
+		if change.StartValue.IsPositive() {
+			change.ChangePercent = change.Change.Div(change.StartValue).Mul(decimal.NewFromInt(100))
+		}
+
+		history.Periods = append(history.Periods, change)
+	}
+
+	return history, nil
+}
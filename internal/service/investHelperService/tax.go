@@ -0,0 +1,244 @@
+package investHelperService
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+	"github.com/shopspring/decimal"
+)
+
+// ЛДВ - льгота за долгосрочное владение: прибыль по бумагам, которыми владели больше 3 лет, не облагается НДФЛ.
+// Лимит льготы (3 млн ₽ за каждый год владения) не учитывается - для частного портфеля он практически недостижим.
+const ldvHoldingYears = 3
+
+var (
+	ndflBaseRate      = decimal.RequireFromString("0.13")
+	ndflIncreasedRate = decimal.RequireFromString("0.15")
+)
+
+// ldvEligibleFrom возвращает момент, после которого продажа лота подпадает под ЛДВ
+func ldvEligibleFrom(buyDate time.Time) time.Time {
+	return buyDate.AddDate(ldvHoldingYears, 0, 0)
+}
+
+// ndflProgressiveThreshold - доход, выше которого применяется ставка 15%. До 2021 года ставка была плоской.
+func ndflProgressiveThreshold(year int) (threshold decimal.Decimal, ok bool) {
+	switch {
+	case year >= 2025:
+		return decimal.NewFromInt(2_400_000), true
+	case year >= 2021:
+		return decimal.NewFromInt(5_000_000), true
+	default:
+		return decimal.Decimal{}, false
+	}
+}
+
+func calculateNDFL(taxBase decimal.Decimal, year int) decimal.Decimal {
+	if !taxBase.IsPositive() {
+		return decimal.Decimal{}
+	}
+
+	threshold, ok := ndflProgressiveThreshold(year)
+	if !ok || taxBase.LessThanOrEqual(threshold) {
+		return taxBase.Mul(ndflBaseRate).Round(0)
+	}
+
+	return threshold.Mul(ndflBaseRate).Add(taxBase.Sub(threshold).Mul(ndflIncreasedRate)).Round(0)
+}
+
+// GetTaxReport считает оценку НДФЛ портфеля за год и лоты, подпадающие под ЛДВ.
+// НДФЛ считается с человека за год, поэтому порог ставки 15% и зачет убытков применяются к продажам всех портфелей
+// пользователя, а портфелю достается доля налога по его налоговой базе.
+func (s *InvestHelperService) GetTaxReport(ctx context.Context, portfolioID int64, year int) (report model.TaxReport, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.GetTaxReport"
+
+	slog.Debug("GetTaxReport start", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", portfolioID), slog.Int("year", year))
+	defer func() {
+		slog.Debug("GetTaxReport finished", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", portfolioID))
+	}()
+
+	portfolio, err := s.repo.GetPortfolio(ctx, portfolioID)
+	if err != nil {
+		return model.TaxReport{}, err
+	}
+
+	userRealizedLots, err := s.repo.GetUserRealizedLots(ctx, portfolioID)
+	if err != nil {
+		return model.TaxReport{}, err
+	}
+
+	stockRemainings, err := s.repo.GetStockRemainings(ctx, portfolioID)
+	if err != nil {
+		return model.TaxReport{}, err
+	}
+
+	report = s.buildTaxReport(year, portfolioID, userRealizedLots, stockRemainings, time.Now())
+	report.PortfolioName = portfolio.PortfolioName
+
+	return report, nil
+}
+
+// getTaxReports строит отчеты по всем годам, в которых были продажи в портфеле, и по текущему году
+func (s *InvestHelperService) getTaxReports(ctx context.Context, portfolioID int64, portfolioName string) ([]model.TaxReport, error) {
+	userRealizedLots, err := s.repo.GetUserRealizedLots(ctx, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+
+	stockRemainings, err := s.repo.GetStockRemainings(ctx, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	years := map[int]struct{}{now.Year(): {}}
+	for _, lot := range userRealizedLots {
+		if lot.PortfolioID == portfolioID {
+			years[lot.SellDate.Year()] = struct{}{}
+		}
+	}
+
+	sortedYears := make([]int, 0, len(years))
+	for year := range years {
+		sortedYears = append(sortedYears, year)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(sortedYears)))
+
+	reports := make([]model.TaxReport, 0, len(sortedYears))
+	for _, year := range sortedYears {
+		report := s.buildTaxReport(year, portfolioID, userRealizedLots, stockRemainings, now)
+		report.PortfolioName = portfolioName
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+// buildTaxReport считает отчет портфеля portfolioID за год по закрытым лотам всех портфелей пользователя.
+// Налог считается с суммарной базы пользователя и делится между портфелями с положительной базой пропорционально ей:
+// убыток одного портфеля уменьшает налог остальных.
+func (s *InvestHelperService) buildTaxReport(
+	year int,
+	portfolioID int64,
+	userRealizedLots []model.RealizedLot,
+	stockRemainings []model.StockRemaining,
+	now time.Time,
+) model.TaxReport {
+	report := model.TaxReport{PortfolioID: portfolioID, Year: year}
+
+	taxBases := make(map[int64]decimal.Decimal)
+	for _, lot := range userRealizedLots {
+		if lot.SellDate.Year() != year {
+			continue
+		}
+
+		profit := lot.SellPrice.Sub(lot.BuyPrice).Mul(decimal.NewFromInt(int64(lot.Quantity)))
+		// убыток по лоту с ЛДВ по-прежнему уменьшает базу, освобождается только прибыль
+		exempt := profit.IsPositive() && lot.SellDate.After(ldvEligibleFrom(lot.BuyDate))
+		if !exempt {
+			taxBases[lot.PortfolioID] = taxBases[lot.PortfolioID].Add(profit)
+		}
+
+		if lot.PortfolioID != portfolioID {
+			continue
+		}
+		report.RealizedProfit = report.RealizedProfit.Add(profit)
+		if exempt {
+			report.ExemptProfit = report.ExemptProfit.Add(profit)
+			report.ExemptLots = append(report.ExemptLots, lot)
+		}
+	}
+
+	var positiveBases decimal.Decimal
+	for _, taxBase := range taxBases {
+		report.UserTaxBase = report.UserTaxBase.Add(taxBase)
+		if taxBase.IsPositive() {
+			positiveBases = positiveBases.Add(taxBase)
+		}
+	}
+	report.UserTax = calculateNDFL(report.UserTaxBase, year)
+
+	report.TaxBase = report.RealizedProfit.Sub(report.ExemptProfit)
+	if report.TaxBase.IsPositive() && report.UserTax.IsPositive() {
+		report.Tax = report.UserTax.Mul(report.TaxBase).Div(positiveBases).Round(0)
+	}
+
+	// открытые лоты показываем только для текущего года - это состояние на сегодня
+	if year != now.Year() {
+		return report
+	}
+
+	warningBorder := now.AddDate(0, 0, s.cfg.Tax.LdvWarningDays)
+	for _, stockRemaining := range stockRemainings {
+		ldvLot := model.LdvLot{
+			Ticker:       stockRemaining.Ticker,
+			Quantity:     stockRemaining.Quantity,
+			BuyPrice:     stockRemaining.Price,
+			BuyDate:      stockRemaining.DtCreate,
+			EligibleFrom: ldvEligibleFrom(stockRemaining.DtCreate),
+		}
+
+		switch {
+		case now.After(ldvLot.EligibleFrom):
+			report.EligibleLots = append(report.EligibleLots, ldvLot)
+		case !ldvLot.EligibleFrom.After(warningBorder):
+			report.UpcomingLots = append(report.UpcomingLots, ldvLot)
+		}
+	}
+
+	return report
+}
+
+// GetSellTaxWarnings возвращает части лотов, которые спишет продажа quantity акций по FIFO на дату tradeDate
+// и по которым ЛДВ наступит в пределах TAX_LDV_WARNING_DAYS после нее. Для сделки задним числом
+// лоты, купленные позже tradeDate, не учитываются.
+func (s *InvestHelperService) GetSellTaxWarnings(
+	ctx context.Context,
+	portfolioID int64,
+	ticker string,
+	quantity int,
+	tradeDate time.Time,
+) (lots []model.LdvLot, err error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.GetSellTaxWarnings"
+
+	slog.Debug("GetSellTaxWarnings start", slog.String("rqID", rqID), slog.String("op", op), slog.String("ticker", ticker), slog.Int("quantity", quantity))
+
+	stockRemainings, err := s.repo.GetStockRemainings(ctx, portfolioID)
+	if err != nil {
+		return nil, err
+	}
+
+	warningBorder := tradeDate.AddDate(0, 0, s.cfg.Tax.LdvWarningDays)
+	for _, stockRemaining := range stockRemainings {
+		if quantity <= 0 {
+			break
+		}
+		if stockRemaining.Ticker != ticker || stockRemaining.DtCreate.After(tradeDate) {
+			continue
+		}
+
+		consumed := min(quantity, stockRemaining.Quantity)
+		quantity -= consumed
+
+		eligibleFrom := ldvEligibleFrom(stockRemaining.DtCreate)
+		if tradeDate.After(eligibleFrom) || eligibleFrom.After(warningBorder) {
+			continue
+		}
+
+		lots = append(lots, model.LdvLot{
+			Ticker:       ticker,
+			Quantity:     consumed,
+			BuyPrice:     stockRemaining.Price,
+			BuyDate:      stockRemaining.DtCreate,
+			EligibleFrom: eligibleFrom,
+		})
+	}
+
+	return lots, nil
+}
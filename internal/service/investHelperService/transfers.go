@@ -0,0 +1,243 @@
+package investHelperService
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/KotFed0t/invest_helper_bot/data/repository"
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/internal/service"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+	"github.com/shopspring/decimal"
+)
+
+// TransferStock переводит quantity бумаг из одного портфеля пользователя в другой вместе с лотами FIFO: у зачисленных
+// лотов сохраняются цена и дата покупки, реализованный результат не фиксируется, деньги портфелей не меняются.
+// Если в портфеле-получателе бумаги нет, она добавляется с нулевым весом.
+func (s *InvestHelperService) TransferStock(
+	ctx context.Context,
+	chatID, fromPortfolioID, toPortfolioID int64,
+	ticker string,
+	quantity int,
+) (model.StockTransfer, error) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "InvestHelperService.TransferStock"
+
+	slog.Debug(
+		"TransferStock start",
+		slog.String("rqID", rqID),
+		slog.String("op", op),
+		slog.Int64("fromPortfolioID", fromPortfolioID),
+		slog.Int64("toPortfolioID", toPortfolioID),
+		slog.String("ticker", ticker),
+		slog.Int("quantity", quantity),
+	)
+
+	if fromPortfolioID == toPortfolioID || quantity <= 0 {
+		return model.StockTransfer{}, service.ErrNotFound
+	}
+
+	userID, err := s.repo.GetUserID(ctx, chatID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return model.StockTransfer{}, service.ErrNotFound
+		}
+		return model.StockTransfer{}, err
+	}
+
+	// переводить можно только между своими портфелями
+	portfolioNames, err := s.repo.GetAllPortfolioNamesByUserID(ctx, userID)
+	if err != nil {
+		return model.StockTransfer{}, err
+	}
+	fromName, fromOk := portfolioNames[fromPortfolioID]
+	toName, toOk := portfolioNames[toPortfolioID]
+	if !fromOk || !toOk {
+		return model.StockTransfer{}, service.ErrNotFound
+	}
+
+	// рыночная цена нужна только для расчета доходности, без нее перевод проводится по стоимости покупки
+	shortname := ticker
+	var marketPrice decimal.Decimal
+	stockInfo, err := s.GetStockInfo(ctx, ticker)
+	if err == nil {
+		shortname = stockInfo.Shortname
+		marketPrice = stockInfo.Price
+	} else {
+		slog.Warn("can't get stock info for transfer", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+	}
+
+	transfer := model.StockTransfer{
+		Ticker:            ticker,
+		Quantity:          quantity,
+		FromPortfolioID:   fromPortfolioID,
+		FromPortfolioName: fromName,
+		ToPortfolioID:     toPortfolioID,
+		ToPortfolioName:   toName,
+	}
+
+	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		// блокируем в порядке возрастания id, чтобы встречные переводы не взаимоблокировались
+		for _, portfolioID := range []int64{min(fromPortfolioID, toPortfolioID), max(fromPortfolioID, toPortfolioID)} {
+			err := s.repo.LockPortfolio(ctx, portfolioID)
+			if err != nil {
+				return err
+			}
+		}
+
+		stock, err := s.repo.GetStockFromPortfolio(ctx, ticker, fromPortfolioID)
+		if err != nil {
+			return err
+		}
+		if stock.Quantity < quantity {
+			return service.ErrNotEnoughStocks
+		}
+
+		lots, err := s.consumeStockRemainings(ctx, fromPortfolioID, ticker, quantity)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.repo.GetStockFromPortfolio(ctx, ticker, toPortfolioID)
+		if errors.Is(err, repository.ErrNotFound) {
+			err = s.repo.InsertStockToPortfolio(ctx, toPortfolioID, ticker, stock.Board, stock.InstrumentType)
+		}
+		if err != nil {
+			return err
+		}
+
+		delta := -quantity
+		err = s.repo.UpdatePortfolioStock(ctx, fromPortfolioID, ticker, nil, &delta)
+		if err != nil {
+			return err
+		}
+		err = s.repo.UpdatePortfolioStock(ctx, toPortfolioID, ticker, nil, &quantity)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		inOperations := make([]model.StockOperation, 0, len(lots))
+		stockRemainings := make([]model.StockRemaining, 0, len(lots))
+		for _, lot := range lots {
+			lotQuantity := decimal.NewFromInt(int64(lot.Quantity))
+			price := marketPrice
+			if !price.IsPositive() {
+				price = lot.Price
+			}
+			inOperations = append(inOperations, model.StockOperation{
+				OperationType:       model.StockOperationTransfer,
+				Ticker:              ticker,
+				Shortname:           shortname,
+				Quantity:            lot.Quantity,
+				Price:               lot.Price,
+				TotalPrice:          price.Mul(lotQuantity),
+				Currency:            "RUB",
+				DtCreate:            now,
+				DtAcquired:          lot.DtCreate,
+				TransferPortfolioID: fromPortfolioID,
+			})
+			stockRemainings = append(stockRemainings, model.StockRemaining{
+				PortfolioID: toPortfolioID,
+				Ticker:      ticker,
+				Quantity:    lot.Quantity,
+				Price:       lot.Price,
+				DtCreate:    lot.DtCreate,
+				DtUpdate:    now,
+			})
+			transfer.Cost = transfer.Cost.Add(lot.Price.Mul(lotQuantity))
+		}
+		transfer.Lots = len(lots)
+
+		var outTotal decimal.Decimal
+		for _, operation := range inOperations {
+			outTotal = outTotal.Add(operation.TotalPrice)
+		}
+		outOperation := model.StockOperation{
+			OperationType:       model.StockOperationTransfer,
+			Ticker:              ticker,
+			Shortname:           shortname,
+			Quantity:            -quantity,
+			Price:               outTotal.Div(decimal.NewFromInt(int64(quantity))),
+			TotalPrice:          outTotal.Neg(),
+			Currency:            "RUB",
+			DtCreate:            now,
+			TransferPortfolioID: toPortfolioID,
+		}
+
+		_, err = s.repo.InsertStockOperationsToHistory(ctx, fromPortfolioID, []model.StockOperation{outOperation})
+		if err != nil {
+			return err
+		}
+		_, err = s.repo.InsertStockOperationsToHistory(ctx, toPortfolioID, inOperations)
+		if err != nil {
+			return err
+		}
+
+		return s.repo.InsertStockRemainings(ctx, toPortfolioID, stockRemainings)
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return model.StockTransfer{}, service.ErrNotFound
+		}
+		return model.StockTransfer{}, err
+	}
+
+	// сбрасываем кэш обоих портфелей и пересчитываем средние цены
+	s.refreshPortfolioAfterReplay(ctx, fromPortfolioID)
+	s.refreshPortfolioAfterReplay(ctx, toPortfolioID)
+
+	slog.Info(
+		"stock transferred",
+		slog.String("rqID", rqID),
+		slog.String("op", op),
+		slog.Int64("fromPortfolioID", fromPortfolioID),
+		slog.Int64("toPortfolioID", toPortfolioID),
+		slog.String("ticker", ticker),
+		slog.Int("quantity", quantity),
+	)
+
+	return transfer, nil
+}
+
+// GetTransferTargets возвращает остальные портфели пользователя, в которые можно перевести бумаги, по алфавиту
+func (s *InvestHelperService) GetTransferTargets(ctx context.Context, chatID, portfolioID int64) ([]model.Portfolio, error) {
+	_, portfolios, err := s.getUserPortfolios(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(portfolios, func(portfolio model.Portfolio) bool {
+		return portfolio.PortfolioID == portfolioID
+	}), nil
+}
+
+// getUserPortfolios возвращает id пользователя и все его портфели по алфавиту
+func (s *InvestHelperService) getUserPortfolios(ctx context.Context, chatID int64) (int64, []model.Portfolio, error) {
+	userID, err := s.repo.GetUserID(ctx, chatID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return 0, nil, service.ErrNotFound
+		}
+		return 0, nil, err
+	}
+
+	portfolioNames, err := s.repo.GetAllPortfolioNamesByUserID(ctx, userID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	portfolios := make([]model.Portfolio, 0, len(portfolioNames))
+	for id, name := range portfolioNames {
+		portfolios = append(portfolios, model.Portfolio{PortfolioID: id, PortfolioName: name})
+	}
+	slices.SortFunc(portfolios, func(a, b model.Portfolio) int {
+		return strings.Compare(a.PortfolioName, b.PortfolioName)
+	})
+
+	return userID, portfolios, nil
+}
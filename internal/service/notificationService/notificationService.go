@@ -0,0 +1,99 @@
+package notificationService
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/KotFed0t/invest_helper_bot/config"
+	"github.com/KotFed0t/invest_helper_bot/internal/model"
+	"github.com/KotFed0t/invest_helper_bot/utils"
+)
+
+type InvestHelperService interface {
+	DetectIndexDrifts(ctx context.Context) ([]model.IndexDrift, error)
+	MarkIndexDriftNotified(ctx context.Context, portfolioID int64, signature string) error
+	DetectUpcomingDividends(ctx context.Context) ([]model.UpcomingDividend, error)
+	MarkUpcomingDividendNotified(ctx context.Context, dividend model.UpcomingDividend) error
+}
+
+type Notifier interface {
+	SendIndexDriftNotification(ctx context.Context, drift model.IndexDrift) error
+	SendUpcomingDividendNotification(ctx context.Context, dividend model.UpcomingDividend) error
+}
+
+// NotificationService - фоновые проверки, по итогам которых владельцу портфеля отправляется сообщение
+type NotificationService struct {
+	cfg                 *config.Config
+	investHelperService InvestHelperService
+	notifier            Notifier
+}
+
+func New(cfg *config.Config, investHelperService InvestHelperService, notifier Notifier) *NotificationService {
+	return &NotificationService{
+		cfg:                 cfg,
+		investHelperService: investHelperService,
+		notifier:            notifier,
+	}
+}
+
+func (s *NotificationService) NotifyIndexDrifts(ctx context.Context) error {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "NotificationService.NotifyIndexDrifts"
+
+	slog.Debug("NotifyIndexDrifts start", slog.String("rqID", rqID), slog.String("op", op))
+
+	drifts, err := s.investHelperService.DetectIndexDrifts(ctx)
+	if err != nil {
+		slog.Error("got error from investHelperService.DetectIndexDrifts", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return err
+	}
+
+	for _, drift := range drifts {
+		err = s.notifier.SendIndexDriftNotification(ctx, drift)
+		if err != nil {
+			// не помечаем как отправленное, попробуем при следующем запуске
+			slog.Error("can't send index drift notification", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", drift.PortfolioID), slog.String("err", err.Error()))
+			continue
+		}
+
+		err = s.investHelperService.MarkIndexDriftNotified(ctx, drift.PortfolioID, drift.Signature)
+		if err != nil {
+			slog.Error("got error from investHelperService.MarkIndexDriftNotified", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", drift.PortfolioID), slog.String("err", err.Error()))
+		}
+	}
+
+	slog.Debug("NotifyIndexDrifts completed", slog.String("rqID", rqID), slog.String("op", op), slog.Int("drifts", len(drifts)))
+
+	return nil
+}
+
+func (s *NotificationService) NotifyUpcomingDividends(ctx context.Context) error {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "NotificationService.NotifyUpcomingDividends"
+
+	slog.Debug("NotifyUpcomingDividends start", slog.String("rqID", rqID), slog.String("op", op))
+
+	dividends, err := s.investHelperService.DetectUpcomingDividends(ctx)
+	if err != nil {
+		slog.Error("got error from investHelperService.DetectUpcomingDividends", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return err
+	}
+
+	for _, dividend := range dividends {
+		err = s.notifier.SendUpcomingDividendNotification(ctx, dividend)
+		if err != nil {
+			// не помечаем как отправленное, попробуем при следующем запуске
+			slog.Error("can't send upcoming dividend notification", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", dividend.PortfolioID), slog.String("ticker", dividend.Ticker), slog.String("err", err.Error()))
+			continue
+		}
+
+		err = s.investHelperService.MarkUpcomingDividendNotified(ctx, dividend)
+		if err != nil {
+			slog.Error("got error from investHelperService.MarkUpcomingDividendNotified", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("portfolioID", dividend.PortfolioID), slog.String("err", err.Error()))
+		}
+	}
+
+	slog.Debug("NotifyUpcomingDividends completed", slog.String("rqID", rqID), slog.String("op", op), slog.Int("dividends", len(dividends)))
+
+	return nil
+}
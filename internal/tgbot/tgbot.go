@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/KotFed0t/invest_helper_bot/config"
+	"github.com/KotFed0t/invest_helper_bot/internal/converter/telebotConverter"
 	"github.com/KotFed0t/invest_helper_bot/internal/model"
 	"github.com/KotFed0t/invest_helper_bot/internal/model/tg/tgCallback.go"
 	"github.com/KotFed0t/invest_helper_bot/internal/transport/telegram"
@@ -57,11 +58,28 @@ func (b *TGBot) Stop() {
 	slog.Info("tgbot stopped")
 }
 
+// SendIndexDriftNotification отправляет владельцу портфеля сообщение о расхождении с индексом
+func (b *TGBot) SendIndexDriftNotification(ctx context.Context, drift model.IndexDrift) error {
+	text, markup := telebotConverter.IndexDriftNotification(drift)
+	_, err := b.bot.Send(tele.ChatID(drift.ChatID), text, markup)
+	return err
+}
+
+// SendUpcomingDividendNotification отправляет владельцу портфеля сообщение о приближающейся отсечке
+func (b *TGBot) SendUpcomingDividendNotification(ctx context.Context, dividend model.UpcomingDividend) error {
+	text, markup := telebotConverter.UpcomingDividendNotification(dividend)
+	_, err := b.bot.Send(tele.ChatID(dividend.ChatID), text, markup)
+	return err
+}
+
 func (b *TGBot) setupRoutes() {
 	// commands
 	b.bot.Handle("/start", b.ctrl.Start)
 	b.bot.Handle("/create_stocks_portfolio", b.ctrl.InitStocksPortfolioCreation)
 	b.bot.Handle("/my_portfolios", b.ctrl.GetPortfolios)
+	b.bot.Handle("/undo", b.ctrl.UndoLastOperation)
+	b.bot.Handle("/corporate_action", b.ctrl.ApplyCorporateAction)
+	b.bot.Handle("/distribute_contribution", b.ctrl.DistributeContribution)
 
 	// text
 	b.bot.Handle(tele.OnText, func(c tele.Context) error {
@@ -91,12 +109,62 @@ func (b *TGBot) setupRoutes() {
 			return b.ctrl.ProcessChangePrice(c)
 		case model.ExpectingPurchaseSum:
 			return b.ctrl.ProcessCalculatePurchase(c)
+		case model.ExpectingRebalanceContribution:
+			return b.ctrl.ProcessRebalance(c)
+		case model.ExpectingPurchaseMaxPerTicker:
+			return b.ctrl.ProcessSetPurchaseMaxPerTicker(c)
+		case model.ExpectingPurchaseMinOrder:
+			return b.ctrl.ProcessSetPurchaseMinOrder(c)
+		case model.ExpectingContributionAllocations:
+			return b.ctrl.ProcessSetContributionAllocations(c)
+		case model.ExpectingContributionSum:
+			return b.ctrl.ProcessDistributeContribution(c)
+		case model.ExpectingSimulationScenario:
+			return b.ctrl.ProcessSimulatePortfolio(c)
+		case model.ExpectingDividendAmount:
+			return b.ctrl.ProcessAddDividend(c)
+		case model.ExpectingCashAmount:
+			return b.ctrl.ProcessCashOperation(c)
+		case model.ExpectingCommission:
+			return b.ctrl.ProcessChangeCommission(c)
+		case model.ExpectingTradeDate:
+			return b.ctrl.ProcessChangeTradeDate(c)
+		case model.ExpectingCommissionPercent:
+			return b.ctrl.ProcessSetCommissionPercent(c)
+		case model.ExpectingOperationQuantity, model.ExpectingOperationPrice, model.ExpectingOperationCommission:
+			return b.ctrl.ProcessEditOperation(c)
+		case model.ExpectingIndexID:
+			return b.ctrl.ProcessSyncWithIndex(c)
+		case model.ExpectingTransferQuantity:
+			return b.ctrl.ProcessTransferStock(c)
 		default:
 			slog.Error("unexpected chatSession action", slog.String("rqID", rqID), slog.Any("state", chatSession.Action))
 			return c.Send("сначала введите одну из команд")
 		}
 	})
 
+	// documents
+	b.bot.Handle(tele.OnDocument, func(c tele.Context) error {
+		ctx := utils.CreateCtxWithRqID(c)
+		rqID := utils.GetRequestIDFromCtx(ctx)
+		chatSession, err := b.session.GetSession(ctx, strconv.FormatInt(c.Chat().ID, 10))
+		if err != nil {
+			slog.Error("got error from session.GetSession", slog.String("rqID", rqID), slog.String("err", err.Error()))
+			return c.Send("что-то пошло не так...")
+		}
+
+		c.Set("session", chatSession)
+
+		switch chatSession.Action {
+		case model.ExpectingBrokerReport:
+			return b.ctrl.ProcessBrokerReport(c)
+		case model.ExpectingPortfolioCsv:
+			return b.ctrl.ProcessPortfolioCsv(c)
+		default:
+			return c.Send("чтобы загрузить файл, откройте портфель и нажмите \"загрузить отчет брокера\" или \"CSV\"")
+		}
+	})
+
 	// callbacks
 	b.bot.Handle(tele.OnCallback, func(c tele.Context) error {
 		callbackBtnText := strings.TrimPrefix(c.Callback().Data, "\f")
@@ -122,6 +190,22 @@ func (b *TGBot) setupRoutes() {
 			return b.ctrl.ProcessBackToPortfolio(c)
 		case callbackBtnText == tgCallback.CalculatePurchase:
 			return b.ctrl.InitCalculatePurchase(c)
+		case callbackBtnText == tgCallback.RebalancePortfolio:
+			return b.ctrl.InitRebalance(c)
+		case callbackBtnText == tgCallback.ToggleAllowSells:
+			return b.ctrl.ToggleAllowSells(c)
+		case callbackBtnText == tgCallback.SetPurchaseMaxPerTicker:
+			return b.ctrl.InitSetPurchaseMaxPerTicker(c)
+		case callbackBtnText == tgCallback.SetPurchaseMinOrder:
+			return b.ctrl.InitSetPurchaseMinOrder(c)
+		case callbackBtnText == tgCallback.DistributeContribution:
+			return b.ctrl.DistributeContribution(c)
+		case callbackBtnText == tgCallback.SetContributionAllocations:
+			return b.ctrl.InitSetContributionAllocations(c)
+		case callbackBtnText == tgCallback.ApplyContributionPlan:
+			return b.ctrl.ApplyContributionPlan(c)
+		case callbackBtnText == tgCallback.SimulatePortfolio:
+			return b.ctrl.InitSimulatePortfolio(c)
 		case callbackBtnText == tgCallback.BackToPortolioList:
 			return b.ctrl.ProcessBackToPortfolioList(c)
 		case callbackBtnText == tgCallback.RebalanceWeights:
@@ -136,6 +220,54 @@ func (b *TGBot) setupRoutes() {
 			return b.ctrl.ApplyCalculatedPurchaseToPortfolio(c)
 		case callbackBtnText == tgCallback.CreatePortfolio:
 			return b.ctrl.InitStocksPortfolioCreation(c)
+		case callbackBtnText == tgCallback.SyncWithIndex:
+			return b.ctrl.InitSyncWithIndex(c)
+		case callbackBtnText == tgCallback.UnlinkIndex:
+			return b.ctrl.UnlinkIndex(c)
+		case callbackBtnText == tgCallback.PortfolioHistory:
+			return b.ctrl.PortfolioHistory(c)
+		case callbackBtnText == tgCallback.TaxReport:
+			return b.ctrl.TaxReport(c)
+		case callbackBtnText == tgCallback.StockDividends:
+			return b.ctrl.StockDividends(c)
+		case callbackBtnText == tgCallback.AddDividend:
+			return b.ctrl.InitAddDividend(c)
+		case callbackBtnText == tgCallback.ToggleDividendNotifications:
+			return b.ctrl.ToggleDividendNotifications(c)
+		case callbackBtnText == tgCallback.PortfolioCash:
+			return b.ctrl.PortfolioCash(c)
+		case callbackBtnText == tgCallback.CalculatePurchaseWithCash:
+			return b.ctrl.CalculatePurchaseWithCash(c)
+		case callbackBtnText == tgCallback.ChangeCommission:
+			return b.ctrl.InitChangeCommission(c)
+		case callbackBtnText == tgCallback.ChangeTradeDate:
+			return b.ctrl.InitChangeTradeDate(c)
+		case callbackBtnText == tgCallback.SetCommissionPercent:
+			return b.ctrl.InitSetCommissionPercent(c)
+		case callbackBtnText == tgCallback.OperationsHistory:
+			return b.ctrl.OperationsHistory(c)
+		case callbackBtnText == tgCallback.EditOperationQuantity,
+			callbackBtnText == tgCallback.EditOperationPrice,
+			callbackBtnText == tgCallback.EditOperationCommission:
+			return b.ctrl.InitEditOperation(c)
+		case callbackBtnText == tgCallback.InitDeleteOperation:
+			return b.ctrl.InitDeleteOperation(c)
+		case callbackBtnText == tgCallback.ProcessDeleteOperation:
+			return b.ctrl.ProcessDeleteOperation(c)
+		case callbackBtnText == tgCallback.ImportBrokerReport:
+			return b.ctrl.InitImportBrokerReport(c)
+		case callbackBtnText == tgCallback.ApplyBrokerImport:
+			return b.ctrl.ApplyBrokerImport(c)
+		case callbackBtnText == tgCallback.PortfolioCsv:
+			return b.ctrl.PortfolioCsv(c)
+		case callbackBtnText == tgCallback.ExportPortfolioCsv:
+			return b.ctrl.ExportPortfolioCsv(c)
+		case callbackBtnText == tgCallback.ImportPortfolioCsv:
+			return b.ctrl.InitImportPortfolioCsv(c)
+		case callbackBtnText == tgCallback.CreatePortfolioFromCsv:
+			return b.ctrl.InitCreatePortfolioFromCsv(c)
+		case callbackBtnText == tgCallback.TransferStock:
+			return b.ctrl.InitTransferStock(c)
 		case callbackBtnText == tgCallback.PageNumber:
 			return nil
 		case strings.HasPrefix(callbackBtnText, tgCallback.EditStockPrefix):
@@ -146,6 +278,24 @@ func (b *TGBot) setupRoutes() {
 			return b.ctrl.GetPortfolios(c)
 		case strings.HasPrefix(callbackBtnText, tgCallback.EditPortfolioPrefix):
 			return b.ctrl.GoToEditPortfolio(c)
+		case strings.HasPrefix(callbackBtnText, tgCallback.TaxReportYearPrefix):
+			return b.ctrl.TaxReport(c)
+		case strings.HasPrefix(callbackBtnText, tgCallback.ToOperationsPage):
+			return b.ctrl.OperationsHistory(c)
+		case strings.HasPrefix(callbackBtnText, tgCallback.EditOperationPrefix):
+			return b.ctrl.GoToEditOperation(c)
+		case strings.HasPrefix(callbackBtnText, tgCallback.CashOperationPrefix):
+			return b.ctrl.InitCashOperation(c)
+		case strings.HasPrefix(callbackBtnText, tgCallback.ApplyIndexWeightsPrefix):
+			return b.ctrl.ApplyIndexWeights(c)
+		case strings.HasPrefix(callbackBtnText, tgCallback.TransferToPortfolioPrefix):
+			return b.ctrl.ChooseTransferTarget(c)
+		case strings.HasPrefix(callbackBtnText, tgCallback.PurchaseStrategyPrefix):
+			return b.ctrl.SetPurchaseStrategy(c)
+		case strings.HasPrefix(callbackBtnText, tgCallback.PurchaseExclusionPrefix):
+			return b.ctrl.TogglePurchaseExclusion(c)
+		case strings.HasPrefix(callbackBtnText, tgCallback.UndoOperationPrefix):
+			return b.ctrl.UndoOperation(c)
 		default:
 			return c.Send("callback не опознан")
 		}
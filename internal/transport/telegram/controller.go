@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -29,16 +31,51 @@ type InvestHelperService interface {
 	GetStockInfo(ctx context.Context, ticker string) (stockInfo moexModel.StockInfo, err error)
 	GetPortfolioStockInfo(ctx context.Context, ticker string, portfolioID int64) (model.Stock, error)
 	AddStockToPortfolio(ctx context.Context, ticker string, portfolioID, chatID int64) (model.Stock, error)
-	SaveStockChangesToPortfolio(ctx context.Context, portfolioID int64, ticker string, weight *decimal.Decimal, quantity *int, price *decimal.Decimal) (model.Stock, error)
+	SaveStockChangesToPortfolio(ctx context.Context, portfolioID int64, ticker string, changes model.StockChanges) (stock model.Stock, operationID int64, err error)
 	DeleteStockFromPortfolio(ctx context.Context, portfolioID int64, ticker string) error
 	GetPortfolioPage(ctx context.Context, portfolioID int64, page int) (model.PortfolioPage, error)
-	CalculatePurchase(ctx context.Context, portfolioID int64, purchaseSum decimal.Decimal) ([]model.StockPurchase, error)
+	CalculatePurchase(ctx context.Context, portfolioID int64, purchaseSum decimal.Decimal, constraints model.PurchaseConstraints) ([]model.StockPurchase, error)
 	GetPortfolios(ctx context.Context, chatID int64, page int) (portfolios []model.Portfolio, hasNextPage bool, err error)
 	RebalanceWeights(ctx context.Context, portfolioID int64) error
 	DeletePortfolio(ctx context.Context, portfolioID int64) error
 	GeneratePortfoliosReport(ctx context.Context, chatID int64) (fileBytes []byte, filename string, err error)
 	UploadFileToCloud(ctx context.Context, reader io.Reader, filename string) (downloadLink string, err error)
 	ApplyCalculatedPurchaseToPortfolio(ctx context.Context, portfolioID int64, stocksToPurchase []model.StockPurchase) error
+	SyncWeightsWithIndex(ctx context.Context, portfolioID int64, indexID string) (model.IndexSyncResult, error)
+	SyncWeightsWithLinkedIndex(ctx context.Context, portfolioID int64) (model.IndexSyncResult, error)
+	UnlinkPortfolioIndex(ctx context.Context, portfolioID int64) error
+	GetPortfolioHistory(ctx context.Context, portfolioID int64) (model.PortfolioHistory, error)
+	GetTaxReport(ctx context.Context, portfolioID int64, year int) (model.TaxReport, error)
+	GetSellTaxWarnings(ctx context.Context, portfolioID int64, ticker string, quantity int, tradeDate time.Time) ([]model.LdvLot, error)
+	AddManualDividend(ctx context.Context, portfolioID int64, ticker string, netAmount decimal.Decimal, paymentDate time.Time) error
+	ToggleDividendNotifications(ctx context.Context, portfolioID int64) (enabled bool, err error)
+	AddCashOperation(ctx context.Context, portfolioID int64, operationType model.CashOperationType, amount decimal.Decimal) error
+	GetCashBalance(ctx context.Context, portfolioID int64) (decimal.Decimal, error)
+	GetPortfolioCash(ctx context.Context, portfolioID int64) (model.PortfolioCash, error)
+	SetCommissionPercent(ctx context.Context, portfolioID int64, percent decimal.Decimal) error
+	GetOperationsHistoryPage(ctx context.Context, portfolioID int64, page int) (model.OperationsHistoryPage, error)
+	GetStockOperation(ctx context.Context, portfolioID, operationID int64) (model.StockOperation, error)
+	UpdateStockOperation(ctx context.Context, portfolioID, operationID int64, changes model.OperationChanges) (model.StockOperation, error)
+	DeleteStockOperation(ctx context.Context, portfolioID, operationID int64) error
+	PreviewBrokerReport(ctx context.Context, portfolioID int64, filename string, content []byte) (model.BrokerImportPreview, error)
+	ImportBrokerReport(ctx context.Context, portfolioID int64, report model.BrokerReport) (model.BrokerImportResult, error)
+	ExportPortfolioCsv(ctx context.Context, portfolioID int64) ([]model.CsvFile, error)
+	ImportPortfolioCsv(ctx context.Context, chatID, portfolioID int64, portfolioName string, content []byte) (model.CsvImportResult, error)
+	ApplyCorporateAction(ctx context.Context, action model.CorporateAction) (model.CorporateActionResult, error)
+	GetTransferTargets(ctx context.Context, chatID, portfolioID int64) ([]model.Portfolio, error)
+	TransferStock(ctx context.Context, chatID, fromPortfolioID, toPortfolioID int64, ticker string, quantity int) (model.StockTransfer, error)
+	UndoLastOperation(ctx context.Context, chatID int64) ([]model.LastOperation, error)
+	UndoOperation(ctx context.Context, chatID, operationID int64) (model.LastOperation, error)
+	GetPurchaseStrategy(ctx context.Context, portfolioID int64) (model.PurchaseStrategy, error)
+	SetPurchaseStrategy(ctx context.Context, portfolioID int64, strategy model.PurchaseStrategy) error
+	GetPortfolioSummaryInfo(ctx context.Context, portfolioID int64) (model.PortfolioSummary, error)
+	CalculateRebalance(ctx context.Context, portfolioID int64, contribution decimal.Decimal) (model.RebalancePlan, error)
+	ToggleAllowSells(ctx context.Context, portfolioID int64) (allowed bool, err error)
+	GetContributionSettings(ctx context.Context, chatID int64) ([]model.ContributionAllocation, []model.Portfolio, error)
+	SetContributionAllocations(ctx context.Context, chatID int64, allocations []model.ContributionAllocation) error
+	CalculateContribution(ctx context.Context, chatID int64, sum decimal.Decimal) (model.ContributionPlan, error)
+	ApplyContributionPlan(ctx context.Context, chatID int64, plan model.ContributionPlan) error
+	SimulatePortfolio(ctx context.Context, portfolioID int64, scenario model.SimulationScenario) (model.SimulationResult, error)
 }
 
 type Session interface {
@@ -378,6 +415,8 @@ func (ctrl *Controller) ProcessSellStock(c tele.Context) error {
 		chatSession.StockChanges = &model.StockChanges{Quantity: &sellQuantity}
 	}
 
+	ctrl.sendSellTaxWarning(ctx, c, chatSession)
+
 	chatSession.Action = model.DefaultAction
 	go ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
 
@@ -450,6 +489,185 @@ func (ctrl *Controller) ProcessChangePrice(c tele.Context) error {
 	return c.Send(telebotConverter.StockDetailResponse(stock, chatSession.StockChanges))
 }
 
+func (ctrl *Controller) InitChangeCommission(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession.Action = model.ExpectingCommission
+	err = ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
+	if err != nil {
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Edit("введите комиссию за всю сделку в рублях:")
+}
+
+func (ctrl *Controller) ProcessChangeCommission(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ProcessChangeCommission"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	input := strings.Replace(c.Message().Text, ",", ".", 1)
+
+	commission, err := decimal.NewFromString(input)
+	if err != nil || commission.IsNegative() {
+		return c.Send("комиссия должна быть числом не меньше 0, введите корректное значение:")
+	}
+
+	if chatSession.StockTicker == "" {
+		slog.Error("stockTicker is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	stock, err := ctrl.investHelperService.GetPortfolioStockInfo(ctx, chatSession.StockTicker, chatSession.PortfolioID)
+	if err != nil && !errors.Is(err, service.ErrActualStockInfoUnavailable) {
+		slog.Error("failed on investHelperService.GetPortfolioStockInfo", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.StockChanges != nil {
+		chatSession.StockChanges.Commission = &commission
+	} else {
+		chatSession.StockChanges = &model.StockChanges{Commission: &commission}
+	}
+
+	chatSession.Action = model.DefaultAction
+	go ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
+
+	return c.Send(telebotConverter.StockDetailResponse(stock, chatSession.StockChanges))
+}
+
+func (ctrl *Controller) InitChangeTradeDate(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession.Action = model.ExpectingTradeDate
+	err = ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
+	if err != nil {
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Edit("введите дату сделки в формате ДД.ММ.ГГГГ или ДД.ММ.ГГГГ ЧЧ:ММ (без времени сделка считается совершенной в начале дня):")
+}
+
+func (ctrl *Controller) ProcessChangeTradeDate(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ProcessChangeTradeDate"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	tradeDate, ok := parseTradeDate(strings.TrimSpace(c.Message().Text))
+	if !ok {
+		return c.Send("не удалось разобрать дату, введите ее в формате ДД.ММ.ГГГГ или ДД.ММ.ГГГГ ЧЧ:ММ:")
+	}
+	if tradeDate.After(time.Now()) {
+		return c.Send("дата сделки не может быть в будущем, введите корректное значение:")
+	}
+
+	if chatSession.StockTicker == "" {
+		slog.Error("stockTicker is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	stock, err := ctrl.investHelperService.GetPortfolioStockInfo(ctx, chatSession.StockTicker, chatSession.PortfolioID)
+	if err != nil && !errors.Is(err, service.ErrActualStockInfoUnavailable) {
+		slog.Error("failed on investHelperService.GetPortfolioStockInfo", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.StockChanges != nil {
+		chatSession.StockChanges.TradeDate = &tradeDate
+	} else {
+		chatSession.StockChanges = &model.StockChanges{TradeDate: &tradeDate}
+	}
+
+	ctrl.sendSellTaxWarning(ctx, c, chatSession)
+
+	chatSession.Action = model.DefaultAction
+	go ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
+
+	return c.Send(telebotConverter.StockDetailResponse(stock, chatSession.StockChanges))
+}
+
+// sendSellTaxWarning предупреждает, если продажа из сессии спишет лоты, которым на дату сделки осталось недолго до ЛДВ.
+// Вызывается после ввода количества и после смены даты сделки.
+func (ctrl *Controller) sendSellTaxWarning(ctx context.Context, c tele.Context, chatSession model.Session) {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.sendSellTaxWarning"
+
+	changes := chatSession.StockChanges
+	if changes == nil || changes.Quantity == nil || *changes.Quantity >= 0 {
+		return
+	}
+
+	tradeDate := time.Now()
+	if changes.TradeDate != nil {
+		tradeDate = *changes.TradeDate
+	}
+
+	ldvLots, err := ctrl.investHelperService.GetSellTaxWarnings(ctx, chatSession.PortfolioID, chatSession.StockTicker, -*changes.Quantity, tradeDate)
+	if err != nil {
+		slog.Warn("failed on investHelperService.GetSellTaxWarnings", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return
+	}
+	if len(ldvLots) > 0 {
+		_ = c.Send(telebotConverter.SellTaxWarning(ldvLots))
+	}
+}
+
+// parseTradeDate разбирает дату сделки, введенную пользователем. Сделка за сегодня без времени считается совершенной сейчас.
+func parseTradeDate(input string) (time.Time, bool) {
+	if tradeDate, err := time.ParseInLocation("02.01.2006 15:04", input, time.Local); err == nil {
+		return tradeDate, true
+	}
+
+	tradeDate, err := time.ParseInLocation("02.01.2006", input, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	now := time.Now()
+	if tradeDate.Year() == now.Year() && tradeDate.YearDay() == now.YearDay() {
+		return now, true
+	}
+	return tradeDate, true
+}
+
 func (ctrl *Controller) ProcessAddStockToPortfolio(c tele.Context) error {
 	ctx := utils.CreateCtxWithRqID(c)
 	rqID := utils.GetRequestIDFromCtx(ctx)
@@ -557,6 +775,7 @@ func (ctrl *Controller) ProcessBackToPortfolio(c tele.Context) error {
 	chatSession.StockChanges = nil
 	chatSession.StockTicker = ""
 	chatSession.StocksToPurchase = nil
+	chatSession.BrokerReport = nil
 	go ctrl.session.SetSession(context.WithoutCancel(ctx), strconv.FormatInt(c.Chat().ID, 10), chatSession)
 
 	return c.Edit(telebotConverter.PortfolioDetailsResponse(portfolioPage, ctrl.cfg.StocksPerPage))
@@ -594,22 +813,88 @@ func (ctrl *Controller) SaveStockChanges(c tele.Context) error {
 		return ctrl.ProcessBackToPortfolioList(c)
 	}
 
-	stock, err := ctrl.investHelperService.SaveStockChangesToPortfolio(
-		ctx,
-		chatSession.PortfolioID,
-		chatSession.StockTicker,
-		chatSession.StockChanges.NewTargetWeight,
-		chatSession.StockChanges.Quantity,
-		chatSession.StockChanges.CustomPrice,
-	)
+	stock, operationID, err := ctrl.investHelperService.SaveStockChangesToPortfolio(ctx, chatSession.PortfolioID, chatSession.StockTicker, *chatSession.StockChanges)
+	if errors.Is(err, service.ErrInvalidOperationHistory) {
+		return ctrl.sendAutoDeleteMsg(c, "на дату сделки бумаг в портфеле было меньше, чем в продаже. Укажите другую дату или количество")
+	}
 	if err != nil && !errors.Is(err, service.ErrActualStockInfoUnavailable) {
 		slog.Error("got error from investHelperService.SaveStockChangesToPortfolio", slog.String("rqID", rqID), slog.String("op", op))
 		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
 	}
 
+	withOperation := chatSession.StockChanges.Quantity != nil
 	chatSession.StockChanges = nil
 	go ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
 
+	if withOperation {
+		return c.Edit(telebotConverter.StockSavedResponse(stock, operationID))
+	}
+	return c.Edit(telebotConverter.StockDetailResponse(stock, nil))
+}
+
+// UndoLastOperation отменяет командой /undo последнюю пачку сделок пользователя
+func (ctrl *Controller) UndoLastOperation(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.UndoLastOperation"
+
+	lastOperations, err := ctrl.investHelperService.UndoLastOperation(ctx, c.Chat().ID)
+	if err != nil {
+		if errors.Is(err, service.ErrNothingToUndo) {
+			return ctrl.sendAutoDeleteMsg(c, fmt.Sprintf(
+				"нет сделок, записанных за последние %d мин., отменять нечего. Переводы и корпоративные действия через /undo не отменяются",
+				int(ctrl.cfg.UndoWindow.Minutes()),
+			))
+		}
+		if errors.Is(err, service.ErrOperationNotEditable) {
+			return ctrl.sendAutoDeleteMsg(c, operationNotEditableMsg)
+		}
+		slog.Error("failed on investHelperService.UndoLastOperation", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Send(telebotConverter.UndoneOperationsMessage(lastOperations))
+}
+
+// UndoOperation отменяет сделку по кнопке под карточкой бумаги после сохранения
+func (ctrl *Controller) UndoOperation(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.UndoOperation"
+
+	callbackStr := strings.TrimPrefix(c.Callback().Data, fmt.Sprintf("\f%s", tgCallback.UndoOperationPrefix))
+	operationID, err := strconv.ParseInt(callbackStr, 10, 64)
+	if err != nil {
+		slog.Error("invalid operationID in callback", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()), slog.String("callback", c.Callback().Data))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	undoneOperation, err := ctrl.investHelperService.UndoOperation(ctx, c.Chat().ID, operationID)
+	if err != nil {
+		if errors.Is(err, service.ErrNothingToUndo) {
+			return ctrl.sendAutoDeleteMsg(c, fmt.Sprintf("сделка уже отменена или записана больше %d мин. назад", int(ctrl.cfg.UndoWindow.Minutes())))
+		}
+		if errors.Is(err, service.ErrOperationNotEditable) {
+			return ctrl.sendAutoDeleteMsg(c, operationNotEditableMsg)
+		}
+		slog.Error("failed on investHelperService.UndoOperation", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	go ctrl.sendAutoDeleteMsg(c, telebotConverter.UndoneOperationsMessage([]model.LastOperation{undoneOperation}))
+
+	// перерисовываем карточку бумаги с восстановленными количеством и средней ценой
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil || chatSession.PortfolioID != undoneOperation.PortfolioID || chatSession.StockTicker != undoneOperation.Operation.Ticker {
+		return nil
+	}
+
+	stock, err := ctrl.investHelperService.GetPortfolioStockInfo(ctx, chatSession.StockTicker, chatSession.PortfolioID)
+	if err != nil && !errors.Is(err, service.ErrActualStockInfoUnavailable) {
+		slog.Error("failed on investHelperService.GetPortfolioStockInfo", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
 	return c.Edit(telebotConverter.StockDetailResponse(stock, nil))
 }
 
@@ -682,6 +967,8 @@ func (ctrl *Controller) GoToPortfolioPage(c tele.Context) error {
 
 func (ctrl *Controller) InitCalculatePurchase(c tele.Context) error {
 	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.InitCalculatePurchase"
 	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
 	if err != nil {
 		if errors.Is(err, session.ErrNotFound) {
@@ -691,12 +978,81 @@ func (ctrl *Controller) InitCalculatePurchase(c tele.Context) error {
 	}
 
 	chatSession.Action = model.ExpectingPurchaseSum
+	chatSession.PurchaseConstraints = model.PurchaseConstraints{}
 	err = ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
 	if err != nil {
 		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
 	}
 
-	return c.Edit("введите сумму закупки:")
+	cashBalance, err := ctrl.investHelperService.GetCashBalance(ctx, chatSession.PortfolioID)
+	if err != nil {
+		// без остатка денег можно рассчитать закуп на введенную сумму
+		slog.Warn("failed on investHelperService.GetCashBalance", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+	}
+
+	strategy, err := ctrl.investHelperService.GetPurchaseStrategy(ctx, chatSession.PortfolioID)
+	if err != nil {
+		slog.Warn("failed on investHelperService.GetPurchaseStrategy", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+	}
+
+	return c.Edit(telebotConverter.CalculatePurchaseRequest(cashBalance, strategy))
+}
+
+func (ctrl *Controller) SetPurchaseStrategy(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.SetPurchaseStrategy"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	strategy := strings.TrimPrefix(c.Callback().Data, fmt.Sprintf("\f%s", tgCallback.PurchaseStrategyPrefix))
+	err = ctrl.investHelperService.SetPurchaseStrategy(ctx, chatSession.PortfolioID, model.PurchaseStrategy(strategy))
+	if err != nil {
+		slog.Error("failed on investHelperService.SetPurchaseStrategy", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return ctrl.InitCalculatePurchase(c)
+}
+
+func (ctrl *Controller) CalculatePurchaseWithCash(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.CalculatePurchaseWithCash"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	cashBalance, err := ctrl.investHelperService.GetCashBalance(ctx, chatSession.PortfolioID)
+	if err != nil {
+		slog.Error("failed on investHelperService.GetCashBalance", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if !cashBalance.IsPositive() {
+		return c.Send("свободных денег нет, введите сумму закупки:")
+	}
+
+	return ctrl.calculatePurchase(ctx, c, chatSession, cashBalance)
 }
 
 func (ctrl *Controller) ProcessCalculatePurchase(c tele.Context) error {
@@ -723,21 +1079,30 @@ func (ctrl *Controller) ProcessCalculatePurchase(c tele.Context) error {
 		return c.Send("Сумма должна быть положительным числом > 0, введите корректное значение:")
 	}
 
-	stocksToPurchase, err := ctrl.investHelperService.CalculatePurchase(ctx, chatSession.PortfolioID, purchaseSum)
+	return ctrl.calculatePurchase(ctx, c, chatSession, purchaseSum)
+}
+
+func (ctrl *Controller) calculatePurchase(ctx context.Context, c tele.Context, chatSession model.Session, purchaseSum decimal.Decimal) error {
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.calculatePurchase"
+
+	stocksToPurchase, err := ctrl.investHelperService.CalculatePurchase(ctx, chatSession.PortfolioID, purchaseSum, chatSession.PurchaseConstraints)
 	if err != nil {
 		slog.Error("failed on investHelperService.CalculatePurchase", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
 		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
 	}
 
-	if len(stocksToPurchase) == 0 {
+	// с ограничениями показываем пустой расчет, чтобы их можно было ослабить без повторного ввода суммы
+	if len(stocksToPurchase) == 0 && chatSession.PurchaseConstraints.IsEmpty() {
 		return c.Send("нельзя купить соответствуя индексу на указанную сумму, введите сумму больше:")
 	}
 
 	chatSession.Action = model.DefaultAction
 	chatSession.StocksToPurchase = stocksToPurchase
+	chatSession.PurchaseSum = purchaseSum
 	go ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
 
-	texts, markup := telebotConverter.CalculatedStockPurchaseResponse(stocksToPurchase, purchaseSum)
+	texts, markup := telebotConverter.CalculatedStockPurchaseResponse(stocksToPurchase, purchaseSum, chatSession.PurchaseConstraints)
 	for _, text := range texts {
 		_ = c.Send(text)
 	}
@@ -745,30 +1110,243 @@ func (ctrl *Controller) ProcessCalculatePurchase(c tele.Context) error {
 	return c.Send("навигация:", markup)
 }
 
-func (ctrl *Controller) GetPortfolios(c tele.Context) error {
+// recalculatePurchase пересчитывает закуп на сумму последнего расчета с ограничениями из сессии
+func (ctrl *Controller) recalculatePurchase(ctx context.Context, c tele.Context, chatSession model.Session) error {
+	if !chatSession.PurchaseSum.IsPositive() {
+		return ctrl.InitCalculatePurchase(c)
+	}
+
+	return ctrl.calculatePurchase(ctx, c, chatSession, chatSession.PurchaseSum)
+}
+
+func (ctrl *Controller) TogglePurchaseExclusion(c tele.Context) error {
 	ctx := utils.CreateCtxWithRqID(c)
 	rqID := utils.GetRequestIDFromCtx(ctx)
-	op := "Controller.GetPortfolios"
-	var err error
-
-	page := 1
-	if c.Callback() != nil {
-		pageStr := strings.TrimPrefix(c.Callback().Data, fmt.Sprintf("\f%s", tgCallback.ToPortfolioListPage))
-		page, err = strconv.Atoi(pageStr)
-		if err != nil {
-			page = 1
+	op := "Controller.TogglePurchaseExclusion"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
 		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
 	}
 
-	portfolios, hasNextPage, err := ctrl.investHelperService.GetPortfolios(ctx, c.Chat().ID, page)
-	if err != nil {
-		slog.Error("failed on investHelperService.GetPortfolios", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
-		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
 	}
 
-	chatSession, _ := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
-	chatSession.CurPortfolioListPage = page
-	go ctrl.session.SetSession(context.WithoutCancel(ctx), strconv.FormatInt(c.Chat().ID, 10), chatSession)
+	ticker := strings.TrimPrefix(c.Callback().Data, fmt.Sprintf("\f%s", tgCallback.PurchaseExclusionPrefix))
+	excluded := chatSession.PurchaseConstraints.ExcludedTickers
+	if i := slices.Index(excluded, ticker); i >= 0 {
+		chatSession.PurchaseConstraints.ExcludedTickers = slices.Delete(excluded, i, i+1)
+	} else {
+		chatSession.PurchaseConstraints.ExcludedTickers = append(excluded, ticker)
+	}
+
+	return ctrl.recalculatePurchase(ctx, c, chatSession)
+}
+
+func (ctrl *Controller) InitSetPurchaseMaxPerTicker(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession.Action = model.ExpectingPurchaseMaxPerTicker
+	err = ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
+	if err != nil {
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Send("введите максимальную сумму закупа одной бумаги (0 - без ограничения):")
+}
+
+func (ctrl *Controller) ProcessSetPurchaseMaxPerTicker(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	maxPerTicker, err := decimal.NewFromString(strings.Replace(c.Message().Text, ",", ".", 1))
+	if err != nil || maxPerTicker.IsNegative() {
+		return c.Send("Сумма должна быть числом >= 0, введите корректное значение:")
+	}
+
+	chatSession.PurchaseConstraints.MaxPerTicker = maxPerTicker
+	return ctrl.recalculatePurchase(ctx, c, chatSession)
+}
+
+func (ctrl *Controller) InitSetPurchaseMinOrder(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession.Action = model.ExpectingPurchaseMinOrder
+	err = ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
+	if err != nil {
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Send("введите минимальную сумму заявки, меньшие заявки не предлагаются (0 - без ограничения):")
+}
+
+func (ctrl *Controller) ProcessSetPurchaseMinOrder(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	minOrderSum, err := decimal.NewFromString(strings.Replace(c.Message().Text, ",", ".", 1))
+	if err != nil || minOrderSum.IsNegative() {
+		return c.Send("Сумма должна быть числом >= 0, введите корректное значение:")
+	}
+
+	chatSession.PurchaseConstraints.MinOrderSum = minOrderSum
+	return ctrl.recalculatePurchase(ctx, c, chatSession)
+}
+
+func (ctrl *Controller) InitRebalance(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.InitRebalance"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	chatSession.Action = model.ExpectingRebalanceContribution
+	err = ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
+	if err != nil {
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	summary, err := ctrl.investHelperService.GetPortfolioSummaryInfo(ctx, chatSession.PortfolioID)
+	if err != nil {
+		slog.Error("failed on investHelperService.GetPortfolioSummaryInfo", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Edit(telebotConverter.RebalanceRequest(summary.AllowSells, ctrl.cfg.Rebalance.WeightTolerance))
+}
+
+func (ctrl *Controller) ToggleAllowSells(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ToggleAllowSells"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	_, err = ctrl.investHelperService.ToggleAllowSells(ctx, chatSession.PortfolioID)
+	if err != nil {
+		slog.Error("failed on investHelperService.ToggleAllowSells", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return ctrl.InitRebalance(c)
+}
+
+func (ctrl *Controller) ProcessRebalance(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ProcessRebalance"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	input := strings.Replace(c.Message().Text, ",", ".", 1)
+
+	contribution, err := decimal.NewFromString(input)
+	if err != nil || contribution.IsNegative() {
+		return c.Send("Сумма должна быть числом >= 0, введите корректное значение:")
+	}
+
+	plan, err := ctrl.investHelperService.CalculateRebalance(ctx, chatSession.PortfolioID, contribution)
+	if err != nil {
+		slog.Error("failed on investHelperService.CalculateRebalance", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession.Action = model.DefaultAction
+	chatSession.StocksToPurchase = plan.Trades
+	go ctrl.session.SetSession(context.WithoutCancel(ctx), strconv.FormatInt(c.Chat().ID, 10), chatSession)
+
+	texts, markup := telebotConverter.RebalancePlanResponse(plan)
+	for _, text := range texts {
+		_ = c.Send(text)
+	}
+
+	return c.Send("навигация:", markup)
+}
+
+func (ctrl *Controller) GetPortfolios(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.GetPortfolios"
+	var err error
+
+	page := 1
+	if c.Callback() != nil {
+		pageStr := strings.TrimPrefix(c.Callback().Data, fmt.Sprintf("\f%s", tgCallback.ToPortfolioListPage))
+		page, err = strconv.Atoi(pageStr)
+		if err != nil {
+			page = 1
+		}
+	}
+
+	portfolios, hasNextPage, err := ctrl.investHelperService.GetPortfolios(ctx, c.Chat().ID, page)
+	if err != nil {
+		slog.Error("failed on investHelperService.GetPortfolios", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession, _ := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	chatSession.CurPortfolioListPage = page
+	go ctrl.session.SetSession(context.WithoutCancel(ctx), strconv.FormatInt(c.Chat().ID, 10), chatSession)
 
 	// при пагинации нужен Edit
 	if c.Callback() != nil {
@@ -865,29 +1443,29 @@ func (ctrl *Controller) RebalanceWeights(c tele.Context) error {
 	return c.Edit(telebotConverter.PortfolioDetailsResponse(portfolioPage, ctrl.cfg.StocksPerPage))
 }
 
-func (ctrl *Controller) sendAutoDeleteMsg(c tele.Context, text string) error {
-	msg, err := c.Bot().Send(c.Chat(), text)
+func (ctrl *Controller) InitSyncWithIndex(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
 	if err != nil {
-		return err
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
 	}
 
-	time.AfterFunc(5*time.Second, func() {
-		c.Bot().Delete(msg)
-	})
-	return nil
-}
+	chatSession.Action = model.ExpectingIndexID
+	err = ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
+	if err != nil {
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
 
-func (ctrl *Controller) InitDeletePortfolio(c tele.Context) error {
-	return c.Edit(
-		"Подтвердите удаление портфеля. Это действие необратимо, будут удалены все данные по портфелю и история операций!",
-		telebotConverter.DeletePortfolioConfirmation(),
-	)
+	return c.Edit("введите код индекса Мосбиржи (например IMOEX или MOEXBC):")
 }
 
-func (ctrl *Controller) ProcessDeletePortfolio(c tele.Context) error {
+func (ctrl *Controller) ProcessSyncWithIndex(c tele.Context) error {
 	ctx := utils.CreateCtxWithRqID(c)
 	rqID := utils.GetRequestIDFromCtx(ctx)
-	op := "Controller.ProcessDeletePortfolio"
+	op := "Controller.ProcessSyncWithIndex"
 	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
 	if err != nil {
 		if errors.Is(err, session.ErrNotFound) {
@@ -901,27 +1479,57 @@ func (ctrl *Controller) ProcessDeletePortfolio(c tele.Context) error {
 		return ctrl.ProcessBackToPortfolioList(c)
 	}
 
-	err = ctrl.investHelperService.DeletePortfolio(ctx, chatSession.PortfolioID)
+	indexID := strings.ToUpper(strings.TrimSpace(c.Message().Text))
+
+	result, err := ctrl.investHelperService.SyncWeightsWithIndex(ctx, chatSession.PortfolioID, indexID)
 	if err != nil {
-		slog.Error("failed on investHelperService.DeletePortfolio", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		if errors.Is(err, service.ErrNotFound) {
+			return c.Send("не удалось найти состав указанного индекса, введите другой код:")
+		}
+		slog.Error("failed on investHelperService.SyncWeightsWithIndex", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
 		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
 	}
 
-	go ctrl.sendAutoDeleteMsg(c, "портфель успешно удален")
+	chatSession.Action = model.DefaultAction
+	go ctrl.session.SetSession(context.WithoutCancel(ctx), strconv.FormatInt(c.Chat().ID, 10), chatSession)
 
-	portfolios, hasNextPage, err := ctrl.investHelperService.GetPortfolios(ctx, c.Chat().ID, 1)
+	return c.Send(telebotConverter.IndexSyncResultResponse(result))
+}
+
+// ApplyIndexWeights - кнопка из уведомления о расхождении с индексом
+func (ctrl *Controller) ApplyIndexWeights(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ApplyIndexWeights"
+
+	callbackStr := strings.TrimPrefix(c.Callback().Data, fmt.Sprintf("\f%s", tgCallback.ApplyIndexWeightsPrefix))
+	portfolioID, err := strconv.ParseInt(callbackStr, 10, 64)
 	if err != nil {
-		slog.Error("failed on investHelperService.GetPortfolios", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		slog.Error("invalid portfolioID in callback", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()), slog.String("callback", c.Callback().Data))
 		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
 	}
 
-	return c.Edit(telebotConverter.PortfolioListResponse(portfolios, ctrl.cfg.PortfoliosPerPage, 1, hasNextPage))
+	result, err := ctrl.investHelperService.SyncWeightsWithLinkedIndex(ctx, portfolioID)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			return ctrl.sendAutoDeleteMsg(c, "портфель больше не привязан к индексу")
+		}
+		slog.Error("failed on investHelperService.SyncWeightsWithLinkedIndex", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession, _ := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	chatSession.PortfolioID = portfolioID
+	chatSession.Action = model.DefaultAction
+	go ctrl.session.SetSession(context.WithoutCancel(ctx), strconv.FormatInt(c.Chat().ID, 10), chatSession)
+
+	return c.Edit(telebotConverter.IndexSyncResultResponse(result))
 }
 
-func (ctrl *Controller) ApplyCalculatedPurchaseToPortfolio(c tele.Context) error {
+func (ctrl *Controller) UnlinkIndex(c tele.Context) error {
 	ctx := utils.CreateCtxWithRqID(c)
 	rqID := utils.GetRequestIDFromCtx(ctx)
-	op := "Controller.ApplyCalculatedPurchaseToPortfolio"
+	op := "Controller.UnlinkIndex"
 	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
 	if err != nil {
 		if errors.Is(err, session.ErrNotFound) {
@@ -935,53 +1543,1452 @@ func (ctrl *Controller) ApplyCalculatedPurchaseToPortfolio(c tele.Context) error
 		return ctrl.ProcessBackToPortfolioList(c)
 	}
 
-	if len(chatSession.StocksToPurchase) == 0 {
-		slog.Error("StocksToPurchase is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
-		return ctrl.ProcessBackToPortfolioList(c)
-	}
-
-	err = ctrl.investHelperService.ApplyCalculatedPurchaseToPortfolio(ctx, chatSession.PortfolioID, chatSession.StocksToPurchase)
+	err = ctrl.investHelperService.UnlinkPortfolioIndex(ctx, chatSession.PortfolioID)
 	if err != nil {
-		slog.Error("failed on investHelperService.ApplyCalculatedPurchaseToPortfolio", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		slog.Error("failed on investHelperService.UnlinkPortfolioIndex", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
 		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
 	}
 
-	go ctrl.sendAutoDeleteMsg(c, "операции успешно применены")
-
-	_, markup := telebotConverter.CalculatedStockPurchaseResponse(nil, decimal.NewFromInt(0))
+	go ctrl.sendAutoDeleteMsg(c, "портфель отвязан от индекса")
 
-	return c.Edit("навигация:", markup)
+	return ctrl.ProcessBackToPortfolio(c)
 }
 
-func (ctrl *Controller) GenerateReport(c tele.Context) error {
+func (ctrl *Controller) PortfolioHistory(c tele.Context) error {
 	ctx := utils.CreateCtxWithRqID(c)
 	rqID := utils.GetRequestIDFromCtx(ctx)
-	op := "Controller.GenerateReport"
-
-	fileBytes, filename, err := ctrl.investHelperService.GeneratePortfoliosReport(ctx, c.Chat().ID)
+	op := "Controller.PortfolioHistory"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
 	if err != nil {
-		slog.Error("failed on investHelperService.GeneratePortfoliosReport", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
 		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
 	}
 
-	if len(fileBytes) < ctrl.cfg.Telegram.FileLimitInBytes {
-		doc := &tele.Document{
-			File:     tele.File{FileReader: bytes.NewReader(fileBytes)},
-			FileName: filename,
-		}
-		return c.Send(doc)
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
 	}
 
-	// иначе загружаем в облако и отправляем ссылку на скачивание
-	downloadLink, err := ctrl.investHelperService.UploadFileToCloud(ctx, bytes.NewReader(fileBytes), filename)
+	history, err := ctrl.investHelperService.GetPortfolioHistory(ctx, chatSession.PortfolioID)
 	if err != nil {
-		slog.Error("failed on investHelperService.UploadFileToCloud", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		slog.Error("failed on investHelperService.GetPortfolioHistory", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
 		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
 	}
 
-	return c.Send(downloadLink)
+	return c.Edit(telebotConverter.PortfolioHistoryResponse(history))
 }
 
-// TODO поправить логирование излишнее
+func (ctrl *Controller) TaxReport(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.TaxReport"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	year := time.Now().Year()
+	if strings.HasPrefix(c.Callback().Data, fmt.Sprintf("\f%s", tgCallback.TaxReportYearPrefix)) {
+		callbackStr := strings.TrimPrefix(c.Callback().Data, fmt.Sprintf("\f%s", tgCallback.TaxReportYearPrefix))
+		year, err = strconv.Atoi(callbackStr)
+		if err != nil {
+			slog.Error("invalid year in callback", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()), slog.String("callback", c.Callback().Data))
+			return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+		}
+	}
+
+	report, err := ctrl.investHelperService.GetTaxReport(ctx, chatSession.PortfolioID, year)
+	if err != nil {
+		slog.Error("failed on investHelperService.GetTaxReport", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Edit(telebotConverter.TaxReportResponse(report, time.Now().Year()))
+}
+
+func (ctrl *Controller) StockDividends(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.StockDividends"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.StockTicker == "" {
+		slog.Error("stockTicker is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	stock, err := ctrl.investHelperService.GetPortfolioStockInfo(ctx, chatSession.StockTicker, chatSession.PortfolioID)
+	if err != nil && !errors.Is(err, service.ErrActualStockInfoUnavailable) {
+		slog.Error("failed on investHelperService.GetPortfolioStockInfo", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Edit(telebotConverter.StockDividendsResponse(stock))
+}
+
+func (ctrl *Controller) InitAddDividend(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession.Action = model.ExpectingDividendAmount
+	err = ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
+	if err != nil {
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Edit("введите сумму полученных дивидендов после удержания налога 13% и через пробел дату выплаты в формате ДД.ММ.ГГГГ (без даты выплата считается сегодняшней):")
+}
+
+func (ctrl *Controller) ProcessAddDividend(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ProcessAddDividend"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	fields := strings.Fields(c.Message().Text)
+	if len(fields) == 0 || len(fields) > 2 {
+		return c.Send("введите сумму и дату выплаты через пробел, например 1250.50 15.07.2025:")
+	}
+
+	netAmount, err := decimal.NewFromString(strings.Replace(fields[0], ",", ".", 1))
+	if err != nil || !netAmount.IsPositive() {
+		return c.Send("сумма должна быть числом больше 0, введите корректное значение:")
+	}
+
+	paymentDate := time.Now()
+	if len(fields) == 2 {
+		paymentDate, err = time.ParseInLocation("02.01.2006", fields[1], time.Local)
+		if err != nil {
+			return c.Send("не удалось разобрать дату выплаты, введите ее в формате ДД.ММ.ГГГГ:")
+		}
+		if paymentDate.After(time.Now()) {
+			return c.Send("дата выплаты не может быть в будущем, введите корректное значение:")
+		}
+	}
+
+	if chatSession.StockTicker == "" {
+		slog.Error("stockTicker is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	err = ctrl.investHelperService.AddManualDividend(ctx, chatSession.PortfolioID, chatSession.StockTicker, netAmount, paymentDate)
+	if errors.Is(err, service.ErrDividendAlreadyRecorded) {
+		chatSession.Action = model.DefaultAction
+		go ctrl.session.SetSession(context.WithoutCancel(ctx), strconv.FormatInt(c.Chat().ID, 10), chatSession)
+		return c.Send("эта выплата уже учтена: дивиденд начислен по дате отсечки или загружен из отчета брокера")
+	}
+	if err != nil {
+		slog.Error("failed on investHelperService.AddManualDividend", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession.Action = model.DefaultAction
+	go ctrl.session.SetSession(context.WithoutCancel(ctx), strconv.FormatInt(c.Chat().ID, 10), chatSession)
+
+	stock, err := ctrl.investHelperService.GetPortfolioStockInfo(ctx, chatSession.StockTicker, chatSession.PortfolioID)
+	if err != nil && !errors.Is(err, service.ErrActualStockInfoUnavailable) {
+		slog.Error("failed on investHelperService.GetPortfolioStockInfo", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Send(telebotConverter.StockDividendsResponse(stock))
+}
+
+func (ctrl *Controller) ToggleDividendNotifications(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ToggleDividendNotifications"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	enabled, err := ctrl.investHelperService.ToggleDividendNotifications(ctx, chatSession.PortfolioID)
+	if err != nil {
+		slog.Error("failed on investHelperService.ToggleDividendNotifications", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if enabled {
+		go ctrl.sendAutoDeleteMsg(c, "уведомления об отсечках включены")
+	} else {
+		go ctrl.sendAutoDeleteMsg(c, "уведомления об отсечках выключены")
+	}
+
+	return ctrl.ProcessBackToPortfolio(c)
+}
+
+func (ctrl *Controller) PortfolioCash(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.PortfolioCash"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	cash, err := ctrl.investHelperService.GetPortfolioCash(ctx, chatSession.PortfolioID)
+	if err != nil {
+		slog.Error("failed on investHelperService.GetPortfolioCash", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Edit(telebotConverter.PortfolioCashResponse(cash))
+}
+
+func (ctrl *Controller) InitCashOperation(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.InitCashOperation"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	operationType := model.CashOperationType(strings.TrimPrefix(c.Callback().Data, fmt.Sprintf("\f%s", tgCallback.CashOperationPrefix)))
+	switch operationType {
+	case model.CashOperationDeposit, model.CashOperationWithdrawal, model.CashOperationCoupon:
+	default:
+		slog.Error("unsupported cash operation type in callback", slog.String("rqID", rqID), slog.String("op", op), slog.String("callback", c.Callback().Data))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession.Action = model.ExpectingCashAmount
+	chatSession.CashOperationType = operationType
+	err = ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
+	if err != nil {
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	switch operationType {
+	case model.CashOperationWithdrawal:
+		return c.Edit("введите сумму вывода:")
+	case model.CashOperationCoupon:
+		return c.Edit("введите сумму полученных купонов:")
+	default:
+		return c.Edit("введите сумму пополнения:")
+	}
+}
+
+func (ctrl *Controller) ProcessCashOperation(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ProcessCashOperation"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	input := strings.Replace(c.Message().Text, ",", ".", 1)
+
+	amount, err := decimal.NewFromString(input)
+	if err != nil || !amount.IsPositive() {
+		return c.Send("сумма должна быть числом больше 0, введите корректное значение:")
+	}
+
+	err = ctrl.investHelperService.AddCashOperation(ctx, chatSession.PortfolioID, chatSession.CashOperationType, amount)
+	if err != nil {
+		if errors.Is(err, service.ErrNotEnoughCash) {
+			return c.Send("свободных денег меньше указанной суммы, введите сумму поменьше:")
+		}
+		slog.Error("failed on investHelperService.AddCashOperation", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession.Action = model.DefaultAction
+	chatSession.CashOperationType = ""
+	go ctrl.session.SetSession(context.WithoutCancel(ctx), strconv.FormatInt(c.Chat().ID, 10), chatSession)
+
+	cash, err := ctrl.investHelperService.GetPortfolioCash(ctx, chatSession.PortfolioID)
+	if err != nil {
+		slog.Error("failed on investHelperService.GetPortfolioCash", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Send(telebotConverter.PortfolioCashResponse(cash))
+}
+
+func (ctrl *Controller) InitSetCommissionPercent(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession.Action = model.ExpectingCommissionPercent
+	err = ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
+	if err != nil {
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Edit("введите комиссию брокера и биржи в процентах от суммы сделки (например 0.05):")
+}
+
+func (ctrl *Controller) ProcessSetCommissionPercent(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ProcessSetCommissionPercent"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	input := strings.Replace(c.Message().Text, ",", ".", 1)
+
+	percent, err := decimal.NewFromString(input)
+	if err != nil || percent.IsNegative() || percent.GreaterThanOrEqual(decimal.NewFromInt(100)) {
+		return c.Send("комиссия должна быть числом от 0 до 100, введите корректное значение:")
+	}
+
+	err = ctrl.investHelperService.SetCommissionPercent(ctx, chatSession.PortfolioID, percent)
+	if err != nil {
+		slog.Error("failed on investHelperService.SetCommissionPercent", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession.Action = model.DefaultAction
+	go ctrl.session.SetSession(context.WithoutCancel(ctx), strconv.FormatInt(c.Chat().ID, 10), chatSession)
+
+	portfolioPage, err := ctrl.investHelperService.GetPortfolioPage(ctx, chatSession.PortfolioID, max(chatSession.CurPortfolioDetailsPage, 1))
+	if err != nil {
+		slog.Error("failed on investHelperService.GetPortfolioPage", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Send(telebotConverter.PortfolioDetailsResponse(portfolioPage, ctrl.cfg.StocksPerPage))
+}
+
+func (ctrl *Controller) OperationsHistory(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.OperationsHistory"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	page := max(chatSession.CurOperationsPage, 1)
+	if strings.HasPrefix(c.Callback().Data, fmt.Sprintf("\f%s", tgCallback.ToOperationsPage)) {
+		pageStr := strings.TrimPrefix(c.Callback().Data, fmt.Sprintf("\f%s", tgCallback.ToOperationsPage))
+		page, err = strconv.Atoi(pageStr)
+		if err != nil {
+			page = 1
+		}
+	}
+
+	history, err := ctrl.investHelperService.GetOperationsHistoryPage(ctx, chatSession.PortfolioID, page)
+	if err != nil {
+		slog.Error("failed on investHelperService.GetOperationsHistoryPage", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession.Action = model.DefaultAction
+	chatSession.CurOperationsPage = page
+	chatSession.OperationID = 0
+	go ctrl.session.SetSession(context.WithoutCancel(ctx), strconv.FormatInt(c.Chat().ID, 10), chatSession)
+
+	return c.Edit(telebotConverter.OperationsHistoryResponse(history, ctrl.cfg.OperationsPerPage))
+}
+
+func (ctrl *Controller) GoToEditOperation(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.GoToEditOperation"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	callbackStr := strings.TrimPrefix(c.Callback().Data, fmt.Sprintf("\f%s", tgCallback.EditOperationPrefix))
+	operationID, err := strconv.ParseInt(callbackStr, 10, 64)
+	if err != nil {
+		slog.Error("invalid operationID in callback", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()), slog.String("callback", c.Callback().Data))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	operation, err := ctrl.investHelperService.GetStockOperation(ctx, chatSession.PortfolioID, operationID)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			return ctrl.OperationsHistory(c)
+		}
+		slog.Error("failed on investHelperService.GetStockOperation", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession.OperationID = operationID
+	go ctrl.session.SetSession(context.WithoutCancel(ctx), strconv.FormatInt(c.Chat().ID, 10), chatSession)
+
+	return c.Edit(telebotConverter.StockOperationResponse(operation))
+}
+
+func (ctrl *Controller) InitEditOperation(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.InitEditOperation"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.OperationID == 0 {
+		slog.Error("OperationID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolio(c)
+	}
+
+	var prompt string
+	switch strings.TrimPrefix(c.Callback().Data, "\f") {
+	case tgCallback.EditOperationQuantity:
+		chatSession.Action = model.ExpectingOperationQuantity
+		prompt = "введите количество бумаг в операции:"
+	case tgCallback.EditOperationPrice:
+		chatSession.Action = model.ExpectingOperationPrice
+		prompt = "введите цену за одну бумагу:"
+	default:
+		chatSession.Action = model.ExpectingOperationCommission
+		prompt = "введите комиссию за всю сделку в рублях:"
+	}
+
+	err = ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
+	if err != nil {
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Edit(prompt)
+}
+
+func (ctrl *Controller) ProcessEditOperation(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ProcessEditOperation"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 || chatSession.OperationID == 0 {
+		slog.Error("PortfolioID or OperationID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	input := strings.Replace(c.Message().Text, ",", ".", 1)
+
+	var changes model.OperationChanges
+	switch chatSession.Action {
+	case model.ExpectingOperationQuantity:
+		quantity, err := strconv.Atoi(input)
+		if err != nil || quantity <= 0 {
+			return c.Send("количество должно быть целым числом больше 0, введите корректное значение:")
+		}
+		changes.Quantity = &quantity
+	case model.ExpectingOperationPrice:
+		price, err := decimal.NewFromString(input)
+		if err != nil || !price.IsPositive() {
+			return c.Send("цена должна быть числом больше 0, введите корректное значение:")
+		}
+		changes.Price = &price
+	default:
+		commission, err := decimal.NewFromString(input)
+		if err != nil || commission.IsNegative() {
+			return c.Send("комиссия должна быть числом не меньше 0, введите корректное значение:")
+		}
+		changes.Commission = &commission
+	}
+
+	operation, err := ctrl.investHelperService.UpdateStockOperation(ctx, chatSession.PortfolioID, chatSession.OperationID, changes)
+	if err != nil {
+		if errors.Is(err, service.ErrOperationNotEditable) {
+			return ctrl.sendAutoDeleteMsg(c, operationNotEditableMsg)
+		}
+		if errors.Is(err, service.ErrInvalidOperationHistory) {
+			return c.Send("после исправления продажа превысит количество бумаг в портфеле на ее дату, изменение не сохранено. Введите другое значение:")
+		}
+		if errors.Is(err, service.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		slog.Error("failed on investHelperService.UpdateStockOperation", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession.Action = model.DefaultAction
+	go ctrl.session.SetSession(context.WithoutCancel(ctx), strconv.FormatInt(c.Chat().ID, 10), chatSession)
+
+	return c.Send(telebotConverter.StockOperationResponse(operation))
+}
+
+func (ctrl *Controller) InitDeleteOperation(c tele.Context) error {
+	return c.Edit("Удалить операцию? Остатки, средние цены и деньги портфеля будут пересчитаны по оставшейся истории.", telebotConverter.DeleteOperationConfirmation())
+}
+
+func (ctrl *Controller) ProcessDeleteOperation(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ProcessDeleteOperation"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 || chatSession.OperationID == 0 {
+		slog.Error("PortfolioID or OperationID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	err = ctrl.investHelperService.DeleteStockOperation(ctx, chatSession.PortfolioID, chatSession.OperationID)
+	if err != nil && !errors.Is(err, service.ErrNotFound) {
+		if errors.Is(err, service.ErrOperationNotEditable) {
+			return ctrl.sendAutoDeleteMsg(c, operationNotEditableMsg)
+		}
+		if errors.Is(err, service.ErrInvalidOperationHistory) {
+			return ctrl.sendAutoDeleteMsg(c, "без этой покупки более поздние продажи превысят количество бумаг в портфеле, операция не удалена")
+		}
+		slog.Error("failed on investHelperService.DeleteStockOperation", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return ctrl.OperationsHistory(c)
+}
+
+func (ctrl *Controller) InitImportBrokerReport(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession.Action = model.ExpectingBrokerReport
+	chatSession.BrokerReport = nil
+	err = ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
+	if err != nil {
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Edit("отправьте файл брокерского отчета: Т-Банк и ВТБ - xlsx, Сбер - html, Альфа-Инвестиции - xml")
+}
+
+func (ctrl *Controller) ProcessBrokerReport(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ProcessBrokerReport"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	doc := c.Message().Document
+	if doc.FileSize > int64(ctrl.cfg.Telegram.FileLimitInBytes) {
+		return c.Send("файл слишком большой, отправьте отчет за период покороче:")
+	}
+
+	reader, err := c.Bot().File(&doc.File)
+	if err != nil {
+		slog.Error("failed on bot.File", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		slog.Error("failed to read broker report", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	preview, err := ctrl.investHelperService.PreviewBrokerReport(ctx, chatSession.PortfolioID, doc.FileName, content)
+	if err != nil {
+		if errors.Is(err, service.ErrUnsupportedBrokerReport) {
+			return c.Send("не удалось распознать отчет, поддерживаются отчеты Т-Банка и ВТБ (xlsx), Сбера (html) и Альфа-Инвестиций (xml). Отправьте другой файл:")
+		}
+		slog.Error("failed on investHelperService.PreviewBrokerReport", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession.Action = model.DefaultAction
+	chatSession.BrokerReport = &preview.Report
+	err = ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
+	if err != nil {
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Send(telebotConverter.BrokerImportPreviewResponse(preview))
+}
+
+func (ctrl *Controller) ApplyBrokerImport(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ApplyBrokerImport"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 || chatSession.BrokerReport == nil {
+		slog.Error("PortfolioID or BrokerReport is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	result, err := ctrl.investHelperService.ImportBrokerReport(ctx, chatSession.PortfolioID, *chatSession.BrokerReport)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidOperationHistory) {
+			go ctrl.sendAutoDeleteMsg(c, "после загрузки продажи превысят количество бумаг в портфеле, отчет не загружен")
+			return ctrl.ProcessBackToPortfolio(c)
+		}
+		slog.Error("failed on investHelperService.ImportBrokerReport", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	go ctrl.sendAutoDeleteMsg(c, telebotConverter.BrokerImportResultMessage(result))
+
+	return ctrl.ProcessBackToPortfolio(c)
+}
+
+func (ctrl *Controller) PortfolioCsv(c tele.Context) error {
+	return c.Edit(telebotConverter.PortfolioCsvMenuResponse())
+}
+
+func (ctrl *Controller) ExportPortfolioCsv(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ExportPortfolioCsv"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	files, err := ctrl.investHelperService.ExportPortfolioCsv(ctx, chatSession.PortfolioID)
+	if err != nil {
+		slog.Error("failed on investHelperService.ExportPortfolioCsv", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	for _, file := range files {
+		doc := &tele.Document{
+			File:     tele.File{FileReader: bytes.NewReader(file.Content)},
+			FileName: file.Filename,
+		}
+		err = c.Send(doc)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ctrl *Controller) InitImportPortfolioCsv(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession.Action = model.ExpectingPortfolioCsv
+	err = ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
+	if err != nil {
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Edit(telebotConverter.PortfolioCsvUploadRequest())
+}
+
+// InitCreatePortfolioFromCsv - создание портфеля из CSV, название берется из имени файла
+func (ctrl *Controller) InitCreatePortfolioFromCsv(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.InitCreatePortfolioFromCsv"
+	strChatID := strconv.FormatInt(c.Chat().ID, 10)
+	chatSession, err := ctrl.session.GetSession(ctx, strChatID)
+	if err != nil && !errors.Is(err, session.ErrNotFound) {
+		slog.Error("got error from session.GetSession", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return c.Send("что-то пошло не так...")
+	}
+
+	chatSession.Action = model.ExpectingPortfolioCsv
+	chatSession.PortfolioID = 0
+	err = ctrl.session.SetSession(ctx, strChatID, chatSession)
+	if err != nil {
+		slog.Error("got error from session.SetSession", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return c.Send("что-то пошло не так...")
+	}
+
+	return c.Send(telebotConverter.PortfolioCsvUploadRequest() + "\n\nНазвание портфеля будет взято из имени файла.")
+}
+
+func (ctrl *Controller) ProcessPortfolioCsv(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ProcessPortfolioCsv"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	doc := c.Message().Document
+	if doc.FileSize > int64(ctrl.cfg.Telegram.FileLimitInBytes) {
+		return c.Send("файл слишком большой, отправьте файл поменьше:")
+	}
+
+	reader, err := c.Bot().File(&doc.File)
+	if err != nil {
+		slog.Error("failed on bot.File", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		slog.Error("failed to read csv file", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	// у выгруженных ботом файлов отрезаем суффикс, чтобы название совпало с исходным портфелем
+	portfolioName := strings.TrimSuffix(doc.FileName, filepath.Ext(doc.FileName))
+	portfolioName = strings.TrimSuffix(strings.TrimSuffix(portfolioName, "_positions"), "_operations")
+	result, err := ctrl.investHelperService.ImportPortfolioCsv(ctx, c.Chat().ID, chatSession.PortfolioID, portfolioName, content)
+	if err != nil {
+		if errors.Is(err, service.ErrUnsupportedCsv) {
+			return c.Send("формат файла не распознан, проверьте заголовок.\n\n" + telebotConverter.PortfolioCsvUploadRequest())
+		}
+		slog.Error("failed on investHelperService.ImportPortfolioCsv", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if len(result.Errors) > 0 {
+		return c.Send(telebotConverter.CsvImportErrorsResponse(result))
+	}
+
+	chatSession.Action = model.DefaultAction
+	chatSession.PortfolioID = result.PortfolioID
+	chatSession.CurPortfolioDetailsPage = 1
+	go ctrl.session.SetSession(context.WithoutCancel(ctx), strconv.FormatInt(c.Chat().ID, 10), chatSession)
+
+	go ctrl.sendAutoDeleteMsg(c, telebotConverter.CsvImportResultMessage(result))
+
+	portfolioPage, err := ctrl.investHelperService.GetPortfolioPage(ctx, result.PortfolioID, 1)
+	if err != nil {
+		slog.Error("failed on investHelperService.GetPortfolioPage", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Send(telebotConverter.PortfolioDetailsResponse(portfolioPage, ctrl.cfg.StocksPerPage))
+}
+
+func (ctrl *Controller) InitTransferStock(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.InitTransferStock"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 || chatSession.StockTicker == "" {
+		slog.Error("PortfolioID or StockTicker is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	portfolios, err := ctrl.investHelperService.GetTransferTargets(ctx, c.Chat().ID, chatSession.PortfolioID)
+	if err != nil {
+		slog.Error("failed on investHelperService.GetTransferTargets", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if len(portfolios) == 0 {
+		return ctrl.sendAutoDeleteMsg(c, "нет других портфелей, сначала создайте портфель для перевода")
+	}
+
+	return c.Edit(telebotConverter.TransferTargetsResponse(chatSession.StockTicker, portfolios))
+}
+
+func (ctrl *Controller) ChooseTransferTarget(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ChooseTransferTarget"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	callbackStr := strings.TrimPrefix(c.Callback().Data, fmt.Sprintf("\f%s", tgCallback.TransferToPortfolioPrefix))
+	portfolioID, err := strconv.ParseInt(callbackStr, 10, 64)
+	if err != nil {
+		slog.Error("invalid portfolioID in callback", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()), slog.String("callback", c.Callback().Data))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession.TransferPortfolioID = portfolioID
+	chatSession.Action = model.ExpectingTransferQuantity
+	err = ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
+	if err != nil {
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Edit("введите кол-во бумаг для перевода:")
+}
+
+func (ctrl *Controller) ProcessTransferStock(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ProcessTransferStock"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 || chatSession.TransferPortfolioID == 0 || chatSession.StockTicker == "" {
+		slog.Error("PortfolioID, TransferPortfolioID or StockTicker is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	quantity, err := strconv.Atoi(c.Message().Text)
+	if err != nil || quantity <= 0 {
+		return c.Send("количество должно быть целым числом больше 0, введите корректное значение:")
+	}
+
+	transfer, err := ctrl.investHelperService.TransferStock(ctx, c.Chat().ID, chatSession.PortfolioID, chatSession.TransferPortfolioID, chatSession.StockTicker, quantity)
+	if err != nil {
+		if errors.Is(err, service.ErrNotEnoughStocks) {
+			return c.Send("нельзя перевести больше, чем есть в портфеле. Введите корректное значение:")
+		}
+		if errors.Is(err, service.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		slog.Error("failed on investHelperService.TransferStock", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession.Action = model.DefaultAction
+	chatSession.TransferPortfolioID = 0
+	chatSession.StockChanges = nil
+	go ctrl.session.SetSession(context.WithoutCancel(ctx), strconv.FormatInt(c.Chat().ID, 10), chatSession)
+
+	go ctrl.sendAutoDeleteMsg(c, telebotConverter.StockTransferResultMessage(transfer))
+
+	stock, err := ctrl.investHelperService.GetPortfolioStockInfo(ctx, chatSession.StockTicker, chatSession.PortfolioID)
+	if err != nil && !errors.Is(err, service.ErrActualStockInfoUnavailable) {
+		slog.Error("failed on investHelperService.GetPortfolioStockInfo", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Send(telebotConverter.StockDetailResponse(stock, nil))
+}
+
+const corporateActionUsage = "использование:\n/corporate_action split ТИКЕР 10:1 [дд.мм.гггг]\n/corporate_action rename СТАРЫЙ НОВЫЙ [дд.мм.гггг]\n\n" +
+	"в сплите сначала указывается, сколько было бумаг, потом сколько стало (для обратного сплита 1:10). Без даты действие применяется с сегодняшнего дня."
+
+// ApplyCorporateAction - административная команда, применяет сплит или смену тикера ко всем портфелям с бумагой
+func (ctrl *Controller) ApplyCorporateAction(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ApplyCorporateAction"
+
+	if !slices.Contains(ctrl.cfg.Telegram.AdminChatIDs, c.Chat().ID) {
+		slog.Warn("corporate action from non admin chat", slog.String("rqID", rqID), slog.String("op", op), slog.Int64("chatID", c.Chat().ID))
+		return c.Send("команда недоступна")
+	}
+
+	args := c.Args()
+	if len(args) != 3 && len(args) != 4 {
+		return c.Send(corporateActionUsage)
+	}
+
+	action := model.CorporateAction{
+		Ticker:        strings.ToUpper(args[1]),
+		EffectiveDate: time.Now(),
+		ChatID:        c.Chat().ID,
+	}
+	switch strings.ToLower(args[0]) {
+	case string(model.CorporateActionSplit):
+		action.Type = model.CorporateActionSplit
+		ratioFrom, ratioTo, ok := strings.Cut(args[2], ":")
+		var errFrom, errTo error
+		action.RatioFrom, errFrom = strconv.Atoi(ratioFrom)
+		action.RatioTo, errTo = strconv.Atoi(ratioTo)
+		if !ok || errFrom != nil || errTo != nil || action.RatioFrom <= 0 || action.RatioTo <= 0 || action.RatioFrom == action.RatioTo {
+			return c.Send("коэффициент сплита должен быть в виде 10:1 из положительных чисел.\n\n" + corporateActionUsage)
+		}
+	case string(model.CorporateActionRename):
+		action.Type = model.CorporateActionRename
+		action.NewTicker = strings.ToUpper(args[2])
+		if action.NewTicker == action.Ticker {
+			return c.Send("новый тикер совпадает со старым")
+		}
+	default:
+		return c.Send(corporateActionUsage)
+	}
+
+	if len(args) == 4 {
+		date, err := time.ParseInLocation("02.01.2006", args[3], time.Local)
+		if err != nil || date.After(time.Now()) {
+			return c.Send("дата должна быть в формате дд.мм.гггг и не в будущем")
+		}
+		action.EffectiveDate = date
+	}
+
+	result, err := ctrl.investHelperService.ApplyCorporateAction(ctx, action)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrCorporateActionApplied):
+			return c.Send("это корпоративное действие уже применено")
+		case errors.Is(err, service.ErrNotFound):
+			return c.Send(fmt.Sprintf("бумага %s не найдена на бирже", action.NewTicker))
+		case errors.Is(err, service.ErrInvalidOperationHistory):
+			return c.Send(fmt.Sprintf("после действия продажа в одном из портфелей превысит количество бумаг, ничего не изменено: %s", err.Error()))
+		}
+		slog.Error("failed on investHelperService.ApplyCorporateAction", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return c.Send(internalErrMsg)
+	}
+
+	return c.Send(telebotConverter.CorporateActionResultMessage(result))
+}
+
+func (ctrl *Controller) sendAutoDeleteMsg(c tele.Context, text string) error {
+	msg, err := c.Bot().Send(c.Chat(), text)
+	if err != nil {
+		return err
+	}
+
+	time.AfterFunc(5*time.Second, func() {
+		c.Bot().Delete(msg)
+	})
+	return nil
+}
+
+func (ctrl *Controller) InitDeletePortfolio(c tele.Context) error {
+	return c.Edit(
+		"Подтвердите удаление портфеля. Это действие необратимо, будут удалены все данные по портфелю и история операций!",
+		telebotConverter.DeletePortfolioConfirmation(),
+	)
+}
+
+func (ctrl *Controller) ProcessDeletePortfolio(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ProcessDeletePortfolio"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	err = ctrl.investHelperService.DeletePortfolio(ctx, chatSession.PortfolioID)
+	if err != nil {
+		slog.Error("failed on investHelperService.DeletePortfolio", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	go ctrl.sendAutoDeleteMsg(c, "портфель успешно удален")
+
+	portfolios, hasNextPage, err := ctrl.investHelperService.GetPortfolios(ctx, c.Chat().ID, 1)
+	if err != nil {
+		slog.Error("failed on investHelperService.GetPortfolios", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Edit(telebotConverter.PortfolioListResponse(portfolios, ctrl.cfg.PortfoliosPerPage, 1, hasNextPage))
+}
+
+func (ctrl *Controller) ApplyCalculatedPurchaseToPortfolio(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ApplyCalculatedPurchaseToPortfolio"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	if len(chatSession.StocksToPurchase) == 0 {
+		slog.Error("StocksToPurchase is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	err = ctrl.investHelperService.ApplyCalculatedPurchaseToPortfolio(ctx, chatSession.PortfolioID, chatSession.StocksToPurchase)
+	if err != nil {
+		if errors.Is(err, service.ErrNotEnoughStocks) {
+			return ctrl.sendAutoDeleteMsg(c, "в портфеле меньше бумаг, чем нужно продать по плану, рассчитайте ребалансировку заново")
+		}
+		slog.Error("failed on investHelperService.ApplyCalculatedPurchaseToPortfolio", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	go ctrl.sendAutoDeleteMsg(c, "операции успешно применены")
+
+	_, markup := telebotConverter.CalculatedStockPurchaseResponse(nil, decimal.NewFromInt(0), model.PurchaseConstraints{})
+
+	return c.Edit("навигация:", markup)
+}
+
+func (ctrl *Controller) GenerateReport(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.GenerateReport"
+
+	fileBytes, filename, err := ctrl.investHelperService.GeneratePortfoliosReport(ctx, c.Chat().ID)
+	if err != nil {
+		slog.Error("failed on investHelperService.GeneratePortfoliosReport", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if len(fileBytes) < ctrl.cfg.Telegram.FileLimitInBytes {
+		doc := &tele.Document{
+			File:     tele.File{FileReader: bytes.NewReader(fileBytes)},
+			FileName: filename,
+		}
+		return c.Send(doc)
+	}
+
+	// иначе загружаем в облако и отправляем ссылку на скачивание
+	downloadLink, err := ctrl.investHelperService.UploadFileToCloud(ctx, bytes.NewReader(fileBytes), filename)
+	if err != nil {
+		slog.Error("failed on investHelperService.UploadFileToCloud", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Send(downloadLink)
+}
+
+// TODO поправить логирование излишнее
 
 // TODO юнит тесты сервисного слоя
+
+func (ctrl *Controller) DistributeContribution(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.DistributeContribution"
+
+	allocations, portfolios, err := ctrl.investHelperService.GetContributionSettings(ctx, c.Chat().ID)
+	if err != nil {
+		slog.Error("failed on investHelperService.GetContributionSettings", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession, _ := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	chatSession.Action = model.ExpectingContributionSum
+	if len(allocations) == 0 {
+		chatSession.Action = model.ExpectingContributionAllocations
+	}
+	err = ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
+	if err != nil {
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	var text string
+	var markup *tele.ReplyMarkup
+	if len(allocations) == 0 {
+		text, markup = telebotConverter.ContributionAllocationsRequest(portfolios)
+	} else {
+		text, markup = telebotConverter.ContributionRequest(allocations)
+	}
+
+	if c.Callback() != nil {
+		return c.Edit(text, markup)
+	}
+	return c.Send(text, markup)
+}
+
+func (ctrl *Controller) InitSetContributionAllocations(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.InitSetContributionAllocations"
+
+	_, portfolios, err := ctrl.investHelperService.GetContributionSettings(ctx, c.Chat().ID)
+	if err != nil {
+		slog.Error("failed on investHelperService.GetContributionSettings", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession, _ := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	chatSession.Action = model.ExpectingContributionAllocations
+	err = ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
+	if err != nil {
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Edit(telebotConverter.ContributionAllocationsRequest(portfolios))
+}
+
+func (ctrl *Controller) ProcessSetContributionAllocations(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ProcessSetContributionAllocations"
+
+	_, portfolios, err := ctrl.investHelperService.GetContributionSettings(ctx, c.Chat().ID)
+	if err != nil {
+		slog.Error("failed on investHelperService.GetContributionSettings", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	allocations, ok := parseContributionAllocations(c.Message().Text, portfolios)
+	if !ok {
+		return c.Send("не удалось разобрать доли, введите в формате \"номер портфеля процент\", каждую долю с новой строки:")
+	}
+
+	err = ctrl.investHelperService.SetContributionAllocations(ctx, c.Chat().ID, allocations)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidAllocations) {
+			return c.Send("доли должны быть больше 0, портфели не должны повторяться, а сумма долей - не больше 100%. Введите корректные доли:")
+		}
+		slog.Error("failed on investHelperService.SetContributionAllocations", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return ctrl.DistributeContribution(c)
+}
+
+// parseContributionAllocations разбирает доли вида "номер процент", по одной на строку.
+// Номер - порядковый номер портфеля в списке, порядок строк - порядок расчета.
+func parseContributionAllocations(input string, portfolios []model.Portfolio) ([]model.ContributionAllocation, bool) {
+	lines := strings.FieldsFunc(input, func(r rune) bool {
+		return r == '\n' || r == ';'
+	})
+
+	allocations := make([]model.ContributionAllocation, 0, len(lines))
+	for _, line := range lines {
+		fields := strings.Fields(strings.ReplaceAll(line, "%", ""))
+		if len(fields) != 2 {
+			return nil, false
+		}
+
+		number, err := strconv.Atoi(strings.TrimSuffix(fields[0], ")"))
+		if err != nil || number < 1 || number > len(portfolios) {
+			return nil, false
+		}
+
+		percent, err := decimal.NewFromString(strings.Replace(fields[1], ",", ".", 1))
+		if err != nil {
+			return nil, false
+		}
+
+		portfolio := portfolios[number-1]
+		allocations = append(allocations, model.ContributionAllocation{
+			PortfolioID:   portfolio.PortfolioID,
+			PortfolioName: portfolio.PortfolioName,
+			Percent:       percent,
+		})
+	}
+
+	return allocations, len(allocations) > 0
+}
+
+func (ctrl *Controller) ProcessDistributeContribution(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ProcessDistributeContribution"
+
+	sum, err := decimal.NewFromString(strings.Replace(c.Message().Text, ",", ".", 1))
+	if err != nil || !sum.IsPositive() {
+		return c.Send("Сумма должна быть положительным числом > 0, введите корректное значение:")
+	}
+
+	plan, err := ctrl.investHelperService.CalculateContribution(ctx, c.Chat().ID, sum)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			return ctrl.DistributeContribution(c)
+		}
+		slog.Error("failed on investHelperService.CalculateContribution", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession, _ := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	chatSession.Action = model.DefaultAction
+	chatSession.ContributionPlan = &plan
+	go ctrl.session.SetSession(context.WithoutCancel(ctx), strconv.FormatInt(c.Chat().ID, 10), chatSession)
+
+	texts, markup := telebotConverter.ContributionPlanResponse(plan)
+	for _, text := range texts {
+		_ = c.Send(text)
+	}
+
+	return c.Send("навигация:", markup)
+}
+
+func (ctrl *Controller) ApplyContributionPlan(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ApplyContributionPlan"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.ContributionPlan == nil {
+		slog.Error("ContributionPlan is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	err = ctrl.investHelperService.ApplyContributionPlan(ctx, c.Chat().ID, *chatSession.ContributionPlan)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		slog.Error("failed on investHelperService.ApplyContributionPlan", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	go ctrl.sendAutoDeleteMsg(c, "операции успешно применены")
+
+	return ctrl.ProcessBackToPortfolioList(c)
+}
+
+func (ctrl *Controller) InitSimulatePortfolio(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.InitSimulatePortfolio"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	chatSession.Action = model.ExpectingSimulationScenario
+	err = ctrl.session.SetSession(ctx, strconv.FormatInt(c.Chat().ID, 10), chatSession)
+	if err != nil {
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	return c.Edit(telebotConverter.SimulationRequest())
+}
+
+func (ctrl *Controller) ProcessSimulatePortfolio(c tele.Context) error {
+	ctx := utils.CreateCtxWithRqID(c)
+	rqID := utils.GetRequestIDFromCtx(ctx)
+	op := "Controller.ProcessSimulatePortfolio"
+	chatSession, err := ctrl.getSessionFromTeleCtxOrStorage(ctx, c)
+	if err != nil {
+		if errors.Is(err, session.ErrNotFound) {
+			return ctrl.ProcessBackToPortfolioList(c)
+		}
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	if chatSession.PortfolioID == 0 {
+		slog.Error("PortfolioID is empty in chatSession", slog.String("rqID", rqID), slog.String("op", op))
+		return ctrl.ProcessBackToPortfolioList(c)
+	}
+
+	scenario, ok := parseSimulationScenario(c.Message().Text)
+	if !ok {
+		return c.Send("не удалось разобрать сценарий, введите каждое изменение с новой строки в формате \"SBER +20\", \"GAZP -5\" или \"SBER -20%\":")
+	}
+
+	result, err := ctrl.investHelperService.SimulatePortfolio(ctx, chatSession.PortfolioID, scenario)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrTickerNotInPortfolio):
+			return c.Send("в сценарии есть бумаги, которых нет в портфеле, введите сценарий заново:")
+		case errors.Is(err, service.ErrNotEnoughStocks):
+			return c.Send("нельзя продать больше бумаг, чем есть в портфеле, введите сценарий заново:")
+		case errors.Is(err, service.ErrNotFound):
+			return c.Send("в портфеле пока нет бумаг")
+		}
+		slog.Error("failed on investHelperService.SimulatePortfolio", slog.String("rqID", rqID), slog.String("op", op), slog.String("err", err.Error()))
+		return ctrl.sendAutoDeleteMsg(c, internalErrMsg)
+	}
+
+	chatSession.Action = model.DefaultAction
+	go ctrl.session.SetSession(context.WithoutCancel(ctx), strconv.FormatInt(c.Chat().ID, 10), chatSession)
+
+	texts, markup := telebotConverter.SimulationResponse(result)
+	for _, text := range texts {
+		_ = c.Send(text)
+	}
+
+	return c.Send("навигация:", markup)
+}
+
+// parseSimulationScenario разбирает сценарий, по одному изменению на строку: "SBER +20" - купить 20 шт,
+// "GAZP -5" - продать 5 шт, "SBER -20%" - изменение цены на -20%.
+func parseSimulationScenario(input string) (model.SimulationScenario, bool) {
+	lines := strings.FieldsFunc(input, func(r rune) bool {
+		return r == '\n' || r == ';'
+	})
+
+	var scenario model.SimulationScenario
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return model.SimulationScenario{}, false
+		}
+
+		ticker := strings.ToUpper(fields[0])
+		value := strings.Replace(fields[1], ",", ".", 1)
+
+		if strings.HasSuffix(value, "%") {
+			percent, err := decimal.NewFromString(strings.TrimSuffix(value, "%"))
+			if err != nil || percent.IsZero() || percent.LessThanOrEqual(decimal.NewFromInt(-100)) {
+				return model.SimulationScenario{}, false
+			}
+			scenario.PriceChanges = append(scenario.PriceChanges, model.SimulationPriceChange{Ticker: ticker, Percent: percent})
+			continue
+		}
+
+		quantity, err := strconv.Atoi(strings.TrimPrefix(value, "+"))
+		if err != nil || quantity == 0 {
+			return model.SimulationScenario{}, false
+		}
+		scenario.Trades = append(scenario.Trades, model.SimulationTrade{Ticker: ticker, Quantity: quantity})
+	}
+
+	return scenario, len(scenario.Trades)+len(scenario.PriceChanges) > 0
+}
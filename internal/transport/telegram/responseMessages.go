@@ -2,4 +2,5 @@ package telegram
 
 const(
 	internalErrMsg string = "что-то пошло не так..."
+	operationNotEditableMsg string = "сплиты, смены тикера и переводы между портфелями не редактируются, как и сделки с бумагой до ее перевода в другой портфель"
 )
\ No newline at end of file
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS dividend_income;
+DROP TABLE IF EXISTS dividends;
\ No newline at end of file
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS dividends(
+    ticker TEXT NOT NULL,
+    registry_close_date DATE NOT NULL,
+    value DECIMAL(18, 6) NOT NULL,
+    currency TEXT NOT NULL,
+    CONSTRAINT dividends_pk PRIMARY KEY (ticker, registry_close_date)
+);
+
+CREATE TABLE IF NOT EXISTS dividend_income(
+    row_id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    portfolio_id BIGINT NOT NULL references portfolios(portfolio_id) ON DELETE CASCADE,
+    ticker TEXT NOT NULL,
+    dividend_date DATE NOT NULL,
+    quantity INT NOT NULL DEFAULT 0,
+    amount_per_share DECIMAL(18, 6) NOT NULL DEFAULT 0,
+    gross_amount DECIMAL(18, 6) NOT NULL,
+    tax_amount DECIMAL(18, 6) NOT NULL,
+    net_amount DECIMAL(18, 6) NOT NULL,
+    source TEXT NOT NULL,
+    dt_create TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+-- автоматическое начисление по одной дате закрытия реестра делается только один раз
+CREATE UNIQUE INDEX IF NOT EXISTS dividend_income_auto_unique_idx ON dividend_income(portfolio_id, ticker, dividend_date) WHERE source = 'auto';
+CREATE INDEX IF NOT EXISTS dividend_income_portfolioid_ticker_idx ON dividend_income(portfolio_id, ticker);
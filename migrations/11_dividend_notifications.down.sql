@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS dividend_notifications_sent;
+
+ALTER TABLE portfolios
+    DROP COLUMN IF EXISTS dividend_notifications;
@@ -0,0 +1,11 @@
+ALTER TABLE portfolios
+    ADD COLUMN IF NOT EXISTS dividend_notifications BOOLEAN NOT NULL DEFAULT true;
+
+-- об одной отсечке владельцу портфеля сообщаем только один раз
+CREATE TABLE IF NOT EXISTS dividend_notifications_sent(
+    portfolio_id BIGINT NOT NULL references portfolios(portfolio_id) ON DELETE CASCADE,
+    ticker TEXT NOT NULL,
+    registry_close_date DATE NOT NULL,
+    dt_create TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    CONSTRAINT dividend_notifications_sent_pk PRIMARY KEY (portfolio_id, ticker, registry_close_date)
+);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS cash_operations(
+    row_id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    portfolio_id BIGINT NOT NULL references portfolios(portfolio_id) ON DELETE CASCADE,
+    operation_type TEXT NOT NULL,
+    -- со знаком: поступления положительные, списания отрицательные
+    amount DECIMAL(18, 6) NOT NULL,
+    ticker TEXT NOT NULL DEFAULT '',
+    dt_create TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS cash_operations_portfolioid_idx ON cash_operations(portfolio_id);
@@ -0,0 +1,5 @@
+ALTER TABLE portfolios
+    DROP COLUMN IF EXISTS commission_percent;
+
+ALTER TABLE stocks_operations_history
+    DROP COLUMN IF EXISTS commission;
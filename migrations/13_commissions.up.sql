@@ -0,0 +1,6 @@
+ALTER TABLE stocks_operations_history
+    ADD COLUMN IF NOT EXISTS commission DECIMAL(18, 6) NOT NULL DEFAULT 0;
+
+-- комиссия брокера и биржи в процентах от суммы сделки, используется если комиссия не указана вручную
+ALTER TABLE portfolios
+    ADD COLUMN IF NOT EXISTS commission_percent DECIMAL(6, 4) NOT NULL DEFAULT 0;
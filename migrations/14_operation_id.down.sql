@@ -0,0 +1,10 @@
+ALTER TABLE cash_operations
+    DROP COLUMN IF EXISTS operation_id;
+
+DROP INDEX IF EXISTS stocks_operations_history_portfolioid_idx;
+
+ALTER TABLE stocks_operations_history
+    DROP CONSTRAINT IF EXISTS stocks_operations_history_pk;
+
+ALTER TABLE stocks_operations_history
+    DROP COLUMN IF EXISTS operation_id;
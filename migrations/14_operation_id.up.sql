@@ -0,0 +1,35 @@
+ALTER TABLE stocks_operations_history
+    ADD COLUMN IF NOT EXISTS operation_id BIGINT GENERATED BY DEFAULT AS IDENTITY;
+
+ALTER TABLE stocks_operations_history
+    ADD CONSTRAINT stocks_operations_history_pk PRIMARY KEY (operation_id);
+
+CREATE INDEX IF NOT EXISTS stocks_operations_history_portfolioid_idx ON stocks_operations_history(portfolio_id, dt_create);
+
+-- денежные проводки по сделке (оплата, выручка, комиссия, пополнение на недостающую сумму) ссылаются на операцию,
+-- чтобы их можно было пересчитать при исправлении истории
+ALTER TABLE cash_operations
+    ADD COLUMN IF NOT EXISTS operation_id BIGINT;
+
+-- проводки, записанные до появления ссылки, связываем с операцией по тикеру и времени сделки
+UPDATE cash_operations c
+SET operation_id = h.operation_id
+FROM stocks_operations_history h
+WHERE c.portfolio_id = h.portfolio_id
+AND c.operation_type IN ('buy', 'sell', 'commission')
+AND c.ticker = h.ticker
+AND c.dt_create = h.dt_create;
+
+UPDATE cash_operations c
+SET operation_id = (
+    SELECT MIN(h.operation_id) FROM stocks_operations_history h
+    WHERE h.portfolio_id = c.portfolio_id AND h.dt_create = c.dt_create
+)
+WHERE c.operation_type = 'deposit'
+AND c.operation_id IS NULL
+AND EXISTS (
+    SELECT 1 FROM cash_operations t
+    WHERE t.portfolio_id = c.portfolio_id
+    AND t.operation_type = 'buy'
+    AND t.dt_create = c.dt_create
+);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS stocks_operations_history_external_id_idx;
+
+ALTER TABLE stocks_operations_history
+    DROP COLUMN IF EXISTS external_id;
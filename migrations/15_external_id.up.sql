@@ -0,0 +1,5 @@
+-- номер сделки у брокера, по нему при импорте отчета отсекаются уже загруженные сделки
+ALTER TABLE stocks_operations_history
+    ADD COLUMN IF NOT EXISTS external_id TEXT NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX IF NOT EXISTS stocks_operations_history_external_id_idx ON stocks_operations_history(portfolio_id, external_id) WHERE external_id <> '';
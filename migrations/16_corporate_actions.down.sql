@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS corporate_actions;
+
+ALTER TABLE stocks_operations_history
+    DROP COLUMN IF EXISTS operation_type;
@@ -0,0 +1,20 @@
+-- тип операции в истории: сделка или корпоративное действие (сплит, смена тикера)
+ALTER TABLE stocks_operations_history
+    ADD COLUMN IF NOT EXISTS operation_type TEXT NOT NULL DEFAULT 'trade';
+
+-- журнал примененных корпоративных действий
+CREATE TABLE IF NOT EXISTS corporate_actions(
+    action_id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    action_type TEXT NOT NULL,
+    ticker TEXT NOT NULL,
+    new_ticker TEXT NOT NULL DEFAULT '',
+    -- сплит: ratio_from старых бумаг превращаются в ratio_to новых
+    ratio_from INTEGER NOT NULL DEFAULT 1,
+    ratio_to INTEGER NOT NULL DEFAULT 1,
+    effective_date TIMESTAMP WITH TIME ZONE NOT NULL,
+    portfolios_cnt INTEGER NOT NULL DEFAULT 0,
+    chat_id BIGINT NOT NULL,
+    dt_create TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS corporate_actions_unique_idx ON corporate_actions(action_type, ticker, effective_date);
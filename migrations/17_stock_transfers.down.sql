@@ -0,0 +1,3 @@
+ALTER TABLE stocks_operations_history
+    DROP COLUMN IF EXISTS transfer_portfolio_id,
+    DROP COLUMN IF EXISTS dt_acquired;
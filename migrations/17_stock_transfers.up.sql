@@ -0,0 +1,4 @@
+-- перевод бумаг между портфелями: у зачисления сохраняется дата покупки исходного лота
+ALTER TABLE stocks_operations_history
+    ADD COLUMN IF NOT EXISTS dt_acquired TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS transfer_portfolio_id BIGINT references portfolios(portfolio_id) ON DELETE SET NULL;
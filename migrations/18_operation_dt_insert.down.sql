@@ -0,0 +1,2 @@
+ALTER TABLE stocks_operations_history
+    DROP COLUMN IF EXISTS dt_insert;
@@ -0,0 +1,9 @@
+-- когда операция записана в историю (dt_create - дата сделки, может быть задним числом), нужно для отмены последней операции
+ALTER TABLE stocks_operations_history
+    ADD COLUMN IF NOT EXISTS dt_insert TIMESTAMP WITH TIME ZONE;
+
+UPDATE stocks_operations_history SET dt_insert = dt_create WHERE dt_insert IS NULL;
+
+ALTER TABLE stocks_operations_history
+    ALTER COLUMN dt_insert SET DEFAULT now(),
+    ALTER COLUMN dt_insert SET NOT NULL;
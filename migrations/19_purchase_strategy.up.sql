@@ -0,0 +1,3 @@
+-- стратегия распределения суммы закупа по бумагам портфеля
+ALTER TABLE portfolios
+    ADD COLUMN IF NOT EXISTS purchase_strategy TEXT NOT NULL DEFAULT 'largest_lot';
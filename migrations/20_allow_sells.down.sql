@@ -0,0 +1,2 @@
+ALTER TABLE portfolios
+    DROP COLUMN IF EXISTS allow_sells;
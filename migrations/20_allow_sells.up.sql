@@ -0,0 +1,3 @@
+-- можно ли при ребалансировке предлагать продажи бумаг
+ALTER TABLE portfolios
+    ADD COLUMN IF NOT EXISTS allow_sells BOOLEAN NOT NULL DEFAULT FALSE;
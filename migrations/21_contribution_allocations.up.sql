@@ -0,0 +1,8 @@
+-- доли распределения пополнения между портфелями пользователя, position - порядок расчета, остаток переходит в следующий портфель
+CREATE TABLE IF NOT EXISTS contribution_allocations(
+    user_id BIGINT NOT NULL references users(user_id) ON DELETE CASCADE,
+    portfolio_id BIGINT NOT NULL references portfolios(portfolio_id) ON DELETE CASCADE,
+    percent DECIMAL(5, 2) NOT NULL,
+    position INT NOT NULL,
+    CONSTRAINT contribution_allocations_pk PRIMARY KEY (user_id, portfolio_id)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE stocks_operations_history
+    DROP COLUMN IF EXISTS ratio_from,
+    DROP COLUMN IF EXISTS ratio_to;
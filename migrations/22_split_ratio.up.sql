@@ -0,0 +1,4 @@
+-- коэффициент сплита: при пересборке истории количество после сплита считается от бумаг на его дату
+ALTER TABLE stocks_operations_history
+    ADD COLUMN IF NOT EXISTS ratio_from INT,
+    ADD COLUMN IF NOT EXISTS ratio_to INT;
@@ -0,0 +1,3 @@
+ALTER TABLE stocks_portfolio_details
+    DROP COLUMN IF EXISTS board,
+    DROP COLUMN IF EXISTS instrument_type;
\ No newline at end of file
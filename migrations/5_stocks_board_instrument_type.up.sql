@@ -0,0 +1,3 @@
+ALTER TABLE stocks_portfolio_details
+    ADD COLUMN IF NOT EXISTS board TEXT NOT NULL DEFAULT 'TQBR',
+    ADD COLUMN IF NOT EXISTS instrument_type TEXT NOT NULL DEFAULT 'share';
@@ -0,0 +1,3 @@
+ALTER TABLE portfolios
+    DROP COLUMN IF EXISTS index_id,
+    DROP COLUMN IF EXISTS index_drift_signature;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE portfolios
+    ADD COLUMN IF NOT EXISTS index_id TEXT,
+    ADD COLUMN IF NOT EXISTS index_drift_signature TEXT NOT NULL DEFAULT '';
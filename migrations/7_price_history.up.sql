@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS price_history(
+    ticker TEXT NOT NULL,
+    board TEXT NOT NULL,
+    trade_date DATE NOT NULL,
+    open DECIMAL(18, 6) NOT NULL,
+    high DECIMAL(18, 6) NOT NULL,
+    low DECIMAL(18, 6) NOT NULL,
+    close DECIMAL(18, 6) NOT NULL,
+    volume BIGINT NOT NULL DEFAULT 0,
+    CONSTRAINT price_history_pk PRIMARY KEY (ticker, board, trade_date)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS portfolio_snapshot_stocks;
+DROP TABLE IF EXISTS portfolio_snapshots;
\ No newline at end of file
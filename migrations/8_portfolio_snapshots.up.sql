@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS portfolio_snapshots(
+    snapshot_id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    portfolio_id BIGINT NOT NULL references portfolios(portfolio_id) ON DELETE CASCADE,
+    snapshot_date DATE NOT NULL,
+    balance_inside_index DECIMAL(18, 6) NOT NULL,
+    balance_outside_index DECIMAL(18, 6) NOT NULL,
+    growth_sum_inside_index DECIMAL(18, 6) NOT NULL,
+    growth_sum_outside_index DECIMAL(18, 6) NOT NULL,
+    index_offset DECIMAL(18, 6) NOT NULL,
+    CONSTRAINT unique_portfolio_snapshot_date UNIQUE (portfolio_id, snapshot_date)
+);
+
+CREATE TABLE IF NOT EXISTS portfolio_snapshot_stocks(
+    snapshot_id BIGINT NOT NULL references portfolio_snapshots(snapshot_id) ON DELETE CASCADE,
+    ticker TEXT NOT NULL,
+    quantity INT NOT NULL,
+    price DECIMAL(18, 6) NOT NULL,
+    CONSTRAINT portfolio_snapshot_stocks_pk PRIMARY KEY (snapshot_id, ticker)
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS realized_pnl(
+    row_id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    portfolio_id BIGINT NOT NULL references portfolios(portfolio_id) ON DELETE CASCADE,
+    ticker TEXT NOT NULL,
+    quantity INT NOT NULL,
+    buy_price DECIMAL(18, 6) NOT NULL,
+    sell_price DECIMAL(18, 6) NOT NULL,
+    buy_date TIMESTAMP WITH TIME ZONE NOT NULL,
+    sell_date TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS realized_pnl_portfolioid_ticker_idx ON realized_pnl(portfolio_id, ticker);